@@ -11,5 +11,6 @@ type ExportSettings struct {
 	MaxTimeout       uint     `json:"maxTimeout"`
 	ProxyStatus      string   `json:"proxyStatus"`
 	ReputationLabels []string `json:"reputationLabels"`
+	TargetCheckIDs   []uint   `json:"targetCheckIds"`
 	OutputFormat     string   `json:"outputFormat"`
 }
@@ -10,9 +10,13 @@ type ProxyDetail struct {
 	Password        string                    `json:"password"`
 	HasAuth         bool                      `json:"has_auth"`
 	EstimatedType   string                    `json:"estimated_type"`
-	Country         string                    `json:"country"`
+	Country         string                    `json:"country"` // Country of the IP the proxy is reached on
+	ExitIP          string                    `json:"exit_ip,omitempty"`
+	ExitCountry     string                    `json:"exit_country,omitempty"`
+	ExitChanged     bool                      `json:"exit_changed"` // Traffic leaves from another IP than the proxy's
 	CreatedAt       time.Time                 `json:"created_at"`
 	LatestCheck     *time.Time                `json:"latest_check,omitempty"`
 	LatestStatistic *ProxyStatistic           `json:"latest_statistic,omitempty"`
 	Reputation      *ProxyReputationBreakdown `json:"reputation,omitempty"`
+	TargetChecks    []TargetCheckResult       `json:"target_checks,omitempty"`
 }
@@ -11,6 +11,8 @@ type ProxyStatistic struct {
 	Protocol       string    `json:"protocol"`
 	AnonymityLevel string    `json:"anonymity_level"`
 	Judge          string    `json:"judge"`
+	ExitIP         string    `json:"exit_ip,omitempty"`
+	ExitCountry    string    `json:"exit_country,omitempty"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
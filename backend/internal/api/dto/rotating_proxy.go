@@ -3,29 +3,94 @@ package dto
 import "time"
 
 type RotatingProxy struct {
-	ID               uint64     `json:"id"`
-	Name             string     `json:"name"`
-	Protocol         string     `json:"protocol"`
-	AliveProxyCount  int        `json:"alive_proxy_count"`
-	ListenPort       uint16     `json:"listen_port"`
-	AuthRequired     bool       `json:"auth_required"`
-	AuthUsername     string     `json:"auth_username,omitempty"`
-	AuthPassword     string     `json:"auth_password,omitempty"`
-	ListenHost       string     `json:"listen_host,omitempty"`
-	ListenAddress    string     `json:"listen_address,omitempty"`
-	LastRotationAt   *time.Time `json:"last_rotation_at,omitempty"`
-	LastServedProxy  string     `json:"last_served_proxy,omitempty"`
-	ReputationLabels []string   `json:"reputation_labels,omitempty"`
-	CreatedAt        time.Time  `json:"created_at"`
+	ID                        uint64                     `json:"id"`
+	Name                      string                     `json:"name"`
+	Protocol                  string                     `json:"protocol"`
+	AliveProxyCount           int                        `json:"alive_proxy_count"`
+	ListenPort                uint16                     `json:"listen_port"`
+	ListenProtocol            string                     `json:"listen_protocol"`
+	AuthRequired              bool                       `json:"auth_required"`
+	AuthUsername              string                     `json:"auth_username,omitempty"`
+	AuthPassword              string                     `json:"auth_password,omitempty"`
+	AllowedClientCIDRs        []string                   `json:"allowed_client_cidrs,omitempty"`
+	TLSCertificate            string                     `json:"tls_certificate,omitempty"`
+	TLSFingerprint            string                     `json:"tls_fingerprint,omitempty"`
+	TLSExpiresAt              *time.Time                 `json:"tls_expires_at,omitempty"`
+	StripRequestHeaders       []string                   `json:"strip_request_headers,omitempty"`
+	SetRequestHeaders         map[string]string          `json:"set_request_headers,omitempty"`
+	UserAgentPool             []string                   `json:"user_agent_pool,omitempty"`
+	ExposeUpstreamHeader      bool                       `json:"expose_upstream_header"`
+	RoutingRules              []RotatingProxyRoutingRule `json:"routing_rules,omitempty"`
+	SessionTTLSeconds         uint32                     `json:"session_ttl_seconds"`
+	RotationStrategy          string                     `json:"rotation_strategy"`
+	RotationIntervalSeconds   uint32                     `json:"rotation_interval_seconds"`
+	FailoverAttempts          uint8                      `json:"failover_attempts"`
+	FailoverTimeoutSeconds    uint16                     `json:"failover_timeout_seconds"`
+	FailoverCooldownSeconds   uint32                     `json:"failover_cooldown_seconds"`
+	HostBanCooldownSeconds    uint32                     `json:"host_ban_cooldown_seconds"`
+	RateLimitPerSecond        uint32                     `json:"rate_limit_per_second"`
+	MaxConcurrentConnections  uint32                     `json:"max_concurrent_connections"`
+	MaxConnectionsPerUpstream uint32                     `json:"max_connections_per_upstream"`
+	MaxBandwidthKBPerSecond   uint32                     `json:"max_bandwidth_kb_per_second"`
+	ListenHost                string                     `json:"listen_host,omitempty"`
+	ListenAddress             string                     `json:"listen_address,omitempty"`
+	HostInstance              string                     `json:"host_instance,omitempty"`
+	LastRotationAt            *time.Time                 `json:"last_rotation_at,omitempty"`
+	LastServedProxy           string                     `json:"last_served_proxy,omitempty"`
+	ReputationLabels          []string                   `json:"reputation_labels,omitempty"`
+	AllowedCountries          []string                   `json:"allowed_countries,omitempty"`
+	BlockedCountries          []string                   `json:"blocked_countries,omitempty"`
+	EstimatedTypes            []string                   `json:"estimated_types,omitempty"`
+	MaxResponseTimeMs         uint16                     `json:"max_response_time_ms,omitempty"`
+	MinAnonymityLevel         string                     `json:"min_anonymity_level,omitempty"`
+	TargetCheckIDs            []uint                     `json:"target_check_ids,omitempty"`
+	CreatedAt                 time.Time                  `json:"created_at"`
 }
 
 type RotatingProxyCreateRequest struct {
-	Name             string   `json:"name"`
-	Protocol         string   `json:"protocol"`
-	AuthRequired     bool     `json:"auth_required"`
-	AuthUsername     string   `json:"auth_username,omitempty"`
-	AuthPassword     string   `json:"auth_password,omitempty"`
-	ReputationLabels []string `json:"reputation_labels"`
+	Name                      string                     `json:"name"`
+	Protocol                  string                     `json:"protocol"`
+	ListenProtocol            string                     `json:"listen_protocol,omitempty"`
+	AuthRequired              bool                       `json:"auth_required"`
+	AuthUsername              string                     `json:"auth_username,omitempty"`
+	AuthPassword              string                     `json:"auth_password,omitempty"`
+	AllowedClientCIDRs        []string                   `json:"allowed_client_cidrs,omitempty"`
+	TLSCertificate            string                     `json:"tls_certificate,omitempty"`
+	TLSPrivateKey             string                     `json:"tls_private_key,omitempty"`
+	StripRequestHeaders       []string                   `json:"strip_request_headers,omitempty"`
+	SetRequestHeaders         map[string]string          `json:"set_request_headers,omitempty"`
+	UserAgentPool             []string                   `json:"user_agent_pool,omitempty"`
+	ExposeUpstreamHeader      bool                       `json:"expose_upstream_header,omitempty"`
+	RoutingRules              []RotatingProxyRoutingRule `json:"routing_rules,omitempty"`
+	SessionTTLSeconds         uint32                     `json:"session_ttl_seconds,omitempty"`
+	RotationStrategy          string                     `json:"rotation_strategy,omitempty"`
+	RotationIntervalSeconds   uint32                     `json:"rotation_interval_seconds,omitempty"`
+	FailoverAttempts          uint8                      `json:"failover_attempts,omitempty"`
+	FailoverTimeoutSeconds    uint16                     `json:"failover_timeout_seconds,omitempty"`
+	FailoverCooldownSeconds   uint32                     `json:"failover_cooldown_seconds,omitempty"`
+	HostBanCooldownSeconds    uint32                     `json:"host_ban_cooldown_seconds,omitempty"`
+	RateLimitPerSecond        uint32                     `json:"rate_limit_per_second,omitempty"`
+	MaxConcurrentConnections  uint32                     `json:"max_concurrent_connections,omitempty"`
+	MaxConnectionsPerUpstream uint32                     `json:"max_connections_per_upstream,omitempty"`
+	MaxBandwidthKBPerSecond   uint32                     `json:"max_bandwidth_kb_per_second,omitempty"`
+	ReputationLabels          []string                   `json:"reputation_labels"`
+	AllowedCountries          []string                   `json:"allowed_countries,omitempty"`
+	BlockedCountries          []string                   `json:"blocked_countries,omitempty"`
+	EstimatedTypes            []string                   `json:"estimated_types,omitempty"`
+	MaxResponseTimeMs         uint16                     `json:"max_response_time_ms,omitempty"`
+	MinAnonymityLevel         string                     `json:"min_anonymity_level,omitempty"`
+	TargetCheckIDs            []uint                     `json:"target_check_ids,omitempty"`
+}
+
+// RotatingProxyRoutingRule sends requests for matching destination hosts to
+// a narrower set of upstreams. Rules are evaluated in order; a rule for "*"
+// is the default and must come last.
+type RotatingProxyRoutingRule struct {
+	Hosts             []string `json:"hosts"`
+	Countries         []string `json:"countries,omitempty"`
+	EstimatedTypes    []string `json:"estimated_types,omitempty"`
+	MaxResponseTimeMs uint16   `json:"max_response_time_ms,omitempty"`
+	MinAnonymityLevel string   `json:"min_anonymity_level,omitempty"`
 }
 
 type RotatingProxyNext struct {
@@ -37,3 +102,83 @@ type RotatingProxyNext struct {
 	HasAuth  bool   `json:"has_auth"`
 	Protocol string `json:"protocol"`
 }
+
+type RotatingProxyTrafficTotals struct {
+	Requests     int64   `json:"requests"`
+	Tunnels      int64   `json:"tunnels"`
+	BytesIn      int64   `json:"bytes_in"`
+	BytesOut     int64   `json:"bytes_out"`
+	Errors       int64   `json:"errors"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+type RotatingProxyUpstreamTraffic struct {
+	ProxyID uint64 `json:"proxy_id"`
+	IP      string `json:"ip,omitempty"`
+	Port    uint16 `json:"port,omitempty"`
+	RotatingProxyTrafficTotals
+}
+
+type RotatingProxyTrafficBucket struct {
+	BucketStart time.Time `json:"bucket_start"`
+	RotatingProxyTrafficTotals
+}
+
+type RotatingProxyTraffic struct {
+	RotatingProxyID uint64                         `json:"rotating_proxy_id"`
+	Since           time.Time                      `json:"since"`
+	Totals          RotatingProxyTrafficTotals     `json:"totals"`
+	Upstreams       []RotatingProxyUpstreamTraffic `json:"upstreams"`
+	Buckets         []RotatingProxyTrafficBucket   `json:"buckets"`
+}
+
+// RotatingProxyHostBan reports that a target host banned one of a rotator's
+// upstreams. The rotator skips the upstream for that host until the cooldown
+// ends; it defaults to the rotator's host ban cooldown.
+type RotatingProxyHostBan struct {
+	ProxyID         uint64     `json:"proxy_id"`
+	Host            string     `json:"host"`
+	CooldownSeconds uint32     `json:"cooldown_seconds,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+}
+
+type RotatingProxyAllowedClientsRequest struct {
+	AllowedClientCIDRs []string `json:"allowed_client_cidrs"`
+}
+
+// RotatingProxyUpdateRequest changes selected settings of a rotator. Fields
+// left out of the payload keep their current value.
+type RotatingProxyUpdateRequest struct {
+	Name                      *string                     `json:"name,omitempty"`
+	Protocol                  *string                     `json:"protocol,omitempty"`
+	AuthRequired              *bool                       `json:"auth_required,omitempty"`
+	AuthUsername              *string                     `json:"auth_username,omitempty"`
+	AuthPassword              *string                     `json:"auth_password,omitempty"`
+	AllowedClientCIDRs        *[]string                   `json:"allowed_client_cidrs,omitempty"`
+	TLSCertificate            *string                     `json:"tls_certificate,omitempty"`
+	TLSPrivateKey             *string                     `json:"tls_private_key,omitempty"`
+	RegenerateTLSCertificate  *bool                       `json:"regenerate_tls_certificate,omitempty"`
+	StripRequestHeaders       *[]string                   `json:"strip_request_headers,omitempty"`
+	SetRequestHeaders         *map[string]string          `json:"set_request_headers,omitempty"`
+	UserAgentPool             *[]string                   `json:"user_agent_pool,omitempty"`
+	ExposeUpstreamHeader      *bool                       `json:"expose_upstream_header,omitempty"`
+	RoutingRules              *[]RotatingProxyRoutingRule `json:"routing_rules,omitempty"`
+	SessionTTLSeconds         *uint32                     `json:"session_ttl_seconds,omitempty"`
+	RotationStrategy          *string                     `json:"rotation_strategy,omitempty"`
+	RotationIntervalSeconds   *uint32                     `json:"rotation_interval_seconds,omitempty"`
+	FailoverAttempts          *uint8                      `json:"failover_attempts,omitempty"`
+	FailoverTimeoutSeconds    *uint16                     `json:"failover_timeout_seconds,omitempty"`
+	FailoverCooldownSeconds   *uint32                     `json:"failover_cooldown_seconds,omitempty"`
+	HostBanCooldownSeconds    *uint32                     `json:"host_ban_cooldown_seconds,omitempty"`
+	RateLimitPerSecond        *uint32                     `json:"rate_limit_per_second,omitempty"`
+	MaxConcurrentConnections  *uint32                     `json:"max_concurrent_connections,omitempty"`
+	MaxConnectionsPerUpstream *uint32                     `json:"max_connections_per_upstream,omitempty"`
+	MaxBandwidthKBPerSecond   *uint32                     `json:"max_bandwidth_kb_per_second,omitempty"`
+	ReputationLabels          *[]string                   `json:"reputation_labels,omitempty"`
+	AllowedCountries          *[]string                   `json:"allowed_countries,omitempty"`
+	BlockedCountries          *[]string                   `json:"blocked_countries,omitempty"`
+	EstimatedTypes            *[]string                   `json:"estimated_types,omitempty"`
+	MaxResponseTimeMs         *uint16                     `json:"max_response_time_ms,omitempty"`
+	MinAnonymityLevel         *string                     `json:"min_anonymity_level,omitempty"`
+	TargetCheckIDs            *[]uint                     `json:"target_check_ids,omitempty"`
+}
@@ -0,0 +1,36 @@
+package dto
+
+import "time"
+
+type TargetCheck struct {
+	ID               uint      `json:"id"`
+	Name             string    `json:"name"`
+	URL              string    `json:"url"`
+	Method           string    `json:"method"`
+	ExpectedStatuses []uint    `json:"expected_statuses"`
+	BodyRegex        string    `json:"body_regex,omitempty"`
+	MaxLatencyMs     uint16    `json:"max_latency_ms,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// TargetCheckRequest creates or replaces a target check. An empty method
+// defaults to GET and empty expected statuses accept any 2xx response.
+type TargetCheckRequest struct {
+	Name             string `json:"name"`
+	URL              string `json:"url"`
+	Method           string `json:"method,omitempty"`
+	ExpectedStatuses []uint `json:"expected_statuses,omitempty"`
+	BodyRegex        string `json:"body_regex,omitempty"`
+	MaxLatencyMs     uint16 `json:"max_latency_ms,omitempty"`
+}
+
+type TargetCheckResult struct {
+	TargetCheckID uint      `json:"target_check_id"`
+	Name          string    `json:"name"`
+	Protocol      string    `json:"protocol"`
+	Passed        bool      `json:"passed"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	ResponseTime  uint16    `json:"response_time"`
+	Error         string    `json:"error,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
@@ -31,6 +31,7 @@ func Setup() {
 		config.EnableRedisSynchronization(context.Background(), redisClient)
 		judges.EnableRedisSynchronization(context.Background(), redisClient)
 		geolite.EnableRedisDistribution(context.Background(), redisClient)
+		rotatingproxy.EnableRedisSynchronization(context.Background(), redisClient)
 	}
 
 	if _, err := database.SetupDB(); err != nil {
@@ -125,6 +126,7 @@ func Setup() {
 
 	go judges.StartJudgeRoutine()
 	go jobruntime.StartProxyStatisticsRoutine(context.Background())
+	go jobruntime.StartRotatingProxyStateRoutine(context.Background())
 	go jobruntime.StartProxyHistoryRoutine(context.Background())
 	go jobruntime.StartProxySnapshotRoutine(context.Background())
 	go jobruntime.StartProxyGeoRefreshRoutine(context.Background())
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 
@@ -41,10 +42,24 @@ func listRotatingProxies(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	ids := make([]uint64, len(proxies))
 	for idx := range proxies {
-		proxies[idx].ListenHost = rotatorHost
-		if rotatorHost != "" {
-			proxies[idx].ListenAddress = fmt.Sprintf("%s:%d", rotatorHost, proxies[idx].ListenPort)
+		ids[idx] = proxies[idx].ID
+	}
+	placements := rotatingproxy.GlobalManager.Placements(ids)
+
+	for idx := range proxies {
+		host := rotatorHost
+		if placement, ok := placements[proxies[idx].ID]; ok {
+			proxies[idx].HostInstance = placement.Instance
+			if placement.Host != "" {
+				host = placement.Host
+			}
+		}
+
+		proxies[idx].ListenHost = host
+		if host != "" {
+			proxies[idx].ListenAddress = net.JoinHostPort(host, strconv.Itoa(int(proxies[idx].ListenPort)))
 		} else {
 			proxies[idx].ListenAddress = fmt.Sprintf("%d", proxies[idx].ListenPort)
 		}
@@ -82,6 +97,46 @@ func createRotatingProxy(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, proxy)
 }
 
+func updateRotatingProxy(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rawID := strings.TrimSpace(r.PathValue("id"))
+	if rawID == "" {
+		writeError(w, "Missing rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	id, convErr := strconv.ParseUint(rawID, 10, 64)
+	if convErr != nil {
+		writeError(w, "Invalid rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	var payload dto.RotatingProxyUpdateRequest
+	if decodeErr := json.NewDecoder(r.Body).Decode(&payload); decodeErr != nil {
+		writeError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	proxy, updateErr := database.UpdateRotatingProxy(userID, id, payload)
+	if updateErr != nil {
+		writeRotatingProxyError(w, updateErr)
+		return
+	}
+
+	if err := rotatingproxy.GlobalManager.Reload(id); err != nil {
+		log.Error("rotating proxy: failed to reload listener", "rotator_id", id, "error", err)
+		writeError(w, "Failed to apply rotating proxy changes", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, proxy)
+}
+
 func deleteRotatingProxy(w http.ResponseWriter, r *http.Request) {
 	userID, err := auth.GetUserIDFromRequest(r)
 	if err != nil {
@@ -139,12 +194,170 @@ func getNextRotatingProxy(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, nextProxy)
 }
 
+func rotateRotatingProxyNow(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rawID := strings.TrimSpace(r.PathValue("id"))
+	if rawID == "" {
+		writeError(w, "Missing rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	id, convErr := strconv.ParseUint(rawID, 10, 64)
+	if convErr != nil {
+		writeError(w, "Invalid rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	nextProxy, dbErr := rotatingproxy.GlobalManager.RotateNow(userID, id)
+	if dbErr != nil {
+		writeRotatingProxyError(w, dbErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, nextProxy)
+}
+
+func reportRotatingProxyHostBan(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rawID := strings.TrimSpace(r.PathValue("id"))
+	if rawID == "" {
+		writeError(w, "Missing rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	id, convErr := strconv.ParseUint(rawID, 10, 64)
+	if convErr != nil {
+		writeError(w, "Invalid rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	var payload dto.RotatingProxyHostBan
+	if decodeErr := json.NewDecoder(r.Body).Decode(&payload); decodeErr != nil {
+		writeError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ban, dbErr := database.ResolveRotatingProxyHostBan(userID, id, payload)
+	if dbErr != nil {
+		writeRotatingProxyError(w, dbErr)
+		return
+	}
+
+	rotatingproxy.BanUpstreamForHost(id, ban.ProxyID, ban.Host, time.Duration(ban.CooldownSeconds)*time.Second)
+
+	writeJSON(w, http.StatusCreated, ban)
+}
+
+func updateRotatingProxyAllowedClients(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rawID := strings.TrimSpace(r.PathValue("id"))
+	if rawID == "" {
+		writeError(w, "Missing rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	id, convErr := strconv.ParseUint(rawID, 10, 64)
+	if convErr != nil {
+		writeError(w, "Invalid rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	var payload dto.RotatingProxyAllowedClientsRequest
+	if decodeErr := json.NewDecoder(r.Body).Decode(&payload); decodeErr != nil {
+		writeError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	allowed, dbErr := database.UpdateRotatingProxyAllowedClients(userID, id, payload)
+	if dbErr != nil {
+		writeRotatingProxyError(w, dbErr)
+		return
+	}
+
+	if err := rotatingproxy.GlobalManager.Reload(id); err != nil {
+		log.Error("rotating proxy: failed to reload listener", "rotator_id", id, "error", err)
+		writeError(w, "Failed to apply rotating proxy allowlist", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dto.RotatingProxyAllowedClientsRequest{AllowedClientCIDRs: allowed})
+}
+
+const defaultRotatingProxyTrafficHours = 24
+
+func getRotatingProxyTraffic(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rawID := strings.TrimSpace(r.PathValue("id"))
+	if rawID == "" {
+		writeError(w, "Missing rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	id, convErr := strconv.ParseUint(rawID, 10, 64)
+	if convErr != nil {
+		writeError(w, "Invalid rotating proxy id", http.StatusBadRequest)
+		return
+	}
+
+	hours := defaultRotatingProxyTrafficHours
+	if rawHours := strings.TrimSpace(r.URL.Query().Get("hours")); rawHours != "" {
+		parsed, parseErr := strconv.Atoi(rawHours)
+		maxHours := int(database.RotatingProxyTrafficRetention / time.Hour)
+		if parseErr != nil || parsed <= 0 || parsed > maxHours {
+			writeError(w, fmt.Sprintf("hours must be between 1 and %d", maxHours), http.StatusBadRequest)
+			return
+		}
+		hours = parsed
+	}
+
+	since := time.Now().Add(-time.Duration(hours-1) * time.Hour)
+	traffic, dbErr := database.GetRotatingProxyTraffic(userID, id, since)
+	if dbErr != nil {
+		writeRotatingProxyError(w, dbErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, traffic)
+}
+
 func writeRotatingProxyError(w http.ResponseWriter, err error) {
 	switch {
 	case errors.Is(err, database.ErrRotatingProxyNameRequired),
 		errors.Is(err, database.ErrRotatingProxyNameTooLong),
 		errors.Is(err, database.ErrRotatingProxyProtocolMissing),
 		errors.Is(err, database.ErrRotatingProxyProtocolDenied),
+		errors.Is(err, database.ErrRotatingProxyListenProtocol),
+		errors.Is(err, database.ErrRotatingProxyStrategyInvalid),
+		errors.Is(err, database.ErrRotatingProxyEstimatedType),
+		errors.Is(err, database.ErrRotatingProxyAnonymityLevel),
+		errors.Is(err, database.ErrRotatingProxyCountryInvalid),
+		errors.Is(err, database.ErrRotatingProxyClientCIDRInvalid),
+		errors.Is(err, database.ErrRotatingProxyTLSCertificate),
+		errors.Is(err, database.ErrRotatingProxyTLSRequiresHTTPS),
+		errors.Is(err, database.ErrRotatingProxyHeaderInvalid),
+		errors.Is(err, database.ErrRotatingProxyRoutingRuleInvalid),
+		errors.Is(err, database.ErrRotatingProxyBanHostInvalid),
+		errors.Is(err, database.ErrRotatingProxyTargetChecks),
 		errors.Is(err, database.ErrRotatingProxyAuthUsernameNeeded),
 		errors.Is(err, database.ErrRotatingProxyAuthPasswordNeeded):
 		writeError(w, err.Error(), http.StatusBadRequest)
@@ -152,7 +365,8 @@ func writeRotatingProxyError(w http.ResponseWriter, err error) {
 		writeError(w, err.Error(), http.StatusConflict)
 	case errors.Is(err, database.ErrRotatingProxyPortExhausted):
 		writeError(w, err.Error(), http.StatusServiceUnavailable)
-	case errors.Is(err, database.ErrRotatingProxyNotFound):
+	case errors.Is(err, database.ErrRotatingProxyNotFound),
+		errors.Is(err, database.ErrRotatingProxyUpstreamGone):
 		writeError(w, err.Error(), http.StatusNotFound)
 	case errors.Is(err, database.ErrRotatingProxyNoAliveProxies):
 		writeError(w, err.Error(), http.StatusConflict)
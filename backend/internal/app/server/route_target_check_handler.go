@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/auth"
+	"magpie/internal/database"
+)
+
+func listTargetChecks(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	checks, dbErr := database.ListTargetChecks(userID)
+	if dbErr != nil {
+		writeError(w, "Failed to load target checks", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"target_checks": checks})
+}
+
+func createTargetCheck(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload dto.TargetCheckRequest
+	if decodeErr := json.NewDecoder(r.Body).Decode(&payload); decodeErr != nil {
+		writeError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	check, createErr := database.CreateTargetCheck(userID, payload)
+	if createErr != nil {
+		writeTargetCheckError(w, createErr)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, check)
+}
+
+func updateTargetCheck(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, ok := parseTargetCheckID(w, r)
+	if !ok {
+		return
+	}
+
+	var payload dto.TargetCheckRequest
+	if decodeErr := json.NewDecoder(r.Body).Decode(&payload); decodeErr != nil {
+		writeError(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	check, updateErr := database.UpdateTargetCheck(userID, id, payload)
+	if updateErr != nil {
+		writeTargetCheckError(w, updateErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, check)
+}
+
+func deleteTargetCheck(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromRequest(r)
+	if err != nil {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, ok := parseTargetCheckID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteTargetCheck(userID, id); err != nil {
+		writeTargetCheckError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseTargetCheckID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	rawID := strings.TrimSpace(r.PathValue("id"))
+	if rawID == "" {
+		writeError(w, "Missing target check id", http.StatusBadRequest)
+		return 0, false
+	}
+
+	id, convErr := strconv.ParseUint(rawID, 10, 0)
+	if convErr != nil || id == 0 {
+		writeError(w, "Invalid target check id", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+func writeTargetCheckError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrTargetCheckNameRequired),
+		errors.Is(err, database.ErrTargetCheckNameTooLong),
+		errors.Is(err, database.ErrTargetCheckURLInvalid),
+		errors.Is(err, database.ErrTargetCheckURLBlocked),
+		errors.Is(err, database.ErrTargetCheckMethodInvalid),
+		errors.Is(err, database.ErrTargetCheckStatusInvalid),
+		errors.Is(err, database.ErrTargetCheckRegexInvalid),
+		errors.Is(err, database.ErrTargetCheckRegexWithHead):
+		writeError(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrTargetCheckNameConflict),
+		errors.Is(err, database.ErrTargetCheckLimitReached):
+		writeError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, database.ErrTargetCheckNotFound):
+		writeError(w, err.Error(), http.StatusNotFound)
+	default:
+		writeError(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -67,8 +67,18 @@ func OpenRoutes(port int) error {
 
 	apiMux.Handle("GET /rotatingProxies", auth.RequireAuth(http.HandlerFunc(listRotatingProxies)))
 	apiMux.Handle("POST /rotatingProxies", auth.RequireAuth(http.HandlerFunc(createRotatingProxy)))
+	apiMux.Handle("PUT /rotatingProxies/{id}", auth.RequireAuth(http.HandlerFunc(updateRotatingProxy)))
 	apiMux.Handle("DELETE /rotatingProxies/{id}", auth.RequireAuth(http.HandlerFunc(deleteRotatingProxy)))
 	apiMux.Handle("POST /rotatingProxies/{id}/next", auth.RequireAuth(http.HandlerFunc(getNextRotatingProxy)))
+	apiMux.Handle("PUT /rotatingProxies/{id}/allowedClients", auth.RequireAuth(http.HandlerFunc(updateRotatingProxyAllowedClients)))
+	apiMux.Handle("POST /rotatingProxies/{id}/rotate", auth.RequireAuth(http.HandlerFunc(rotateRotatingProxyNow)))
+	apiMux.Handle("POST /rotatingProxies/{id}/bans", auth.RequireAuth(http.HandlerFunc(reportRotatingProxyHostBan)))
+	apiMux.Handle("GET /rotatingProxies/{id}/traffic", auth.RequireAuth(http.HandlerFunc(getRotatingProxyTraffic)))
+
+	apiMux.Handle("GET /targetChecks", auth.RequireAuth(http.HandlerFunc(listTargetChecks)))
+	apiMux.Handle("POST /targetChecks", auth.RequireAuth(http.HandlerFunc(createTargetCheck)))
+	apiMux.Handle("PUT /targetChecks/{id}", auth.RequireAuth(http.HandlerFunc(updateTargetCheck)))
+	apiMux.Handle("DELETE /targetChecks/{id}", auth.RequireAuth(http.HandlerFunc(deleteTargetCheck)))
 
 	apiMux.Handle("GET /getScrapingSourcesCount", auth.RequireAuth(http.HandlerFunc(getScrapeSourcesCount)))
 	apiMux.Handle("GET /getScrapingSourcesPage/{page}", auth.RequireAuth(http.HandlerFunc(getScrapeSourcePage)))
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"regexp"
 	"sort"
 	"strings"
@@ -37,6 +38,9 @@ var (
 	refreshOnce singleflight.Group
 	httpClient  = &http.Client{Timeout: 30 * time.Second}
 	ipRegex     = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?:/\d{1,2})?\b`)
+	// ipv6Regex over-matches (e.g. clock times); candidates are validated by
+	// parseCIDROrIP.
+	ipv6Regex = regexp.MustCompile(`(?i)(?:^|[^0-9a-z:.])((?:[0-9a-f]{0,4}:){2,7}[0-9a-f.]*(?:/\d{1,3})?)`)
 )
 
 type atomicMap struct {
@@ -93,10 +97,10 @@ func init() {
 
 // Initialize hydrates the in-memory blacklist cache and backfills missing proxy hashes.
 func Initialize(ctx context.Context) error {
-	if hashUpdated, intUpdated, err := database.BackfillProxyIPMetadata(ctx); err != nil {
+	if hashUpdated, addrUpdated, err := database.BackfillProxyIPMetadata(ctx); err != nil {
 		return fmt.Errorf("backfill proxy ip metadata: %w", err)
-	} else if hashUpdated > 0 || intUpdated > 0 {
-		log.Info("Backfilled proxy IP metadata", "hash_count", hashUpdated, "int_count", intUpdated)
+	} else if hashUpdated > 0 || addrUpdated > 0 {
+		log.Info("Backfilled proxy IP metadata", "hash_count", hashUpdated, "address_count", addrUpdated)
 	}
 	return LoadCache(ctx)
 }
@@ -114,9 +118,9 @@ func LoadCache(ctx context.Context) error {
 	}
 	sort.Slice(ranges, func(i, j int) bool {
 		if ranges[i].StartIP == ranges[j].StartIP {
-			return ranges[i].EndIP < ranges[j].EndIP
+			return ranges[i].EndIP.Less(ranges[j].EndIP)
 		}
-		return ranges[i].StartIP < ranges[j].StartIP
+		return ranges[i].StartIP.Less(ranges[j].StartIP)
 	})
 	rangeCache.Store(ranges)
 	return nil
@@ -140,7 +144,7 @@ func cloneSet(m map[string]struct{}) map[string]struct{} {
 
 // IsIPBlacklisted checks the in-memory cache for the given IP.
 func IsIPBlacklisted(ip string) bool {
-	normalized := normalizeIPv4(ip)
+	normalized := normalizeIP(ip)
 	if normalized == "" {
 		return false
 	}
@@ -159,7 +163,7 @@ func FilterProxies(proxies []domain.Proxy) (allowed []domain.Proxy, blocked []do
 	allowed = make([]domain.Proxy, 0, len(proxies))
 
 	for _, proxy := range proxies {
-		ip := normalizeIPv4(proxy.GetIp())
+		ip := normalizeIP(proxy.GetIp())
 		if ip == "" {
 			continue
 		}
@@ -424,8 +428,8 @@ func diffRanges(after, before []domain.BlacklistedRange) []domain.BlacklistedRan
 	}
 
 	type key struct {
-		start uint32
-		end   uint32
+		start netip.Addr
+		end   netip.Addr
 	}
 	beforeSet := make(map[key]struct{}, len(before))
 	for _, r := range before {
@@ -488,7 +492,7 @@ func parseIPs(payload []byte) ([]string, []domain.BlacklistedRange) {
 
 	for scanner.Scan() {
 		line := scanner.Bytes()
-		matches := ipRegex.FindAll(line, -1)
+		matches := append(ipRegex.FindAll(line, -1), findIPv6Candidates(line)...)
 		for _, match := range matches {
 			ipStr := string(match)
 			cidrs, ips := parseCIDROrIP(ipStr)
@@ -512,59 +516,69 @@ func parseIPs(payload []byte) ([]string, []domain.BlacklistedRange) {
 	return out, ranges
 }
 
-func normalizeIPv4(raw string) string {
+func findIPv6Candidates(line []byte) [][]byte {
+	var candidates [][]byte
+	for _, match := range ipv6Regex.FindAllSubmatch(line, -1) {
+		candidate := bytes.TrimRight(match[1], ".")
+		addr, _, _ := bytes.Cut(candidate, []byte("/"))
+		// Skip bare "::" runs, which are separators far more often than the
+		// unspecified address.
+		if len(bytes.Trim(addr, ":.")) == 0 {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// normalizeIP returns raw in the canonical form proxies store their IP in.
+func normalizeIP(raw string) string {
 	parsed := net.ParseIP(raw)
 	if parsed == nil {
 		return ""
 	}
-	v4 := parsed.To4()
-	if v4 == nil {
-		return ""
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String()
 	}
-	return v4.String()
+	return parsed.String()
 }
 
 func parseCIDROrIP(raw string) ([]domain.BlacklistedRange, []string) {
 	if !strings.Contains(raw, "/") {
-		ip := normalizeIPv4(raw)
+		ip := normalizeIP(raw)
 		if ip == "" {
 			return nil, nil
 		}
 		return nil, []string{ip}
 	}
 
-	_, ipnet, err := net.ParseCIDR(raw)
-	if err != nil || ipnet == nil {
-		return nil, nil
-	}
-
-	base := ipnet.IP.To4()
-	if base == nil {
+	prefix, err := netip.ParsePrefix(raw)
+	if err != nil {
 		return nil, nil
 	}
 
-	ones, bits := ipnet.Mask.Size()
-	if bits != 32 || ones < 0 || ones > 32 {
-		return nil, nil
+	bits := prefix.Bits()
+	if prefix.Addr().Is4In6() && bits >= 96 {
+		bits -= 96
 	}
-
-	start := ipToUint32(base.Mask(ipnet.Mask))
-	hostCount := uint32(1) << uint32(bits-ones)
-	lastIP := start + hostCount - 1
+	prefix = netip.PrefixFrom(prefix.Addr().Unmap(), bits).Masked()
 
 	return []domain.BlacklistedRange{{
-		CIDR:    ipnet.String(),
-		StartIP: start,
-		EndIP:   lastIP,
+		CIDR:    prefix.String(),
+		StartIP: prefix.Addr(),
+		EndIP:   lastAddrOfPrefix(prefix),
 	}}, nil
 }
 
-func ipToUint32(ip net.IP) uint32 {
-	ip = ip.To4()
-	if ip == nil {
-		return 0
+// lastAddrOfPrefix returns the highest address inside a masked prefix.
+func lastAddrOfPrefix(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr()
+	raw := addr.AsSlice()
+	for bit := prefix.Bits(); bit < addr.BitLen(); bit++ {
+		raw[bit/8] |= 0x80 >> (bit % 8)
 	}
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	last, _ := netip.AddrFromSlice(raw)
+	return last
 }
 
 func inRange(ip string, ranges []domain.BlacklistedRange) bool {
@@ -572,16 +586,20 @@ func inRange(ip string, ranges []domain.BlacklistedRange) bool {
 		return false
 	}
 
-	u := ipToUint32(net.ParseIP(ip))
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
 
 	lo, hi := 0, len(ranges)
 	for lo < hi {
 		mid := (lo + hi) / 2
-		if u < ranges[mid].StartIP {
+		if addr.Less(ranges[mid].StartIP) {
 			hi = mid
 			continue
 		}
-		if u > ranges[mid].EndIP {
+		if ranges[mid].EndIP.Less(addr) {
 			lo = mid + 1
 			continue
 		}
@@ -0,0 +1,73 @@
+package config
+
+import "time"
+
+const (
+	defaultCheckBackoffMultiplier = 2.0
+	defaultCheckBackoffMax        = 7 * 24 * time.Hour
+	minCheckInterval              = time.Second
+)
+
+// CheckBackoff adapts a proxy's checking interval to its failure streak:
+// proxies alive on their last check come back sooner, and proxies failing
+// beyond the grace streak back off exponentially up to MaxTimer.
+type CheckBackoff struct {
+	Enabled bool `json:"enabled"`
+	// AliveFactor scales the interval for proxies alive on their last check.
+	// Values outside (0, 1) keep the base interval.
+	AliveFactor float64 `json:"alive_factor"`
+	// GraceFailures is how many consecutive failures are still checked at
+	// the base interval.
+	GraceFailures uint32  `json:"grace_failures"`
+	Multiplier    float64 `json:"multiplier"`
+	MaxTimer      Timer   `json:"max_timer"`
+}
+
+// CheckIntervalFor returns the delay before the next check of a proxy that
+// has failed its last failures checks in a row.
+func CheckIntervalFor(failures uint16) time.Duration {
+	return checkIntervalFor(GetConfig().Checker.Backoff, GetTimeBetweenChecks(), failures)
+}
+
+func checkIntervalFor(policy CheckBackoff, base time.Duration, failures uint16) time.Duration {
+	if !policy.Enabled {
+		return base
+	}
+
+	if failures == 0 {
+		if policy.AliveFactor <= 0 || policy.AliveFactor >= 1 {
+			return base
+		}
+		interval := time.Duration(float64(base) * policy.AliveFactor)
+		if interval < minCheckInterval {
+			interval = minCheckInterval
+		}
+		return interval
+	}
+
+	if uint32(failures) <= policy.GraceFailures {
+		return base
+	}
+
+	maxInterval := defaultCheckBackoffMax
+	if ms := CalculateMillisecondsOfCheckingPeriod(policy.MaxTimer); ms > 0 {
+		maxInterval = time.Duration(ms) * time.Millisecond
+	}
+	if maxInterval <= base {
+		return base
+	}
+
+	multiplier := policy.Multiplier
+	if multiplier <= 1 {
+		multiplier = defaultCheckBackoffMultiplier
+	}
+
+	interval := float64(base)
+	for step := uint32(failures) - policy.GraceFailures; step > 0; step-- {
+		interval *= multiplier
+		if interval >= float64(maxInterval) {
+			return maxInterval
+		}
+	}
+	return time.Duration(interval)
+}
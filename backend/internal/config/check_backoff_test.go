@@ -0,0 +1,73 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckIntervalFor_DisabledKeepsBaseInterval(t *testing.T) {
+	policy := CheckBackoff{AliveFactor: 0.5, Multiplier: 2}
+	for _, failures := range []uint16{0, 1, 50} {
+		if got := checkIntervalFor(policy, time.Hour, failures); got != time.Hour {
+			t.Fatalf("checkIntervalFor(%d) = %s, want 1h", failures, got)
+		}
+	}
+}
+
+func TestCheckIntervalFor_ChecksAliveProxiesSooner(t *testing.T) {
+	policy := CheckBackoff{Enabled: true, AliveFactor: 0.25}
+	if got := checkIntervalFor(policy, time.Hour, 0); got != 15*time.Minute {
+		t.Fatalf("alive interval = %s, want 15m", got)
+	}
+
+	policy.AliveFactor = 0.0001
+	if got := checkIntervalFor(policy, time.Hour, 0); got != minCheckInterval {
+		t.Fatalf("alive interval = %s, want the minimum of %s", got, minCheckInterval)
+	}
+
+	policy.AliveFactor = 3
+	if got := checkIntervalFor(policy, time.Hour, 0); got != time.Hour {
+		t.Fatalf("alive interval = %s, want 1h for a factor outside (0, 1)", got)
+	}
+}
+
+func TestCheckIntervalFor_BacksOffDeadProxiesUpToCap(t *testing.T) {
+	policy := CheckBackoff{
+		Enabled:       true,
+		GraceFailures: 2,
+		Multiplier:    3,
+		MaxTimer:      Timer{Hours: 20},
+	}
+
+	cases := []struct {
+		failures uint16
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Hour},
+		{failures: 2, want: time.Hour},
+		{failures: 3, want: 3 * time.Hour},
+		{failures: 4, want: 9 * time.Hour},
+		{failures: 5, want: 20 * time.Hour},
+		{failures: 65535, want: 20 * time.Hour},
+	}
+
+	for _, tc := range cases {
+		if got := checkIntervalFor(policy, time.Hour, tc.failures); got != tc.want {
+			t.Fatalf("checkIntervalFor(%d) = %s, want %s", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestCheckIntervalFor_DefaultsMultiplierAndCap(t *testing.T) {
+	policy := CheckBackoff{Enabled: true}
+
+	if got := checkIntervalFor(policy, time.Hour, 2); got != 4*time.Hour {
+		t.Fatalf("checkIntervalFor(2) = %s, want 4h with the default multiplier", got)
+	}
+	if got := checkIntervalFor(policy, time.Hour, 100); got != defaultCheckBackoffMax {
+		t.Fatalf("checkIntervalFor(100) = %s, want default cap %s", got, defaultCheckBackoffMax)
+	}
+	if got := checkIntervalFor(policy, 10*24*time.Hour, 100); got != 10*24*time.Hour {
+		t.Fatalf("cap below the base interval should keep the base interval, got %s", got)
+	}
+}
@@ -27,6 +27,8 @@ type Config struct {
 		Timeout        uint32 `json:"timeout"`
 		CheckerTimer   Timer  `json:"checker_timer"`
 
+		Backoff CheckBackoff `json:"backoff"`
+
 		JudgesThreads uint32  `json:"judges_threads"`
 		JudgesTimeout uint32  `json:"judges_timeout"`
 		Judges        []judge `json:"judges"`
@@ -4,8 +4,6 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
-	"fmt"
-	"math/bits"
 	"net"
 	"net/netip"
 	"sort"
@@ -22,12 +20,12 @@ const (
 )
 
 type cidrSpan struct {
-	start  uint32
-	end    uint32
+	start  netip.Addr
+	end    netip.Addr
 	source string
 }
 
-// ListBlacklistedIPs returns all stored blacklist entries as plain IPv4 strings.
+// ListBlacklistedIPs returns all stored blacklist entries as plain IP strings.
 func ListBlacklistedIPs(ctx context.Context) ([]string, error) {
 	if DB == nil {
 		return nil, errors.New("database not initialised")
@@ -208,7 +206,7 @@ func dedupeIPs(ips []domain.BlacklistedIP) []domain.BlacklistedIP {
 
 	seen := make(map[string]domain.BlacklistedIP, len(ips))
 	for _, ip := range ips {
-		normalized := normalizeIPv4(ip.IP)
+		normalized := normalizeIP(ip.IP)
 		if normalized == "" {
 			continue
 		}
@@ -325,7 +323,8 @@ func BackfillProxyIPHashes(ctx context.Context) (int64, error) {
 	return updated, nil
 }
 
-// BackfillProxyIPMetadata fills missing IP hashes and IP ints for legacy rows.
+// BackfillProxyIPMetadata fills missing IP hashes and range-searchable
+// addresses (ip_int or ip_v6) for legacy rows.
 func BackfillProxyIPMetadata(ctx context.Context) (int64, int64, error) {
 	if DB == nil {
 		return 0, 0, errors.New("database not initialised")
@@ -338,18 +337,19 @@ func BackfillProxyIPMetadata(ctx context.Context) (int64, int64, error) {
 
 	var (
 		hashUpdated int64
-		intUpdated  int64
+		addrUpdated int64
 		batch       []domain.Proxy
 	)
 
 	result := db.
-		Where("COALESCE(octet_length(ip_hash), 0) = 0 OR ip_int = 0").
+		Where("COALESCE(octet_length(ip_hash), 0) = 0 OR (ip_int = 0 AND COALESCE(octet_length(ip_v6), 0) = 0)").
 		FindInBatches(&batch, maxParamsPerBatch, func(tx *gorm.DB, _ int) error {
 			if len(batch) == 0 {
 				return nil
 			}
 
 			for i := range batch {
+				// AfterFind already filled ip_int or ip_v6 from the decrypted IP.
 				batch[i].GenerateHash()
 			}
 
@@ -360,12 +360,13 @@ func BackfillProxyIPMetadata(ctx context.Context) (int64, int64, error) {
 						Updates(map[string]any{
 							"ip_hash": batch[i].IPHash,
 							"ip_int":  batch[i].IPInt,
+							"ip_v6":   batch[i].IPv6,
 						}).Error; err != nil {
 						return err
 					}
 					hashUpdated++
-					if batch[i].IPInt != 0 {
-						intUpdated++
+					if batch[i].IPInt != 0 || len(batch[i].IPv6) > 0 {
+						addrUpdated++
 					}
 				}
 			}
@@ -375,10 +376,10 @@ func BackfillProxyIPMetadata(ctx context.Context) (int64, int64, error) {
 		})
 
 	if result.Error != nil {
-		return hashUpdated, intUpdated, result.Error
+		return hashUpdated, addrUpdated, result.Error
 	}
 
-	return hashUpdated, intUpdated, nil
+	return hashUpdated, addrUpdated, nil
 }
 
 // RemoveProxiesByIPs removes proxy/user associations for proxies whose IP is in the given list.
@@ -452,31 +453,33 @@ func RemoveProxiesByIPs(ctx context.Context, ips []string) (int64, []domain.Prox
 	return totalRemoved, orphaned, nil
 }
 
-func cidrBounds(cidr string) (uint32, uint32, error) {
+func cidrBounds(cidr string) (netip.Addr, netip.Addr, error) {
 	_, start, end, err := normalizeCIDRWithBounds(cidr)
 	return start, end, err
 }
 
-func normalizeCIDR(raw string) (string, uint32, uint32, error) {
+func normalizeCIDR(raw string) (string, netip.Addr, netip.Addr, error) {
 	return normalizeCIDRWithBounds(raw)
 }
 
-func normalizeCIDRWithBounds(raw string) (string, uint32, uint32, error) {
+func normalizeCIDRWithBounds(raw string) (string, netip.Addr, netip.Addr, error) {
 	prefix, err := netip.ParsePrefix(raw)
 	if err != nil {
-		return "", 0, 0, err
+		return "", netip.Addr{}, netip.Addr{}, err
 	}
-	if !prefix.Addr().Is4() {
-		return "", 0, 0, fmt.Errorf("non-ipv4 cidr: %s", raw)
-	}
-	prefix = prefix.Masked()
-	start := ipToUint32(prefix.Addr())
-	if prefix.Bits() == 32 {
-		return prefix.String(), start, start, nil
+	prefix = netip.PrefixFrom(prefix.Addr().Unmap(), unmappedBits(prefix)).Masked()
+	return prefix.String(), prefix.Addr(), lastAddrOfPrefix(prefix), nil
+}
+
+// lastAddrOfPrefix returns the highest address inside a masked prefix.
+func lastAddrOfPrefix(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr()
+	raw := addr.AsSlice()
+	for bit := prefix.Bits(); bit < addr.BitLen(); bit++ {
+		raw[bit/8] |= 0x80 >> (bit % 8)
 	}
-	size := uint32(1) << (32 - prefix.Bits())
-	end := start + size - 1
-	return prefix.String(), start, end, nil
+	last, _ := netip.AddrFromSlice(raw)
+	return last
 }
 
 func ipToUint32(addr netip.Addr) uint32 {
@@ -484,25 +487,18 @@ func ipToUint32(addr netip.Addr) uint32 {
 	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
 }
 
-func uint32ToIP(val uint32) net.IP {
-	return net.IPv4(
-		byte(val>>24),
-		byte(val>>16),
-		byte(val>>8),
-		byte(val),
-	).To4()
-}
-
+// mergeSpans sorts spans and joins overlapping or adjacent ones of the same
+// address family.
 func mergeSpans(spans []cidrSpan) []cidrSpan {
 	if len(spans) == 0 {
 		return spans
 	}
 
 	sort.Slice(spans, func(i, j int) bool {
-		if spans[i].start == spans[j].start {
-			return spans[i].end < spans[j].end
+		if cmp := spans[i].start.Compare(spans[j].start); cmp != 0 {
+			return cmp < 0
 		}
-		return spans[i].start < spans[j].start
+		return spans[i].end.Less(spans[j].end)
 	})
 
 	merged := make([]cidrSpan, 0, len(spans))
@@ -513,11 +509,14 @@ func mergeSpans(spans []cidrSpan) []cidrSpan {
 			continue
 		}
 		last := &merged[len(merged)-1]
-		if s.start <= last.end+1 {
-			if s.end > last.end {
-				last.end = s.end
+		if s.start.BitLen() == last.end.BitLen() {
+			following := last.end.Next()
+			if !following.IsValid() || s.start.Compare(following) <= 0 {
+				if last.end.Less(s.end) {
+					last.end = s.end
+				}
+				continue
 			}
-			continue
 		}
 		merged = append(merged, s)
 	}
@@ -525,28 +524,22 @@ func mergeSpans(spans []cidrSpan) []cidrSpan {
 	return merged
 }
 
-func rangeToCIDRs(start, end uint32) []string {
+// rangeToCIDRs splits an address range into the fewest covering prefixes.
+func rangeToCIDRs(start, end netip.Addr) []string {
 	var cidrs []string
 
-	s := uint64(start)
-	e := uint64(end)
-
-	for s <= e {
-		maxSize := uint64(bits.TrailingZeros32(uint32(s)))
-		remaining := e - s + 1
-		maxAllowed := uint64(bits.Len64(remaining) - 1)
-
-		hostBits := maxAllowed
-		if maxSize < hostBits {
-			hostBits = maxSize
+	for start.IsValid() && start.Compare(end) <= 0 {
+		var prefix netip.Prefix
+		for bits := 0; bits <= start.BitLen(); bits++ {
+			candidate := netip.PrefixFrom(start, bits).Masked()
+			if candidate.Addr() == start && lastAddrOfPrefix(candidate).Compare(end) <= 0 {
+				prefix = candidate
+				break
+			}
 		}
 
-		prefixLen := 32 - hostBits
-
-		cidr := fmt.Sprintf("%s/%d", uint32ToIP(uint32(s)).String(), prefixLen)
-		cidrs = append(cidrs, cidr)
-
-		s += 1 << hostBits
+		cidrs = append(cidrs, prefix.String())
+		start = lastAddrOfPrefix(prefix).Next()
 	}
 
 	return cidrs
@@ -561,7 +554,7 @@ func normalizeIPList(ips []string) []string {
 	out := make([]string, 0, len(ips))
 
 	for _, raw := range ips {
-		ip := normalizeIPv4(raw)
+		ip := normalizeIP(raw)
 		if ip == "" {
 			continue
 		}
@@ -575,16 +568,16 @@ func normalizeIPList(ips []string) []string {
 	return out
 }
 
-func normalizeIPv4(raw string) string {
+// normalizeIP returns raw in the canonical form proxies store their IP in.
+func normalizeIP(raw string) string {
 	parsed := net.ParseIP(raw)
 	if parsed == nil {
 		return ""
 	}
-	ipv4 := parsed.To4()
-	if ipv4 == nil {
-		return ""
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		return ipv4.String()
 	}
-	return ipv4.String()
+	return parsed.String()
 }
 
 // RemoveProxiesByRanges removes proxies whose IP falls inside any of the provided ranges.
@@ -601,44 +594,34 @@ func RemoveProxiesByRanges(ctx context.Context, ranges []domain.BlacklistedRange
 		db = db.WithContext(ctx)
 	}
 
-	type span struct {
-		start uint32
-		end   uint32
-	}
-	spans := make([]span, 0, len(ranges))
+	spans := make([]cidrSpan, 0, len(ranges))
 	for _, r := range ranges {
 		start := r.StartIP
 		end := r.EndIP
-		if start > end {
+		if !start.IsValid() || !end.IsValid() || start.BitLen() != end.BitLen() {
+			continue
+		}
+		if end.Less(start) {
 			start, end = end, start
 		}
-		spans = append(spans, span{start: start, end: end})
+		spans = append(spans, cidrSpan{start: start, end: end})
 	}
 
 	// Sort and merge to reduce queries
-	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
-	merged := make([]span, 0, len(spans))
-	for _, s := range spans {
-		if len(merged) == 0 {
-			merged = append(merged, s)
-			continue
-		}
-		last := &merged[len(merged)-1]
-		if s.start <= last.end+1 {
-			if s.end > last.end {
-				last.end = s.end
-			}
-			continue
-		}
-		merged = append(merged, s)
-	}
+	merged := mergeSpans(spans)
 
 	var proxies []domain.Proxy
 	for _, s := range merged {
+		query := db.Preload("Users")
+		if s.start.Is4() {
+			query = query.Where("ip_int BETWEEN ? AND ?", ipToUint32(s.start), ipToUint32(s.end))
+		} else {
+			start, end := s.start.As16(), s.end.As16()
+			query = query.Where("ip_v6 BETWEEN ? AND ?", start[:], end[:])
+		}
+
 		var batch []domain.Proxy
-		if err := db.Preload("Users").
-			Where("ip_int BETWEEN ? AND ?", s.start, s.end).
-			Find(&batch).Error; err != nil {
+		if err := query.Find(&batch).Error; err != nil {
 			return 0, nil, err
 		}
 		proxies = append(proxies, batch...)
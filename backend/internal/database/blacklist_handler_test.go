@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"magpie/internal/domain"
+)
+
+func TestNormalizeCIDR_HandlesBothFamilies(t *testing.T) {
+	cases := []struct {
+		raw, cidr, start, end string
+	}{
+		{raw: "192.0.2.7/24", cidr: "192.0.2.0/24", start: "192.0.2.0", end: "192.0.2.255"},
+		{raw: "2001:db8::1/32", cidr: "2001:db8::/32", start: "2001:db8::", end: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{raw: "::ffff:198.51.100.0/120", cidr: "198.51.100.0/24", start: "198.51.100.0", end: "198.51.100.255"},
+	}
+
+	for _, tc := range cases {
+		cidr, start, end, err := normalizeCIDR(tc.raw)
+		if err != nil {
+			t.Fatalf("normalizeCIDR(%q) returned error: %v", tc.raw, err)
+		}
+		if cidr != tc.cidr || start.String() != tc.start || end.String() != tc.end {
+			t.Fatalf("normalizeCIDR(%q) = %s %s-%s, want %s %s-%s", tc.raw, cidr, start, end, tc.cidr, tc.start, tc.end)
+		}
+	}
+}
+
+func TestDedupeRanges_MergesWithinFamilyOnly(t *testing.T) {
+	ranges := []domain.BlacklistedRange{
+		{CIDR: "10.0.0.0/25"},
+		{CIDR: "10.0.0.128/25"},
+		{CIDR: "2001:db8::/33"},
+		{CIDR: "2001:db8:8000::/33"},
+		{CIDR: "2001:db8:1::/48"},
+		{CIDR: "255.255.255.255/32"},
+		{CIDR: "::/128"},
+	}
+
+	var got []string
+	for _, r := range dedupeRanges(ranges) {
+		got = append(got, r.CIDR)
+	}
+
+	want := []string{"10.0.0.0/24", "255.255.255.255/32", "::/128", "2001:db8::/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("dedupeRanges = %v, want %v", got, want)
+	}
+}
+
+func TestRangeToCIDRs_SplitsIPv6Ranges(t *testing.T) {
+	start := netip.MustParseAddr("2001:db8::1")
+	end := netip.MustParseAddr("2001:db8::4")
+
+	want := []string{"2001:db8::1/128", "2001:db8::2/127", "2001:db8::4/128"}
+	if got := rangeToCIDRs(start, end); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rangeToCIDRs = %v, want %v", got, want)
+	}
+}
+
+func TestRemoveProxiesByRanges_MatchesIPv6Proxies(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	ips := []string{"10.0.0.5", "2001:db8::5", "2001:db9::1"}
+	for _, ip := range ips {
+		proxy := domain.Proxy{Port: 8080}
+		if err := proxy.SetIP(ip); err != nil {
+			t.Fatalf("set ip %s: %v", ip, err)
+		}
+		if err := db.Create(&proxy).Error; err != nil {
+			t.Fatalf("create proxy %s: %v", ip, err)
+		}
+	}
+
+	_, start, end, err := normalizeCIDR("2001:db8::/32")
+	if err != nil {
+		t.Fatalf("normalizeCIDR: %v", err)
+	}
+
+	_, orphaned, err := RemoveProxiesByRanges(context.Background(), []domain.BlacklistedRange{{StartIP: start, EndIP: end}})
+	if err != nil {
+		t.Fatalf("RemoveProxiesByRanges returned error: %v", err)
+	}
+	if len(orphaned) != 1 || orphaned[0].GetIp() != "2001:db8::5" {
+		t.Fatalf("orphaned = %+v, want only 2001:db8::5", orphaned)
+	}
+
+	_, start, end, _ = normalizeCIDR("10.0.0.0/8")
+	_, orphaned, err = RemoveProxiesByRanges(context.Background(), []domain.BlacklistedRange{{StartIP: start, EndIP: end}})
+	if err != nil {
+		t.Fatalf("RemoveProxiesByRanges returned error: %v", err)
+	}
+	if len(orphaned) != 1 || orphaned[0].GetIp() != "10.0.0.5" {
+		t.Fatalf("orphaned = %+v, want only 10.0.0.5", orphaned)
+	}
+}
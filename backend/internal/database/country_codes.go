@@ -0,0 +1,291 @@
+package database
+
+import "strings"
+
+// countryNamesByCode maps ISO 3166-1 alpha-2 codes to the English country
+// names GeoLite stores on each proxy, lowercased.
+var countryNamesByCode = map[string]string{
+	"ad": "andorra",
+	"ae": "united arab emirates",
+	"af": "afghanistan",
+	"ag": "antigua and barbuda",
+	"ai": "anguilla",
+	"al": "albania",
+	"am": "armenia",
+	"ao": "angola",
+	"aq": "antarctica",
+	"ar": "argentina",
+	"as": "american samoa",
+	"at": "austria",
+	"au": "australia",
+	"aw": "aruba",
+	"ax": "åland",
+	"az": "azerbaijan",
+	"ba": "bosnia and herzegovina",
+	"bb": "barbados",
+	"bd": "bangladesh",
+	"be": "belgium",
+	"bf": "burkina faso",
+	"bg": "bulgaria",
+	"bh": "bahrain",
+	"bi": "burundi",
+	"bj": "benin",
+	"bl": "saint barthélemy",
+	"bm": "bermuda",
+	"bn": "brunei",
+	"bo": "bolivia",
+	"bq": "bonaire, sint eustatius, and saba",
+	"br": "brazil",
+	"bs": "bahamas",
+	"bt": "bhutan",
+	"bv": "bouvet island",
+	"bw": "botswana",
+	"by": "belarus",
+	"bz": "belize",
+	"ca": "canada",
+	"cc": "cocos (keeling) islands",
+	"cd": "dr congo",
+	"cf": "central african republic",
+	"cg": "congo republic",
+	"ch": "switzerland",
+	"ci": "ivory coast",
+	"ck": "cook islands",
+	"cl": "chile",
+	"cm": "cameroon",
+	"cn": "china",
+	"co": "colombia",
+	"cr": "costa rica",
+	"cu": "cuba",
+	"cv": "cabo verde",
+	"cw": "curaçao",
+	"cx": "christmas island",
+	"cy": "cyprus",
+	"cz": "czechia",
+	"de": "germany",
+	"dj": "djibouti",
+	"dk": "denmark",
+	"dm": "dominica",
+	"do": "dominican republic",
+	"dz": "algeria",
+	"ec": "ecuador",
+	"ee": "estonia",
+	"eg": "egypt",
+	"eh": "western sahara",
+	"er": "eritrea",
+	"es": "spain",
+	"et": "ethiopia",
+	"fi": "finland",
+	"fj": "fiji",
+	"fk": "falkland islands",
+	"fm": "federated states of micronesia",
+	"fo": "faroe islands",
+	"fr": "france",
+	"ga": "gabon",
+	"gb": "united kingdom",
+	"gd": "grenada",
+	"ge": "georgia",
+	"gf": "french guiana",
+	"gg": "guernsey",
+	"gh": "ghana",
+	"gi": "gibraltar",
+	"gl": "greenland",
+	"gm": "gambia",
+	"gn": "guinea",
+	"gp": "guadeloupe",
+	"gq": "equatorial guinea",
+	"gr": "greece",
+	"gs": "south georgia and the south sandwich islands",
+	"gt": "guatemala",
+	"gu": "guam",
+	"gw": "guinea-bissau",
+	"gy": "guyana",
+	"hk": "hong kong",
+	"hm": "heard and mcdonald islands",
+	"hn": "honduras",
+	"hr": "croatia",
+	"ht": "haiti",
+	"hu": "hungary",
+	"id": "indonesia",
+	"ie": "ireland",
+	"il": "israel",
+	"im": "isle of man",
+	"in": "india",
+	"io": "british indian ocean territory",
+	"iq": "iraq",
+	"ir": "iran",
+	"is": "iceland",
+	"it": "italy",
+	"je": "jersey",
+	"jm": "jamaica",
+	"jo": "jordan",
+	"jp": "japan",
+	"ke": "kenya",
+	"kg": "kyrgyzstan",
+	"kh": "cambodia",
+	"ki": "kiribati",
+	"km": "comoros",
+	"kn": "st kitts and nevis",
+	"kp": "north korea",
+	"kr": "south korea",
+	"kw": "kuwait",
+	"ky": "cayman islands",
+	"kz": "kazakhstan",
+	"la": "laos",
+	"lb": "lebanon",
+	"lc": "saint lucia",
+	"li": "liechtenstein",
+	"lk": "sri lanka",
+	"lr": "liberia",
+	"ls": "lesotho",
+	"lt": "lithuania",
+	"lu": "luxembourg",
+	"lv": "latvia",
+	"ly": "libya",
+	"ma": "morocco",
+	"mc": "monaco",
+	"md": "moldova",
+	"me": "montenegro",
+	"mf": "saint martin",
+	"mg": "madagascar",
+	"mh": "marshall islands",
+	"mk": "north macedonia",
+	"ml": "mali",
+	"mm": "myanmar",
+	"mn": "mongolia",
+	"mo": "macao",
+	"mp": "northern mariana islands",
+	"mq": "martinique",
+	"mr": "mauritania",
+	"ms": "montserrat",
+	"mt": "malta",
+	"mu": "mauritius",
+	"mv": "maldives",
+	"mw": "malawi",
+	"mx": "mexico",
+	"my": "malaysia",
+	"mz": "mozambique",
+	"na": "namibia",
+	"nc": "new caledonia",
+	"ne": "niger",
+	"nf": "norfolk island",
+	"ng": "nigeria",
+	"ni": "nicaragua",
+	"nl": "the netherlands",
+	"no": "norway",
+	"np": "nepal",
+	"nr": "nauru",
+	"nu": "niue",
+	"nz": "new zealand",
+	"om": "oman",
+	"pa": "panama",
+	"pe": "peru",
+	"pf": "french polynesia",
+	"pg": "papua new guinea",
+	"ph": "philippines",
+	"pk": "pakistan",
+	"pl": "poland",
+	"pm": "saint pierre and miquelon",
+	"pn": "pitcairn islands",
+	"pr": "puerto rico",
+	"ps": "palestine",
+	"pt": "portugal",
+	"pw": "palau",
+	"py": "paraguay",
+	"qa": "qatar",
+	"re": "réunion",
+	"ro": "romania",
+	"rs": "serbia",
+	"ru": "russia",
+	"rw": "rwanda",
+	"sa": "saudi arabia",
+	"sb": "solomon islands",
+	"sc": "seychelles",
+	"sd": "sudan",
+	"se": "sweden",
+	"sg": "singapore",
+	"sh": "saint helena",
+	"si": "slovenia",
+	"sj": "svalbard and jan mayen",
+	"sk": "slovakia",
+	"sl": "sierra leone",
+	"sm": "san marino",
+	"sn": "senegal",
+	"so": "somalia",
+	"sr": "suriname",
+	"ss": "south sudan",
+	"st": "são tomé and príncipe",
+	"sv": "el salvador",
+	"sx": "sint maarten",
+	"sy": "syria",
+	"sz": "eswatini",
+	"tc": "turks and caicos islands",
+	"td": "chad",
+	"tf": "french southern territories",
+	"tg": "togo",
+	"th": "thailand",
+	"tj": "tajikistan",
+	"tk": "tokelau",
+	"tl": "timor-leste",
+	"tm": "turkmenistan",
+	"tn": "tunisia",
+	"to": "tonga",
+	"tr": "türkiye",
+	"tt": "trinidad and tobago",
+	"tv": "tuvalu",
+	"tw": "taiwan",
+	"tz": "tanzania",
+	"ua": "ukraine",
+	"ug": "uganda",
+	"um": "u.s. outlying islands",
+	"us": "united states",
+	"uy": "uruguay",
+	"uz": "uzbekistan",
+	"va": "vatican city",
+	"vc": "st vincent and grenadines",
+	"ve": "venezuela",
+	"vg": "british virgin islands",
+	"vi": "u.s. virgin islands",
+	"vn": "vietnam",
+	"vu": "vanuatu",
+	"wf": "wallis and futuna",
+	"ws": "samoa",
+	"xk": "kosovo",
+	"ye": "yemen",
+	"yt": "mayotte",
+	"za": "south africa",
+	"zm": "zambia",
+	"zw": "zimbabwe",
+}
+
+// countryNameAliases lists names older GeoLite databases used for a country,
+// so proxies checked before a rename still match its code.
+var countryNameAliases = map[string][]string{
+	"cv": {"cape verde"},
+	"cz": {"czech republic"},
+	"jo": {"hashemite kingdom of jordan"},
+	"lt": {"republic of lithuania"},
+	"md": {"republic of moldova"},
+	"mk": {"macedonia"},
+	"nl": {"netherlands"},
+	"sz": {"swaziland"},
+	"tr": {"turkey"},
+}
+
+// resolveCountryNames returns the lowercased names stored for a country
+// value. Two-letter values are read as ISO codes; ok is false for an
+// unknown code. Any other value is taken to be a country name.
+func resolveCountryNames(value string) (names []string, ok bool) {
+	country := strings.ToLower(strings.TrimSpace(value))
+	if country == "" {
+		return nil, true
+	}
+	if len(country) != 2 {
+		return []string{country}, true
+	}
+
+	name, known := countryNamesByCode[country]
+	if !known {
+		return []string{country}, false
+	}
+	return append([]string{name}, countryNameAliases[country]...), true
+}
@@ -151,6 +151,7 @@ func defaultMigrations() []any {
 		domain.ProxyReputation{},
 		domain.UserProxy{},
 		domain.RotatingProxy{},
+		domain.RotatingProxyTraffic{},
 		domain.ProxyHistory{},
 		domain.ProxySnapshot{},
 		domain.ProxyStatistic{},
@@ -161,6 +162,8 @@ func defaultMigrations() []any {
 		domain.UserScrapeSite{},
 		domain.ProxyScrapeSite{},
 		domain.Protocol{},
+		domain.TargetCheck{},
+		domain.TargetCheckResult{},
 	}
 }
 
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"magpie/internal/domain"
+)
+
+func TestGetProxyDetail_ExposesLatestExitIP(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, protocol, proxies := seedTargetCheckProxies(t, db, 1)
+	proxy := proxies[0]
+
+	var judge domain.Judge
+	if err := db.First(&judge).Error; err != nil {
+		t.Fatalf("load judge: %v", err)
+	}
+
+	// An alive check recorded the exit IP, a later failed check did not.
+	stats := []domain.ProxyStatistic{
+		{Alive: true, Attempt: 1, ProtocolID: protocol.ID, ProxyID: proxy.ID, JudgeID: judge.ID, ExitIP: "198.51.100.7", ExitCountry: "France", CreatedAt: time.Now().Add(time.Minute)},
+		{Alive: false, Attempt: 1, ProtocolID: protocol.ID, ProxyID: proxy.ID, JudgeID: judge.ID, CreatedAt: time.Now().Add(2 * time.Minute)},
+	}
+	if err := db.Create(&stats).Error; err != nil {
+		t.Fatalf("create statistics: %v", err)
+	}
+
+	var stored string
+	if err := db.Table("proxy_statistics").Select("exit_ip").Where("id = ?", stats[0].ID).Scan(&stored).Error; err != nil {
+		t.Fatalf("load stored exit ip: %v", err)
+	}
+	if stored == "" || strings.Contains(stored, "198.51.100.7") {
+		t.Fatalf("expected the exit ip to be stored encrypted, got %q", stored)
+	}
+
+	detail, err := GetProxyDetail(user.ID, proxy.ID)
+	if err != nil {
+		t.Fatalf("get proxy detail: %v", err)
+	}
+	if detail == nil {
+		t.Fatal("expected proxy detail, got nil")
+	}
+	if detail.Country != "Germany" || detail.ExitIP != "198.51.100.7" || detail.ExitCountry != "France" || !detail.ExitChanged {
+		t.Fatalf("unexpected entry/exit details: %+v", detail)
+	}
+	if detail.LatestStatistic == nil || detail.LatestStatistic.Alive || detail.LatestStatistic.ExitIP != "" {
+		t.Fatalf("expected the latest statistic to be the failed check, got %+v", detail.LatestStatistic)
+	}
+}
@@ -177,7 +177,7 @@ func clamp(value, min, max int) int {
 func insertProxies(tx *gorm.DB, proxies []domain.Proxy, batchSize int) error {
 	return tx.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "hash"}},
-		DoUpdates: clause.AssignmentColumns([]string{"hash", "ip_hash", "ip_int"}), // To get the ids from duplicates
+		DoUpdates: clause.AssignmentColumns([]string{"hash", "ip_hash", "ip_int", "ip_v6"}), // To get the ids from duplicates
 	}).CreateInBatches(proxies, batchSize).Error
 }
 
@@ -836,9 +836,41 @@ func GetProxyDetail(userId uint, proxyId uint64) (*dto.ProxyDetail, error) {
 
 	detail.Reputation = mapReputationsToBreakdown(proxy.Reputations)
 
+	if err := attachExitIP(detail, proxy); err != nil {
+		return nil, err
+	}
+
+	targetChecks, err := getTargetCheckResults(userId, proxy.ID)
+	if err != nil {
+		return nil, err
+	}
+	detail.TargetChecks = targetChecks
+
 	return detail, nil
 }
 
+// attachExitIP fills the exit IP and country from the latest check that
+// recorded one. Failed checks carry no exit IP, so this is not necessarily the
+// latest statistic.
+func attachExitIP(detail *dto.ProxyDetail, proxy domain.Proxy) error {
+	var stat domain.ProxyStatistic
+	err := DB.
+		Where("proxy_id = ? AND exit_ip <> ''", proxy.ID).
+		Order("created_at DESC").
+		First(&stat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	detail.ExitIP = stat.ExitIP
+	detail.ExitCountry = normaliseDisplayValue(stat.ExitCountry, "Unknown")
+	detail.ExitChanged = stat.ExitIP != proxy.GetIp()
+	return nil
+}
+
 func GetProxyStatistics(userId uint, proxyId uint64, limit int) ([]dto.ProxyStatistic, error) {
 	if proxyId == 0 {
 		return []dto.ProxyStatistic{}, nil
@@ -923,6 +955,8 @@ func mapProxyStatistic(stat *domain.ProxyStatistic) dto.ProxyStatistic {
 		Protocol:       protocol,
 		AnonymityLevel: anonymity,
 		Judge:          judge,
+		ExitIP:         stat.ExitIP,
+		ExitCountry:    stat.ExitCountry,
 		CreatedAt:      stat.CreatedAt,
 	}
 }
@@ -1204,6 +1238,7 @@ func GetProxiesForExport(userID uint, settings dto.ExportSettings) ([]domain.Pro
 		Where("user_proxies.user_id = ?", userID)
 
 	baseQuery = applyExportReputationFilters(baseQuery, settings)
+	baseQuery = applyExportTargetCheckFilters(baseQuery, userID, settings)
 
 	if settings.ProxyStatus == "alive" || settings.ProxyStatus == "dead" {
 		isAlive := settings.ProxyStatus == "alive"
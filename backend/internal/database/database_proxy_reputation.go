@@ -66,6 +66,7 @@ func RecalculateProxyReputations(ctx context.Context, proxyIDs []uint64) error {
 		}
 	}
 
+	rotatorPools.invalidate()
 	return nil
 }
 
@@ -26,6 +26,13 @@ var (
 	ErrRotatingProxyAuthUsernameNeeded = errors.New("authentication username is required when authentication is enabled")
 	ErrRotatingProxyAuthPasswordNeeded = errors.New("authentication password is required when authentication is enabled")
 	ErrRotatingProxyPortExhausted      = errors.New("no available ports for rotating proxies")
+	ErrRotatingProxyListenProtocol     = errors.New("listen protocol must be http, https or socks5")
+	ErrRotatingProxyUpstreamGone       = errors.New("upstream proxy is no longer available for this rotating proxy")
+	ErrRotatingProxyStrategyInvalid    = errors.New("rotation strategy is not supported")
+	ErrRotatingProxyEstimatedType      = errors.New("estimated type must be residential, datacenter or isp")
+	ErrRotatingProxyAnonymityLevel     = errors.New("anonymity level must be elite, anonymous or transparent")
+	ErrRotatingProxyCountryInvalid     = errors.New("countries must be country names or ISO 3166-1 alpha-2 codes")
+	ErrRotatingProxyClientCIDRInvalid  = errors.New("allowed clients must be IP addresses or CIDR ranges")
 )
 
 var (
@@ -37,7 +44,44 @@ var (
 	}
 )
 
-const rotatingProxyNameMaxLength = 120
+const (
+	rotatingProxyNameMaxLength = 120
+
+	DefaultRotatingProxySessionTTLSeconds = 600
+	maxRotatingProxySessionTTLSeconds     = 86400
+
+	DefaultRotatingProxyFailoverAttempts        = 3
+	maxRotatingProxyFailoverAttempts            = 10
+	DefaultRotatingProxyFailoverTimeoutSeconds  = 30
+	maxRotatingProxyFailoverTimeoutSeconds      = 300
+	DefaultRotatingProxyFailoverCooldownSeconds = 60
+	maxRotatingProxyFailoverCooldownSeconds     = 3600
+	DefaultRotatingProxyHostBanCooldownSeconds  = 900
+	maxRotatingProxyHostBanCooldownSeconds      = 86400
+
+	maxRotatingProxyRotationIntervalSeconds = 86400
+)
+
+// RotatingProxySelection narrows the upstreams a single rotation may pick.
+type RotatingProxySelection struct {
+	// ExcludeProxyIDs are never selected.
+	ExcludeProxyIDs []uint64
+	// AvoidProxyIDs are only selected when no other upstream is left.
+	AvoidProxyIDs []uint64
+	// Countries, EstimatedTypes, MaxResponseTimeMs and MinAnonymityLevel
+	// narrow the candidates for a single request on top of the rotator's own
+	// filters.
+	Countries         []string
+	EstimatedTypes    []string
+	MaxResponseTimeMs uint16
+	MinAnonymityLevel string
+}
+
+const (
+	RotatingProxyListenHTTP   = "http"
+	RotatingProxyListenHTTPS  = "https"
+	RotatingProxyListenSOCKS5 = "socks5"
+)
 
 func CreateRotatingProxy(userID uint, payload dto.RotatingProxyCreateRequest) (*dto.RotatingProxy, error) {
 	if DB == nil {
@@ -57,6 +101,65 @@ func CreateRotatingProxy(userID uint, payload dto.RotatingProxyCreateRequest) (*
 		return nil, ErrRotatingProxyProtocolMissing
 	}
 
+	listenProtocol, err := normalizeRotatorListenProtocol(payload.ListenProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, err := normalizeRotationStrategy(payload.RotationStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	estimatedTypes, err := normalizeRotatorEstimatedTypes(payload.EstimatedTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	minAnonymity, err := normalizeRotatorAnonymityLevel(payload.MinAnonymityLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedClients, err := NormalizeRotatorClientCIDRs(payload.AllowedClientCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	stripHeaders, err := NormalizeRotatorStripHeaders(payload.StripRequestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	setHeaders, err := NormalizeRotatorSetHeaders(payload.SetRequestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgents, err := NormalizeRotatorUserAgents(payload.UserAgentPool)
+	if err != nil {
+		return nil, err
+	}
+
+	routingRules, err := NormalizeRotatorRoutingRules(payload.RoutingRules)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedCountries, err := normalizeRotatorCountries(payload.AllowedCountries)
+	if err != nil {
+		return nil, err
+	}
+	blockedCountries, err := normalizeRotatorCountries(payload.BlockedCountries)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCertificate, tlsPrivateKey, err := resolveRotatorCertificate(listenProtocol, payload.TLSCertificate, payload.TLSPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
 	if payload.AuthRequired {
 		if strings.TrimSpace(payload.AuthUsername) == "" {
 			return nil, ErrRotatingProxyAuthUsernameNeeded
@@ -66,9 +169,16 @@ func CreateRotatingProxy(userID uint, payload dto.RotatingProxyCreateRequest) (*
 		}
 	}
 
+	sessionTTL := normalizeRotatorSessionTTL(payload.SessionTTLSeconds)
+	failoverAttempts := normalizeRotatorFailoverAttempts(payload.FailoverAttempts)
+	failoverTimeout := normalizeRotatorFailoverTimeout(payload.FailoverTimeoutSeconds)
+	failoverCooldown := normalizeRotatorFailoverCooldown(payload.FailoverCooldownSeconds)
+	hostBanCooldown := normalizeRotatorHostBanCooldown(payload.HostBanCooldownSeconds)
+	rotationInterval := normalizeRotatorRotationInterval(payload.RotationIntervalSeconds)
+
 	var result *dto.RotatingProxy
 
-	err := DB.Transaction(func(tx *gorm.DB) error {
+	err = DB.Transaction(func(tx *gorm.DB) error {
 		var user domain.User
 		if err := tx.First(&user, userID).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -88,16 +198,47 @@ func CreateRotatingProxy(userID uint, payload dto.RotatingProxyCreateRequest) (*
 			return ErrRotatingProxyProtocolDenied
 		}
 
+		targetChecks, err := normalizeRotatorTargetChecks(tx, userID, payload.TargetCheckIDs)
+		if err != nil {
+			return err
+		}
+
 		filters := sanitizeRotatorReputationLabels(payload.ReputationLabels)
 
 		entity := domain.RotatingProxy{
-			UserID:           userID,
-			Name:             name,
-			ProtocolID:       protocol.ID,
-			AuthRequired:     payload.AuthRequired,
-			AuthUsername:     strings.TrimSpace(payload.AuthUsername),
-			AuthPassword:     payload.AuthPassword,
-			ReputationLabels: domain.StringList(filters),
+			UserID:                    userID,
+			Name:                      name,
+			ProtocolID:                protocol.ID,
+			ListenProtocol:            listenProtocol,
+			AuthRequired:              payload.AuthRequired,
+			SessionTTLSeconds:         sessionTTL,
+			RotationStrategy:          strategy,
+			RotationIntervalSeconds:   rotationInterval,
+			FailoverAttempts:          failoverAttempts,
+			FailoverTimeoutSeconds:    failoverTimeout,
+			FailoverCooldownSeconds:   failoverCooldown,
+			HostBanCooldownSeconds:    hostBanCooldown,
+			RateLimitPerSecond:        payload.RateLimitPerSecond,
+			MaxConcurrentConnections:  payload.MaxConcurrentConnections,
+			MaxConnectionsPerUpstream: payload.MaxConnectionsPerUpstream,
+			MaxBandwidthKBPerSecond:   payload.MaxBandwidthKBPerSecond,
+			AuthUsername:              strings.TrimSpace(payload.AuthUsername),
+			AuthPassword:              payload.AuthPassword,
+			AllowedClientCIDRs:        domain.StringList(allowedClients),
+			TLSCertificate:            tlsCertificate,
+			TLSPrivateKey:             tlsPrivateKey,
+			StripRequestHeaders:       domain.StringList(stripHeaders),
+			SetRequestHeaders:         domain.StringMap(setHeaders),
+			UserAgentPool:             domain.StringList(userAgents),
+			ExposeUpstreamHeader:      payload.ExposeUpstreamHeader,
+			RoutingRules:              routingRules,
+			ReputationLabels:          domain.StringList(filters),
+			AllowedCountries:          domain.StringList(allowedCountries),
+			BlockedCountries:          domain.StringList(blockedCountries),
+			EstimatedTypes:            domain.StringList(estimatedTypes),
+			MaxResponseTimeMs:         payload.MaxResponseTimeMs,
+			MinAnonymityLevel:         minAnonymity,
+			TargetCheckIDs:            domain.UintList(targetChecks),
 		}
 
 		listenPort, err := allocateListenPort(tx)
@@ -113,23 +254,13 @@ func CreateRotatingProxy(userID uint, payload dto.RotatingProxyCreateRequest) (*
 			return err
 		}
 
-		aliveProxies, err := aliveProxiesForProtocol(tx, userID, protocol.ID, filters)
+		aliveProxies, err := aliveProxiesForProtocol(tx, userID, protocol.ID, rotatorFilterFromEntity(entity))
 		if err != nil {
 			return err
 		}
 
-		result = &dto.RotatingProxy{
-			ID:               entity.ID,
-			Name:             entity.Name,
-			Protocol:         protocol.Name,
-			AliveProxyCount:  len(aliveProxies),
-			ListenPort:       entity.ListenPort,
-			AuthRequired:     entity.AuthRequired,
-			AuthUsername:     entity.AuthUsername,
-			AuthPassword:     strings.TrimSpace(payload.AuthPassword),
-			ReputationLabels: filters,
-			CreatedAt:        entity.CreatedAt,
-		}
+		result = rotatingProxyToDTO(entity, protocol.Name, len(aliveProxies))
+		result.AuthPassword = strings.TrimSpace(payload.AuthPassword)
 
 		entity.AuthPassword = ""
 
@@ -143,6 +274,257 @@ func CreateRotatingProxy(userID uint, payload dto.RotatingProxyCreateRequest) (*
 	return result, nil
 }
 
+// UpdateRotatingProxy applies the given changes to a rotator. The listen port
+// and listen protocol cannot be changed. The rotator's in-memory pool is
+// reloaded so new filters apply to the next rotation.
+func UpdateRotatingProxy(userID uint, rotatingProxyID uint64, payload dto.RotatingProxyUpdateRequest) (*dto.RotatingProxy, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
+
+	var result *dto.RotatingProxy
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var entity domain.RotatingProxy
+		if err := tx.
+			Preload("Protocol").
+			Where("user_id = ? AND id = ?", userID, rotatingProxyID).
+			First(&entity).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRotatingProxyNotFound
+			}
+			return err
+		}
+
+		if err := applyRotatingProxyUpdate(&entity, payload); err != nil {
+			return err
+		}
+		if err := applyRotatorCertificateUpdate(&entity, payload); err != nil {
+			return err
+		}
+		if payload.TargetCheckIDs != nil {
+			targetChecks, err := normalizeRotatorTargetChecks(tx, userID, *payload.TargetCheckIDs)
+			if err != nil {
+				return err
+			}
+			entity.TargetCheckIDs = domain.UintList(targetChecks)
+		}
+
+		if payload.Protocol != nil {
+			protocolName := strings.ToLower(strings.TrimSpace(*payload.Protocol))
+			if protocolName == "" {
+				return ErrRotatingProxyProtocolMissing
+			}
+			if protocolName != entity.Protocol.Name {
+				var user domain.User
+				if err := tx.First(&user, userID).Error; err != nil {
+					return err
+				}
+				protocol, err := fetchProtocolByName(tx, protocolName)
+				if err != nil {
+					if errors.Is(err, gorm.ErrRecordNotFound) {
+						return ErrRotatingProxyProtocolDenied
+					}
+					return err
+				}
+				if !isProtocolEnabledForUser(user, protocolName) {
+					return ErrRotatingProxyProtocolDenied
+				}
+				entity.ProtocolID = protocol.ID
+				entity.Protocol = protocol
+			}
+		}
+
+		if entity.AuthRequired {
+			if entity.AuthUsername == "" {
+				return ErrRotatingProxyAuthUsernameNeeded
+			}
+			if entity.AuthPassword == "" {
+				return ErrRotatingProxyAuthPasswordNeeded
+			}
+		}
+
+		// The rotation position is owned by the in-memory pool and the listen
+		// settings by the running server, so neither is written here.
+		if err := tx.
+			Omit(clause.Associations, "ListenPort", "ListenProtocol", "LastProxyID", "LastRotationAt", "CreatedAt").
+			Save(&entity).Error; err != nil {
+			if isUniqueConstraintError(err) {
+				return ErrRotatingProxyNameConflict
+			}
+			return err
+		}
+
+		aliveProxies, err := aliveProxiesForProtocol(tx, userID, entity.ProtocolID, rotatorFilterFromEntity(entity))
+		if err != nil {
+			return err
+		}
+
+		result = rotatingProxyToDTO(entity, entity.Protocol.Name, len(aliveProxies))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rotatorPools.reload(rotatingProxyID)
+	return result, nil
+}
+
+func applyRotatingProxyUpdate(entity *domain.RotatingProxy, payload dto.RotatingProxyUpdateRequest) error {
+	if payload.Name != nil {
+		name := strings.TrimSpace(*payload.Name)
+		if name == "" {
+			return ErrRotatingProxyNameRequired
+		}
+		if len(name) > rotatingProxyNameMaxLength {
+			return ErrRotatingProxyNameTooLong
+		}
+		entity.Name = name
+	}
+
+	if payload.AuthRequired != nil {
+		entity.AuthRequired = *payload.AuthRequired
+	}
+	if payload.AuthUsername != nil {
+		entity.AuthUsername = strings.TrimSpace(*payload.AuthUsername)
+	}
+	if payload.AuthPassword != nil && *payload.AuthPassword != "" {
+		entity.AuthPassword = *payload.AuthPassword
+	}
+	if payload.AllowedClientCIDRs != nil {
+		allowed, err := NormalizeRotatorClientCIDRs(*payload.AllowedClientCIDRs)
+		if err != nil {
+			return err
+		}
+		entity.AllowedClientCIDRs = domain.StringList(allowed)
+	}
+	if err := applyRotatorHeaderPolicyUpdate(entity, payload); err != nil {
+		return err
+	}
+	if payload.RoutingRules != nil {
+		rules, err := NormalizeRotatorRoutingRules(*payload.RoutingRules)
+		if err != nil {
+			return err
+		}
+		entity.RoutingRules = rules
+	}
+
+	if payload.SessionTTLSeconds != nil {
+		entity.SessionTTLSeconds = normalizeRotatorSessionTTL(*payload.SessionTTLSeconds)
+	}
+	if payload.RotationStrategy != nil {
+		strategy, err := normalizeRotationStrategy(*payload.RotationStrategy)
+		if err != nil {
+			return err
+		}
+		entity.RotationStrategy = strategy
+	}
+	if payload.RotationIntervalSeconds != nil {
+		entity.RotationIntervalSeconds = normalizeRotatorRotationInterval(*payload.RotationIntervalSeconds)
+	}
+	if payload.FailoverAttempts != nil {
+		entity.FailoverAttempts = normalizeRotatorFailoverAttempts(*payload.FailoverAttempts)
+	}
+	if payload.FailoverTimeoutSeconds != nil {
+		entity.FailoverTimeoutSeconds = normalizeRotatorFailoverTimeout(*payload.FailoverTimeoutSeconds)
+	}
+	if payload.FailoverCooldownSeconds != nil {
+		entity.FailoverCooldownSeconds = normalizeRotatorFailoverCooldown(*payload.FailoverCooldownSeconds)
+	}
+	if payload.HostBanCooldownSeconds != nil {
+		entity.HostBanCooldownSeconds = normalizeRotatorHostBanCooldown(*payload.HostBanCooldownSeconds)
+	}
+
+	if payload.RateLimitPerSecond != nil {
+		entity.RateLimitPerSecond = *payload.RateLimitPerSecond
+	}
+	if payload.MaxConcurrentConnections != nil {
+		entity.MaxConcurrentConnections = *payload.MaxConcurrentConnections
+	}
+	if payload.MaxConnectionsPerUpstream != nil {
+		entity.MaxConnectionsPerUpstream = *payload.MaxConnectionsPerUpstream
+	}
+	if payload.MaxBandwidthKBPerSecond != nil {
+		entity.MaxBandwidthKBPerSecond = *payload.MaxBandwidthKBPerSecond
+	}
+
+	if payload.ReputationLabels != nil {
+		entity.ReputationLabels = domain.StringList(sanitizeRotatorReputationLabels(*payload.ReputationLabels))
+	}
+	if payload.AllowedCountries != nil {
+		allowedCountries, err := normalizeRotatorCountries(*payload.AllowedCountries)
+		if err != nil {
+			return err
+		}
+		entity.AllowedCountries = domain.StringList(allowedCountries)
+	}
+	if payload.BlockedCountries != nil {
+		blockedCountries, err := normalizeRotatorCountries(*payload.BlockedCountries)
+		if err != nil {
+			return err
+		}
+		entity.BlockedCountries = domain.StringList(blockedCountries)
+	}
+	if payload.EstimatedTypes != nil {
+		estimatedTypes, err := normalizeRotatorEstimatedTypes(*payload.EstimatedTypes)
+		if err != nil {
+			return err
+		}
+		entity.EstimatedTypes = domain.StringList(estimatedTypes)
+	}
+	if payload.MaxResponseTimeMs != nil {
+		entity.MaxResponseTimeMs = *payload.MaxResponseTimeMs
+	}
+	if payload.MinAnonymityLevel != nil {
+		level, err := normalizeRotatorAnonymityLevel(*payload.MinAnonymityLevel)
+		if err != nil {
+			return err
+		}
+		entity.MinAnonymityLevel = level
+	}
+
+	return nil
+}
+
+func rotatingProxyToDTO(entity domain.RotatingProxy, protocolName string, aliveCount int) *dto.RotatingProxy {
+	result := &dto.RotatingProxy{
+		ID:                        entity.ID,
+		Name:                      entity.Name,
+		Protocol:                  protocolName,
+		AliveProxyCount:           aliveCount,
+		ListenPort:                entity.ListenPort,
+		ListenProtocol:            entity.ListenProtocol,
+		AuthRequired:              entity.AuthRequired,
+		SessionTTLSeconds:         entity.SessionTTLSeconds,
+		RotationStrategy:          entity.RotationStrategy,
+		RotationIntervalSeconds:   entity.RotationIntervalSeconds,
+		FailoverAttempts:          entity.FailoverAttempts,
+		FailoverTimeoutSeconds:    entity.FailoverTimeoutSeconds,
+		FailoverCooldownSeconds:   entity.FailoverCooldownSeconds,
+		HostBanCooldownSeconds:    entity.HostBanCooldownSeconds,
+		RateLimitPerSecond:        entity.RateLimitPerSecond,
+		MaxConcurrentConnections:  entity.MaxConcurrentConnections,
+		MaxConnectionsPerUpstream: entity.MaxConnectionsPerUpstream,
+		MaxBandwidthKBPerSecond:   entity.MaxBandwidthKBPerSecond,
+		AuthUsername:              entity.AuthUsername,
+		AuthPassword:              entity.AuthPassword,
+		AllowedClientCIDRs:        []string(entity.AllowedClientCIDRs),
+		ReputationLabels:          []string(entity.ReputationLabels),
+		AllowedCountries:          []string(entity.AllowedCountries),
+		BlockedCountries:          []string(entity.BlockedCountries),
+		EstimatedTypes:            []string(entity.EstimatedTypes),
+		MaxResponseTimeMs:         entity.MaxResponseTimeMs,
+		MinAnonymityLevel:         entity.MinAnonymityLevel,
+		TargetCheckIDs:            entity.TargetCheckIDs.Clone(),
+		CreatedAt:                 entity.CreatedAt,
+	}
+	applyRotatorCertificateInfo(result, entity.TLSCertificate)
+	applyRotatorHeaderPolicyInfo(result, entity)
+	result.RoutingRules = routingRulesToDTO(entity.RoutingRules)
+	return result
+}
+
 func ListRotatingProxies(userID uint) ([]dto.RotatingProxy, error) {
 	if DB == nil {
 		return nil, fmt.Errorf("rotating proxy: database connection was not initialised")
@@ -167,12 +549,18 @@ func ListRotatingProxies(userID uint) ([]dto.RotatingProxy, error) {
 
 	for _, row := range rows {
 		protocolName := row.Protocol.Name
-		labels := sanitizeRotatorReputationLabels(row.ReputationLabels.Clone())
-		proxies, err := getAliveProxiesCached(userID, row.ProtocolID, labels, protocolCache)
+		filter := rotatorFilterFromEntity(row)
+		proxies, err := getAliveProxiesCached(userID, row.ProtocolID, filter, protocolCache)
 		if err != nil {
 			return nil, err
 		}
 
+		if pool := rotatorPools.lookup(row.ID); pool != nil {
+			if lastProxyID, lastRotationAt := pool.rotationState(); lastProxyID != nil {
+				row.LastProxyID, row.LastRotationAt = lastProxyID, lastRotationAt
+			}
+		}
+
 		lastProxy := ""
 		if row.LastProxyID != nil {
 			lastProxy, err = getProxyAddressCached(userID, *row.LastProxyID, lastProxyCache)
@@ -181,20 +569,43 @@ func ListRotatingProxies(userID uint) ([]dto.RotatingProxy, error) {
 			}
 		}
 
-		result = append(result, dto.RotatingProxy{
-			ID:               row.ID,
-			Name:             row.Name,
-			Protocol:         protocolName,
-			AliveProxyCount:  len(proxies),
-			ListenPort:       row.ListenPort,
-			AuthRequired:     row.AuthRequired,
-			AuthUsername:     row.AuthUsername,
-			AuthPassword:     row.AuthPassword,
-			LastRotationAt:   row.LastRotationAt,
-			LastServedProxy:  lastProxy,
-			ReputationLabels: labels,
-			CreatedAt:        row.CreatedAt,
-		})
+		item := dto.RotatingProxy{
+			ID:                        row.ID,
+			Name:                      row.Name,
+			Protocol:                  protocolName,
+			AliveProxyCount:           len(proxies),
+			ListenPort:                row.ListenPort,
+			ListenProtocol:            NormalizeRotatorListenProtocol(row.ListenProtocol),
+			AuthRequired:              row.AuthRequired,
+			SessionTTLSeconds:         normalizeRotatorSessionTTL(row.SessionTTLSeconds),
+			RotationStrategy:          NormalizeRotationStrategy(row.RotationStrategy),
+			RotationIntervalSeconds:   normalizeRotatorRotationInterval(row.RotationIntervalSeconds),
+			FailoverAttempts:          normalizeRotatorFailoverAttempts(row.FailoverAttempts),
+			FailoverTimeoutSeconds:    normalizeRotatorFailoverTimeout(row.FailoverTimeoutSeconds),
+			FailoverCooldownSeconds:   normalizeRotatorFailoverCooldown(row.FailoverCooldownSeconds),
+			HostBanCooldownSeconds:    normalizeRotatorHostBanCooldown(row.HostBanCooldownSeconds),
+			RateLimitPerSecond:        row.RateLimitPerSecond,
+			MaxConcurrentConnections:  row.MaxConcurrentConnections,
+			MaxConnectionsPerUpstream: row.MaxConnectionsPerUpstream,
+			MaxBandwidthKBPerSecond:   row.MaxBandwidthKBPerSecond,
+			AuthUsername:              row.AuthUsername,
+			AuthPassword:              row.AuthPassword,
+			AllowedClientCIDRs:        []string(row.AllowedClientCIDRs),
+			LastRotationAt:            row.LastRotationAt,
+			LastServedProxy:           lastProxy,
+			ReputationLabels:          filter.ReputationLabels,
+			AllowedCountries:          filter.AllowedCountries,
+			BlockedCountries:          filter.BlockedCountries,
+			EstimatedTypes:            filter.EstimatedTypes,
+			MaxResponseTimeMs:         filter.MaxResponseTimeMs,
+			MinAnonymityLevel:         filter.MinAnonymityLevel,
+			TargetCheckIDs:            filter.TargetCheckIDs,
+			CreatedAt:                 row.CreatedAt,
+		}
+		applyRotatorCertificateInfo(&item, row.TLSCertificate)
+		applyRotatorHeaderPolicyInfo(&item, row)
+		item.RoutingRules = routingRulesToDTO(row.RoutingRules)
+		result = append(result, item)
 	}
 
 	return result, nil
@@ -212,83 +623,85 @@ func DeleteRotatingProxy(userID uint, rotatingProxyID uint64) error {
 	if res.RowsAffected == 0 {
 		return ErrRotatingProxyNotFound
 	}
+
+	rotatorPools.forget(rotatingProxyID)
+	rotatorTraffic.forget(rotatingProxyID)
 	return nil
 }
 
 func GetNextRotatingProxy(userID uint, rotatingProxyID uint64) (*dto.RotatingProxyNext, error) {
+	return GetNextRotatingProxyWithSelection(userID, rotatingProxyID, RotatingProxySelection{})
+}
+
+// GetNextRotatingProxyWithSelection advances the rotation like
+// GetNextRotatingProxy while honouring the given selection constraints.
+// Candidates come from the rotator's in-memory pool; the rotation position is
+// persisted asynchronously by FlushRotatingProxyState.
+func GetNextRotatingProxyWithSelection(userID uint, rotatingProxyID uint64, selection RotatingProxySelection) (*dto.RotatingProxyNext, error) {
 	if DB == nil {
 		return nil, fmt.Errorf("rotating proxy: database connection was not initialised")
 	}
 
-	var result *dto.RotatingProxyNext
-
-	err := DB.Transaction(func(tx *gorm.DB) error {
-		var entity domain.RotatingProxy
-		if err := tx.
-			Preload("Protocol").
-			Clauses(clause.Locking{Strength: "UPDATE"}).
-			Where("user_id = ? AND id = ?", userID, rotatingProxyID).
-			First(&entity).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return ErrRotatingProxyNotFound
-			}
-			return err
-		}
-
-		labels := sanitizeRotatorReputationLabels(entity.ReputationLabels.Clone())
-		proxies, err := aliveProxiesForProtocol(tx, userID, entity.ProtocolID, labels)
-		if err != nil {
-			return err
-		}
-
-		if len(proxies) == 0 {
-			return ErrRotatingProxyNoAliveProxies
-		}
-
-		selected := selectNextProxy(proxies, entity.LastProxyID)
+	pool, err := rotatorPools.acquire(userID, rotatingProxyID)
+	if err != nil {
+		return nil, err
+	}
 
-		now := time.Now()
+	return pool.next(selection)
+}
 
-		updatePayload := map[string]interface{}{
-			"last_proxy_id":    selected.ID,
-			"last_rotation_at": now,
-		}
+// RotateRotatingProxyNow forces the rotator onto another upstream, ignoring its
+// rotation interval.
+func RotateRotatingProxyNow(userID uint, rotatingProxyID uint64) (*dto.RotatingProxyNext, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
 
-		if err := tx.Model(&domain.RotatingProxy{}).
-			Where("id = ?", entity.ID).
-			Updates(updatePayload).Error; err != nil {
-			return err
-		}
+	pool, err := rotatorPools.acquire(userID, rotatingProxyID)
+	if err != nil {
+		return nil, err
+	}
 
-		result = &dto.RotatingProxyNext{
-			ProxyID:  selected.ID,
-			IP:       selected.GetIp(),
-			Port:     selected.Port,
-			Username: selected.Username,
-			Password: selected.Password,
-			HasAuth:  selected.HasAuth(),
-			Protocol: entity.Protocol.Name,
-		}
+	return pool.rotate()
+}
 
-		return nil
-	})
+// GetRotatingProxyUpstream returns the given proxy as the rotator's upstream if
+// it is still alive and matches the rotator's filters as well as the narrowing
+// of selection. It does not advance the rotation and is used to keep sticky
+// sessions on the same upstream.
+func GetRotatingProxyUpstream(userID uint, rotatingProxyID uint64, proxyID uint64, selection RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
 
+	pool, err := rotatorPools.acquire(userID, rotatingProxyID)
 	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	return pool.upstream(proxyID, selection)
+}
+
+func buildRotatingProxyNext(selected domain.Proxy, protocolName string) *dto.RotatingProxyNext {
+	return &dto.RotatingProxyNext{
+		ProxyID:  selected.ID,
+		IP:       selected.GetIp(),
+		Port:     selected.Port,
+		Username: selected.Username,
+		Password: selected.Password,
+		HasAuth:  selected.HasAuth(),
+		Protocol: protocolName,
+	}
 }
 
-func getAliveProxiesCached(userID uint, protocolID int, labels []string, cache map[string][]domain.Proxy) ([]domain.Proxy, error) {
-	normLabels := sanitizeRotatorReputationLabels(labels)
-	cacheKey := buildReputationCacheKey(protocolID, normLabels)
+func getAliveProxiesCached(userID uint, protocolID int, filter rotatorProxyFilter, cache map[string][]domain.Proxy) ([]domain.Proxy, error) {
+	cacheKey := filter.cacheKey(protocolID)
 
 	if proxies, ok := cache[cacheKey]; ok {
 		return proxies, nil
 	}
 
-	proxies, err := aliveProxiesForProtocol(DB, userID, protocolID, normLabels)
+	proxies, err := aliveProxiesForProtocol(DB, userID, protocolID, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -316,16 +729,25 @@ func getProxyAddressCached(userID uint, proxyID uint64, cache map[uint64]string)
 	return address, nil
 }
 
-func aliveProxiesForProtocol(tx *gorm.DB, userID uint, protocolID int, labels []string) ([]domain.Proxy, error) {
-	filterLabels := sanitizeRotatorReputationLabels(labels)
+func aliveProxiesForProtocol(tx *gorm.DB, userID uint, protocolID int, filter rotatorProxyFilter) ([]domain.Proxy, error) {
+	var proxies []domain.Proxy
+	err := aliveProxiesQuery(tx, userID, protocolID, filter).
+		Order("proxies.id").
+		Find(&proxies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return proxies, nil
+}
 
+func aliveProxiesQuery(tx *gorm.DB, userID uint, protocolID int, filter rotatorProxyFilter) *gorm.DB {
 	subQuery := tx.
 		Model(&domain.ProxyStatistic{}).
 		Select("proxy_id, MAX(created_at) AS created_at").
 		Where("protocol_id = ?", protocolID).
 		Group("proxy_id")
 
-	var proxies []domain.Proxy
 	query := tx.
 		Model(&domain.Proxy{}).
 		Select("proxies.*").
@@ -334,16 +756,7 @@ func aliveProxiesForProtocol(tx *gorm.DB, userID uint, protocolID int, labels []
 		Joins("JOIN proxy_statistics ps ON ps.proxy_id = proxies.id AND ps.created_at = latest_stats.created_at AND ps.protocol_id = ?", protocolID).
 		Where("ps.alive = ?", true)
 
-	query = applyReputationFilter(query, filterLabels)
-
-	err := query.
-		Order("proxies.id").
-		Find(&proxies).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return proxies, nil
+	return filter.apply(query)
 }
 
 func applyReputationFilter(query *gorm.DB, labels []string) *gorm.DB {
@@ -373,18 +786,16 @@ func fetchUserProxyByID(tx *gorm.DB, userID uint, proxyID uint64) (*domain.Proxy
 	return &proxy, nil
 }
 
+// selectNextProxy returns the proxy following lastProxyID in id order, which
+// keeps the rotation stable when the previous proxy left the pool.
 func selectNextProxy(proxies []domain.Proxy, lastProxyID *uint64) domain.Proxy {
 	if lastProxyID == nil {
 		return proxies[0]
 	}
 
 	for idx := range proxies {
-		if proxies[idx].ID == *lastProxyID {
-			next := idx + 1
-			if next >= len(proxies) {
-				next = 0
-			}
-			return proxies[next]
+		if proxies[idx].ID > *lastProxyID {
+			return proxies[idx]
 		}
 	}
 
@@ -458,6 +869,121 @@ func allocateListenPort(tx *gorm.DB) (uint16, error) {
 	return 0, ErrRotatingProxyPortExhausted
 }
 
+func normalizeRotatorListenProtocol(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", RotatingProxyListenHTTP:
+		return RotatingProxyListenHTTP, nil
+	case RotatingProxyListenHTTPS:
+		return RotatingProxyListenHTTPS, nil
+	case RotatingProxyListenSOCKS5:
+		return RotatingProxyListenSOCKS5, nil
+	default:
+		return "", ErrRotatingProxyListenProtocol
+	}
+}
+
+// NormalizeRotatorListenProtocol maps stored listen protocols to a supported
+// value, treating unknown or empty entries as plain HTTP.
+func NormalizeRotatorListenProtocol(raw string) string {
+	protocol, err := normalizeRotatorListenProtocol(raw)
+	if err != nil {
+		return RotatingProxyListenHTTP
+	}
+	return protocol
+}
+
+// applyRotatingProxySelection drops excluded upstreams and, as long as other
+// candidates remain, the ones that should be avoided.
+func applyRotatingProxySelection(proxies []domain.Proxy, selection RotatingProxySelection) []domain.Proxy {
+	if len(selection.ExcludeProxyIDs) == 0 && len(selection.AvoidProxyIDs) == 0 {
+		return proxies
+	}
+
+	excluded := make(map[uint64]struct{}, len(selection.ExcludeProxyIDs))
+	for _, id := range selection.ExcludeProxyIDs {
+		excluded[id] = struct{}{}
+	}
+	avoided := make(map[uint64]struct{}, len(selection.AvoidProxyIDs))
+	for _, id := range selection.AvoidProxyIDs {
+		avoided[id] = struct{}{}
+	}
+
+	preferred := make([]domain.Proxy, 0, len(proxies))
+	fallback := make([]domain.Proxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		if _, skip := excluded[proxy.ID]; skip {
+			continue
+		}
+		if _, avoid := avoided[proxy.ID]; avoid {
+			fallback = append(fallback, proxy)
+			continue
+		}
+		preferred = append(preferred, proxy)
+	}
+
+	if len(preferred) > 0 {
+		return preferred
+	}
+	return fallback
+}
+
+func normalizeRotatorFailoverAttempts(attempts uint8) uint8 {
+	if attempts == 0 {
+		return DefaultRotatingProxyFailoverAttempts
+	}
+	if attempts > maxRotatingProxyFailoverAttempts {
+		return maxRotatingProxyFailoverAttempts
+	}
+	return attempts
+}
+
+func normalizeRotatorFailoverTimeout(seconds uint16) uint16 {
+	if seconds == 0 {
+		return DefaultRotatingProxyFailoverTimeoutSeconds
+	}
+	if seconds > maxRotatingProxyFailoverTimeoutSeconds {
+		return maxRotatingProxyFailoverTimeoutSeconds
+	}
+	return seconds
+}
+
+func normalizeRotatorFailoverCooldown(seconds uint32) uint32 {
+	if seconds == 0 {
+		return DefaultRotatingProxyFailoverCooldownSeconds
+	}
+	if seconds > maxRotatingProxyFailoverCooldownSeconds {
+		return maxRotatingProxyFailoverCooldownSeconds
+	}
+	return seconds
+}
+
+func normalizeRotatorHostBanCooldown(seconds uint32) uint32 {
+	if seconds == 0 {
+		return DefaultRotatingProxyHostBanCooldownSeconds
+	}
+	if seconds > maxRotatingProxyHostBanCooldownSeconds {
+		return maxRotatingProxyHostBanCooldownSeconds
+	}
+	return seconds
+}
+
+func normalizeRotatorRotationInterval(seconds uint32) uint32 {
+	if seconds > maxRotatingProxyRotationIntervalSeconds {
+		return maxRotatingProxyRotationIntervalSeconds
+	}
+	return seconds
+}
+
+func normalizeRotatorSessionTTL(seconds uint32) uint32 {
+	if seconds == 0 {
+		return DefaultRotatingProxySessionTTLSeconds
+	}
+	if seconds > maxRotatingProxySessionTTLSeconds {
+		return maxRotatingProxySessionTTLSeconds
+	}
+	return seconds
+}
+
 func sanitizeRotatorReputationLabels(labels []string) []string {
 	if len(labels) == 0 {
 		return nil
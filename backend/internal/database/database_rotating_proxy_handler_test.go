@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -43,17 +44,25 @@ func setupRotatingProxyTestDBWithDSN(t *testing.T, dsn string) *gorm.DB {
 		&domain.UserProxy{},
 		&domain.ProxyReputation{},
 		&domain.RotatingProxy{},
+		&domain.RotatingProxyTraffic{},
 		&domain.ProxyStatistic{},
 		&domain.Protocol{},
+		&domain.AnonymityLevel{},
 		&domain.Judge{},
+		&domain.TargetCheck{},
+		&domain.TargetCheckResult{},
 	); err != nil {
 		t.Fatalf("auto migrate: %v", err)
 	}
 
 	DB = db
+	rotatorPools.reset()
+	rotatorTraffic.reset()
 
 	t.Cleanup(func() {
 		DB = nil
+		rotatorPools.reset()
+		rotatorTraffic.reset()
 	})
 
 	return db
@@ -146,6 +155,10 @@ func TestGetNextRotatingProxy_RotatesAcrossAliveProxies(t *testing.T) {
 		t.Fatalf("first protocol = %q, want %q", first.Protocol, protocol.Name)
 	}
 
+	if err := FlushRotatingProxyState(context.Background()); err != nil {
+		t.Fatalf("flush rotation state: %v", err)
+	}
+
 	var updated domain.RotatingProxy
 	if err := db.First(&updated, rotator.ID).Error; err != nil {
 		t.Fatalf("reload rotating proxy: %v", err)
@@ -165,6 +178,9 @@ func TestGetNextRotatingProxy_RotatesAcrossAliveProxies(t *testing.T) {
 		t.Fatalf("second proxy id = %d, want %d", second.ProxyID, proxies[1].ID)
 	}
 
+	if err := FlushRotatingProxyState(context.Background()); err != nil {
+		t.Fatalf("flush rotation state after second call: %v", err)
+	}
 	if err := db.First(&updated, rotator.ID).Error; err != nil {
 		t.Fatalf("reload rotating proxy after second call: %v", err)
 	}
@@ -489,6 +505,10 @@ func TestGetNextRotatingProxy_ConcurrentStress(t *testing.T) {
 		t.Fatalf("rotation distribution too uneven, max=%d min=%d", maxCount, minCount)
 	}
 
+	if err := FlushRotatingProxyState(context.Background()); err != nil {
+		t.Fatalf("flush rotation state: %v", err)
+	}
+
 	var updated domain.RotatingProxy
 	if err := db.First(&updated, rotator.ID).Error; err != nil {
 		t.Fatalf("reload rotating proxy: %v", err)
@@ -508,3 +528,152 @@ func isSQLiteLocked(err error) bool {
 	message := err.Error()
 	return strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked")
 }
+
+func TestGetRotatingProxyUpstream_OnlyReturnsAliveMatchingProxy(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	user := domain.User{Email: "sticky@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	protocol := domain.Protocol{Name: "http"}
+	if err := db.Create(&protocol).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+
+	judge := domain.Judge{FullString: "http://judge.example.com"}
+	if err := db.Create(&judge).Error; err != nil {
+		t.Fatalf("create judge: %v", err)
+	}
+
+	proxies := []domain.Proxy{
+		{IP: "10.0.2.1", Port: 8000, Country: "AA", EstimatedType: "residential"},
+		{IP: "10.0.2.2", Port: 8001, Country: "AA", EstimatedType: "residential"},
+	}
+	for idx := range proxies {
+		if err := db.Create(&proxies[idx]).Error; err != nil {
+			t.Fatalf("create proxy %d: %v", idx, err)
+		}
+		if err := db.Create(&domain.UserProxy{UserID: user.ID, ProxyID: proxies[idx].ID}).Error; err != nil {
+			t.Fatalf("link proxy %d: %v", idx, err)
+		}
+		stat := domain.ProxyStatistic{
+			Alive:        idx == 0,
+			Attempt:      1,
+			ResponseTime: 100,
+			ProtocolID:   protocol.ID,
+			ProxyID:      proxies[idx].ID,
+			JudgeID:      judge.ID,
+			CreatedAt:    time.Unix(int64(idx+1), 0),
+		}
+		if err := db.Create(&stat).Error; err != nil {
+			t.Fatalf("create statistic %d: %v", idx, err)
+		}
+	}
+
+	rotator := domain.RotatingProxy{UserID: user.ID, Name: "sticky-rotator", ProtocolID: protocol.ID, ListenPort: 10900}
+	if err := db.Create(&rotator).Error; err != nil {
+		t.Fatalf("create rotating proxy: %v", err)
+	}
+
+	next, err := GetRotatingProxyUpstream(user.ID, rotator.ID, proxies[0].ID, RotatingProxySelection{})
+	if err != nil {
+		t.Fatalf("GetRotatingProxyUpstream alive proxy: %v", err)
+	}
+	if next.ProxyID != proxies[0].ID || next.IP != "10.0.2.1" {
+		t.Fatalf("unexpected upstream %+v", next)
+	}
+
+	if _, err := GetRotatingProxyUpstream(user.ID, rotator.ID, proxies[1].ID, RotatingProxySelection{}); err != ErrRotatingProxyUpstreamGone {
+		t.Fatalf("expected ErrRotatingProxyUpstreamGone for dead proxy, got %v", err)
+	}
+	if _, err := GetRotatingProxyUpstream(user.ID, rotator.ID, proxies[0].ID, RotatingProxySelection{Countries: []string{"BB"}}); err != ErrRotatingProxyUpstreamGone {
+		t.Fatalf("expected ErrRotatingProxyUpstreamGone for a proxy outside the selection, got %v", err)
+	}
+
+	var updated domain.RotatingProxy
+	if err := db.First(&updated, rotator.ID).Error; err != nil {
+		t.Fatalf("reload rotating proxy: %v", err)
+	}
+	if updated.LastProxyID != nil {
+		t.Fatal("sticky lookups must not advance the rotation")
+	}
+}
+
+func TestApplyRotatingProxySelection(t *testing.T) {
+	proxies := []domain.Proxy{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	got := applyRotatingProxySelection(proxies, RotatingProxySelection{ExcludeProxyIDs: []uint64{1}, AvoidProxyIDs: []uint64{2}})
+	if len(got) != 1 || got[0].ID != 3 {
+		t.Fatalf("expected only proxy 3, got %+v", got)
+	}
+
+	got = applyRotatingProxySelection(proxies, RotatingProxySelection{ExcludeProxyIDs: []uint64{3}, AvoidProxyIDs: []uint64{1, 2}})
+	if len(got) != 2 {
+		t.Fatalf("expected avoided proxies as fallback, got %+v", got)
+	}
+
+	got = applyRotatingProxySelection(proxies, RotatingProxySelection{ExcludeProxyIDs: []uint64{1, 2, 3}})
+	if len(got) != 0 {
+		t.Fatalf("expected no candidates, got %+v", got)
+	}
+}
+
+func TestUpdateRotatingProxy_AppliesChangesAndReloadsPool(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100, 900})
+
+	rotator.AuthRequired = true
+	rotator.AuthUsername = "client"
+	rotator.AuthPassword = "secret"
+	if err := db.Save(&rotator).Error; err != nil {
+		t.Fatalf("enable auth: %v", err)
+	}
+
+	if _, err := GetNextRotatingProxy(user.ID, rotator.ID); err != nil {
+		t.Fatalf("initial rotation: %v", err)
+	}
+
+	name := "renamed"
+	maxLatency := uint16(500)
+	interval := uint32(60)
+	updated, err := UpdateRotatingProxy(user.ID, rotator.ID, dto.RotatingProxyUpdateRequest{
+		Name:                    &name,
+		MaxResponseTimeMs:       &maxLatency,
+		RotationIntervalSeconds: &interval,
+	})
+	if err != nil {
+		t.Fatalf("UpdateRotatingProxy: %v", err)
+	}
+	if updated.Name != "renamed" || updated.MaxResponseTimeMs != 500 || updated.RotationIntervalSeconds != 60 || updated.AliveProxyCount != 1 {
+		t.Fatalf("unexpected update result %+v", updated)
+	}
+
+	var stored domain.RotatingProxy
+	if err := db.First(&stored, rotator.ID).Error; err != nil {
+		t.Fatalf("reload rotating proxy: %v", err)
+	}
+	if stored.AuthPassword != "secret" || stored.ListenPort != rotator.ListenPort {
+		t.Fatalf("expected password and port to be kept, got %q and %d", stored.AuthPassword, stored.ListenPort)
+	}
+
+	for i := 0; i < 2; i++ {
+		next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+		if err != nil {
+			t.Fatalf("rotation after update: %v", err)
+		}
+		if next.ProxyID != proxies[0].ID {
+			t.Fatalf("expected updated filter to drop slow proxy, got %d", next.ProxyID)
+		}
+	}
+
+	disableAuth := false
+	empty := ""
+	if _, err := UpdateRotatingProxy(user.ID, rotator.ID, dto.RotatingProxyUpdateRequest{Name: &empty}); err != ErrRotatingProxyNameRequired {
+		t.Fatalf("expected ErrRotatingProxyNameRequired, got %v", err)
+	}
+	if _, err := UpdateRotatingProxy(user.ID+1, rotator.ID, dto.RotatingProxyUpdateRequest{AuthRequired: &disableAuth}); err != ErrRotatingProxyNotFound {
+		t.Fatalf("expected ErrRotatingProxyNotFound for another user, got %v", err)
+	}
+}
@@ -0,0 +1,556 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/config"
+	"magpie/internal/domain"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrTargetCheckNotFound       = errors.New("target check not found")
+	ErrTargetCheckNameRequired   = errors.New("target check name is required")
+	ErrTargetCheckNameTooLong    = errors.New("target check name is too long")
+	ErrTargetCheckNameConflict   = errors.New("target check name already exists")
+	ErrTargetCheckURLInvalid     = errors.New("target check url must be an absolute http or https url")
+	ErrTargetCheckURLBlocked     = errors.New("target check url is blocked")
+	ErrTargetCheckMethodInvalid  = errors.New("target check method must be GET, HEAD or POST")
+	ErrTargetCheckStatusInvalid  = errors.New("expected status codes must be between 100 and 599")
+	ErrTargetCheckRegexInvalid   = errors.New("target check body regex is invalid")
+	ErrTargetCheckRegexWithHead  = errors.New("target check body regex cannot be used with HEAD requests")
+	ErrTargetCheckLimitReached   = errors.New("target check limit reached")
+	ErrRotatingProxyTargetChecks = errors.New("target checks must be existing target checks of this user")
+)
+
+const (
+	maxTargetChecksPerUser        = 25
+	maxTargetCheckStatuses        = 20
+	targetCheckNameMaxLength      = 120
+	targetCheckURLMaxLength       = 512
+	targetCheckBodyRegexMaxLength = 512
+	targetCheckResultErrorLength  = 255
+)
+
+var targetCheckMethods = map[string]struct{}{
+	"GET":  {},
+	"HEAD": {},
+	"POST": {},
+}
+
+func ListTargetChecks(userID uint) ([]dto.TargetCheck, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("target check: database connection was not initialised")
+	}
+
+	var rows []domain.TargetCheck
+	if err := DB.Where("user_id = ?", userID).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]dto.TargetCheck, len(rows))
+	for idx := range rows {
+		result[idx] = targetCheckToDTO(rows[idx])
+	}
+	return result, nil
+}
+
+// GetAllTargetChecks returns the target checks of every user. The checker
+// keeps them in memory and runs the ones owned by a proxy's users.
+func GetAllTargetChecks() ([]domain.TargetCheck, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("target check: database connection was not initialised")
+	}
+
+	var rows []domain.TargetCheck
+	if err := DB.Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func CreateTargetCheck(userID uint, payload dto.TargetCheckRequest) (*dto.TargetCheck, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("target check: database connection was not initialised")
+	}
+
+	entity, err := normalizeTargetCheck(payload)
+	if err != nil {
+		return nil, err
+	}
+	entity.UserID = userID
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&domain.TargetCheck{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxTargetChecksPerUser {
+			return ErrTargetCheckLimitReached
+		}
+		if err := ensureTargetCheckNameFree(tx, userID, entity.Name, 0); err != nil {
+			return err
+		}
+
+		if err := tx.Omit(clause.Associations).Create(&entity).Error; err != nil {
+			if isUniqueConstraintError(err) {
+				return ErrTargetCheckNameConflict
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := targetCheckToDTO(entity)
+	return &result, nil
+}
+
+// UpdateTargetCheck replaces the settings of a target check. Stored results
+// are dropped because they were produced under the old settings.
+func UpdateTargetCheck(userID uint, targetCheckID uint, payload dto.TargetCheckRequest) (*dto.TargetCheck, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("target check: database connection was not initialised")
+	}
+
+	updated, err := normalizeTargetCheck(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var entity domain.TargetCheck
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND id = ?", userID, targetCheckID).First(&entity).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTargetCheckNotFound
+			}
+			return err
+		}
+		if err := ensureTargetCheckNameFree(tx, userID, updated.Name, entity.ID); err != nil {
+			return err
+		}
+
+		entity.Name = updated.Name
+		entity.URL = updated.URL
+		entity.Method = updated.Method
+		entity.ExpectedStatuses = updated.ExpectedStatuses
+		entity.BodyRegex = updated.BodyRegex
+		entity.MaxLatencyMs = updated.MaxLatencyMs
+
+		if err := tx.Omit(clause.Associations, "CreatedAt").Save(&entity).Error; err != nil {
+			if isUniqueConstraintError(err) {
+				return ErrTargetCheckNameConflict
+			}
+			return err
+		}
+
+		return tx.Where("target_check_id = ?", entity.ID).Delete(&domain.TargetCheckResult{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reloadRotatorsUsingTargetCheck(userID, targetCheckID)
+
+	result := targetCheckToDTO(entity)
+	return &result, nil
+}
+
+// DeleteTargetCheck removes a target check with its results and drops it from
+// the filters of the user's rotators.
+func DeleteTargetCheck(userID uint, targetCheckID uint) error {
+	if DB == nil {
+		return fmt.Errorf("target check: database connection was not initialised")
+	}
+
+	var affected []uint64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("user_id = ? AND id = ?", userID, targetCheckID).Delete(&domain.TargetCheck{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrTargetCheckNotFound
+		}
+
+		if err := tx.Where("target_check_id = ?", targetCheckID).Delete(&domain.TargetCheckResult{}).Error; err != nil {
+			return err
+		}
+
+		rotators, err := rotatorsUsingTargetCheck(tx, userID, targetCheckID)
+		if err != nil {
+			return err
+		}
+		for _, rotator := range rotators {
+			remaining := make(domain.UintList, 0, len(rotator.TargetCheckIDs))
+			for _, id := range rotator.TargetCheckIDs {
+				if id != targetCheckID {
+					remaining = append(remaining, id)
+				}
+			}
+			if err := tx.Model(&domain.RotatingProxy{}).
+				Where("id = ?", rotator.ID).
+				Update("target_check_ids", remaining).Error; err != nil {
+				return err
+			}
+			affected = append(affected, rotator.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rotatorID := range affected {
+		rotatorPools.reload(rotatorID)
+	}
+	return nil
+}
+
+func ensureTargetCheckNameFree(tx *gorm.DB, userID uint, name string, excludeID uint) error {
+	var count int64
+	if err := tx.Model(&domain.TargetCheck{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, excludeID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTargetCheckNameConflict
+	}
+	return nil
+}
+
+// SaveTargetCheckResults stores the latest result per target check, proxy and
+// protocol, replacing the previous one. Results of target checks deleted since
+// the checker loaded them are dropped.
+func SaveTargetCheckResults(ctx context.Context, results []domain.TargetCheckResult) error {
+	if DB == nil {
+		return fmt.Errorf("target check: database connection was not initialised")
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	results, err := dropDeletedTargetCheckResults(DB.WithContext(ctx), results)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	for idx := range results {
+		results[idx].Error = truncateTargetCheckError(results[idx].Error)
+	}
+
+	return DB.WithContext(ctx).
+		Omit(clause.Associations).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "target_check_id"}, {Name: "proxy_id"}, {Name: "protocol_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"passed", "status_code", "response_time", "error", "checked_at"}),
+		}).
+		Create(&results).Error
+}
+
+func dropDeletedTargetCheckResults(tx *gorm.DB, results []domain.TargetCheckResult) ([]domain.TargetCheckResult, error) {
+	ids := make([]uint, 0, len(results))
+	seen := make(map[uint]struct{}, len(results))
+	for _, result := range results {
+		if _, ok := seen[result.TargetCheckID]; !ok {
+			seen[result.TargetCheckID] = struct{}{}
+			ids = append(ids, result.TargetCheckID)
+		}
+	}
+
+	var existing []uint
+	if err := tx.Model(&domain.TargetCheck{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+		return nil, err
+	}
+	if len(existing) == len(ids) {
+		return results, nil
+	}
+
+	kept := make(map[uint]struct{}, len(existing))
+	for _, id := range existing {
+		kept[id] = struct{}{}
+	}
+	filtered := make([]domain.TargetCheckResult, 0, len(results))
+	for _, result := range results {
+		if _, ok := kept[result.TargetCheckID]; ok {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered, nil
+}
+
+// getTargetCheckResults returns the user's target check results for a proxy.
+func getTargetCheckResults(userID uint, proxyID uint64) ([]dto.TargetCheckResult, error) {
+	type resultRow struct {
+		TargetCheckID uint
+		Name          string
+		Protocol      string
+		Passed        bool
+		StatusCode    int
+		ResponseTime  uint16
+		Error         string
+		CheckedAt     time.Time
+	}
+
+	var rows []resultRow
+	if err := DB.Table("target_check_results").
+		Select("target_check_results.target_check_id, target_checks.name, protocols.name AS protocol, "+
+			"target_check_results.passed, target_check_results.status_code, target_check_results.response_time, "+
+			"target_check_results.error, target_check_results.checked_at").
+		Joins("JOIN target_checks ON target_checks.id = target_check_results.target_check_id").
+		Joins("LEFT JOIN protocols ON protocols.id = target_check_results.protocol_id").
+		Where("target_check_results.proxy_id = ? AND target_checks.user_id = ?", proxyID, userID).
+		Order("target_checks.name, target_check_results.protocol_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]dto.TargetCheckResult, len(rows))
+	for idx, row := range rows {
+		results[idx] = dto.TargetCheckResult{
+			TargetCheckID: row.TargetCheckID,
+			Name:          row.Name,
+			Protocol:      normaliseDisplayValue(row.Protocol, "Unknown"),
+			Passed:        row.Passed,
+			StatusCode:    row.StatusCode,
+			ResponseTime:  row.ResponseTime,
+			Error:         row.Error,
+			CheckedAt:     row.CheckedAt,
+		}
+	}
+	return results, nil
+}
+
+// applyExportTargetCheckFilters keeps proxies that passed every selected
+// target check of the user. With protocol filters, only results for those
+// protocols count.
+func applyExportTargetCheckFilters(query *gorm.DB, userID uint, settings dto.ExportSettings) *gorm.DB {
+	protocols := protocolsForExport(settings)
+
+	for _, targetCheckID := range sanitizeTargetCheckIDs(settings.TargetCheckIDs) {
+		condition := "EXISTS (SELECT 1 FROM target_check_results tcr JOIN target_checks tc ON tc.id = tcr.target_check_id " +
+			"WHERE tcr.proxy_id = proxies.id AND tcr.target_check_id = ? AND tc.user_id = ? AND tcr.passed = ?"
+		args := []any{targetCheckID, userID, true}
+		if len(protocols) > 0 {
+			condition += " AND tcr.protocol_id IN (SELECT id FROM protocols WHERE name IN ?)"
+			args = append(args, protocols)
+		}
+		query = query.Where(condition+")", args...)
+	}
+
+	return query
+}
+
+// normalizeRotatorTargetChecks de-duplicates the target checks a rotator
+// requires and makes sure they belong to the user.
+func normalizeRotatorTargetChecks(tx *gorm.DB, userID uint, ids []uint) ([]uint, error) {
+	ids = sanitizeTargetCheckIDs(ids)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var count int64
+	if err := tx.Model(&domain.TargetCheck{}).
+		Where("user_id = ? AND id IN ?", userID, ids).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(ids) {
+		return nil, ErrRotatingProxyTargetChecks
+	}
+	return ids, nil
+}
+
+func sanitizeTargetCheckIDs(ids []uint) []uint {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	seen := make(map[uint]struct{}, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id == 0 {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+		if len(result) == maxTargetChecksPerUser {
+			break
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func rotatorsUsingTargetCheck(tx *gorm.DB, userID uint, targetCheckID uint) ([]domain.RotatingProxy, error) {
+	var rotators []domain.RotatingProxy
+	if err := tx.Select("id", "target_check_ids").Where("user_id = ?", userID).Find(&rotators).Error; err != nil {
+		return nil, err
+	}
+
+	using := make([]domain.RotatingProxy, 0, len(rotators))
+	for _, rotator := range rotators {
+		for _, id := range rotator.TargetCheckIDs {
+			if id == targetCheckID {
+				using = append(using, rotator)
+				break
+			}
+		}
+	}
+	return using, nil
+}
+
+// reloadRotatorsUsingTargetCheck refreshes the pools of rotators filtering on
+// a target check whose results were reset.
+func reloadRotatorsUsingTargetCheck(userID uint, targetCheckID uint) {
+	rotators, err := rotatorsUsingTargetCheck(DB, userID, targetCheckID)
+	if err != nil {
+		return
+	}
+	for _, rotator := range rotators {
+		rotatorPools.reload(rotator.ID)
+	}
+}
+
+func normalizeTargetCheck(payload dto.TargetCheckRequest) (domain.TargetCheck, error) {
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		return domain.TargetCheck{}, ErrTargetCheckNameRequired
+	}
+	if len(name) > targetCheckNameMaxLength {
+		return domain.TargetCheck{}, ErrTargetCheckNameTooLong
+	}
+
+	targetURL, err := normalizeTargetCheckURL(payload.URL)
+	if err != nil {
+		return domain.TargetCheck{}, err
+	}
+
+	method := strings.ToUpper(strings.TrimSpace(payload.Method))
+	if method == "" {
+		method = "GET"
+	}
+	if _, ok := targetCheckMethods[method]; !ok {
+		return domain.TargetCheck{}, ErrTargetCheckMethodInvalid
+	}
+
+	statuses, err := normalizeTargetCheckStatuses(payload.ExpectedStatuses)
+	if err != nil {
+		return domain.TargetCheck{}, err
+	}
+
+	bodyRegex := strings.TrimSpace(payload.BodyRegex)
+	if bodyRegex != "" {
+		if method == "HEAD" {
+			return domain.TargetCheck{}, ErrTargetCheckRegexWithHead
+		}
+		if len(bodyRegex) > targetCheckBodyRegexMaxLength {
+			return domain.TargetCheck{}, ErrTargetCheckRegexInvalid
+		}
+		if _, err := regexp.Compile(bodyRegex); err != nil {
+			return domain.TargetCheck{}, ErrTargetCheckRegexInvalid
+		}
+	}
+
+	return domain.TargetCheck{
+		Name:             name,
+		URL:              targetURL,
+		Method:           method,
+		ExpectedStatuses: domain.UintList(statuses),
+		BodyRegex:        bodyRegex,
+		MaxLatencyMs:     payload.MaxLatencyMs,
+	}, nil
+}
+
+func normalizeTargetCheckURL(raw string) (string, error) {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" || len(trimmed) > targetCheckURLMaxLength {
+		return "", ErrTargetCheckURLInvalid
+	}
+
+	parsed, err := url.Parse(trimmed)
+	if err != nil || parsed.Hostname() == "" {
+		return "", ErrTargetCheckURLInvalid
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", ErrTargetCheckURLInvalid
+	}
+
+	normalized := parsed.String()
+	if config.IsWebsiteBlocked(normalized) {
+		return "", ErrTargetCheckURLBlocked
+	}
+	return normalized, nil
+}
+
+func normalizeTargetCheckStatuses(values []uint) ([]uint, error) {
+	if len(values) > maxTargetCheckStatuses {
+		return nil, ErrTargetCheckStatusInvalid
+	}
+
+	seen := make(map[uint]struct{}, len(values))
+	result := make([]uint, 0, len(values))
+	for _, status := range values {
+		if status < 100 || status > 599 {
+			return nil, ErrTargetCheckStatusInvalid
+		}
+		if _, ok := seen[status]; ok {
+			continue
+		}
+		seen[status] = struct{}{}
+		result = append(result, status)
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+func truncateTargetCheckError(message string) string {
+	runes := []rune(message)
+	if len(runes) > targetCheckResultErrorLength {
+		runes = runes[:targetCheckResultErrorLength]
+	}
+	return string(runes)
+}
+
+func targetCheckToDTO(entity domain.TargetCheck) dto.TargetCheck {
+	statuses := entity.ExpectedStatuses.Clone()
+	if statuses == nil {
+		statuses = []uint{}
+	}
+
+	return dto.TargetCheck{
+		ID:               entity.ID,
+		Name:             entity.Name,
+		URL:              entity.URL,
+		Method:           entity.Method,
+		ExpectedStatuses: statuses,
+		BodyRegex:        entity.BodyRegex,
+		MaxLatencyMs:     entity.MaxLatencyMs,
+		CreatedAt:        entity.CreatedAt,
+	}
+}
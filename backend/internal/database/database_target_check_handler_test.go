@@ -0,0 +1,283 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+func TestCreateTargetCheck_NormalizesAndValidates(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	user := domain.User{Email: "targets@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	created, err := CreateTargetCheck(user.ID, dto.TargetCheckRequest{
+		Name:             " Shop ",
+		URL:              " HTTPS://shop.example.com/login ",
+		ExpectedStatuses: []uint{200, 302, 200},
+		BodyRegex:        "(?i)sign in",
+		MaxLatencyMs:     1500,
+	})
+	if err != nil {
+		t.Fatalf("create target check: %v", err)
+	}
+	if created.Name != "Shop" || created.URL != "https://shop.example.com/login" || created.Method != "GET" {
+		t.Fatalf("unexpected normalized target check: %+v", created)
+	}
+	if len(created.ExpectedStatuses) != 2 || created.ExpectedStatuses[0] != 200 || created.ExpectedStatuses[1] != 302 {
+		t.Fatalf("expected statuses [200 302], got %v", created.ExpectedStatuses)
+	}
+
+	cases := []struct {
+		name    string
+		payload dto.TargetCheckRequest
+		want    error
+	}{
+		{name: "missing name", payload: dto.TargetCheckRequest{URL: "https://a.example.com"}, want: ErrTargetCheckNameRequired},
+		{name: "bad scheme", payload: dto.TargetCheckRequest{Name: "ftp", URL: "ftp://a.example.com"}, want: ErrTargetCheckURLInvalid},
+		{name: "relative url", payload: dto.TargetCheckRequest{Name: "relative", URL: "/login"}, want: ErrTargetCheckURLInvalid},
+		{name: "bad method", payload: dto.TargetCheckRequest{Name: "delete", URL: "https://a.example.com", Method: "delete"}, want: ErrTargetCheckMethodInvalid},
+		{name: "bad status", payload: dto.TargetCheckRequest{Name: "status", URL: "https://a.example.com", ExpectedStatuses: []uint{99}}, want: ErrTargetCheckStatusInvalid},
+		{name: "bad regex", payload: dto.TargetCheckRequest{Name: "regex", URL: "https://a.example.com", BodyRegex: "("}, want: ErrTargetCheckRegexInvalid},
+		{name: "head with regex", payload: dto.TargetCheckRequest{Name: "head", URL: "https://a.example.com", Method: "HEAD", BodyRegex: "ok"}, want: ErrTargetCheckRegexWithHead},
+		{name: "duplicate name", payload: dto.TargetCheckRequest{Name: "Shop", URL: "https://b.example.com"}, want: ErrTargetCheckNameConflict},
+	}
+	for _, tc := range cases {
+		if _, err := CreateTargetCheck(user.ID, tc.payload); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestSaveTargetCheckResults_KeepsLatestResult(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, protocol, proxies := seedTargetCheckProxies(t, db, 1)
+
+	check := domain.TargetCheck{UserID: user.ID, Name: "shop", URL: "https://shop.example.com", Method: "GET"}
+	if err := db.Create(&check).Error; err != nil {
+		t.Fatalf("create target check: %v", err)
+	}
+
+	result := domain.TargetCheckResult{TargetCheckID: check.ID, ProxyID: proxies[0].ID, ProtocolID: protocol.ID, Passed: true, StatusCode: 200, CheckedAt: time.Unix(10, 0)}
+	if err := SaveTargetCheckResults(context.Background(), []domain.TargetCheckResult{result}); err != nil {
+		t.Fatalf("save first result: %v", err)
+	}
+
+	result.Passed, result.StatusCode, result.Error, result.CheckedAt = false, 403, "unexpected status 403", time.Unix(20, 0)
+	if err := SaveTargetCheckResults(context.Background(), []domain.TargetCheckResult{result}); err != nil {
+		t.Fatalf("save second result: %v", err)
+	}
+
+	results, err := getTargetCheckResults(user.ID, proxies[0].ID)
+	if err != nil {
+		t.Fatalf("load results: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected a single stored result, got %d", len(results))
+	}
+	if results[0].Passed || results[0].StatusCode != 403 || results[0].Name != "shop" || results[0].Protocol != "http" {
+		t.Fatalf("unexpected stored result: %+v", results[0])
+	}
+
+	if results, err := getTargetCheckResults(user.ID+1, proxies[0].ID); err != nil || len(results) != 0 {
+		t.Fatalf("expected no results for another user, got %v (err %v)", results, err)
+	}
+}
+
+func TestSaveTargetCheckResults_DropsResultsOfDeletedChecks(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, protocol, proxies := seedTargetCheckProxies(t, db, 1)
+
+	checks := []domain.TargetCheck{
+		{UserID: user.ID, Name: "kept", URL: "https://kept.example.com", Method: "GET"},
+		{UserID: user.ID, Name: "deleted", URL: "https://deleted.example.com", Method: "GET"},
+	}
+	if err := db.Create(&checks).Error; err != nil {
+		t.Fatalf("create target checks: %v", err)
+	}
+	if err := DeleteTargetCheck(user.ID, checks[1].ID); err != nil {
+		t.Fatalf("delete target check: %v", err)
+	}
+
+	results := []domain.TargetCheckResult{
+		{TargetCheckID: checks[0].ID, ProxyID: proxies[0].ID, ProtocolID: protocol.ID, Passed: true, StatusCode: 200, CheckedAt: time.Unix(10, 0)},
+		{TargetCheckID: checks[1].ID, ProxyID: proxies[0].ID, ProtocolID: protocol.ID, Passed: true, StatusCode: 200, CheckedAt: time.Unix(10, 0)},
+	}
+	if err := SaveTargetCheckResults(context.Background(), results); err != nil {
+		t.Fatalf("save results: %v", err)
+	}
+
+	stored, err := getTargetCheckResults(user.ID, proxies[0].ID)
+	if err != nil {
+		t.Fatalf("load results: %v", err)
+	}
+	if len(stored) != 1 || stored[0].TargetCheckID != checks[0].ID {
+		t.Fatalf("expected only the kept check's result, got %+v", stored)
+	}
+}
+
+func TestTargetCheckFilters_RequirePassedResults(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, protocol, proxies := seedTargetCheckProxies(t, db, 3)
+
+	https := domain.Protocol{Name: "https"}
+	if err := db.Create(&https).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+
+	shop, err := CreateTargetCheck(user.ID, dto.TargetCheckRequest{Name: "shop", URL: "https://shop.example.com"})
+	if err != nil {
+		t.Fatalf("create target check: %v", err)
+	}
+
+	// Proxy 0 passed over http, proxy 1 failed and proxy 2 only passed over
+	// https.
+	results := []domain.TargetCheckResult{
+		{TargetCheckID: shop.ID, ProxyID: proxies[0].ID, ProtocolID: protocol.ID, Passed: true, CheckedAt: time.Now()},
+		{TargetCheckID: shop.ID, ProxyID: proxies[1].ID, ProtocolID: protocol.ID, Passed: false, CheckedAt: time.Now()},
+		{TargetCheckID: shop.ID, ProxyID: proxies[2].ID, ProtocolID: https.ID, Passed: true, CheckedAt: time.Now()},
+	}
+	if err := SaveTargetCheckResults(context.Background(), results); err != nil {
+		t.Fatalf("save results: %v", err)
+	}
+
+	matched, err := aliveProxiesForProtocol(db, user.ID, protocol.ID, rotatorFilterFromEntity(domain.RotatingProxy{
+		TargetCheckIDs: domain.UintList{shop.ID},
+	}))
+	if err != nil {
+		t.Fatalf("alive proxies: %v", err)
+	}
+	if len(matched) != 1 || matched[0].ID != proxies[0].ID {
+		t.Fatalf("expected only proxy %d for the rotator, got %+v", proxies[0].ID, matched)
+	}
+
+	var exported []domain.Proxy
+	query := applyExportTargetCheckFilters(db.Model(&domain.Proxy{}), user.ID, dto.ExportSettings{TargetCheckIDs: []uint{shop.ID}})
+	if err := query.Order("proxies.id").Find(&exported).Error; err != nil {
+		t.Fatalf("export query: %v", err)
+	}
+	if len(exported) != 2 || exported[0].ID != proxies[0].ID || exported[1].ID != proxies[2].ID {
+		t.Fatalf("expected proxies %d and %d in the export, got %+v", proxies[0].ID, proxies[2].ID, exported)
+	}
+
+	exported = nil
+	query = applyExportTargetCheckFilters(db.Model(&domain.Proxy{}), user.ID+1, dto.ExportSettings{TargetCheckIDs: []uint{shop.ID}})
+	if err := query.Find(&exported).Error; err != nil {
+		t.Fatalf("export query for another user: %v", err)
+	}
+	if len(exported) != 0 {
+		t.Fatalf("expected target checks of other users to match nothing, got %+v", exported)
+	}
+}
+
+func TestRotatorTargetChecks_ValidatedAndDroppedOnDelete(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, _, _ := seedTargetCheckProxies(t, db, 1)
+
+	other := domain.User{Email: "targets-other@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatalf("create other user: %v", err)
+	}
+	foreign, err := CreateTargetCheck(other.ID, dto.TargetCheckRequest{Name: "foreign", URL: "https://foreign.example.com"})
+	if err != nil {
+		t.Fatalf("create foreign target check: %v", err)
+	}
+	if _, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name:           "foreign-target",
+		Protocol:       "http",
+		TargetCheckIDs: []uint{foreign.ID},
+	}); !errors.Is(err, ErrRotatingProxyTargetChecks) {
+		t.Fatalf("expected ErrRotatingProxyTargetChecks, got %v", err)
+	}
+
+	shop, err := CreateTargetCheck(user.ID, dto.TargetCheckRequest{Name: "shop", URL: "https://shop.example.com"})
+	if err != nil {
+		t.Fatalf("create target check: %v", err)
+	}
+	login, err := CreateTargetCheck(user.ID, dto.TargetCheckRequest{Name: "login", URL: "https://login.example.com"})
+	if err != nil {
+		t.Fatalf("create target check: %v", err)
+	}
+
+	rotator, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name:           "targets",
+		Protocol:       "http",
+		TargetCheckIDs: []uint{shop.ID, login.ID, shop.ID},
+	})
+	if err != nil {
+		t.Fatalf("create rotating proxy: %v", err)
+	}
+	if len(rotator.TargetCheckIDs) != 2 {
+		t.Fatalf("expected de-duplicated target checks, got %v", rotator.TargetCheckIDs)
+	}
+
+	if err := DeleteTargetCheck(user.ID, shop.ID); err != nil {
+		t.Fatalf("delete target check: %v", err)
+	}
+	if err := DeleteTargetCheck(user.ID, shop.ID); !errors.Is(err, ErrTargetCheckNotFound) {
+		t.Fatalf("expected ErrTargetCheckNotFound on second delete, got %v", err)
+	}
+
+	var stored domain.RotatingProxy
+	if err := db.First(&stored, rotator.ID).Error; err != nil {
+		t.Fatalf("load rotating proxy: %v", err)
+	}
+	if len(stored.TargetCheckIDs) != 1 || stored.TargetCheckIDs[0] != login.ID {
+		t.Fatalf("expected only target check %d to remain, got %v", login.ID, stored.TargetCheckIDs)
+	}
+}
+
+// seedTargetCheckProxies creates a user with count proxies that are alive
+// over http.
+func seedTargetCheckProxies(t *testing.T, db *gorm.DB, count int) (domain.User, domain.Protocol, []domain.Proxy) {
+	t.Helper()
+
+	user := domain.User{Email: "target-proxies@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	protocol := domain.Protocol{Name: "http"}
+	if err := db.Create(&protocol).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+
+	judge := domain.Judge{FullString: "http://judge.example.com"}
+	if err := db.Create(&judge).Error; err != nil {
+		t.Fatalf("create judge: %v", err)
+	}
+
+	proxies := make([]domain.Proxy, count)
+	for idx := range proxies {
+		proxies[idx] = domain.Proxy{IP: fmt.Sprintf("10.0.9.%d", idx+1), Port: uint16(8100 + idx), Country: "Germany", EstimatedType: "Datacenter"}
+		if err := db.Create(&proxies[idx]).Error; err != nil {
+			t.Fatalf("create proxy %d: %v", idx, err)
+		}
+		if err := db.Create(&domain.UserProxy{UserID: user.ID, ProxyID: proxies[idx].ID}).Error; err != nil {
+			t.Fatalf("link proxy %d: %v", idx, err)
+		}
+		stat := domain.ProxyStatistic{
+			Alive:        true,
+			Attempt:      1,
+			ResponseTime: 200,
+			ProtocolID:   protocol.ID,
+			ProxyID:      proxies[idx].ID,
+			JudgeID:      judge.ID,
+		}
+		if err := db.Create(&stat).Error; err != nil {
+			t.Fatalf("create statistic %d: %v", idx, err)
+		}
+	}
+
+	return user, protocol, proxies
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+const maxRotatingProxyClientCIDRs = 256
+
+// NormalizeRotatorClientCIDRs validates a client allowlist and returns it in
+// canonical prefix form. Bare addresses become single-host prefixes.
+func NormalizeRotatorClientCIDRs(entries []string) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if len(entries) > maxRotatingProxyClientCIDRs {
+		return nil, ErrRotatingProxyClientCIDRInvalid
+	}
+
+	seen := make(map[string]struct{}, len(entries))
+	result := make([]string, 0, len(entries))
+	for _, raw := range entries {
+		entry := strings.TrimSpace(raw)
+		if entry == "" {
+			continue
+		}
+
+		prefix, err := parseClientPrefix(entry)
+		if err != nil {
+			return nil, ErrRotatingProxyClientCIDRInvalid
+		}
+
+		canonical := prefix.String()
+		if _, ok := seen[canonical]; ok {
+			continue
+		}
+		seen[canonical] = struct{}{}
+		result = append(result, canonical)
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+func parseClientPrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(prefix.Addr().Unmap(), unmappedBits(prefix)).Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// unmappedBits adjusts the prefix length of IPv4-mapped IPv6 prefixes to their
+// IPv4 equivalent.
+func unmappedBits(prefix netip.Prefix) int {
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		return prefix.Bits() - 96
+	}
+	return prefix.Bits()
+}
+
+// UpdateRotatingProxyAllowedClients replaces the client allowlist of a rotator.
+func UpdateRotatingProxyAllowedClients(userID uint, rotatingProxyID uint64, payload dto.RotatingProxyAllowedClientsRequest) ([]string, error) {
+	allowed, err := NormalizeRotatorClientCIDRs(payload.AllowedClientCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	if DB == nil {
+		return nil, fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
+
+	res := DB.Model(&domain.RotatingProxy{}).
+		Where("user_id = ? AND id = ?", userID, rotatingProxyID).
+		Update("allowed_client_cidrs", domain.StringList(allowed))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrRotatingProxyNotFound
+	}
+
+	if allowed == nil {
+		allowed = []string{}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+func TestNormalizeRotatorClientCIDRs(t *testing.T) {
+	got, err := NormalizeRotatorClientCIDRs([]string{" 203.0.113.7 ", "10.1.2.3/8", "2001:db8::1", "::ffff:192.0.2.0/120", "10.0.0.0/8", ""})
+	if err != nil {
+		t.Fatalf("NormalizeRotatorClientCIDRs: %v", err)
+	}
+	want := []string{"203.0.113.7/32", "10.0.0.0/8", "2001:db8::1/128", "192.0.2.0/24"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	for _, invalid := range []string{"not-an-ip", "10.0.0.0/33", "example.com"} {
+		if _, err := NormalizeRotatorClientCIDRs([]string{invalid}); err != ErrRotatingProxyClientCIDRInvalid {
+			t.Fatalf("expected ErrRotatingProxyClientCIDRInvalid for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestUpdateRotatingProxyAllowedClients_PersistsAllowlist(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, _ := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100})
+
+	allowed, err := UpdateRotatingProxyAllowedClients(user.ID, rotator.ID, dto.RotatingProxyAllowedClientsRequest{
+		AllowedClientCIDRs: []string{"198.51.100.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateRotatingProxyAllowedClients: %v", err)
+	}
+	if len(allowed) != 1 || allowed[0] != "198.51.100.0/24" {
+		t.Fatalf("unexpected allowlist %v", allowed)
+	}
+
+	var stored domain.RotatingProxy
+	if err := db.First(&stored, rotator.ID).Error; err != nil {
+		t.Fatalf("reload rotating proxy: %v", err)
+	}
+	if len(stored.AllowedClientCIDRs) != 1 || stored.AllowedClientCIDRs[0] != "198.51.100.0/24" {
+		t.Fatalf("allowlist not persisted: %v", stored.AllowedClientCIDRs)
+	}
+
+	if _, err := UpdateRotatingProxyAllowedClients(user.ID+1, rotator.ID, dto.RotatingProxyAllowedClientsRequest{}); err != ErrRotatingProxyNotFound {
+		t.Fatalf("expected ErrRotatingProxyNotFound for another user, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"magpie/internal/api/dto"
+)
+
+var ErrRotatingProxyBanHostInvalid = errors.New("ban host must be a host name or IP address")
+
+// NormalizeRotatorDestinationHost reduces a request destination ("host",
+// "host:port" or "[v6]:port") to the lowercase host that routing rules and host
+// bans match on. It returns an empty string for anything that is not a host
+// name or IP address.
+func NormalizeRotatorDestinationHost(raw string) string {
+	host := strings.TrimSpace(raw)
+	if splitHost, _, err := net.SplitHostPort(host); err == nil {
+		host = splitHost
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+
+	if host == "" || strings.Contains(host, "*") || !validRouteHostPattern(host) {
+		return ""
+	}
+	return host
+}
+
+// ResolveRotatingProxyHostBan validates a ban reported for one of a rotator's
+// upstreams and fills in its cooldown. The upstream must currently be one of
+// the rotator's candidates.
+func ResolveRotatingProxyHostBan(userID uint, rotatingProxyID uint64, payload dto.RotatingProxyHostBan) (dto.RotatingProxyHostBan, error) {
+	host := NormalizeRotatorDestinationHost(payload.Host)
+	if host == "" {
+		return dto.RotatingProxyHostBan{}, ErrRotatingProxyBanHostInvalid
+	}
+	if DB == nil {
+		return dto.RotatingProxyHostBan{}, fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
+
+	pool, err := rotatorPools.acquire(userID, rotatingProxyID)
+	if err != nil {
+		return dto.RotatingProxyHostBan{}, err
+	}
+	if _, err := pool.upstream(payload.ProxyID, RotatingProxySelection{}); err != nil {
+		return dto.RotatingProxyHostBan{}, err
+	}
+
+	cooldown := payload.CooldownSeconds
+	if cooldown == 0 {
+		pool.mu.Lock()
+		cooldown = pool.rotator.HostBanCooldownSeconds
+		pool.mu.Unlock()
+	}
+	cooldown = normalizeRotatorHostBanCooldown(cooldown)
+
+	expiresAt := time.Now().Add(time.Duration(cooldown) * time.Second)
+	return dto.RotatingProxyHostBan{
+		ProxyID:         payload.ProxyID,
+		Host:            host,
+		CooldownSeconds: cooldown,
+		ExpiresAt:       &expiresAt,
+	}, nil
+}
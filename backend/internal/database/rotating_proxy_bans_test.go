@@ -0,0 +1,47 @@
+package database
+
+import (
+	"testing"
+
+	"magpie/internal/api/dto"
+)
+
+func TestNormalizeRotatorDestinationHost(t *testing.T) {
+	cases := map[string]string{
+		"Example.COM:443":   "example.com",
+		"www.example.com.":  "www.example.com",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"203.0.113.9":       "203.0.113.9",
+		"*.example.com":     "",
+		"bad host":          "",
+		"":                  "",
+	}
+	for raw, want := range cases {
+		if got := NormalizeRotatorDestinationHost(raw); got != want {
+			t.Fatalf("NormalizeRotatorDestinationHost(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestResolveRotatingProxyHostBan(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100})
+
+	ban, err := ResolveRotatingProxyHostBan(user.ID, rotator.ID, dto.RotatingProxyHostBan{ProxyID: proxies[0].ID, Host: "Shop.Example.com:443"})
+	if err != nil {
+		t.Fatalf("ResolveRotatingProxyHostBan: %v", err)
+	}
+	if ban.Host != "shop.example.com" || ban.CooldownSeconds != DefaultRotatingProxyHostBanCooldownSeconds || ban.ExpiresAt == nil {
+		t.Fatalf("unexpected ban %+v", ban)
+	}
+
+	if _, err := ResolveRotatingProxyHostBan(user.ID, rotator.ID, dto.RotatingProxyHostBan{ProxyID: proxies[0].ID, Host: "*"}); err != ErrRotatingProxyBanHostInvalid {
+		t.Fatalf("expected ErrRotatingProxyBanHostInvalid, got %v", err)
+	}
+	if _, err := ResolveRotatingProxyHostBan(user.ID, rotator.ID, dto.RotatingProxyHostBan{ProxyID: proxies[0].ID + 100, Host: "example.com"}); err != ErrRotatingProxyUpstreamGone {
+		t.Fatalf("expected ErrRotatingProxyUpstreamGone, got %v", err)
+	}
+	if _, err := ResolveRotatingProxyHostBan(user.ID+1, rotator.ID, dto.RotatingProxyHostBan{ProxyID: proxies[0].ID, Host: "example.com"}); err != ErrRotatingProxyNotFound {
+		t.Fatalf("expected ErrRotatingProxyNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"magpie/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+const maxRotatorCountryFilters = 250
+
+var (
+	estimatedTypeSet = map[string]struct{}{
+		"residential": {},
+		"datacenter":  {},
+		"isp":         {},
+	}
+
+	// anonymityLevelOrder lists levels from most to least anonymous.
+	anonymityLevelOrder = []string{"elite", "anonymous", "transparent"}
+)
+
+// rotatorProxyFilter narrows the alive proxies a rotator may hand out.
+type rotatorProxyFilter struct {
+	ReputationLabels  []string
+	AllowedCountries  []string
+	BlockedCountries  []string
+	EstimatedTypes    []string
+	MaxResponseTimeMs uint16
+	MinAnonymityLevel string
+	TargetCheckIDs    []uint
+}
+
+func rotatorFilterFromEntity(entity domain.RotatingProxy) rotatorProxyFilter {
+	return rotatorProxyFilter{
+		ReputationLabels:  sanitizeRotatorReputationLabels(entity.ReputationLabels.Clone()),
+		AllowedCountries:  sanitizeRotatorCountries(entity.AllowedCountries.Clone()),
+		BlockedCountries:  sanitizeRotatorCountries(entity.BlockedCountries.Clone()),
+		EstimatedTypes:    sanitizeRotatorEstimatedTypes(entity.EstimatedTypes.Clone()),
+		MaxResponseTimeMs: entity.MaxResponseTimeMs,
+		MinAnonymityLevel: NormalizeRotatorAnonymityLevel(entity.MinAnonymityLevel),
+		TargetCheckIDs:    sanitizeTargetCheckIDs(entity.TargetCheckIDs.Clone()),
+	}
+}
+
+func (f rotatorProxyFilter) cacheKey(protocolID int) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s|%v",
+		buildReputationCacheKey(protocolID, f.ReputationLabels),
+		strings.Join(f.AllowedCountries, ","),
+		strings.Join(f.BlockedCountries, ","),
+		strings.Join(f.EstimatedTypes, ","),
+		f.MaxResponseTimeMs,
+		f.MinAnonymityLevel,
+		f.TargetCheckIDs,
+	)
+}
+
+// apply adds the filter conditions to an alive-proxy query. The query must
+// join the latest statistic as "ps".
+func (f rotatorProxyFilter) apply(query *gorm.DB) *gorm.DB {
+	query = applyReputationFilter(query, f.ReputationLabels)
+
+	if len(f.AllowedCountries) > 0 {
+		query = query.Where("LOWER(proxies.country) IN ?", f.AllowedCountries)
+	}
+	if len(f.BlockedCountries) > 0 {
+		query = query.Where("LOWER(proxies.country) NOT IN ?", f.BlockedCountries)
+	}
+	if len(f.EstimatedTypes) > 0 && len(f.EstimatedTypes) < len(estimatedTypeSet) {
+		query = query.Where("LOWER(proxies.estimated_type) IN ?", f.EstimatedTypes)
+	}
+	if f.MaxResponseTimeMs > 0 {
+		query = query.Where("ps.response_time <= ?", f.MaxResponseTimeMs)
+	}
+	if levels := anonymityLevelsAtLeast(f.MinAnonymityLevel); len(levels) > 0 && len(levels) < len(anonymityLevelOrder) {
+		query = query.
+			Joins("JOIN anonymity_levels al ON al.id = ps.level_id").
+			Where("LOWER(al.name) IN ?", levels)
+	}
+	// Every required target check must have passed over the rotator's
+	// protocol on its latest run.
+	for _, targetCheckID := range f.TargetCheckIDs {
+		query = query.Where(
+			"EXISTS (SELECT 1 FROM target_check_results tcr WHERE tcr.proxy_id = proxies.id AND tcr.protocol_id = ps.protocol_id AND tcr.target_check_id = ? AND tcr.passed = ?)",
+			targetCheckID, true,
+		)
+	}
+
+	return query
+}
+
+func normalizeRotatorCountries(values []string) ([]string, error) {
+	for _, raw := range values {
+		if _, ok := resolveCountryNames(raw); !ok {
+			return nil, ErrRotatingProxyCountryInvalid
+		}
+	}
+	return sanitizeRotatorCountries(values), nil
+}
+
+// sanitizeRotatorCountries trims, lowercases and de-duplicates country names.
+// Countries are matched against the name stored on each proxy, so ISO codes
+// are replaced by the names GeoLite uses for them.
+func sanitizeRotatorCountries(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(values))
+	result := make([]string, 0, len(values))
+	for _, raw := range values {
+		names, _ := resolveCountryNames(raw)
+		for _, country := range names {
+			if _, ok := seen[country]; ok {
+				continue
+			}
+			seen[country] = struct{}{}
+			result = append(result, country)
+		}
+		if len(result) >= maxRotatorCountryFilters {
+			result = result[:maxRotatorCountryFilters]
+			break
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func normalizeRotatorEstimatedTypes(values []string) ([]string, error) {
+	for _, raw := range values {
+		value := strings.ToLower(strings.TrimSpace(raw))
+		if value == "" {
+			continue
+		}
+		if _, ok := estimatedTypeSet[value]; !ok {
+			return nil, ErrRotatingProxyEstimatedType
+		}
+	}
+	return sanitizeRotatorEstimatedTypes(values), nil
+}
+
+func sanitizeRotatorEstimatedTypes(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(values))
+	result := make([]string, 0, len(values))
+	for _, raw := range values {
+		value := strings.ToLower(strings.TrimSpace(raw))
+		if _, ok := estimatedTypeSet[value]; !ok {
+			continue
+		}
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		result = append(result, value)
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func normalizeRotatorAnonymityLevel(raw string) (string, error) {
+	level := strings.ToLower(strings.TrimSpace(raw))
+	if level == "" {
+		return "", nil
+	}
+	for _, candidate := range anonymityLevelOrder {
+		if candidate == level {
+			return level, nil
+		}
+	}
+	return "", ErrRotatingProxyAnonymityLevel
+}
+
+// NormalizeRotatorAnonymityLevel maps a stored minimum anonymity level to a
+// supported value, treating unknown entries as no restriction.
+func NormalizeRotatorAnonymityLevel(raw string) string {
+	level, err := normalizeRotatorAnonymityLevel(raw)
+	if err != nil {
+		return ""
+	}
+	return level
+}
+
+// anonymityLevelsAtLeast returns the level names that are at least as
+// anonymous as minimum.
+func anonymityLevelsAtLeast(minimum string) []string {
+	for idx, level := range anonymityLevelOrder {
+		if level == minimum {
+			return anonymityLevelOrder[:idx+1]
+		}
+	}
+	return nil
+}
+
+// candidateCheck is the outcome of a candidate's latest check.
+type candidateCheck struct {
+	responseTime   uint16
+	anonymityLevel string
+}
+
+// loadCandidateChecks returns the latest check of every alive proxy matching
+// the filter.
+func loadCandidateChecks(tx *gorm.DB, userID uint, protocolID int, filter rotatorProxyFilter) (map[uint64]candidateCheck, error) {
+	type checkRow struct {
+		ProxyID        uint64
+		ResponseTime   uint16
+		AnonymityLevel *string
+	}
+
+	var rows []checkRow
+	if err := aliveProxiesQuery(tx, userID, protocolID, filter).
+		Joins("LEFT JOIN anonymity_levels candidate_level ON candidate_level.id = ps.level_id").
+		Select("proxies.id AS proxy_id, ps.response_time AS response_time, candidate_level.name AS anonymity_level").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	checks := make(map[uint64]candidateCheck, len(rows))
+	for _, row := range rows {
+		check := candidateCheck{responseTime: row.ResponseTime}
+		if row.AnonymityLevel != nil {
+			check.anonymityLevel = strings.ToLower(strings.TrimSpace(*row.AnonymityLevel))
+		}
+		checks[row.ProxyID] = check
+	}
+	return checks, nil
+}
+
+// narrowRotatingProxyCandidates keeps the proxies matching the request-level
+// filters of a selection. Unknown countries or types match nothing rather than
+// being ignored, so a request never exits somewhere it did not ask for.
+func narrowRotatingProxyCandidates(proxies []domain.Proxy, checks map[uint64]candidateCheck, selection RotatingProxySelection) []domain.Proxy {
+	countries := lowercaseSet(sanitizeRotatorCountries(selection.Countries))
+	types := lowercaseSet(selection.EstimatedTypes)
+	levels := lowercaseSet(anonymityLevelsAtLeast(NormalizeRotatorAnonymityLevel(selection.MinAnonymityLevel)))
+	if len(countries) == 0 && len(types) == 0 && len(levels) == 0 && selection.MaxResponseTimeMs == 0 {
+		return proxies
+	}
+
+	narrowed := make([]domain.Proxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		if len(countries) > 0 {
+			if _, ok := countries[strings.ToLower(strings.TrimSpace(proxy.Country))]; !ok {
+				continue
+			}
+		}
+		if len(types) > 0 {
+			if _, ok := types[strings.ToLower(strings.TrimSpace(proxy.EstimatedType))]; !ok {
+				continue
+			}
+		}
+
+		check, checked := checks[proxy.ID]
+		if selection.MaxResponseTimeMs > 0 && (!checked || check.responseTime > selection.MaxResponseTimeMs) {
+			continue
+		}
+		if len(levels) > 0 {
+			if _, ok := levels[check.anonymityLevel]; !checked || !ok {
+				continue
+			}
+		}
+		narrowed = append(narrowed, proxy)
+	}
+	return narrowed
+}
+
+func lowercaseSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, raw := range values {
+		if value := strings.ToLower(strings.TrimSpace(raw)); value != "" {
+			set[value] = struct{}{}
+		}
+	}
+	return set
+}
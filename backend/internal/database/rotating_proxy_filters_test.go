@@ -0,0 +1,188 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+func TestAliveProxiesForProtocol_AppliesRotatorFilters(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	user := domain.User{Email: "filters@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	protocol := domain.Protocol{Name: "http"}
+	if err := db.Create(&protocol).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+
+	levels := []domain.AnonymityLevel{{Name: "elite"}, {Name: "anonymous"}, {Name: "transparent"}}
+	if err := db.Create(&levels).Error; err != nil {
+		t.Fatalf("create anonymity levels: %v", err)
+	}
+
+	judge := domain.Judge{FullString: "http://judge.example.com"}
+	if err := db.Create(&judge).Error; err != nil {
+		t.Fatalf("create judge: %v", err)
+	}
+
+	type seed struct {
+		country       string
+		estimatedType string
+		latency       uint16
+		level         int
+	}
+	seeds := []seed{
+		{country: "United States", estimatedType: "Residential", latency: 300, level: levels[0].ID},
+		{country: "United States", estimatedType: "Datacenter", latency: 200, level: levels[0].ID},
+		{country: "Germany", estimatedType: "Residential", latency: 250, level: levels[0].ID},
+		{country: "United States", estimatedType: "Residential", latency: 1200, level: levels[0].ID},
+		{country: "United States", estimatedType: "Residential", latency: 400, level: levels[2].ID},
+	}
+
+	proxies := make([]domain.Proxy, len(seeds))
+	for idx, s := range seeds {
+		proxies[idx] = domain.Proxy{IP: fmt.Sprintf("10.0.4.%d", idx+1), Port: uint16(7000 + idx), Country: s.country, EstimatedType: s.estimatedType}
+		if err := db.Create(&proxies[idx]).Error; err != nil {
+			t.Fatalf("create proxy %d: %v", idx, err)
+		}
+		if err := db.Create(&domain.UserProxy{UserID: user.ID, ProxyID: proxies[idx].ID}).Error; err != nil {
+			t.Fatalf("link proxy %d: %v", idx, err)
+		}
+		level := s.level
+		stat := domain.ProxyStatistic{
+			Alive:        true,
+			Attempt:      1,
+			ResponseTime: s.latency,
+			ProtocolID:   protocol.ID,
+			LevelID:      &level,
+			ProxyID:      proxies[idx].ID,
+			JudgeID:      judge.ID,
+			CreatedAt:    time.Unix(int64(idx+1), 0),
+		}
+		if err := db.Create(&stat).Error; err != nil {
+			t.Fatalf("create statistic %d: %v", idx, err)
+		}
+	}
+
+	filter := rotatorFilterFromEntity(domain.RotatingProxy{
+		AllowedCountries:  domain.StringList{" united states "},
+		EstimatedTypes:    domain.StringList{"residential"},
+		MaxResponseTimeMs: 800,
+		MinAnonymityLevel: "anonymous",
+	})
+
+	got, err := aliveProxiesForProtocol(db, user.ID, protocol.ID, filter)
+	if err != nil {
+		t.Fatalf("aliveProxiesForProtocol: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != proxies[0].ID {
+		t.Fatalf("expected only proxy %d to match, got %+v", proxies[0].ID, got)
+	}
+
+	blocked := rotatorFilterFromEntity(domain.RotatingProxy{BlockedCountries: domain.StringList{"United States"}})
+	got, err = aliveProxiesForProtocol(db, user.ID, protocol.ID, blocked)
+	if err != nil {
+		t.Fatalf("aliveProxiesForProtocol with deny list: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != proxies[2].ID {
+		t.Fatalf("expected only proxy %d outside the deny list, got %+v", proxies[2].ID, got)
+	}
+}
+
+func TestCreateRotatingProxy_ValidatesFilterValues(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	user := domain.User{Email: "filters-invalid@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	if _, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name:           "bad-type",
+		Protocol:       "http",
+		EstimatedTypes: []string{"mobile"},
+	}); err != ErrRotatingProxyEstimatedType {
+		t.Fatalf("expected ErrRotatingProxyEstimatedType, got %v", err)
+	}
+
+	if _, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name:              "bad-level",
+		Protocol:          "http",
+		MinAnonymityLevel: "stealth",
+	}); err != ErrRotatingProxyAnonymityLevel {
+		t.Fatalf("expected ErrRotatingProxyAnonymityLevel, got %v", err)
+	}
+
+	if _, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name:             "bad-country",
+		Protocol:         "http",
+		AllowedCountries: []string{"US", "XX"},
+	}); err != ErrRotatingProxyCountryInvalid {
+		t.Fatalf("expected ErrRotatingProxyCountryInvalid, got %v", err)
+	}
+}
+
+func TestSanitizeRotatorCountries_MapsCodesToStoredNames(t *testing.T) {
+	got := sanitizeRotatorCountries([]string{" US ", "United States", "nl", "Germany"})
+	want := []string{"united states", "the netherlands", "netherlands", "germany"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("sanitizeRotatorCountries = %q, want %q", got, want)
+	}
+}
+
+func TestGetNextRotatingProxyWithSelection_NarrowsCandidatesPerRequest(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{400, 90, 250})
+
+	if err := db.Model(&proxies[0]).Update("country", "Germany").Error; err != nil {
+		t.Fatalf("update country: %v", err)
+	}
+	if err := db.Model(&proxies[2]).Update("estimated_type", "datacenter").Error; err != nil {
+		t.Fatalf("update estimated type: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		selection RotatingProxySelection
+		want      uint64
+	}{
+		{name: "country", selection: RotatingProxySelection{Countries: []string{" GERMANY "}}, want: proxies[0].ID},
+		{name: "country code", selection: RotatingProxySelection{Countries: []string{"de"}}, want: proxies[0].ID},
+		{name: "type", selection: RotatingProxySelection{EstimatedTypes: []string{"Datacenter"}}, want: proxies[2].ID},
+		{name: "latency", selection: RotatingProxySelection{MaxResponseTimeMs: 100}, want: proxies[1].ID},
+	}
+	for _, tc := range cases {
+		for i := 0; i < 2; i++ {
+			next, err := GetNextRotatingProxyWithSelection(user.ID, rotator.ID, tc.selection)
+			if err != nil {
+				t.Fatalf("%s: GetNextRotatingProxyWithSelection: %v", tc.name, err)
+			}
+			if next.ProxyID != tc.want {
+				t.Fatalf("%s: got proxy %d, want %d", tc.name, next.ProxyID, tc.want)
+			}
+		}
+	}
+
+	if _, err := GetNextRotatingProxyWithSelection(user.ID, rotator.ID, RotatingProxySelection{EstimatedTypes: []string{"mobile"}}); err != ErrRotatingProxyNoAliveProxies {
+		t.Fatalf("expected ErrRotatingProxyNoAliveProxies for an unknown type, got %v", err)
+	}
+
+	seen := make(map[uint64]bool)
+	for i := 0; i < 3; i++ {
+		next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+		if err != nil {
+			t.Fatalf("GetNextRotatingProxy: %v", err)
+		}
+		seen[next.ProxyID] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected unfiltered requests to rotate over every proxy, saw %v", seen)
+	}
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+const (
+	maxRotatingProxyHeaderRules  = 64
+	maxRotatingProxyUserAgents   = 256
+	maxRotatingProxyHeaderLength = 1024
+)
+
+var ErrRotatingProxyHeaderInvalid = errors.New("header rules must use valid header names and values and cannot change connection or framing headers")
+
+// protectedRotatorHeaders control how a request reaches the upstream and are
+// managed by the rotator itself, so header policies may not touch them.
+var protectedRotatorHeaders = map[string]struct{}{
+	"Host":                {},
+	"Connection":          {},
+	"Content-Length":      {},
+	"Transfer-Encoding":   {},
+	"Upgrade":             {},
+	"Proxy-Authorization": {},
+	"Proxy-Connection":    {},
+	"Te":                  {},
+	"Trailer":             {},
+}
+
+func normalizeRotatorHeaderName(raw string) (string, error) {
+	name := strings.TrimSpace(raw)
+	if !httpguts.ValidHeaderFieldName(name) {
+		return "", ErrRotatingProxyHeaderInvalid
+	}
+	name = http.CanonicalHeaderKey(name)
+	if _, ok := protectedRotatorHeaders[name]; ok {
+		return "", ErrRotatingProxyHeaderInvalid
+	}
+	return name, nil
+}
+
+func validRotatorHeaderValue(value string) bool {
+	return len(value) <= maxRotatingProxyHeaderLength && httpguts.ValidHeaderFieldValue(value)
+}
+
+// NormalizeRotatorStripHeaders validates the request headers a rotator removes
+// and returns their canonical names without duplicates.
+func NormalizeRotatorStripHeaders(entries []string) ([]string, error) {
+	if len(entries) > maxRotatingProxyHeaderRules {
+		return nil, ErrRotatingProxyHeaderInvalid
+	}
+
+	seen := make(map[string]struct{}, len(entries))
+	result := make([]string, 0, len(entries))
+	for _, raw := range entries {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		name, err := normalizeRotatorHeaderName(raw)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		result = append(result, name)
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// NormalizeRotatorSetHeaders validates the request headers a rotator sets or
+// overrides and returns them keyed by canonical name.
+func NormalizeRotatorSetHeaders(entries map[string]string) (map[string]string, error) {
+	if len(entries) > maxRotatingProxyHeaderRules {
+		return nil, ErrRotatingProxyHeaderInvalid
+	}
+
+	result := make(map[string]string, len(entries))
+	for rawName, rawValue := range entries {
+		name, err := normalizeRotatorHeaderName(rawName)
+		if err != nil {
+			return nil, err
+		}
+		value := strings.TrimSpace(rawValue)
+		if !validRotatorHeaderValue(value) {
+			return nil, ErrRotatingProxyHeaderInvalid
+		}
+		result[name] = value
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// NormalizeRotatorUserAgents validates the pool of User-Agent values a rotator
+// cycles through.
+func NormalizeRotatorUserAgents(entries []string) ([]string, error) {
+	if len(entries) > maxRotatingProxyUserAgents {
+		return nil, ErrRotatingProxyHeaderInvalid
+	}
+
+	seen := make(map[string]struct{}, len(entries))
+	result := make([]string, 0, len(entries))
+	for _, raw := range entries {
+		value := strings.TrimSpace(raw)
+		if value == "" {
+			continue
+		}
+		if !validRotatorHeaderValue(value) {
+			return nil, ErrRotatingProxyHeaderInvalid
+		}
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		result = append(result, value)
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// applyRotatorHeaderPolicyUpdate replaces the header rules present in the
+// payload.
+func applyRotatorHeaderPolicyUpdate(entity *domain.RotatingProxy, payload dto.RotatingProxyUpdateRequest) error {
+	if payload.StripRequestHeaders != nil {
+		strip, err := NormalizeRotatorStripHeaders(*payload.StripRequestHeaders)
+		if err != nil {
+			return err
+		}
+		entity.StripRequestHeaders = domain.StringList(strip)
+	}
+	if payload.SetRequestHeaders != nil {
+		set, err := NormalizeRotatorSetHeaders(*payload.SetRequestHeaders)
+		if err != nil {
+			return err
+		}
+		entity.SetRequestHeaders = domain.StringMap(set)
+	}
+	if payload.UserAgentPool != nil {
+		agents, err := NormalizeRotatorUserAgents(*payload.UserAgentPool)
+		if err != nil {
+			return err
+		}
+		entity.UserAgentPool = domain.StringList(agents)
+	}
+	if payload.ExposeUpstreamHeader != nil {
+		entity.ExposeUpstreamHeader = *payload.ExposeUpstreamHeader
+	}
+	return nil
+}
+
+func applyRotatorHeaderPolicyInfo(item *dto.RotatingProxy, entity domain.RotatingProxy) {
+	item.StripRequestHeaders = []string(entity.StripRequestHeaders)
+	item.SetRequestHeaders = entity.SetRequestHeaders.Clone()
+	item.UserAgentPool = []string(entity.UserAgentPool)
+	item.ExposeUpstreamHeader = entity.ExposeUpstreamHeader
+}
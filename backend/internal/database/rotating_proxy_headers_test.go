@@ -0,0 +1,77 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"magpie/internal/api/dto"
+)
+
+func TestNormalizeRotatorHeaderRules(t *testing.T) {
+	strip, err := NormalizeRotatorStripHeaders([]string{" x-forwarded-for ", "Via", "X-FORWARDED-FOR", ""})
+	if err != nil {
+		t.Fatalf("NormalizeRotatorStripHeaders: %v", err)
+	}
+	if want := []string{"X-Forwarded-For", "Via"}; !reflect.DeepEqual(strip, want) {
+		t.Fatalf("strip = %v, want %v", strip, want)
+	}
+
+	set, err := NormalizeRotatorSetHeaders(map[string]string{"accept-language": " en-US "})
+	if err != nil {
+		t.Fatalf("NormalizeRotatorSetHeaders: %v", err)
+	}
+	if want := map[string]string{"Accept-Language": "en-US"}; !reflect.DeepEqual(set, want) {
+		t.Fatalf("set = %v, want %v", set, want)
+	}
+
+	invalid := []func() error{
+		func() error { _, err := NormalizeRotatorStripHeaders([]string{"Bad Header"}); return err },
+		func() error { _, err := NormalizeRotatorStripHeaders([]string{"content-length"}); return err },
+		func() error {
+			_, err := NormalizeRotatorSetHeaders(map[string]string{"Host": "example.com"})
+			return err
+		},
+		func() error { _, err := NormalizeRotatorSetHeaders(map[string]string{"X-Test": "a\r\nb"}); return err },
+		func() error { _, err := NormalizeRotatorUserAgents([]string{"agent\nX-Injected: 1"}); return err },
+	}
+	for idx, check := range invalid {
+		if err := check(); err != ErrRotatingProxyHeaderInvalid {
+			t.Fatalf("case %d: expected ErrRotatingProxyHeaderInvalid, got %v", idx, err)
+		}
+	}
+}
+
+func TestUpdateRotatingProxy_PersistsHeaderPolicy(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, _ := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100})
+
+	strip := []string{"via"}
+	set := map[string]string{"user-agent": "magpie"}
+	agents := []string{"agent-a", "agent-b"}
+	expose := true
+	updated, err := UpdateRotatingProxy(user.ID, rotator.ID, dto.RotatingProxyUpdateRequest{
+		StripRequestHeaders:  &strip,
+		SetRequestHeaders:    &set,
+		UserAgentPool:        &agents,
+		ExposeUpstreamHeader: &expose,
+	})
+	if err != nil {
+		t.Fatalf("UpdateRotatingProxy: %v", err)
+	}
+	if !updated.ExposeUpstreamHeader || !reflect.DeepEqual(updated.StripRequestHeaders, []string{"Via"}) {
+		t.Fatalf("unexpected update response %+v", updated)
+	}
+
+	stored, err := GetRotatingProxyByID(rotator.ID)
+	if err != nil {
+		t.Fatalf("GetRotatingProxyByID: %v", err)
+	}
+	if stored.SetRequestHeaders["User-Agent"] != "magpie" || !reflect.DeepEqual([]string(stored.UserAgentPool), agents) || !stored.ExposeUpstreamHeader {
+		t.Fatalf("header policy not persisted: %+v", stored)
+	}
+
+	bad := []string{"Transfer-Encoding"}
+	if _, err := UpdateRotatingProxy(user.ID, rotator.ID, dto.RotatingProxyUpdateRequest{StripRequestHeaders: &bad}); err != ErrRotatingProxyHeaderInvalid {
+		t.Fatalf("expected ErrRotatingProxyHeaderInvalid, got %v", err)
+	}
+}
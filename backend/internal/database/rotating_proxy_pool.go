@@ -0,0 +1,419 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+
+	"github.com/charmbracelet/log"
+	"gorm.io/gorm"
+)
+
+const rotatorPoolRefreshInterval = 30 * time.Second
+
+// rotatorPool caches the upstream candidates of a single rotator so requests
+// can be served without touching the database. Rotation state is kept in
+// memory and written back by FlushRotatingProxyState.
+type rotatorPool struct {
+	mu sync.Mutex
+
+	rotatorID  uint64
+	rotator    domain.RotatingProxy
+	proxies    []domain.Proxy
+	latencies  map[uint64]uint16
+	scores     map[uint64]float32
+	checks     map[uint64]candidateCheck
+	lastUsed   map[uint64]time.Time
+	loadedAt   time.Time
+	generation uint64
+	refreshing bool
+
+	lastProxyID    *uint64
+	lastRotationAt *time.Time
+	dirty          bool
+}
+
+type rotatorPoolState struct {
+	rotator   domain.RotatingProxy
+	proxies   []domain.Proxy
+	latencies map[uint64]uint16
+	scores    map[uint64]float32
+	checks    map[uint64]candidateCheck
+}
+
+type rotatorPoolRegistry struct {
+	mu         sync.RWMutex
+	pools      map[uint64]*rotatorPool
+	generation atomic.Uint64
+}
+
+var rotatorPools = newRotatorPoolRegistry()
+
+func newRotatorPoolRegistry() *rotatorPoolRegistry {
+	return &rotatorPoolRegistry{pools: make(map[uint64]*rotatorPool)}
+}
+
+// acquire returns the pool for the rotator, loading it on first use and
+// scheduling a refresh when it is stale.
+func (r *rotatorPoolRegistry) acquire(userID uint, rotatorID uint64) (*rotatorPool, error) {
+	r.mu.RLock()
+	pool := r.pools[rotatorID]
+	r.mu.RUnlock()
+
+	if pool == nil {
+		generation := r.generation.Load()
+		state, err := loadRotatorPoolState(rotatorID)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mu.Lock()
+		if existing, ok := r.pools[rotatorID]; ok {
+			pool = existing
+		} else {
+			pool = &rotatorPool{
+				rotatorID:      rotatorID,
+				lastUsed:       make(map[uint64]time.Time),
+				lastProxyID:    state.rotator.LastProxyID,
+				lastRotationAt: state.rotator.LastRotationAt,
+			}
+			pool.apply(state, generation)
+			r.pools[rotatorID] = pool
+		}
+		r.mu.Unlock()
+	}
+
+	pool.mu.Lock()
+	owner := pool.rotator.UserID
+	pool.mu.Unlock()
+	if owner != userID {
+		return nil, ErrRotatingProxyNotFound
+	}
+
+	r.refreshIfStale(pool)
+	return pool, nil
+}
+
+// invalidate marks every pool as stale, e.g. after new statistics were stored.
+func (r *rotatorPoolRegistry) invalidate() {
+	r.generation.Add(1)
+}
+
+// reload refreshes a cached pool right away, e.g. after its rotator was
+// edited. The rotation position is kept.
+func (r *rotatorPoolRegistry) reload(rotatorID uint64) {
+	pool := r.lookup(rotatorID)
+	if pool == nil {
+		return
+	}
+	r.refresh(pool, r.generation.Load())
+}
+
+func (r *rotatorPoolRegistry) forget(rotatorID uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pools, rotatorID)
+}
+
+func (r *rotatorPoolRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pools = make(map[uint64]*rotatorPool)
+}
+
+func (r *rotatorPoolRegistry) lookup(rotatorID uint64) *rotatorPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pools[rotatorID]
+}
+
+func (r *rotatorPoolRegistry) all() []*rotatorPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pools := make([]*rotatorPool, 0, len(r.pools))
+	for _, pool := range r.pools {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// refreshIfStale reloads the pool in the background once it has aged out or
+// new statistics landed. Empty pools are reloaded inline since there is
+// nothing to serve in the meantime.
+func (r *rotatorPoolRegistry) refreshIfStale(pool *rotatorPool) {
+	generation := r.generation.Load()
+
+	pool.mu.Lock()
+	stale := pool.generation != generation || time.Since(pool.loadedAt) >= rotatorPoolRefreshInterval
+	if !stale || pool.refreshing {
+		pool.mu.Unlock()
+		return
+	}
+	pool.refreshing = true
+	empty := len(pool.proxies) == 0
+	pool.mu.Unlock()
+
+	if empty {
+		r.refresh(pool, generation)
+		return
+	}
+	go r.refresh(pool, generation)
+}
+
+func (r *rotatorPoolRegistry) refresh(pool *rotatorPool, generation uint64) {
+	state, err := loadRotatorPoolState(pool.rotatorID)
+
+	pool.mu.Lock()
+	pool.refreshing = false
+	if err != nil {
+		// Back off until the next refresh interval instead of retrying on every request.
+		pool.loadedAt = time.Now()
+		pool.mu.Unlock()
+
+		if errors.Is(err, ErrRotatingProxyNotFound) {
+			r.forget(pool.rotatorID)
+			return
+		}
+		log.Warn("rotating proxy: failed to refresh pool", "rotator_id", pool.rotatorID, "error", err)
+		return
+	}
+	pool.apply(state, generation)
+	pool.mu.Unlock()
+}
+
+// apply swaps in freshly loaded candidates. Callers must hold pool.mu unless
+// the pool is not shared yet.
+func (p *rotatorPool) apply(state rotatorPoolState, generation uint64) {
+	p.rotator = state.rotator
+	p.proxies = state.proxies
+	p.latencies = state.latencies
+	p.scores = state.scores
+	p.checks = state.checks
+	p.loadedAt = time.Now()
+	p.generation = generation
+}
+
+// next returns the upstream to serve. Rotators with a rotation interval keep
+// serving the last upstream until the interval elapsed or it dropped out of the
+// candidates.
+func (p *rotatorPool) next(selection RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidates := applyRotatingProxySelection(narrowRotatingProxyCandidates(p.proxies, p.checks, selection), selection)
+	if len(candidates) == 0 {
+		return nil, ErrRotatingProxyNoAliveProxies
+	}
+
+	now := time.Now()
+	if pinned, ok := p.pinnedLocked(candidates, now); ok {
+		p.lastUsed[pinned.ID] = now
+		return buildRotatingProxyNext(pinned, p.rotator.Protocol.Name), nil
+	}
+
+	return p.rotateLocked(candidates, now), nil
+}
+
+// rotate moves to another upstream regardless of the rotation interval. The
+// current upstream is only kept when it is the last one left.
+func (p *rotatorPool) rotate() (*dto.RotatingProxyNext, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var selection RotatingProxySelection
+	if p.lastProxyID != nil {
+		selection.AvoidProxyIDs = []uint64{*p.lastProxyID}
+	}
+
+	candidates := applyRotatingProxySelection(p.proxies, selection)
+	if len(candidates) == 0 {
+		return nil, ErrRotatingProxyNoAliveProxies
+	}
+
+	return p.rotateLocked(candidates, time.Now()), nil
+}
+
+func (p *rotatorPool) pinnedLocked(candidates []domain.Proxy, now time.Time) (domain.Proxy, bool) {
+	interval := time.Duration(p.rotator.RotationIntervalSeconds) * time.Second
+	if interval <= 0 || p.lastProxyID == nil || p.lastRotationAt == nil {
+		return domain.Proxy{}, false
+	}
+	if now.Sub(*p.lastRotationAt) >= interval {
+		return domain.Proxy{}, false
+	}
+
+	for _, proxy := range candidates {
+		if proxy.ID == *p.lastProxyID {
+			return proxy, true
+		}
+	}
+	return domain.Proxy{}, false
+}
+
+func (p *rotatorPool) rotateLocked(candidates []domain.Proxy, now time.Time) *dto.RotatingProxyNext {
+	selected := p.selectLocked(candidates)
+
+	proxyID := selected.ID
+	p.lastUsed[proxyID] = now
+	p.lastProxyID = &proxyID
+	p.lastRotationAt = &now
+	p.dirty = true
+
+	return buildRotatingProxyNext(selected, p.rotator.Protocol.Name)
+}
+
+// upstream returns the given candidate if it also satisfies the narrowing of
+// selection. Exclusions in selection are left to the caller.
+func (p *rotatorPool) upstream(proxyID uint64, selection RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, proxy := range narrowRotatingProxyCandidates(p.proxies, p.checks, selection) {
+		if proxy.ID == proxyID {
+			return buildRotatingProxyNext(proxy, p.rotator.Protocol.Name), nil
+		}
+	}
+	return nil, ErrRotatingProxyUpstreamGone
+}
+
+// pin makes proxyID the current upstream as if the pool had just rotated onto
+// it.
+func (p *rotatorPool) pin(proxyID uint64, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastProxyID = &proxyID
+	p.lastRotationAt = &at
+	p.dirty = true
+}
+
+func (p *rotatorPool) rotationState() (*uint64, *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastProxyID, p.lastRotationAt
+}
+
+// takeDirty returns the rotation state that still needs to be persisted.
+func (p *rotatorPool) takeDirty() (uint64, time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.dirty || p.lastProxyID == nil || p.lastRotationAt == nil {
+		return 0, time.Time{}, false
+	}
+	p.dirty = false
+	return *p.lastProxyID, *p.lastRotationAt, true
+}
+
+func (p *rotatorPool) markDirty() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dirty = true
+}
+
+func loadRotatorPoolState(rotatorID uint64) (rotatorPoolState, error) {
+	if DB == nil {
+		return rotatorPoolState{}, fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
+
+	var entity domain.RotatingProxy
+	if err := DB.
+		Preload("Protocol").
+		Where("id = ?", rotatorID).
+		First(&entity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return rotatorPoolState{}, ErrRotatingProxyNotFound
+		}
+		return rotatorPoolState{}, err
+	}
+
+	filter := rotatorFilterFromEntity(entity)
+	proxies, err := aliveProxiesForProtocol(DB, entity.UserID, entity.ProtocolID, filter)
+	if err != nil {
+		return rotatorPoolState{}, err
+	}
+
+	state := rotatorPoolState{rotator: entity, proxies: proxies}
+	if len(proxies) == 0 {
+		return state, nil
+	}
+
+	state.checks, err = loadCandidateChecks(DB, entity.UserID, entity.ProtocolID, filter)
+	if err != nil {
+		return rotatorPoolState{}, err
+	}
+
+	switch NormalizeRotationStrategy(entity.RotationStrategy) {
+	case RotationStrategyLowestLatency:
+		state.latencies, err = loadMedianLatencies(DB, entity.UserID, entity.ProtocolID, filter)
+	case RotationStrategyReputationWeighted:
+		state.scores, err = loadOverallReputationScores(DB, entity.UserID, entity.ProtocolID, filter)
+	}
+	if err != nil {
+		return rotatorPoolState{}, err
+	}
+
+	return state, nil
+}
+
+// FlushRotatingProxyState persists the rotation position of every rotator that
+// served traffic since the previous flush.
+func FlushRotatingProxyState(ctx context.Context) error {
+	if DB == nil {
+		return fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
+
+	db := DB
+	if ctx != nil {
+		db = db.WithContext(ctx)
+	}
+
+	var errs []error
+	for _, pool := range rotatorPools.all() {
+		lastProxyID, lastRotationAt, ok := pool.takeDirty()
+		if !ok {
+			continue
+		}
+
+		if err := db.Model(&domain.RotatingProxy{}).
+			Where("id = ?", pool.rotatorID).
+			Updates(map[string]interface{}{
+				"last_proxy_id":    lastProxyID,
+				"last_rotation_at": lastRotationAt,
+			}).Error; err != nil {
+			pool.markDirty()
+			errs = append(errs, fmt.Errorf("rotator %d: %w", pool.rotatorID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ReloadRotatingProxyPool refreshes the cached pool of a rotator that was
+// edited on another instance.
+func ReloadRotatingProxyPool(rotatorID uint64) {
+	rotatorPools.reload(rotatorID)
+}
+
+// ApplyRotatingProxyRotation moves a cached pool onto the upstream a manual
+// rotation picked on another instance, so the instance serving the rotator
+// and the one that handled the request agree on the rotation position. Pools
+// that are not cached pick the position up from the database when loaded.
+func ApplyRotatingProxyRotation(rotatorID uint64, proxyID uint64) {
+	if pool := rotatorPools.lookup(rotatorID); pool != nil {
+		pool.pin(proxyID, time.Now())
+	}
+}
+
+// ForgetRotatingProxyPool drops the cached pool of a rotator that was deleted
+// on another instance.
+func ForgetRotatingProxyPool(rotatorID uint64) {
+	rotatorPools.forget(rotatorID)
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"magpie/internal/domain"
+)
+
+func TestRotatorPool_ServesCachedCandidatesUntilRefreshed(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100, 100})
+
+	if _, err := GetNextRotatingProxy(user.ID, rotator.ID); err != nil {
+		t.Fatalf("initial rotation: %v", err)
+	}
+
+	if err := db.Where("proxy_id = ?", proxies[1].ID).Delete(&domain.ProxyStatistic{}).Error; err != nil {
+		t.Fatalf("delete statistics: %v", err)
+	}
+
+	next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+	if err != nil {
+		t.Fatalf("cached rotation: %v", err)
+	}
+	if next.ProxyID != proxies[1].ID {
+		t.Fatalf("expected cached pool to still serve proxy %d, got %d", proxies[1].ID, next.ProxyID)
+	}
+
+	pool := rotatorPools.lookup(rotator.ID)
+	if pool == nil {
+		t.Fatal("expected rotator pool to be cached")
+	}
+	rotatorPools.invalidate()
+	rotatorPools.refresh(pool, rotatorPools.generation.Load())
+
+	for i := 0; i < 3; i++ {
+		next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+		if err != nil {
+			t.Fatalf("rotation after refresh: %v", err)
+		}
+		if next.ProxyID != proxies[0].ID {
+			t.Fatalf("expected refreshed pool to drop proxy %d, got %d", proxies[1].ID, next.ProxyID)
+		}
+	}
+}
+
+func TestRotatorPool_RejectsOtherUsers(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, _ := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100})
+
+	if _, err := GetNextRotatingProxy(user.ID, rotator.ID); err != nil {
+		t.Fatalf("owner rotation: %v", err)
+	}
+	if _, err := GetNextRotatingProxy(user.ID+1, rotator.ID); err != ErrRotatingProxyNotFound {
+		t.Fatalf("expected ErrRotatingProxyNotFound for another user, got %v", err)
+	}
+}
+
+func TestFlushRotatingProxyState_PersistsOnlyOnFlush(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100, 100})
+
+	if _, err := GetNextRotatingProxy(user.ID, rotator.ID); err != nil {
+		t.Fatalf("rotation: %v", err)
+	}
+
+	var stored domain.RotatingProxy
+	if err := db.First(&stored, rotator.ID).Error; err != nil {
+		t.Fatalf("reload rotating proxy: %v", err)
+	}
+	if stored.LastProxyID != nil {
+		t.Fatal("expected rotation state to be persisted asynchronously")
+	}
+
+	if err := FlushRotatingProxyState(context.Background()); err != nil {
+		t.Fatalf("flush rotation state: %v", err)
+	}
+	if err := db.First(&stored, rotator.ID).Error; err != nil {
+		t.Fatalf("reload rotating proxy after flush: %v", err)
+	}
+	if stored.LastProxyID == nil || *stored.LastProxyID != proxies[0].ID {
+		t.Fatalf("last proxy id = %v, want %d", stored.LastProxyID, proxies[0].ID)
+	}
+	if stored.LastRotationAt == nil {
+		t.Fatal("expected last rotation timestamp after flush")
+	}
+}
+
+func TestGetNextRotatingProxy_HonoursRotationInterval(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100, 100, 100})
+	if err := db.Model(&rotator).Update("rotation_interval_seconds", 300).Error; err != nil {
+		t.Fatalf("set rotation interval: %v", err)
+	}
+
+	expectNext := func(step string, want uint64) {
+		t.Helper()
+		next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if next.ProxyID != want {
+			t.Fatalf("%s: expected proxy %d, got %d", step, want, next.ProxyID)
+		}
+	}
+
+	expectNext("first rotation", proxies[0].ID)
+	expectNext("within interval", proxies[0].ID)
+
+	rotated, err := RotateRotatingProxyNow(user.ID, rotator.ID)
+	if err != nil {
+		t.Fatalf("rotate now: %v", err)
+	}
+	if rotated.ProxyID != proxies[1].ID {
+		t.Fatalf("expected manual rotation to move to proxy %d, got %d", proxies[1].ID, rotated.ProxyID)
+	}
+	expectNext("after manual rotation", proxies[1].ID)
+
+	pool := rotatorPools.lookup(rotator.ID)
+	pool.mu.Lock()
+	expired := time.Now().Add(-301 * time.Second)
+	pool.lastRotationAt = &expired
+	pool.mu.Unlock()
+	expectNext("after interval elapsed", proxies[2].ID)
+
+	if err := db.Where("proxy_id = ?", proxies[2].ID).Delete(&domain.ProxyStatistic{}).Error; err != nil {
+		t.Fatalf("delete statistics: %v", err)
+	}
+	rotatorPools.invalidate()
+	rotatorPools.refresh(pool, rotatorPools.generation.Load())
+	expectNext("after pinned proxy died", proxies[0].ID)
+}
+
+func TestApplyRotatingProxyRotation_PinsRotationFromAnotherInstance(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100, 100, 100})
+	if err := db.Model(&rotator).Update("rotation_interval_seconds", 300).Error; err != nil {
+		t.Fatalf("set rotation interval: %v", err)
+	}
+
+	// Uncached pools are left alone and load the position from the database.
+	ApplyRotatingProxyRotation(rotator.ID, proxies[2].ID)
+	if rotatorPools.lookup(rotator.ID) != nil {
+		t.Fatal("expected no pool to be loaded for an uncached rotator")
+	}
+
+	next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+	if err != nil {
+		t.Fatalf("first rotation: %v", err)
+	}
+	if next.ProxyID != proxies[0].ID {
+		t.Fatalf("expected proxy %d, got %d", proxies[0].ID, next.ProxyID)
+	}
+
+	ApplyRotatingProxyRotation(rotator.ID, proxies[2].ID)
+
+	next, err = GetNextRotatingProxy(user.ID, rotator.ID)
+	if err != nil {
+		t.Fatalf("rotation after pin: %v", err)
+	}
+	if next.ProxyID != proxies[2].ID {
+		t.Fatalf("expected the pinned proxy %d within the interval, got %d", proxies[2].ID, next.ProxyID)
+	}
+}
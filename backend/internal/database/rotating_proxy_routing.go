@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+const (
+	maxRotatingProxyRoutingRules = 64
+	maxRotatingProxyRuleHosts    = 32
+	maxRotatingProxyHostLength   = 253
+
+	// RotatingProxyDefaultRouteHost matches every destination host.
+	RotatingProxyDefaultRouteHost = "*"
+)
+
+var ErrRotatingProxyRoutingRuleInvalid = errors.New("routing rules need valid host patterns, and only the last rule may match every host")
+
+// NormalizeRotatorRoutingRules validates an ordered routing rule list. Host
+// patterns are lowercased and rule filters are normalised like the rotator's
+// own filters.
+func NormalizeRotatorRoutingRules(rules []dto.RotatingProxyRoutingRule) (domain.RotatorRoutingRules, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxRotatingProxyRoutingRules {
+		return nil, ErrRotatingProxyRoutingRuleInvalid
+	}
+
+	result := make(domain.RotatorRoutingRules, 0, len(rules))
+	for idx, rule := range rules {
+		hosts, err := normalizeRouteHosts(rule.Hosts)
+		if err != nil {
+			return nil, err
+		}
+		if containsString(hosts, RotatingProxyDefaultRouteHost) {
+			if idx != len(rules)-1 {
+				return nil, ErrRotatingProxyRoutingRuleInvalid
+			}
+			hosts = []string{RotatingProxyDefaultRouteHost}
+		}
+
+		estimatedTypes, err := normalizeRotatorEstimatedTypes(rule.EstimatedTypes)
+		if err != nil {
+			return nil, err
+		}
+		minAnonymity, err := normalizeRotatorAnonymityLevel(rule.MinAnonymityLevel)
+		if err != nil {
+			return nil, err
+		}
+		countries, err := normalizeRotatorCountries(rule.Countries)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, domain.RotatorRoutingRule{
+			Hosts:             hosts,
+			Countries:         countries,
+			EstimatedTypes:    estimatedTypes,
+			MaxResponseTimeMs: rule.MaxResponseTimeMs,
+			MinAnonymityLevel: minAnonymity,
+		})
+	}
+
+	return result, nil
+}
+
+func normalizeRouteHosts(entries []string) ([]string, error) {
+	if len(entries) > maxRotatingProxyRuleHosts {
+		return nil, ErrRotatingProxyRoutingRuleInvalid
+	}
+
+	seen := make(map[string]struct{}, len(entries))
+	hosts := make([]string, 0, len(entries))
+	for _, raw := range entries {
+		host := strings.ToLower(strings.TrimSpace(raw))
+		if host == "" {
+			continue
+		}
+		if !validRouteHostPattern(host) {
+			return nil, ErrRotatingProxyRoutingRuleInvalid
+		}
+		if _, ok := seen[host]; ok {
+			continue
+		}
+		seen[host] = struct{}{}
+		hosts = append(hosts, host)
+	}
+
+	if len(hosts) == 0 {
+		return nil, ErrRotatingProxyRoutingRuleInvalid
+	}
+	return hosts, nil
+}
+
+// validRouteHostPattern accepts "*", IP addresses, host names and
+// "*.<host name>" wildcards.
+func validRouteHostPattern(pattern string) bool {
+	if pattern == RotatingProxyDefaultRouteHost {
+		return true
+	}
+	if len(pattern) > maxRotatingProxyHostLength {
+		return false
+	}
+	if _, err := netip.ParseAddr(pattern); err == nil {
+		return true
+	}
+
+	name := strings.TrimPrefix(pattern, "*.")
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func routingRulesToDTO(rules domain.RotatorRoutingRules) []dto.RotatingProxyRoutingRule {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	result := make([]dto.RotatingProxyRoutingRule, len(rules))
+	for idx, rule := range rules {
+		result[idx] = dto.RotatingProxyRoutingRule{
+			Hosts:             append([]string(nil), rule.Hosts...),
+			Countries:         append([]string(nil), rule.Countries...),
+			EstimatedTypes:    append([]string(nil), rule.EstimatedTypes...),
+			MaxResponseTimeMs: rule.MaxResponseTimeMs,
+			MinAnonymityLevel: rule.MinAnonymityLevel,
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+func TestNormalizeRotatorRoutingRules(t *testing.T) {
+	rules, err := NormalizeRotatorRoutingRules([]dto.RotatingProxyRoutingRule{
+		{Hosts: []string{" *.Google.com ", "*.google.com"}, EstimatedTypes: []string{"Residential"}, MinAnonymityLevel: "ELITE"},
+		{Hosts: []string{"*", "example.com"}, Countries: []string{" Germany ", "de", "US"}},
+	})
+	if err != nil {
+		t.Fatalf("NormalizeRotatorRoutingRules: %v", err)
+	}
+	want := domain.RotatorRoutingRules{
+		{Hosts: []string{"*.google.com"}, EstimatedTypes: []string{"residential"}, MinAnonymityLevel: "elite"},
+		{Hosts: []string{"*"}, Countries: []string{"germany", "united states"}},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("rules = %+v, want %+v", rules, want)
+	}
+
+	invalid := [][]dto.RotatingProxyRoutingRule{
+		{{Hosts: nil}},
+		{{Hosts: []string{"exa mple.com"}}},
+		{{Hosts: []string{"*example.com"}}},
+		{{Hosts: []string{"*"}}, {Hosts: []string{"example.com"}}},
+	}
+	for idx, rules := range invalid {
+		if _, err := NormalizeRotatorRoutingRules(rules); err != ErrRotatingProxyRoutingRuleInvalid {
+			t.Fatalf("case %d: expected ErrRotatingProxyRoutingRuleInvalid, got %v", idx, err)
+		}
+	}
+
+	if _, err := NormalizeRotatorRoutingRules([]dto.RotatingProxyRoutingRule{{Hosts: []string{"*"}, MinAnonymityLevel: "stealth"}}); err != ErrRotatingProxyAnonymityLevel {
+		t.Fatalf("expected ErrRotatingProxyAnonymityLevel, got %v", err)
+	}
+	if _, err := NormalizeRotatorRoutingRules([]dto.RotatingProxyRoutingRule{{Hosts: []string{"*"}, Countries: []string{"XX"}}}); err != ErrRotatingProxyCountryInvalid {
+		t.Fatalf("expected ErrRotatingProxyCountryInvalid, got %v", err)
+	}
+}
+
+func TestGetNextRotatingProxyWithSelection_HonoursMinAnonymityLevel(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100, 120})
+
+	level := domain.AnonymityLevel{Name: "elite"}
+	if err := db.Create(&level).Error; err != nil {
+		t.Fatalf("create anonymity level: %v", err)
+	}
+	if err := db.Model(&domain.ProxyStatistic{}).Where("proxy_id = ?", proxies[1].ID).Update("level_id", level.ID).Error; err != nil {
+		t.Fatalf("update statistic level: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		next, err := GetNextRotatingProxyWithSelection(user.ID, rotator.ID, RotatingProxySelection{MinAnonymityLevel: "anonymous"})
+		if err != nil {
+			t.Fatalf("GetNextRotatingProxyWithSelection: %v", err)
+		}
+		if next.ProxyID != proxies[1].ID {
+			t.Fatalf("got proxy %d, want elite proxy %d", next.ProxyID, proxies[1].ID)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"magpie/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RotationStrategyRoundRobin         = "round_robin"
+	RotationStrategyRandom             = "random"
+	RotationStrategyLeastRecentlyUsed  = "least_recently_used"
+	RotationStrategyLowestLatency      = "lowest_latency"
+	RotationStrategyReputationWeighted = "reputation_weighted"
+
+	latencyWindow           = 24 * time.Hour
+	minimumReputationWeight = 1.0
+)
+
+var rotationStrategies = map[string]struct{}{
+	RotationStrategyRoundRobin:         {},
+	RotationStrategyRandom:             {},
+	RotationStrategyLeastRecentlyUsed:  {},
+	RotationStrategyLowestLatency:      {},
+	RotationStrategyReputationWeighted: {},
+}
+
+var (
+	strategyRandMu sync.Mutex
+	strategyRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func normalizeRotationStrategy(raw string) (string, error) {
+	strategy := strings.ToLower(strings.TrimSpace(raw))
+	if strategy == "" {
+		return RotationStrategyRoundRobin, nil
+	}
+	if _, ok := rotationStrategies[strategy]; !ok {
+		return "", ErrRotatingProxyStrategyInvalid
+	}
+	return strategy, nil
+}
+
+// NormalizeRotationStrategy maps a stored strategy to a supported value,
+// falling back to round-robin for unknown entries.
+func NormalizeRotationStrategy(raw string) string {
+	strategy, err := normalizeRotationStrategy(raw)
+	if err != nil {
+		return RotationStrategyRoundRobin
+	}
+	return strategy
+}
+
+// selectLocked picks the next upstream from candidates, which must be
+// non-empty and ordered by proxy id. Callers must hold p.mu.
+func (p *rotatorPool) selectLocked(candidates []domain.Proxy) domain.Proxy {
+	switch NormalizeRotationStrategy(p.rotator.RotationStrategy) {
+	case RotationStrategyRandom:
+		return candidates[randomIndex(len(candidates))]
+	case RotationStrategyLeastRecentlyUsed:
+		return selectLeastRecentlyUsed(candidates, p.lastUsed)
+	case RotationStrategyLowestLatency:
+		return selectLowestLatency(candidates, p.latencies)
+	case RotationStrategyReputationWeighted:
+		return selectWeightedByReputation(candidates, p.scores)
+	default:
+		return selectNextProxy(candidates, p.lastProxyID)
+	}
+}
+
+// selectLeastRecentlyUsed prefers proxies that were never served, then the
+// one served longest ago.
+func selectLeastRecentlyUsed(proxies []domain.Proxy, lastUsed map[uint64]time.Time) domain.Proxy {
+	selected := proxies[0]
+	var oldest time.Time
+	for idx, proxy := range proxies {
+		last, ok := lastUsed[proxy.ID]
+		if !ok {
+			return proxy
+		}
+		if idx == 0 || last.Before(oldest) {
+			selected = proxy
+			oldest = last
+		}
+	}
+	return selected
+}
+
+func randomIndex(n int) int {
+	strategyRandMu.Lock()
+	defer strategyRandMu.Unlock()
+	return strategyRand.Intn(n)
+}
+
+func randomFloat() float64 {
+	strategyRandMu.Lock()
+	defer strategyRandMu.Unlock()
+	return strategyRand.Float64()
+}
+
+// loadMedianLatencies returns the median response time of successful checks
+// over the last day for each proxy matching the rotator's filter. The median
+// is computed in the database with window functions, which Postgres and
+// SQLite both support.
+func loadMedianLatencies(tx *gorm.DB, userID uint, protocolID int, filter rotatorProxyFilter) (map[uint64]uint16, error) {
+	type latencyRow struct {
+		ProxyID      uint64
+		ResponseTime float64
+	}
+
+	candidates := aliveProxiesQuery(tx, userID, protocolID, filter).Select("proxies.id")
+	ranked := tx.
+		Table("proxy_statistics AS samples").
+		Select("samples.proxy_id, samples.response_time, "+
+			"ROW_NUMBER() OVER (PARTITION BY samples.proxy_id ORDER BY samples.response_time) AS position, "+
+			"COUNT(*) OVER (PARTITION BY samples.proxy_id) AS total").
+		Where("samples.protocol_id = ? AND samples.alive = ? AND samples.created_at >= ?", protocolID, true, time.Now().Add(-latencyWindow)).
+		Where("samples.proxy_id IN (?)", candidates)
+
+	var rows []latencyRow
+	if err := tx.
+		Table("(?) AS ranked", ranked).
+		Select("ranked.proxy_id, AVG(ranked.response_time) AS response_time").
+		Where("ranked.position IN ((ranked.total + 1) / 2, (ranked.total + 2) / 2)").
+		Group("ranked.proxy_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	medians := make(map[uint64]uint16, len(rows))
+	for _, row := range rows {
+		medians[row.ProxyID] = uint16(math.Round(row.ResponseTime))
+	}
+	return medians, nil
+}
+
+// selectLowestLatency returns the proxy with the lowest median latency.
+// Proxies without recent samples are only used when none have data.
+func selectLowestLatency(proxies []domain.Proxy, latencies map[uint64]uint16) domain.Proxy {
+	selected := proxies[0]
+	best, found := uint16(0), false
+	for _, proxy := range proxies {
+		latency, ok := latencies[proxy.ID]
+		if !ok {
+			continue
+		}
+		if !found || latency < best {
+			selected, best, found = proxy, latency, true
+		}
+	}
+	return selected
+}
+
+func loadOverallReputationScores(tx *gorm.DB, userID uint, protocolID int, filter rotatorProxyFilter) (map[uint64]float32, error) {
+	candidates := aliveProxiesQuery(tx, userID, protocolID, filter).Select("proxies.id")
+
+	var reputations []domain.ProxyReputation
+	if err := tx.
+		Model(&domain.ProxyReputation{}).
+		Select("proxy_id, score").
+		Where("kind = ? AND proxy_id IN (?)", domain.ProxyReputationKindOverall, candidates).
+		Find(&reputations).Error; err != nil {
+		return nil, err
+	}
+
+	scores := make(map[uint64]float32, len(reputations))
+	for _, rep := range reputations {
+		scores[rep.ProxyID] = rep.Score
+	}
+	return scores, nil
+}
+
+// selectWeightedByReputation picks a proxy at random with a probability
+// proportional to its overall reputation score. Unscored proxies get the
+// minimum weight so they are still exercised occasionally.
+func selectWeightedByReputation(proxies []domain.Proxy, scores map[uint64]float32) domain.Proxy {
+	weights := make([]float64, len(proxies))
+	var total float64
+	for idx, proxy := range proxies {
+		weight := float64(scores[proxy.ID])
+		if weight < minimumReputationWeight {
+			weight = minimumReputationWeight
+		}
+		weights[idx] = weight
+		total += weight
+	}
+
+	target := randomFloat() * total
+	for idx, weight := range weights {
+		target -= weight
+		if target < 0 {
+			return proxies[idx]
+		}
+	}
+	return proxies[len(proxies)-1]
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+func seedStrategyRotator(t *testing.T, db *gorm.DB, strategy string, latencies []uint16) (domain.User, domain.RotatingProxy, []domain.Proxy) {
+	t.Helper()
+
+	user := domain.User{Email: "strategy@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	protocol := domain.Protocol{Name: "http"}
+	if err := db.Create(&protocol).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+
+	judge := domain.Judge{FullString: "http://judge.example.com"}
+	if err := db.Create(&judge).Error; err != nil {
+		t.Fatalf("create judge: %v", err)
+	}
+
+	proxies := make([]domain.Proxy, len(latencies))
+	for idx, latency := range latencies {
+		proxies[idx] = domain.Proxy{IP: fmt.Sprintf("10.0.3.%d", idx+1), Port: uint16(9000 + idx), Country: "AA", EstimatedType: "residential"}
+		if err := db.Create(&proxies[idx]).Error; err != nil {
+			t.Fatalf("create proxy %d: %v", idx, err)
+		}
+		if err := db.Create(&domain.UserProxy{UserID: user.ID, ProxyID: proxies[idx].ID}).Error; err != nil {
+			t.Fatalf("link proxy %d: %v", idx, err)
+		}
+		stat := domain.ProxyStatistic{
+			Alive:        true,
+			Attempt:      1,
+			ResponseTime: latency,
+			ProtocolID:   protocol.ID,
+			ProxyID:      proxies[idx].ID,
+			JudgeID:      judge.ID,
+			CreatedAt:    time.Now().Add(-time.Minute),
+		}
+		if err := db.Create(&stat).Error; err != nil {
+			t.Fatalf("create statistic %d: %v", idx, err)
+		}
+	}
+
+	rotator := domain.RotatingProxy{
+		UserID:           user.ID,
+		Name:             "strategy-rotator",
+		ProtocolID:       protocol.ID,
+		ListenPort:       11000,
+		RotationStrategy: strategy,
+	}
+	if err := db.Create(&rotator).Error; err != nil {
+		t.Fatalf("create rotating proxy: %v", err)
+	}
+
+	return user, rotator, proxies
+}
+
+func TestGetNextRotatingProxy_LowestLatencyPrefersFastestProxy(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyLowestLatency, []uint16{400, 90, 250})
+
+	for i := 0; i < 3; i++ {
+		next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+		if err != nil {
+			t.Fatalf("GetNextRotatingProxy call %d: %v", i, err)
+		}
+		if next.ProxyID != proxies[1].ID {
+			t.Fatalf("call %d selected proxy %d, want fastest %d", i, next.ProxyID, proxies[1].ID)
+		}
+	}
+}
+
+func TestLoadMedianLatencies_UsesRecentSuccessfulChecks(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyLowestLatency, []uint16{400, 90, 250})
+
+	var judge domain.Judge
+	if err := db.First(&judge).Error; err != nil {
+		t.Fatalf("load judge: %v", err)
+	}
+
+	// Older samples outweigh proxy 1's latest check; failed and stale checks
+	// are ignored.
+	samples := []domain.ProxyStatistic{
+		{Alive: true, ResponseTime: 380, ProxyID: proxies[0].ID, CreatedAt: time.Now().Add(-2 * time.Hour)},
+		{Alive: true, ResponseTime: 900, ProxyID: proxies[1].ID, CreatedAt: time.Now().Add(-2 * time.Hour)},
+		{Alive: true, ResponseTime: 950, ProxyID: proxies[1].ID, CreatedAt: time.Now().Add(-3 * time.Hour)},
+		{Alive: false, ResponseTime: 5, ProxyID: proxies[2].ID, CreatedAt: time.Now().Add(-2 * time.Hour)},
+		{Alive: true, ResponseTime: 10, ProxyID: proxies[2].ID, CreatedAt: time.Now().Add(-2 * latencyWindow)},
+	}
+	for idx := range samples {
+		samples[idx].Attempt, samples[idx].ProtocolID, samples[idx].JudgeID = 1, rotator.ProtocolID, judge.ID
+	}
+	if err := db.Create(&samples).Error; err != nil {
+		t.Fatalf("create samples: %v", err)
+	}
+
+	medians, err := loadMedianLatencies(db, user.ID, rotator.ProtocolID, rotatorFilterFromEntity(rotator))
+	if err != nil {
+		t.Fatalf("loadMedianLatencies: %v", err)
+	}
+	want := map[uint64]uint16{proxies[0].ID: 390, proxies[1].ID: 900, proxies[2].ID: 250}
+	if fmt.Sprint(medians) != fmt.Sprint(want) {
+		t.Fatalf("medians = %v, want %v", medians, want)
+	}
+
+	next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+	if err != nil {
+		t.Fatalf("GetNextRotatingProxy: %v", err)
+	}
+	if next.ProxyID != proxies[2].ID {
+		t.Fatalf("selected proxy %d, want %d with the lowest median", next.ProxyID, proxies[2].ID)
+	}
+}
+
+func TestGetNextRotatingProxy_LeastRecentlyUsedCyclesThroughPool(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyLeastRecentlyUsed, []uint16{100, 100, 100})
+
+	seen := make(map[uint64]bool, len(proxies))
+	for i := 0; i < len(proxies); i++ {
+		next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+		if err != nil {
+			t.Fatalf("GetNextRotatingProxy call %d: %v", i, err)
+		}
+		if seen[next.ProxyID] {
+			t.Fatalf("proxy %d reused before the pool was exhausted", next.ProxyID)
+		}
+		seen[next.ProxyID] = true
+	}
+
+	next, err := GetNextRotatingProxy(user.ID, rotator.ID)
+	if err != nil {
+		t.Fatalf("GetNextRotatingProxy after full cycle: %v", err)
+	}
+	if next.ProxyID != proxies[0].ID {
+		t.Fatalf("expected least recently used proxy %d, got %d", proxies[0].ID, next.ProxyID)
+	}
+}
+
+func TestLoadOverallReputationScores_CoversCandidatePool(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, proxies := seedStrategyRotator(t, db, RotationStrategyReputationWeighted, []uint16{100, 100, 100})
+
+	reputations := []domain.ProxyReputation{
+		{ProxyID: proxies[0].ID, Kind: domain.ProxyReputationKindOverall, Score: 80, Label: "good"},
+		{ProxyID: proxies[1].ID, Kind: "http", Score: 40, Label: "neutral"},
+	}
+	if err := db.Create(&reputations).Error; err != nil {
+		t.Fatalf("create reputations: %v", err)
+	}
+
+	scores, err := loadOverallReputationScores(db, user.ID, rotator.ProtocolID, rotatorFilterFromEntity(rotator))
+	if err != nil {
+		t.Fatalf("loadOverallReputationScores: %v", err)
+	}
+	if len(scores) != 1 || scores[proxies[0].ID] != 80 {
+		t.Fatalf("expected only the overall score of proxy %d, got %v", proxies[0].ID, scores)
+	}
+}
+
+func TestSelectWeightedByReputation_FavoursHigherScores(t *testing.T) {
+	proxies := []domain.Proxy{{ID: 1}, {ID: 2}}
+	scores := map[uint64]float32{1: 1, 2: 99}
+
+	picks := map[uint64]int{}
+	for i := 0; i < 1000; i++ {
+		picks[selectWeightedByReputation(proxies, scores).ID]++
+	}
+	if picks[2] <= picks[1] {
+		t.Fatalf("expected higher scored proxy to be picked more often, got %v", picks)
+	}
+}
+
+func TestCreateRotatingProxy_RejectsUnknownStrategy(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	user := domain.User{Email: "strategy-invalid@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := db.Create(&domain.Protocol{Name: "http"}).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+
+	_, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name:             "bad-strategy",
+		Protocol:         "http",
+		RotationStrategy: "fastest-ever",
+	})
+	if err != ErrRotatingProxyStrategyInvalid {
+		t.Fatalf("expected ErrRotatingProxyStrategyInvalid, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/config"
+	"magpie/internal/domain"
+	"magpie/internal/security"
+)
+
+const rotatingProxyCertificateValidity = 365 * 24 * time.Hour
+
+var (
+	ErrRotatingProxyTLSCertificate   = errors.New("tls certificate and private key must be a matching PEM encoded pair")
+	ErrRotatingProxyTLSRequiresHTTPS = errors.New("tls certificates can only be used with the https listen protocol")
+)
+
+// resolveRotatorCertificate validates an uploaded certificate and key for an
+// https rotator, or generates a self-signed pair when none was uploaded.
+func resolveRotatorCertificate(listenProtocol, certPEM, keyPEM string) (string, string, error) {
+	certPEM, keyPEM = strings.TrimSpace(certPEM), strings.TrimSpace(keyPEM)
+
+	if listenProtocol != RotatingProxyListenHTTPS {
+		if certPEM != "" || keyPEM != "" {
+			return "", "", ErrRotatingProxyTLSRequiresHTTPS
+		}
+		return "", "", nil
+	}
+
+	if certPEM == "" && keyPEM == "" {
+		return generateRotatorCertificate()
+	}
+
+	if _, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM)); err != nil {
+		return "", "", ErrRotatingProxyTLSCertificate
+	}
+	return certPEM, keyPEM, nil
+}
+
+// generateRotatorCertificate issues a self-signed certificate for the address
+// this instance is reachable on. Clients are expected to pin it, since it is
+// not signed by a public authority.
+func generateRotatorCertificate() (string, string, error) {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if currentIP := strings.TrimSpace(config.GetCurrentIp()); currentIP != "" {
+		hosts = append(hosts, currentIP)
+	}
+	return security.GenerateSelfSignedCertificate("Magpie Rotator", hosts, rotatingProxyCertificateValidity)
+}
+
+// applyRotatorCertificateUpdate replaces or regenerates the certificate of an
+// https rotator.
+func applyRotatorCertificateUpdate(entity *domain.RotatingProxy, payload dto.RotatingProxyUpdateRequest) error {
+	regenerate := payload.RegenerateTLSCertificate != nil && *payload.RegenerateTLSCertificate
+	if payload.TLSCertificate == nil && payload.TLSPrivateKey == nil && !regenerate {
+		return nil
+	}
+
+	listenProtocol := NormalizeRotatorListenProtocol(entity.ListenProtocol)
+	if listenProtocol != RotatingProxyListenHTTPS {
+		return ErrRotatingProxyTLSRequiresHTTPS
+	}
+
+	var certPEM, keyPEM string
+	if !regenerate {
+		if payload.TLSCertificate == nil || payload.TLSPrivateKey == nil {
+			return ErrRotatingProxyTLSCertificate
+		}
+		certPEM, keyPEM = *payload.TLSCertificate, *payload.TLSPrivateKey
+		if strings.TrimSpace(certPEM) == "" || strings.TrimSpace(keyPEM) == "" {
+			return ErrRotatingProxyTLSCertificate
+		}
+	}
+
+	certPEM, keyPEM, err := resolveRotatorCertificate(listenProtocol, certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	entity.TLSCertificate, entity.TLSPrivateKey = certPEM, keyPEM
+	return nil
+}
+
+// applyRotatorCertificateInfo publishes the certificate clients need to trust
+// an https rotator. The private key never leaves the backend.
+func applyRotatorCertificateInfo(target *dto.RotatingProxy, certPEM string) {
+	if certPEM == "" {
+		return
+	}
+
+	target.TLSCertificate = certPEM
+	fingerprint, notAfter, err := security.CertificateFingerprint(certPEM)
+	if err != nil {
+		return
+	}
+	target.TLSFingerprint = fingerprint
+	target.TLSExpiresAt = &notAfter
+}
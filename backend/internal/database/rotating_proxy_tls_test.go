@@ -0,0 +1,116 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+	"magpie/internal/security"
+)
+
+func TestCreateRotatingProxy_GeneratesCertificateForHTTPSListener(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, _, _ := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100})
+
+	created, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name:           "tls-rotator",
+		Protocol:       "http",
+		ListenProtocol: "HTTPS",
+	})
+	if err != nil {
+		t.Fatalf("CreateRotatingProxy: %v", err)
+	}
+	if created.ListenProtocol != RotatingProxyListenHTTPS {
+		t.Fatalf("listen protocol = %q, want https", created.ListenProtocol)
+	}
+	if !strings.Contains(created.TLSCertificate, "BEGIN CERTIFICATE") || created.TLSFingerprint == "" || created.TLSExpiresAt == nil {
+		t.Fatalf("expected certificate details in the response, got %+v", created)
+	}
+
+	var stored domain.RotatingProxy
+	if err := db.First(&stored, created.ID).Error; err != nil {
+		t.Fatalf("reload rotating proxy: %v", err)
+	}
+	if !security.IsProxySecretEncrypted(stored.TLSPrivateKeyEncrypted) {
+		t.Fatal("expected the private key to be stored encrypted")
+	}
+	if !strings.Contains(stored.TLSPrivateKey, "PRIVATE KEY") {
+		t.Fatal("expected the private key to be decrypted on load")
+	}
+
+	listed, err := ListRotatingProxies(user.ID)
+	if err != nil {
+		t.Fatalf("ListRotatingProxies: %v", err)
+	}
+	for _, item := range listed {
+		if item.ID == created.ID && item.TLSFingerprint != created.TLSFingerprint {
+			t.Fatalf("listed fingerprint = %q, want %q", item.TLSFingerprint, created.TLSFingerprint)
+		}
+	}
+}
+
+func TestCreateRotatingProxy_ValidatesUploadedCertificate(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, _, _ := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100})
+
+	certPEM, keyPEM, err := security.GenerateSelfSignedCertificate("uploaded", []string{"proxy.example.com"}, time.Hour)
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	_, otherKey, err := security.GenerateSelfSignedCertificate("other", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("generate second certificate: %v", err)
+	}
+
+	if _, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name: "mismatched", Protocol: "http", ListenProtocol: "https", TLSCertificate: certPEM, TLSPrivateKey: otherKey,
+	}); err != ErrRotatingProxyTLSCertificate {
+		t.Fatalf("expected ErrRotatingProxyTLSCertificate for a mismatched key, got %v", err)
+	}
+
+	if _, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name: "plain", Protocol: "http", TLSCertificate: certPEM, TLSPrivateKey: keyPEM,
+	}); err != ErrRotatingProxyTLSRequiresHTTPS {
+		t.Fatalf("expected ErrRotatingProxyTLSRequiresHTTPS for an http listener, got %v", err)
+	}
+
+	created, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{
+		Name: "uploaded", Protocol: "http", ListenProtocol: "https", TLSCertificate: certPEM, TLSPrivateKey: keyPEM,
+	})
+	if err != nil {
+		t.Fatalf("CreateRotatingProxy: %v", err)
+	}
+	if created.TLSCertificate != strings.TrimSpace(certPEM) {
+		t.Fatal("expected the uploaded certificate to be kept")
+	}
+}
+
+func TestUpdateRotatingProxy_RegeneratesCertificate(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+	user, rotator, _ := seedStrategyRotator(t, db, RotationStrategyRoundRobin, []uint16{100})
+
+	regenerate := true
+	if _, err := UpdateRotatingProxy(user.ID, rotator.ID, dto.RotatingProxyUpdateRequest{RegenerateTLSCertificate: &regenerate}); err != ErrRotatingProxyTLSRequiresHTTPS {
+		t.Fatalf("expected ErrRotatingProxyTLSRequiresHTTPS for an http rotator, got %v", err)
+	}
+
+	created, err := CreateRotatingProxy(user.ID, dto.RotatingProxyCreateRequest{Name: "tls", Protocol: "http", ListenProtocol: "https"})
+	if err != nil {
+		t.Fatalf("CreateRotatingProxy: %v", err)
+	}
+
+	updated, err := UpdateRotatingProxy(user.ID, created.ID, dto.RotatingProxyUpdateRequest{RegenerateTLSCertificate: &regenerate})
+	if err != nil {
+		t.Fatalf("UpdateRotatingProxy: %v", err)
+	}
+	if updated.TLSFingerprint == "" || updated.TLSFingerprint == created.TLSFingerprint {
+		t.Fatalf("expected a new certificate, fingerprint %q -> %q", created.TLSFingerprint, updated.TLSFingerprint)
+	}
+
+	certOnly := updated.TLSCertificate
+	if _, err := UpdateRotatingProxy(user.ID, created.ID, dto.RotatingProxyUpdateRequest{TLSCertificate: &certOnly}); err != ErrRotatingProxyTLSCertificate {
+		t.Fatalf("expected ErrRotatingProxyTLSCertificate without a key, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+const (
+	rotatingProxyTrafficBucket      = time.Hour
+	RotatingProxyTrafficRetention   = 30 * 24 * time.Hour
+	rotatingProxyTrafficPrunePeriod = time.Hour
+	rotatingProxyTrafficBatchSize   = 500
+)
+
+// RotatingProxyTrafficSample is one observation reported by a rotator. ProxyID
+// is the upstream that handled it, or 0 when none did. A non-zero Latency adds
+// one latency sample.
+type RotatingProxyTrafficSample struct {
+	RotatingProxyID uint64
+	ProxyID         uint64
+	Requests        int64
+	Tunnels         int64
+	BytesIn         int64
+	BytesOut        int64
+	Errors          int64
+	Latency         time.Duration
+}
+
+type trafficKey struct {
+	rotatorID   uint64
+	proxyID     uint64
+	bucketStart time.Time
+}
+
+// trafficAccumulator buffers samples in memory until FlushRotatingProxyTraffic
+// merges them into the hourly aggregates.
+type trafficAccumulator struct {
+	mu        sync.Mutex
+	pending   map[trafficKey]*domain.RotatingProxyTraffic
+	lastPrune time.Time
+}
+
+var rotatorTraffic = newTrafficAccumulator()
+
+func newTrafficAccumulator() *trafficAccumulator {
+	return &trafficAccumulator{pending: make(map[trafficKey]*domain.RotatingProxyTraffic)}
+}
+
+func (a *trafficAccumulator) add(sample RotatingProxyTrafficSample, now time.Time) {
+	key := trafficKey{
+		rotatorID:   sample.RotatingProxyID,
+		proxyID:     sample.ProxyID,
+		bucketStart: now.UTC().Truncate(rotatingProxyTrafficBucket),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, ok := a.pending[key]
+	if !ok {
+		entry = &domain.RotatingProxyTraffic{
+			RotatingProxyID: key.rotatorID,
+			ProxyID:         key.proxyID,
+			BucketStart:     key.bucketStart,
+		}
+		a.pending[key] = entry
+	}
+
+	entry.Requests += sample.Requests
+	entry.Tunnels += sample.Tunnels
+	entry.BytesIn += sample.BytesIn
+	entry.BytesOut += sample.BytesOut
+	entry.Errors += sample.Errors
+	if sample.Latency > 0 {
+		entry.LatencyTotalMs += sample.Latency.Milliseconds()
+		entry.LatencySamples++
+	}
+}
+
+func (a *trafficAccumulator) take() []domain.RotatingProxyTraffic {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.pending) == 0 {
+		return nil
+	}
+
+	entries := make([]domain.RotatingProxyTraffic, 0, len(a.pending))
+	for _, entry := range a.pending {
+		entries = append(entries, *entry)
+	}
+	a.pending = make(map[trafficKey]*domain.RotatingProxyTraffic)
+	return entries
+}
+
+// restore puts entries that failed to persist back so the next flush retries
+// them.
+func (a *trafficAccumulator) restore(entries []domain.RotatingProxyTraffic) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, entry := range entries {
+		key := trafficKey{rotatorID: entry.RotatingProxyID, proxyID: entry.ProxyID, bucketStart: entry.BucketStart}
+		current, ok := a.pending[key]
+		if !ok {
+			copied := entry
+			a.pending[key] = &copied
+			continue
+		}
+		current.Requests += entry.Requests
+		current.Tunnels += entry.Tunnels
+		current.BytesIn += entry.BytesIn
+		current.BytesOut += entry.BytesOut
+		current.Errors += entry.Errors
+		current.LatencyTotalMs += entry.LatencyTotalMs
+		current.LatencySamples += entry.LatencySamples
+	}
+}
+
+func (a *trafficAccumulator) forget(rotatorID uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key := range a.pending {
+		if key.rotatorID == rotatorID {
+			delete(a.pending, key)
+		}
+	}
+}
+
+func (a *trafficAccumulator) shouldPrune(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Sub(a.lastPrune) < rotatingProxyTrafficPrunePeriod {
+		return false
+	}
+	a.lastPrune = now
+	return true
+}
+
+func (a *trafficAccumulator) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = make(map[trafficKey]*domain.RotatingProxyTraffic)
+	a.lastPrune = time.Time{}
+}
+
+// RecordRotatingProxyTraffic buffers a traffic sample for a rotator. Samples
+// are persisted by FlushRotatingProxyTraffic.
+func RecordRotatingProxyTraffic(sample RotatingProxyTrafficSample) {
+	if sample.RotatingProxyID == 0 {
+		return
+	}
+	rotatorTraffic.add(sample, time.Now())
+}
+
+// ForgetRotatingProxyTraffic drops the buffered traffic of a rotator that was
+// deleted on another instance.
+func ForgetRotatingProxyTraffic(rotatorID uint64) {
+	rotatorTraffic.forget(rotatorID)
+}
+
+// FlushRotatingProxyTraffic merges the buffered samples into the hourly
+// aggregates and drops aggregates older than RotatingProxyTrafficRetention.
+func FlushRotatingProxyTraffic(ctx context.Context) error {
+	if DB == nil {
+		return fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
+
+	db := DB
+	if ctx != nil {
+		db = db.WithContext(ctx)
+	}
+
+	var errs []error
+	if entries := rotatorTraffic.take(); len(entries) > 0 {
+		entries, err := dropDeletedRotatorTraffic(db, entries)
+		if err == nil && len(entries) > 0 {
+			err = upsertRotatingProxyTraffic(db, entries)
+		}
+		if err != nil {
+			rotatorTraffic.restore(entries)
+			errs = append(errs, fmt.Errorf("persist traffic: %w", err))
+		}
+	}
+
+	now := time.Now()
+	if rotatorTraffic.shouldPrune(now) {
+		cutoff := now.UTC().Add(-RotatingProxyTrafficRetention)
+		if err := db.Where("bucket_start < ?", cutoff).Delete(&domain.RotatingProxyTraffic{}).Error; err != nil {
+			errs = append(errs, fmt.Errorf("prune traffic: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// dropDeletedRotatorTraffic discards entries of rotators that no longer exist.
+// Tunnels of a deleted rotator can still report traffic after it was removed,
+// and a single such entry would make the whole batch fail its foreign key.
+func dropDeletedRotatorTraffic(db *gorm.DB, entries []domain.RotatingProxyTraffic) ([]domain.RotatingProxyTraffic, error) {
+	seen := make(map[uint64]struct{})
+	ids := make([]uint64, 0)
+	for _, entry := range entries {
+		if _, ok := seen[entry.RotatingProxyID]; !ok {
+			seen[entry.RotatingProxyID] = struct{}{}
+			ids = append(ids, entry.RotatingProxyID)
+		}
+	}
+
+	var existing []uint64
+	if err := db.Model(&domain.RotatingProxy{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+		return entries, err
+	}
+	if len(existing) == len(ids) {
+		return entries, nil
+	}
+
+	alive := make(map[uint64]struct{}, len(existing))
+	for _, id := range existing {
+		alive[id] = struct{}{}
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if _, ok := alive[entry.RotatingProxyID]; ok {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+func upsertRotatingProxyTraffic(db *gorm.DB, entries []domain.RotatingProxyTraffic) error {
+	increments := map[string]interface{}{}
+	for _, column := range []string{"requests", "tunnels", "bytes_in", "bytes_out", "errors", "latency_total_ms", "latency_samples"} {
+		increments[column] = gorm.Expr(fmt.Sprintf("rotating_proxy_traffic.%[1]s + excluded.%[1]s", column))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "rotating_proxy_id"}, {Name: "proxy_id"}, {Name: "bucket_start"}},
+			DoUpdates: clause.Assignments(increments),
+		}).CreateInBatches(&entries, rotatingProxyTrafficBatchSize).Error
+	})
+}
+
+type rotatingProxyTrafficRow struct {
+	ProxyID        uint64
+	BucketStart    time.Time
+	Requests       int64
+	Tunnels        int64
+	BytesIn        int64
+	BytesOut       int64
+	Errors         int64
+	LatencyTotalMs int64
+	LatencySamples int64
+}
+
+const rotatingProxyTrafficSums = "SUM(requests) AS requests, SUM(tunnels) AS tunnels, " +
+	"SUM(bytes_in) AS bytes_in, SUM(bytes_out) AS bytes_out, SUM(errors) AS errors, " +
+	"SUM(latency_total_ms) AS latency_total_ms, SUM(latency_samples) AS latency_samples"
+
+// GetRotatingProxyTraffic returns the traffic a rotator carried since the
+// given time, in total, per upstream and per hour.
+func GetRotatingProxyTraffic(userID uint, rotatingProxyID uint64, since time.Time) (dto.RotatingProxyTraffic, error) {
+	report := dto.RotatingProxyTraffic{
+		RotatingProxyID: rotatingProxyID,
+		Since:           since.UTC().Truncate(rotatingProxyTrafficBucket),
+		Upstreams:       []dto.RotatingProxyUpstreamTraffic{},
+		Buckets:         []dto.RotatingProxyTrafficBucket{},
+	}
+	if DB == nil {
+		return report, fmt.Errorf("rotating proxy: database connection was not initialised")
+	}
+
+	var count int64
+	if err := DB.Model(&domain.RotatingProxy{}).
+		Where("user_id = ? AND id = ?", userID, rotatingProxyID).
+		Count(&count).Error; err != nil {
+		return report, err
+	}
+	if count == 0 {
+		return report, ErrRotatingProxyNotFound
+	}
+
+	scope := DB.Model(&domain.RotatingProxyTraffic{}).
+		Where("rotating_proxy_id = ? AND bucket_start >= ?", rotatingProxyID, report.Since)
+
+	var upstreamRows []rotatingProxyTrafficRow
+	if err := scope.Session(&gorm.Session{}).
+		Select("proxy_id, " + rotatingProxyTrafficSums).
+		Group("proxy_id").
+		Scan(&upstreamRows).Error; err != nil {
+		return report, err
+	}
+
+	var bucketRows []rotatingProxyTrafficRow
+	if err := scope.Session(&gorm.Session{}).
+		Select("bucket_start, " + rotatingProxyTrafficSums).
+		Group("bucket_start").
+		Order("bucket_start").
+		Scan(&bucketRows).Error; err != nil {
+		return report, err
+	}
+
+	addresses, err := upstreamAddresses(upstreamRows)
+	if err != nil {
+		return report, err
+	}
+
+	var total rotatingProxyTrafficRow
+	for _, row := range upstreamRows {
+		total.Requests += row.Requests
+		total.Tunnels += row.Tunnels
+		total.BytesIn += row.BytesIn
+		total.BytesOut += row.BytesOut
+		total.Errors += row.Errors
+		total.LatencyTotalMs += row.LatencyTotalMs
+		total.LatencySamples += row.LatencySamples
+
+		upstream := dto.RotatingProxyUpstreamTraffic{
+			ProxyID:                    row.ProxyID,
+			RotatingProxyTrafficTotals: row.totals(),
+		}
+		if proxy, ok := addresses[row.ProxyID]; ok {
+			upstream.IP = proxy.IP
+			upstream.Port = proxy.Port
+		}
+		report.Upstreams = append(report.Upstreams, upstream)
+	}
+	sort.Slice(report.Upstreams, func(i, j int) bool {
+		return report.Upstreams[i].ProxyID < report.Upstreams[j].ProxyID
+	})
+
+	for _, row := range bucketRows {
+		report.Buckets = append(report.Buckets, dto.RotatingProxyTrafficBucket{
+			BucketStart:                row.BucketStart.UTC(),
+			RotatingProxyTrafficTotals: row.totals(),
+		})
+	}
+
+	report.Totals = total.totals()
+	return report, nil
+}
+
+func (row rotatingProxyTrafficRow) totals() dto.RotatingProxyTrafficTotals {
+	totals := dto.RotatingProxyTrafficTotals{
+		Requests: row.Requests,
+		Tunnels:  row.Tunnels,
+		BytesIn:  row.BytesIn,
+		BytesOut: row.BytesOut,
+		Errors:   row.Errors,
+	}
+	if row.LatencySamples > 0 {
+		totals.AvgLatencyMs = float64(row.LatencyTotalMs) / float64(row.LatencySamples)
+	}
+	return totals
+}
+
+func upstreamAddresses(rows []rotatingProxyTrafficRow) (map[uint64]domain.Proxy, error) {
+	ids := make([]uint64, 0, len(rows))
+	for _, row := range rows {
+		if row.ProxyID != 0 {
+			ids = append(ids, row.ProxyID)
+		}
+	}
+
+	result := make(map[uint64]domain.Proxy, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	var proxies []domain.Proxy
+	if err := DB.Where("id IN ?", ids).Find(&proxies).Error; err != nil {
+		return nil, err
+	}
+	for _, proxy := range proxies {
+		result[proxy.ID] = proxy
+	}
+	return result, nil
+}
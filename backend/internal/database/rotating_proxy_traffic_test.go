@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"magpie/internal/domain"
+)
+
+func TestFlushRotatingProxyTraffic_AggregatesPerUpstreamAndHour(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	user := domain.User{Email: "traffic@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	protocol := domain.Protocol{Name: "http"}
+	if err := db.Create(&protocol).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+	rotator := domain.RotatingProxy{UserID: user.ID, Name: "billing", ProtocolID: protocol.ID, ListenPort: 21001}
+	if err := db.Create(&rotator).Error; err != nil {
+		t.Fatalf("create rotator: %v", err)
+	}
+	upstream := domain.Proxy{IP: "10.0.9.1", Port: 8080}
+	if err := db.Create(&upstream).Error; err != nil {
+		t.Fatalf("create proxy: %v", err)
+	}
+
+	RecordRotatingProxyTraffic(RotatingProxyTrafficSample{RotatingProxyID: rotator.ID, ProxyID: upstream.ID, Requests: 1, BytesOut: 100, BytesIn: 1000, Latency: 200 * time.Millisecond})
+	RecordRotatingProxyTraffic(RotatingProxyTrafficSample{RotatingProxyID: rotator.ID, ProxyID: upstream.ID, Errors: 1})
+	if err := FlushRotatingProxyTraffic(context.Background()); err != nil {
+		t.Fatalf("first flush: %v", err)
+	}
+
+	RecordRotatingProxyTraffic(RotatingProxyTrafficSample{RotatingProxyID: rotator.ID, ProxyID: upstream.ID, Tunnels: 1, BytesOut: 50, BytesIn: 500, Latency: 100 * time.Millisecond})
+	RecordRotatingProxyTraffic(RotatingProxyTrafficSample{RotatingProxyID: rotator.ID, Requests: 1})
+	if err := FlushRotatingProxyTraffic(context.Background()); err != nil {
+		t.Fatalf("second flush: %v", err)
+	}
+
+	var rows int64
+	if err := db.Model(&domain.RotatingProxyTraffic{}).Count(&rows).Error; err != nil {
+		t.Fatalf("count traffic rows: %v", err)
+	}
+	if rows != 2 {
+		t.Fatalf("expected flushes to merge into 2 rows, got %d", rows)
+	}
+
+	report, err := GetRotatingProxyTraffic(user.ID, rotator.ID, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetRotatingProxyTraffic: %v", err)
+	}
+
+	totals := report.Totals
+	if totals.Requests != 2 || totals.Tunnels != 1 || totals.BytesOut != 150 || totals.BytesIn != 1500 || totals.Errors != 1 {
+		t.Fatalf("unexpected totals %+v", totals)
+	}
+	if totals.AvgLatencyMs != 150 {
+		t.Fatalf("avg latency = %v, want 150", totals.AvgLatencyMs)
+	}
+
+	if len(report.Upstreams) != 2 || report.Upstreams[0].ProxyID != 0 || report.Upstreams[1].ProxyID != upstream.ID {
+		t.Fatalf("unexpected upstream breakdown %+v", report.Upstreams)
+	}
+	if served := report.Upstreams[1]; served.IP != "10.0.9.1" || served.Port != 8080 || served.Requests != 1 || served.Tunnels != 1 {
+		t.Fatalf("unexpected served upstream %+v", served)
+	}
+	if len(report.Buckets) != 1 || report.Buckets[0].Requests != 2 {
+		t.Fatalf("unexpected hourly buckets %+v", report.Buckets)
+	}
+
+	if _, err := GetRotatingProxyTraffic(user.ID+1, rotator.ID, time.Now()); err != ErrRotatingProxyNotFound {
+		t.Fatalf("expected ErrRotatingProxyNotFound for another user, got %v", err)
+	}
+}
+
+func TestFlushRotatingProxyTraffic_DropsTrafficOfDeletedRotators(t *testing.T) {
+	db := setupRotatingProxyTestDB(t)
+
+	user := domain.User{Email: "traffic-deleted@example.com", Password: "password123", HTTPProtocol: true}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	protocol := domain.Protocol{Name: "http"}
+	if err := db.Create(&protocol).Error; err != nil {
+		t.Fatalf("create protocol: %v", err)
+	}
+	kept := domain.RotatingProxy{UserID: user.ID, Name: "kept", ProtocolID: protocol.ID, ListenPort: 21002}
+	deleted := domain.RotatingProxy{UserID: user.ID, Name: "deleted", ProtocolID: protocol.ID, ListenPort: 21003}
+	if err := db.Create(&kept).Error; err != nil {
+		t.Fatalf("create rotator: %v", err)
+	}
+	if err := db.Create(&deleted).Error; err != nil {
+		t.Fatalf("create rotator: %v", err)
+	}
+
+	if err := DeleteRotatingProxy(user.ID, deleted.ID); err != nil {
+		t.Fatalf("delete rotator: %v", err)
+	}
+
+	// A tunnel of the deleted rotator closing after the delete still reports
+	// its traffic.
+	RecordRotatingProxyTraffic(RotatingProxyTrafficSample{RotatingProxyID: deleted.ID, Tunnels: 1, BytesIn: 10})
+	RecordRotatingProxyTraffic(RotatingProxyTrafficSample{RotatingProxyID: kept.ID, Requests: 1})
+	if err := FlushRotatingProxyTraffic(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	var rows []domain.RotatingProxyTraffic
+	if err := db.Find(&rows).Error; err != nil {
+		t.Fatalf("load traffic rows: %v", err)
+	}
+	if len(rows) != 1 || rows[0].RotatingProxyID != kept.ID || rows[0].Requests != 1 {
+		t.Fatalf("expected only the kept rotator's traffic, got %+v", rows)
+	}
+	if pending := rotatorTraffic.take(); len(pending) != 0 {
+		t.Fatalf("expected no traffic left to retry, got %+v", pending)
+	}
+}
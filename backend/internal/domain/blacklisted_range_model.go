@@ -1,14 +1,16 @@
 package domain
 
-// BlacklistedRange stores IPv4 ranges (CIDRs expanded to start/end) for blacklist enforcement.
+import "net/netip"
+
+// BlacklistedRange stores IPv4 and IPv6 ranges (CIDRs expanded to start/end) for blacklist enforcement.
 type BlacklistedRange struct {
 	ID uint64 `gorm:"primaryKey;autoIncrement"`
 
-	// CIDR holds the normalized network string (e.g. 192.0.2.0/24).
+	// CIDR holds the normalized network string (e.g. 192.0.2.0/24 or 2001:db8::/32).
 	CIDR   string `gorm:"type:cidr;uniqueIndex;not null"`
 	Source string `gorm:"size:512;not null;default:''"`
 
 	// Computed bounds used in-memory; not persisted.
-	StartIP uint32 `gorm:"-"`
-	EndIP   uint32 `gorm:"-"`
+	StartIP netip.Addr `gorm:"-"`
+	EndIP   netip.Addr `gorm:"-"`
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +20,7 @@ type Proxy struct {
 	IPEncrypted string `gorm:"column:ip;default:'';index:idx_proxy_addr,priority:1" json:"-"`
 	IPHash      []byte `gorm:"column:ip_hash;type:bytea;index"`
 	IPInt       uint32 `gorm:"column:ip_int;index"`
+	IPv6        []byte `gorm:"column:ip_v6;type:bytea;index"` // 16-byte IPv6 address, range searchable like ip_int
 	Port        uint16 `gorm:"not null;index:idx_proxy_addr,priority:2"`
 	Username    string `gorm:"default:''"`
 	Password    string `gorm:"-" json:"password"`
@@ -54,7 +56,7 @@ func (proxy *Proxy) BeforeSave(_ *gorm.DB) error {
 	if len(proxy.IPHash) == 0 && proxy.IP != "" {
 		proxy.setIPHash()
 	}
-	if proxy.IP != "" && proxy.IPInt == 0 {
+	if proxy.IP != "" && proxy.IPInt == 0 && len(proxy.IPv6) == 0 {
 		proxy.setIPInt()
 	}
 
@@ -91,7 +93,7 @@ func (proxy *Proxy) AfterFind(_ *gorm.DB) error {
 	if len(proxy.IPHash) == 0 && proxy.IP != "" {
 		proxy.setIPHash()
 	}
-	if proxy.IP != "" && proxy.IPInt == 0 {
+	if proxy.IP != "" && proxy.IPInt == 0 && len(proxy.IPv6) == 0 {
 		proxy.setIPInt()
 	}
 
@@ -128,39 +130,40 @@ func (proxy *Proxy) setIPHash() {
 	proxy.IPHash = ipHash[:]
 }
 
+// setIPInt fills the range-searchable form of the address: ip_int for IPv4
+// and ip_v6 for IPv6.
 func (proxy *Proxy) setIPInt() {
-	if proxy.IP == "" {
-		proxy.IPInt = 0
-		return
-	}
+	proxy.IPInt = 0
+	proxy.IPv6 = nil
+
 	parsed := net.ParseIP(proxy.IP)
 	if parsed == nil {
-		proxy.IPInt = 0
 		return
 	}
-	ip := parsed.To4()
-	if ip == nil {
-		proxy.IPInt = 0
+	if ip := parsed.To4(); ip != nil {
+		proxy.IPInt = uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
 		return
 	}
-	proxy.IPInt = uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	proxy.IPv6 = append([]byte(nil), parsed.To16()...)
 }
 
+// SetIP stores ip in canonical form. IPv4-mapped IPv6 addresses are stored as
+// plain IPv4.
 func (proxy *Proxy) SetIP(ip string) error {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
 		return errors.New("invalid IP address")
 	}
-	ipv4 := parsedIP.To4()
-	if ipv4 == nil {
-		return errors.New("only IPv4 addresses are supported")
+	if ipv4 := parsedIP.To4(); ipv4 != nil {
+		proxy.IP = ipv4.String()
+		return nil
 	}
-	proxy.IP = ipv4.String()
+	proxy.IP = parsedIP.String()
 	return nil
 }
 
 func (proxy *Proxy) GetFullProxy() string {
-	return fmt.Sprintf("%s:%d", proxy.GetIp(), proxy.Port)
+	return net.JoinHostPort(proxy.GetIp(), strconv.Itoa(int(proxy.Port)))
 }
 
 func (proxy *Proxy) GetIp() string {
@@ -21,8 +21,33 @@ func TestProxySetIP(t *testing.T) {
 		t.Fatal("expected error for invalid IP, got nil")
 	}
 
-	if err := proxy.SetIP("::1"); err == nil {
-		t.Fatal("expected error for IPv6 address, got nil")
+	if err := proxy.SetIP("2001:DB8:0::1"); err != nil {
+		t.Fatalf("SetIP returned error for IPv6 address: %v", err)
+	}
+	if got := proxy.GetIp(); got != "2001:db8::1" {
+		t.Fatalf("GetIp returned %s, want 2001:db8::1", got)
+	}
+
+	if err := proxy.SetIP("::ffff:10.0.0.1"); err != nil {
+		t.Fatalf("SetIP returned error for IPv4-mapped address: %v", err)
+	}
+	if got := proxy.GetIp(); got != "10.0.0.1" {
+		t.Fatalf("GetIp returned %s, want 10.0.0.1", got)
+	}
+}
+
+func TestProxySetIPIntFillsRangeColumnPerFamily(t *testing.T) {
+	proxy := Proxy{IP: "10.0.0.1"}
+	proxy.setIPInt()
+	if proxy.IPInt != 0x0A000001 || proxy.IPv6 != nil {
+		t.Fatalf("IPv4 proxy got IPInt %d and IPv6 %v", proxy.IPInt, proxy.IPv6)
+	}
+
+	proxy = Proxy{IP: "2001:db8::1"}
+	proxy.setIPInt()
+	want := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}
+	if proxy.IPInt != 0 || !bytes.Equal(proxy.IPv6, want) {
+		t.Fatalf("IPv6 proxy got IPInt %d and IPv6 %v", proxy.IPInt, proxy.IPv6)
 	}
 }
 
@@ -70,6 +95,13 @@ func TestProxyGetters(t *testing.T) {
 	if proxy.HasAuth() {
 		t.Fatal("HasAuth returned true when password missing")
 	}
+
+	if err := proxy.SetIP("2001:db8::1"); err != nil {
+		t.Fatalf("SetIP returned error: %v", err)
+	}
+	if got := proxy.GetFullProxy(); got != "[2001:db8::1]:3128" {
+		t.Fatalf("GetFullProxy returned %s, want [2001:db8::1]:3128", got)
+	}
 }
 
 func TestProxyBeforeSaveEncryptsAndAfterFindDecrypts(t *testing.T) {
@@ -1,6 +1,12 @@
 package domain
 
-import "time"
+import (
+	"time"
+
+	"magpie/internal/security"
+
+	"gorm.io/gorm"
+)
 
 type ProxyStatistic struct {
 	ID           uint64 `gorm:"primaryKey;autoIncrement"`
@@ -9,6 +15,13 @@ type ProxyStatistic struct {
 	ResponseTime uint16 `gorm:"not null"` // Milliseconds
 	ResponseBody string `gorm:"type:text"`
 
+	// ExitIP is the address the judge saw the request coming from. It is
+	// stored encrypted like Proxy.IP and differs from the proxy's IP for
+	// gateways that route through other hosts.
+	ExitIP          string `gorm:"-"`
+	ExitIPEncrypted string `gorm:"column:exit_ip;default:''"`
+	ExitCountry     string `gorm:"size:56;not null;default:''"` // Human-readable country name of the exit IP
+
 	// Relationships
 	ProtocolID int      `gorm:"index"`
 	Protocol   Protocol `gorm:"foreignKey:ProtocolID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
@@ -24,6 +37,30 @@ type ProxyStatistic struct {
 
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 }
+
+func (statistic *ProxyStatistic) BeforeSave(_ *gorm.DB) error {
+	if statistic.ExitIP == "" {
+		statistic.ExitIPEncrypted = ""
+		return nil
+	}
+
+	encrypted, err := security.EncryptProxySecret(statistic.ExitIP)
+	if err != nil {
+		return err
+	}
+	statistic.ExitIPEncrypted = encrypted
+	return nil
+}
+
+func (statistic *ProxyStatistic) AfterFind(_ *gorm.DB) error {
+	exitIP, _, err := security.DecryptProxySecret(statistic.ExitIPEncrypted)
+	if err != nil {
+		return err
+	}
+	statistic.ExitIP = exitIP
+	return nil
+}
+
 type AnonymityLevel struct {
 	ID   int    `gorm:"primaryKey;autoIncrement"`
 	Name string `gorm:"size:50;not null;unique"` // elite, anonymous, transparent
@@ -9,21 +9,48 @@ import (
 )
 
 type RotatingProxy struct {
-	ID                    uint64     `gorm:"primaryKey;autoIncrement"`
-	UserID                uint       `gorm:"not null;index:idx_rotating_user_name,priority:1"`
-	Name                  string     `gorm:"not null;size:120;index:idx_rotating_user_name,priority:2"`
-	ProtocolID            int        `gorm:"not null;index"`
-	Protocol              Protocol   `gorm:"foreignKey:ProtocolID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
-	ListenPort            uint16     `gorm:"uniqueIndex"`
-	AuthRequired          bool       `gorm:"not null;default:false"`
-	AuthUsername          string     `gorm:"size:120;default:''"`
-	AuthPassword          string     `gorm:"-" json:"-"`
-	AuthPasswordEncrypted string     `gorm:"column:auth_password;default:''"`
-	ReputationLabels      StringList `gorm:"type:jsonb;default:'[]'"`
-	LastProxyID           *uint64    `gorm:"column:last_proxy_id"`
-	LastRotationAt        *time.Time
-	CreatedAt             time.Time `gorm:"autoCreateTime"`
-	UpdatedAt             time.Time `gorm:"autoUpdateTime"`
+	ID                        uint64              `gorm:"primaryKey;autoIncrement"`
+	UserID                    uint                `gorm:"not null;index:idx_rotating_user_name,priority:1"`
+	Name                      string              `gorm:"not null;size:120;index:idx_rotating_user_name,priority:2"`
+	ProtocolID                int                 `gorm:"not null;index"`
+	Protocol                  Protocol            `gorm:"foreignKey:ProtocolID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	ListenPort                uint16              `gorm:"uniqueIndex"`
+	ListenProtocol            string              `gorm:"size:10;not null;default:'http'"`
+	AuthRequired              bool                `gorm:"not null;default:false"`
+	AuthUsername              string              `gorm:"size:120;default:''"`
+	AuthPassword              string              `gorm:"-" json:"-"`
+	AuthPasswordEncrypted     string              `gorm:"column:auth_password;default:''"`
+	AllowedClientCIDRs        StringList          `gorm:"column:allowed_client_cidrs;type:jsonb;default:'[]'"`
+	TLSCertificate            string              `gorm:"column:tls_certificate;type:text;default:''"`
+	TLSPrivateKey             string              `gorm:"-" json:"-"`
+	TLSPrivateKeyEncrypted    string              `gorm:"column:tls_private_key;type:text;default:''"`
+	StripRequestHeaders       StringList          `gorm:"type:jsonb;default:'[]'"`
+	SetRequestHeaders         StringMap           `gorm:"type:jsonb;default:'{}'"`
+	UserAgentPool             StringList          `gorm:"type:jsonb;default:'[]'"`
+	ExposeUpstreamHeader      bool                `gorm:"not null;default:false"`
+	RoutingRules              RotatorRoutingRules `gorm:"type:jsonb;default:'[]'"`
+	ReputationLabels          StringList          `gorm:"type:jsonb;default:'[]'"`
+	AllowedCountries          StringList          `gorm:"type:jsonb;default:'[]'"`
+	BlockedCountries          StringList          `gorm:"type:jsonb;default:'[]'"`
+	EstimatedTypes            StringList          `gorm:"type:jsonb;default:'[]'"`
+	MaxResponseTimeMs         uint16              `gorm:"not null;default:0"`
+	MinAnonymityLevel         string              `gorm:"size:20;not null;default:''"`
+	TargetCheckIDs            UintList            `gorm:"column:target_check_ids;type:jsonb;default:'[]'"`
+	SessionTTLSeconds         uint32              `gorm:"not null;default:600"`
+	RotationStrategy          string              `gorm:"size:32;not null;default:'round_robin'"`
+	RotationIntervalSeconds   uint32              `gorm:"not null;default:0"`
+	FailoverAttempts          uint8               `gorm:"not null;default:3"`
+	FailoverTimeoutSeconds    uint16              `gorm:"not null;default:30"`
+	FailoverCooldownSeconds   uint32              `gorm:"not null;default:60"`
+	HostBanCooldownSeconds    uint32              `gorm:"not null;default:900"`
+	RateLimitPerSecond        uint32              `gorm:"not null;default:0"`
+	MaxConcurrentConnections  uint32              `gorm:"not null;default:0"`
+	MaxConnectionsPerUpstream uint32              `gorm:"not null;default:0"`
+	MaxBandwidthKBPerSecond   uint32              `gorm:"column:max_bandwidth_kb_per_second;not null;default:0"`
+	LastProxyID               *uint64             `gorm:"column:last_proxy_id"`
+	LastRotationAt            *time.Time
+	CreatedAt                 time.Time `gorm:"autoCreateTime"`
+	UpdatedAt                 time.Time `gorm:"autoUpdateTime"`
 }
 
 func (RotatingProxy) TableName() string {
@@ -40,19 +67,36 @@ func (rp *RotatingProxy) BeforeSave(_ *gorm.DB) error {
 	} else {
 		rp.AuthPasswordEncrypted = ""
 	}
+
+	if rp.TLSPrivateKey != "" {
+		encrypted, err := security.EncryptProxySecret(rp.TLSPrivateKey)
+		if err != nil {
+			return err
+		}
+		rp.TLSPrivateKeyEncrypted = encrypted
+	} else {
+		rp.TLSPrivateKeyEncrypted = ""
+	}
 	return nil
 }
 
 func (rp *RotatingProxy) AfterFind(_ *gorm.DB) error {
-	if rp.AuthPasswordEncrypted == "" {
-		rp.AuthPassword = ""
-		return nil
+	rp.AuthPassword = ""
+	if rp.AuthPasswordEncrypted != "" {
+		password, _, err := security.DecryptProxySecret(rp.AuthPasswordEncrypted)
+		if err != nil {
+			return err
+		}
+		rp.AuthPassword = password
 	}
 
-	password, _, err := security.DecryptProxySecret(rp.AuthPasswordEncrypted)
-	if err != nil {
-		return err
+	rp.TLSPrivateKey = ""
+	if rp.TLSPrivateKeyEncrypted != "" {
+		key, _, err := security.DecryptProxySecret(rp.TLSPrivateKeyEncrypted)
+		if err != nil {
+			return err
+		}
+		rp.TLSPrivateKey = key
 	}
-	rp.AuthPassword = password
 	return nil
 }
@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// RotatorRoutingRule narrows a rotator's upstreams for requests to matching
+// destination hosts. A host pattern is an exact host, "*.example.com" for its
+// subdomains, or "*" for every host.
+type RotatorRoutingRule struct {
+	Hosts             []string `json:"hosts"`
+	Countries         []string `json:"countries,omitempty"`
+	EstimatedTypes    []string `json:"estimated_types,omitempty"`
+	MaxResponseTimeMs uint16   `json:"max_response_time_ms,omitempty"`
+	MinAnonymityLevel string   `json:"min_anonymity_level,omitempty"`
+}
+
+// RotatorRoutingRules stores an ordered rule list inside a JSON column.
+type RotatorRoutingRules []RotatorRoutingRule
+
+// Value implements driver.Valuer so RotatorRoutingRules can be stored as JSON.
+func (r RotatorRoutingRules) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return []byte("[]"), nil
+	}
+
+	data, err := json.Marshal([]RotatorRoutingRule(r))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Scan implements sql.Scanner to hydrate the rules from the database.
+func (r *RotatorRoutingRules) Scan(value any) error {
+	if value == nil {
+		*r = nil
+		return nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("domain.RotatorRoutingRules: unsupported type %T", value)
+	}
+
+	if len(data) == 0 {
+		*r = nil
+		return nil
+	}
+
+	var parsed []RotatorRoutingRule
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
@@ -0,0 +1,26 @@
+package domain
+
+import "time"
+
+// RotatingProxyTraffic aggregates what a rotator carried through one upstream
+// during one hour. ProxyID is 0 for requests no upstream could serve. BytesOut
+// counts bytes sent towards the upstream and BytesIn bytes received from it.
+type RotatingProxyTraffic struct {
+	ID              uint64    `gorm:"primaryKey;autoIncrement"`
+	RotatingProxyID uint64    `gorm:"not null;uniqueIndex:idx_rotating_traffic_bucket,priority:1"`
+	ProxyID         uint64    `gorm:"not null;uniqueIndex:idx_rotating_traffic_bucket,priority:2"`
+	BucketStart     time.Time `gorm:"not null;uniqueIndex:idx_rotating_traffic_bucket,priority:3;index"`
+	Requests        int64     `gorm:"not null;default:0"`
+	Tunnels         int64     `gorm:"not null;default:0"`
+	BytesIn         int64     `gorm:"not null;default:0"`
+	BytesOut        int64     `gorm:"not null;default:0"`
+	Errors          int64     `gorm:"not null;default:0"`
+	LatencyTotalMs  int64     `gorm:"not null;default:0"`
+	LatencySamples  int64     `gorm:"not null;default:0"`
+
+	RotatingProxy RotatingProxy `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+func (RotatingProxyTraffic) TableName() string {
+	return "rotating_proxy_traffic"
+}
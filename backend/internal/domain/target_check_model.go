@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+// TargetCheck is a user-defined site the checker requests through each of the
+// user's proxies next to the judges. A proxy passes when the response arrives
+// with an expected status, matches BodyRegex and is not slower than
+// MaxLatencyMs. An empty ExpectedStatuses accepts any 2xx status.
+type TargetCheck struct {
+	ID               uint      `gorm:"primaryKey;autoIncrement"`
+	UserID           uint      `gorm:"not null;uniqueIndex:idx_target_check_user_name,priority:1"`
+	Name             string    `gorm:"not null;size:120;uniqueIndex:idx_target_check_user_name,priority:2"`
+	URL              string    `gorm:"column:url;not null;size:512"`
+	Method           string    `gorm:"size:10;not null;default:'GET'"`
+	ExpectedStatuses UintList  `gorm:"type:jsonb;default:'[]'"`
+	BodyRegex        string    `gorm:"size:512;not null;default:''"`
+	MaxLatencyMs     uint16    `gorm:"not null;default:0"`
+	User             User      `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CreatedAt        time.Time `gorm:"autoCreateTime"`
+	UpdatedAt        time.Time `gorm:"autoUpdateTime"`
+}
+
+func (TargetCheck) TableName() string {
+	return "target_checks"
+}
+
+// TargetCheckResult is the latest outcome of a target check for one proxy and
+// protocol. It is kept apart from the judge statistics so target failures never
+// mark a proxy dead.
+type TargetCheckResult struct {
+	TargetCheckID uint   `gorm:"primaryKey"`
+	ProxyID       uint64 `gorm:"primaryKey;index"`
+	ProtocolID    int    `gorm:"primaryKey"`
+	Passed        bool   `gorm:"not null"`
+	StatusCode    int    `gorm:"not null;default:0"`
+	ResponseTime  uint16 `gorm:"not null;default:0"` // Milliseconds
+	Error         string `gorm:"size:255;not null;default:''"`
+
+	TargetCheck TargetCheck `gorm:"foreignKey:TargetCheckID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Proxy       Proxy       `gorm:"foreignKey:ProxyID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Protocol    Protocol    `gorm:"foreignKey:ProtocolID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	CheckedAt time.Time `gorm:"not null"`
+}
+
+func (TargetCheckResult) TableName() string {
+	return "target_check_results"
+}
@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// UintList stores a slice of unsigned integers inside a JSON column.
+type UintList []uint
+
+// Value implements driver.Valuer so UintList can be stored as JSON.
+func (l UintList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return []byte("[]"), nil
+	}
+
+	data, err := json.Marshal([]uint(l))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Scan implements sql.Scanner to hydrate the UintList from the database.
+func (l *UintList) Scan(value any) error {
+	if value == nil {
+		*l = nil
+		return nil
+	}
+
+	switch v := value.(type) {
+	case []byte:
+		return l.unmarshal(v)
+	case string:
+		return l.unmarshal([]byte(v))
+	default:
+		return fmt.Errorf("domain.UintList: unsupported type %T", value)
+	}
+}
+
+func (l *UintList) unmarshal(data []byte) error {
+	if len(data) == 0 {
+		*l = nil
+		return nil
+	}
+
+	var parsed []uint
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// Clone returns a copy of the underlying slice to avoid sharing memory.
+func (l UintList) Clone() []uint {
+	if len(l) == 0 {
+		return nil
+	}
+	out := make([]uint, len(l))
+	copy(out, l)
+	return out
+}
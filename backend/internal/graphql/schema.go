@@ -186,6 +186,54 @@ func NewSchema() (gql.Schema, error) {
 		},
 	})
 
+	// Byte counters are exposed as Float because they outgrow GraphQL's 32-bit Int.
+	rotatingProxyTrafficFields := func() gql.Fields {
+		return gql.Fields{
+			"requests":     &gql.Field{Type: gql.NewNonNull(gql.Int)},
+			"tunnels":      &gql.Field{Type: gql.NewNonNull(gql.Int)},
+			"bytesIn":      &gql.Field{Type: gql.NewNonNull(gql.Float)},
+			"bytesOut":     &gql.Field{Type: gql.NewNonNull(gql.Float)},
+			"errors":       &gql.Field{Type: gql.NewNonNull(gql.Int)},
+			"avgLatencyMs": &gql.Field{Type: gql.NewNonNull(gql.Float)},
+		}
+	}
+
+	rotatingProxyTrafficTotalsType := gql.NewObject(gql.ObjectConfig{
+		Name:   "RotatingProxyTrafficTotals",
+		Fields: rotatingProxyTrafficFields(),
+	})
+
+	upstreamTrafficFields := rotatingProxyTrafficFields()
+	upstreamTrafficFields["proxyId"] = &gql.Field{Type: gql.NewNonNull(gql.Int)}
+	upstreamTrafficFields["ip"] = &gql.Field{Type: gql.String}
+	upstreamTrafficFields["port"] = &gql.Field{Type: gql.Int}
+	rotatingProxyUpstreamTrafficType := gql.NewObject(gql.ObjectConfig{
+		Name:   "RotatingProxyUpstreamTraffic",
+		Fields: upstreamTrafficFields,
+	})
+
+	trafficBucketFields := rotatingProxyTrafficFields()
+	trafficBucketFields["bucketStart"] = &gql.Field{Type: gql.NewNonNull(gql.DateTime)}
+	rotatingProxyTrafficBucketType := gql.NewObject(gql.ObjectConfig{
+		Name:   "RotatingProxyTrafficBucket",
+		Fields: trafficBucketFields,
+	})
+
+	rotatingProxyTrafficType := gql.NewObject(gql.ObjectConfig{
+		Name: "RotatingProxyTraffic",
+		Fields: gql.Fields{
+			"rotatingProxyId": &gql.Field{Type: gql.NewNonNull(gql.Int)},
+			"since":           &gql.Field{Type: gql.NewNonNull(gql.DateTime)},
+			"totals":          &gql.Field{Type: gql.NewNonNull(rotatingProxyTrafficTotalsType)},
+			"upstreams": &gql.Field{
+				Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(rotatingProxyUpstreamTrafficType))),
+			},
+			"buckets": &gql.Field{
+				Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(rotatingProxyTrafficBucketType))),
+			},
+		},
+	})
+
 	viewerType := gql.NewObject(gql.ObjectConfig{
 		Name: "Viewer",
 		Fields: gql.Fields{
@@ -354,6 +402,37 @@ func NewSchema() (gql.Schema, error) {
 					return nil, nil
 				},
 			},
+			"rotatingProxyTraffic": &gql.Field{
+				Type: rotatingProxyTrafficType,
+				Args: gql.FieldConfigArgument{
+					"id":    &gql.ArgumentConfig{Type: gql.NewNonNull(gql.Int)},
+					"hours": &gql.ArgumentConfig{Type: gql.Int},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					data, ok := p.Source.(*viewerData)
+					if !ok {
+						return nil, nil
+					}
+					id, _ := p.Args["id"].(int)
+					if id <= 0 {
+						return nil, fmt.Errorf("invalid rotating proxy id")
+					}
+					hours := 24
+					if raw, ok := p.Args["hours"].(int); ok && raw > 0 {
+						hours = raw
+					}
+					if maxHours := int(database.RotatingProxyTrafficRetention / time.Hour); hours > maxHours {
+						hours = maxHours
+					}
+
+					since := time.Now().Add(-time.Duration(hours-1) * time.Hour)
+					traffic, err := database.GetRotatingProxyTraffic(data.user.ID, uint64(id), since)
+					if err != nil {
+						return nil, err
+					}
+					return buildRotatingProxyTraffic(traffic), nil
+				},
+			},
 		},
 	})
 
@@ -594,6 +673,45 @@ func graphQLReputationEntry(rep dto.ProxyReputation) map[string]interface{} {
 	}
 }
 
+func buildRotatingProxyTraffic(traffic dto.RotatingProxyTraffic) map[string]interface{} {
+	upstreams := make([]map[string]interface{}, 0, len(traffic.Upstreams))
+	for _, upstream := range traffic.Upstreams {
+		entry := graphQLTrafficTotals(upstream.RotatingProxyTrafficTotals)
+		entry["proxyId"] = int(upstream.ProxyID)
+		if upstream.IP != "" {
+			entry["ip"] = upstream.IP
+			entry["port"] = int(upstream.Port)
+		}
+		upstreams = append(upstreams, entry)
+	}
+
+	buckets := make([]map[string]interface{}, 0, len(traffic.Buckets))
+	for _, bucket := range traffic.Buckets {
+		entry := graphQLTrafficTotals(bucket.RotatingProxyTrafficTotals)
+		entry["bucketStart"] = bucket.BucketStart
+		buckets = append(buckets, entry)
+	}
+
+	return map[string]interface{}{
+		"rotatingProxyId": int(traffic.RotatingProxyID),
+		"since":           traffic.Since,
+		"totals":          graphQLTrafficTotals(traffic.Totals),
+		"upstreams":       upstreams,
+		"buckets":         buckets,
+	}
+}
+
+func graphQLTrafficTotals(totals dto.RotatingProxyTrafficTotals) map[string]interface{} {
+	return map[string]interface{}{
+		"requests":     int(totals.Requests),
+		"tunnels":      int(totals.Tunnels),
+		"bytesIn":      float64(totals.BytesIn),
+		"bytesOut":     float64(totals.BytesOut),
+		"errors":       int(totals.Errors),
+		"avgLatencyMs": totals.AvgLatencyMs,
+	}
+}
+
 func buildScrapeSitePage(userID uint, page int) map[string]interface{} {
 	sites := database.GetScrapeSiteInfoPage(userID, page)
 	items := make([]map[string]interface{}, 0, len(sites))
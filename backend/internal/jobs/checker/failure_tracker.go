@@ -33,6 +33,7 @@ type failureEvent struct {
 type failureResponse struct {
 	removedUsers map[uint]struct{}
 	orphaned     []domain.Proxy
+	failures     uint16
 	err          error
 }
 
@@ -71,7 +72,10 @@ func init() {
 	go trackerInstance.run()
 }
 
-func processFailureEvents(ctx context.Context, proxyID uint64, users []failureEvent) (map[uint]struct{}, []domain.Proxy, error) {
+// processFailureEvents records the outcome of a proxy check. Besides the users
+// the proxy was auto-removed from, it returns the proxy's failure streak used
+// to schedule its next check.
+func processFailureEvents(ctx context.Context, proxyID uint64, users []failureEvent) (map[uint]struct{}, []domain.Proxy, uint16, error) {
 	return trackerInstance.Submit(ctx, proxyID, users)
 }
 
@@ -81,9 +85,9 @@ func newFailureTracker() *failureTracker {
 	}
 }
 
-func (ft *failureTracker) Submit(ctx context.Context, proxyID uint64, users []failureEvent) (map[uint]struct{}, []domain.Proxy, error) {
+func (ft *failureTracker) Submit(ctx context.Context, proxyID uint64, users []failureEvent) (map[uint]struct{}, []domain.Proxy, uint16, error) {
 	if len(users) == 0 {
-		return nil, nil, nil
+		return nil, nil, 0, nil
 	}
 	if ctx == nil {
 		ctx = context.Background()
@@ -99,14 +103,14 @@ func (ft *failureTracker) Submit(ctx context.Context, proxyID uint64, users []fa
 	select {
 	case ft.requests <- req:
 	case <-ctx.Done():
-		return nil, nil, ctx.Err()
+		return nil, nil, 0, ctx.Err()
 	}
 
 	select {
 	case res := <-req.resp:
-		return res.removedUsers, res.orphaned, res.err
+		return res.removedUsers, res.orphaned, res.failures, res.err
 	case <-ctx.Done():
-		return nil, nil, ctx.Err()
+		return nil, nil, 0, ctx.Err()
 	}
 }
 
@@ -217,7 +221,7 @@ func (ft *failureTracker) processBatch(batch []*failureRequest) {
 
 	responses := make(map[*failureRequest]*failureResponse, len(active))
 	for _, req := range active {
-		responses[req] = &failureResponse{}
+		responses[req] = &failureResponse{failures: failureStreak(req, counts)}
 	}
 
 	for _, entry := range increments {
@@ -247,6 +251,32 @@ func (ft *failureTracker) processBatch(batch []*failureRequest) {
 	}
 }
 
+// failureStreak is the shortest failure streak among the users that checked
+// the proxy; a success for any of them makes it zero.
+func failureStreak(req *failureRequest, counts map[failureKey]uint16) uint16 {
+	var (
+		streak uint16
+		found  bool
+	)
+	for _, user := range req.users {
+		if !user.HasEligibleChecks {
+			continue
+		}
+		if user.Success {
+			return 0
+		}
+		count := counts[failureKey{userID: user.UserID, proxyID: req.proxyID}]
+		if count == 0 {
+			// The streak was not read back; it is at least this failure.
+			count = 1
+		}
+		if !found || count < streak {
+			streak, found = count, true
+		}
+	}
+	return streak
+}
+
 func (ft *failureTracker) failBatch(batch []*failureRequest, err error) {
 	for _, req := range batch {
 		if req == nil {
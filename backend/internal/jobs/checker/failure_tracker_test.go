@@ -49,9 +49,13 @@ func TestFailureTrackerIncrementAndAutoRemove(t *testing.T) {
 		HasEligibleChecks: true,
 	}
 
-	if _, _, err := processFailureEvents(ctx, proxy.ID, []failureEvent{event}); err != nil {
+	_, _, failures, err := processFailureEvents(ctx, proxy.ID, []failureEvent{event})
+	if err != nil {
 		t.Fatalf("first failure: %v", err)
 	}
+	if failures != 1 {
+		t.Fatalf("failure streak = %d, want 1", failures)
+	}
 
 	var state domain.UserProxy
 	if err := db.First(&state, "user_id = ? AND proxy_id = ?", user.ID, proxy.ID).Error; err != nil {
@@ -64,10 +68,13 @@ func TestFailureTrackerIncrementAndAutoRemove(t *testing.T) {
 	ctx2, cancel2 := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel2()
 
-	removed, orphaned, err := processFailureEvents(ctx2, proxy.ID, []failureEvent{event})
+	removed, orphaned, failures, err := processFailureEvents(ctx2, proxy.ID, []failureEvent{event})
 	if err != nil {
 		t.Fatalf("second failure: %v", err)
 	}
+	if failures != 2 {
+		t.Fatalf("failure streak = %d, want 2", failures)
+	}
 	if removed == nil {
 		t.Fatalf("expected removed map to be populated")
 	}
@@ -137,9 +144,13 @@ func TestFailureTrackerResetsOnSuccess(t *testing.T) {
 		HasEligibleChecks: true,
 	}
 
-	if _, _, err := processFailureEvents(ctx, proxy.ID, []failureEvent{event}); err != nil {
+	_, _, failures, err := processFailureEvents(ctx, proxy.ID, []failureEvent{event})
+	if err != nil {
 		t.Fatalf("reset event: %v", err)
 	}
+	if failures != 0 {
+		t.Fatalf("failure streak after success = %d, want 0", failures)
+	}
 
 	var state domain.UserProxy
 	if err := db.First(&state, "user_id = ? AND proxy_id = ?", user.ID, proxy.ID).Error; err != nil {
@@ -51,6 +51,41 @@ func ProxyCheckRequest(proxyToCheck domain.Proxy, judge *domain.Judge, protocol
 	return html, nil
 }
 
+// TargetCheckRequest requests a target check's URL through the proxy and
+// returns the status code with up to maxTargetBodyLength bytes of the body.
+// Redirects are followed, so the status is the one of the final response.
+func TargetCheckRequest(proxyToCheck domain.Proxy, endpoint *domain.Judge, method string, protocol string, timeout uint16) (int, string, error) {
+	transport, err := support.CreateTransport(proxyToCheck, endpoint, protocol)
+	if err != nil {
+		return 0, "", err
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(timeout) * time.Millisecond,
+	}
+
+	req, err := http.NewRequest(method, endpoint.FullString, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Connection", "close")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTargetBodyLength))
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+
+	return resp.StatusCode, string(body), nil
+}
+
 func CheckForValidResponse(html string, regex string) bool {
 	if strings.EqualFold(regex, "default") {
 		html = strings.ReplaceAll(html, "_", "-")
@@ -0,0 +1,253 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"magpie/internal/config"
+	"magpie/internal/database"
+	"magpie/internal/domain"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	// Target checks are edited through the API on any instance, so every
+	// checker reloads them on its own instead of syncing them like judges.
+	targetCheckRefreshInterval = time.Minute
+	targetCheckSaveTimeout     = 10 * time.Second
+)
+
+// Test seams.
+var (
+	loadTargetChecks       = database.GetAllTargetChecks
+	saveTargetCheckResults = database.SaveTargetCheckResults
+)
+
+// targetCheck is a target check prepared for the checker. endpoint lets the
+// target's URL go through the same transport setup as a judge.
+type targetCheck struct {
+	check    domain.TargetCheck
+	endpoint *domain.Judge
+	regex    *regexp.Regexp
+	statuses map[int]struct{}
+}
+
+// targetAssignment runs one target check through the proxy with one protocol,
+// using the timeout and retries of the user owning the check.
+type targetAssignment struct {
+	target     *targetCheck
+	protocol   string
+	protocolID int
+	timeout    uint16
+	retries    uint8
+}
+
+var targetCheckCache struct {
+	mu         sync.Mutex
+	byUser     map[uint][]*targetCheck
+	loadedAt   time.Time
+	refreshing bool
+}
+
+// currentTargetChecks returns the target checks by user, reloading them once
+// they are older than targetCheckRefreshInterval. Other workers keep using
+// the previous set while one of them reloads.
+func currentTargetChecks() map[uint][]*targetCheck {
+	targetCheckCache.mu.Lock()
+	byUser := targetCheckCache.byUser
+	stale := time.Since(targetCheckCache.loadedAt) >= targetCheckRefreshInterval && !targetCheckCache.refreshing
+	if stale {
+		targetCheckCache.refreshing = true
+	}
+	targetCheckCache.mu.Unlock()
+
+	if !stale {
+		return byUser
+	}
+
+	rows, err := loadTargetChecks()
+	if err != nil {
+		log.Error("failed to load target checks", "error", err)
+	} else {
+		byUser = prepareTargetChecks(rows)
+	}
+
+	targetCheckCache.mu.Lock()
+	if err == nil {
+		targetCheckCache.byUser = byUser
+	}
+	targetCheckCache.loadedAt = time.Now()
+	targetCheckCache.refreshing = false
+	targetCheckCache.mu.Unlock()
+
+	return byUser
+}
+
+func prepareTargetChecks(rows []domain.TargetCheck) map[uint][]*targetCheck {
+	byUser := make(map[uint][]*targetCheck)
+	for _, row := range rows {
+		endpoint := &domain.Judge{FullString: row.URL}
+		if err := endpoint.SetUp(); err != nil {
+			log.Warn("skipping target check with invalid url", "target_check_id", row.ID, "error", err)
+			continue
+		}
+
+		target := &targetCheck{
+			check:    row,
+			endpoint: endpoint,
+			statuses: make(map[int]struct{}, len(row.ExpectedStatuses)),
+		}
+		if row.BodyRegex != "" {
+			regex, err := regexp.Compile(row.BodyRegex)
+			if err != nil {
+				log.Warn("skipping target check with invalid body regex", "target_check_id", row.ID, "error", err)
+				continue
+			}
+			target.regex = regex
+		}
+		for _, status := range row.ExpectedStatuses {
+			target.statuses[int(status)] = struct{}{}
+		}
+
+		byUser[row.UserID] = append(byUser[row.UserID], target)
+	}
+	return byUser
+}
+
+// buildTargetAssignments lists the target checks of the proxy's users, once
+// per protocol the owning user checks.
+func buildTargetAssignments(proxy domain.Proxy, byUser map[uint][]*targetCheck) []targetAssignment {
+	var assignments []targetAssignment
+
+	for _, user := range proxy.Users {
+		targets := byUser[user.ID]
+		if len(targets) == 0 {
+			continue
+		}
+
+		protocols := user.GetProtocolMap()
+		names := make([]string, 0, len(protocols))
+		for protocol := range protocols {
+			names = append(names, protocol)
+		}
+		sort.Strings(names)
+
+		for _, target := range targets {
+			for _, protocol := range names {
+				assignments = append(assignments, targetAssignment{
+					target:     target,
+					protocol:   protocol,
+					protocolID: protocols[protocol],
+					timeout:    user.Timeout,
+					retries:    user.Retries,
+				})
+			}
+		}
+	}
+
+	return assignments
+}
+
+// processTargetAssignments runs the target checks over the protocols the proxy
+// just passed a judge check with. The other protocols get a failed result
+// without a request, so a proxy that died stops matching target filters.
+func processTargetAssignments(proxy domain.Proxy, assignments []targetAssignment, aliveProtocols map[int]bool) {
+	if len(assignments) == 0 {
+		return
+	}
+
+	results := make([]domain.TargetCheckResult, 0, len(assignments))
+	for _, item := range assignments {
+		result := domain.TargetCheckResult{
+			TargetCheckID: item.target.check.ID,
+			ProxyID:       proxy.ID,
+			ProtocolID:    item.protocolID,
+		}
+
+		if aliveProtocols[item.protocolID] {
+			runTargetCheck(proxy, item, &result)
+		} else {
+			result.Error = "proxy failed the judge check"
+		}
+
+		result.CheckedAt = time.Now()
+		results = append(results, result)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), targetCheckSaveTimeout)
+	defer cancel()
+	if err := saveTargetCheckResults(ctx, results); err != nil {
+		log.Error("failed to save target check results", "proxy_id", proxy.ID, "error", err)
+	}
+}
+
+func runTargetCheck(proxy domain.Proxy, item targetAssignment, result *domain.TargetCheckResult) {
+	if config.IsWebsiteBlocked(item.target.check.URL) {
+		result.Error = "target website is blocked"
+		return
+	}
+
+	attempts := item.retries
+	if attempts == 0 {
+		attempts = 1
+	}
+
+	var (
+		status  int
+		body    string
+		err     error
+		latency time.Duration
+	)
+	for i := uint8(0); i < attempts; i++ {
+		start := time.Now()
+		status, body, err = TargetCheckRequest(proxy, item.target.endpoint, item.target.check.Method, item.protocol, item.timeout)
+		latency = time.Since(start)
+		if err == nil {
+			break
+		}
+	}
+
+	result.ResponseTime = clampResponseTime(latency)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+
+	result.StatusCode = status
+	result.Passed, result.Error = evaluateTargetResponse(item.target, status, body, latency)
+}
+
+// evaluateTargetResponse reports whether a response passes the target check
+// and, if not, why.
+func evaluateTargetResponse(target *targetCheck, status int, body string, latency time.Duration) (bool, string) {
+	if len(target.statuses) > 0 {
+		if _, ok := target.statuses[status]; !ok {
+			return false, fmt.Sprintf("unexpected status %d", status)
+		}
+	} else if status < 200 || status > 299 {
+		return false, fmt.Sprintf("unexpected status %d", status)
+	}
+
+	if target.regex != nil && !target.regex.MatchString(body) {
+		return false, "response body did not match"
+	}
+
+	if limit := target.check.MaxLatencyMs; limit > 0 && latency > time.Duration(limit)*time.Millisecond {
+		return false, fmt.Sprintf("response took %dms, limit is %dms", latency.Milliseconds(), limit)
+	}
+
+	return true, ""
+}
+
+func clampResponseTime(latency time.Duration) uint16 {
+	ms := latency.Milliseconds()
+	if ms > int64(^uint16(0)) {
+		return ^uint16(0)
+	}
+	return uint16(ms)
+}
@@ -0,0 +1,130 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"magpie/internal/domain"
+)
+
+func TestEvaluateTargetResponse(t *testing.T) {
+	targets := prepareTargetChecks([]domain.TargetCheck{
+		{ID: 1, UserID: 1, URL: "https://shop.example.com", Method: "GET"},
+		{ID: 2, UserID: 1, URL: "https://shop.example.com", Method: "GET", ExpectedStatuses: domain.UintList{403}, BodyRegex: "(?i)denied", MaxLatencyMs: 500},
+	})[1]
+	if len(targets) != 2 {
+		t.Fatalf("expected two prepared targets, got %d", len(targets))
+	}
+	defaults, strict := targets[0], targets[1]
+
+	cases := []struct {
+		name    string
+		target  *targetCheck
+		status  int
+		body    string
+		latency time.Duration
+		want    bool
+	}{
+		{name: "default accepts 2xx", target: defaults, status: 204, want: true},
+		{name: "default rejects redirects", target: defaults, status: 302, want: false},
+		{name: "expected status and body", target: strict, status: 403, body: "Access Denied", latency: 100 * time.Millisecond, want: true},
+		{name: "unexpected status", target: strict, status: 200, body: "Access Denied", want: false},
+		{name: "body mismatch", target: strict, status: 403, body: "welcome", want: false},
+		{name: "too slow", target: strict, status: 403, body: "denied", latency: 600 * time.Millisecond, want: false},
+	}
+
+	for _, tc := range cases {
+		passed, reason := evaluateTargetResponse(tc.target, tc.status, tc.body, tc.latency)
+		if passed != tc.want {
+			t.Errorf("%s: passed = %v (%q), want %v", tc.name, passed, reason, tc.want)
+		}
+		if !passed && reason == "" {
+			t.Errorf("%s: expected a failure reason", tc.name)
+		}
+	}
+}
+
+func TestBuildTargetAssignments_RunsOwnedTargetsPerProtocol(t *testing.T) {
+	byUser := prepareTargetChecks([]domain.TargetCheck{
+		{ID: 7, UserID: 1, URL: "https://shop.example.com", Method: "GET"},
+		{ID: 8, UserID: 3, URL: "https://other.example.com", Method: "GET"},
+	})
+
+	proxy := domain.Proxy{ID: 42, Users: []domain.User{
+		{ID: 1, HTTPProtocol: true, SOCKS5Protocol: true, Timeout: 5000, Retries: 2},
+		{ID: 2, HTTPSProtocol: true},
+	}}
+
+	assignments := buildTargetAssignments(proxy, byUser)
+	if len(assignments) != 2 {
+		t.Fatalf("expected 2 assignments, got %d", len(assignments))
+	}
+	if assignments[0].protocol != "http" || assignments[0].protocolID != 1 || assignments[1].protocol != "socks5" || assignments[1].protocolID != 4 {
+		t.Fatalf("unexpected protocols: %+v", assignments)
+	}
+	for _, assignment := range assignments {
+		if assignment.target.check.ID != 7 || assignment.timeout != 5000 || assignment.retries != 2 {
+			t.Fatalf("unexpected assignment: %+v", assignment)
+		}
+	}
+}
+
+func TestProcessTargetAssignments_ChecksAliveProtocolsOnly(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "shop.example.com" {
+			http.Error(w, "unexpected target", http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte("welcome to the shop"))
+	}))
+	defer upstream.Close()
+
+	host, rawPort, err := net.SplitHostPort(upstream.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("split proxy address: %v", err)
+	}
+	port, _ := strconv.Atoi(rawPort)
+
+	var saved []domain.TargetCheckResult
+	originalSave := saveTargetCheckResults
+	saveTargetCheckResults = func(_ context.Context, results []domain.TargetCheckResult) error {
+		saved = append(saved, results...)
+		return nil
+	}
+	t.Cleanup(func() { saveTargetCheckResults = originalSave })
+
+	byUser := prepareTargetChecks([]domain.TargetCheck{
+		{ID: 5, UserID: 1, URL: "http://shop.example.com/", Method: "GET", BodyRegex: "shop"},
+	})
+	proxy := domain.Proxy{ID: 9, IP: host, Port: uint16(port), Users: []domain.User{
+		{ID: 1, HTTPProtocol: true, HTTPSProtocol: true, Timeout: 2000, Retries: 1},
+	}}
+
+	processTargetAssignments(proxy, buildTargetAssignments(proxy, byUser), map[int]bool{1: true})
+
+	if len(saved) != 2 {
+		t.Fatalf("expected a result per protocol, got %d", len(saved))
+	}
+	for _, result := range saved {
+		if result.TargetCheckID != 5 || result.ProxyID != 9 || result.CheckedAt.IsZero() {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		switch result.ProtocolID {
+		case 1:
+			if !result.Passed || result.StatusCode != http.StatusOK {
+				t.Fatalf("expected http target check to pass, got %+v", result)
+			}
+		case 2:
+			if result.Passed || result.StatusCode != 0 || result.Error == "" {
+				t.Fatalf("expected https target check to fail without a request, got %+v", result)
+			}
+		default:
+			t.Fatalf("unexpected protocol in result: %+v", result)
+		}
+	}
+}
@@ -25,7 +25,10 @@ var (
 	stopChannel    = make(chan struct{}) // Signal to stop threads
 )
 
-const maxResponseBodyLength = 4096
+const (
+	maxResponseBodyLength = 4096
+	maxTargetBodyLength   = 1 << 20
+)
 
 type userCheck struct {
 	userID     uint
@@ -33,6 +36,9 @@ type userCheck struct {
 	protocolID int
 }
 
+// requestAssignment groups the checks sent through the proxy with one
+// protocol to one judge. protocol is the proxy protocol used to connect; the
+// judge's scheme decides whether the request itself is http or https.
 type requestAssignment struct {
 	judge    *domain.Judge
 	protocol string
@@ -126,9 +132,10 @@ func work() {
 		proxy = refreshProxyUsers(proxy)
 
 		judgeRequests, userSuccess, userHasChecks, maxTimeout, maxRetries := buildRequestAssignments(proxy)
-		processJudgeAssignments(proxy, judgeRequests, userSuccess, maxTimeout, maxRetries)
+		aliveProtocols := processJudgeAssignments(proxy, judgeRequests, userSuccess, maxTimeout, maxRetries)
+		processTargetAssignments(proxy, buildTargetAssignments(proxy, currentTargetChecks()), aliveProtocols)
 
-		removedUsers, orphaned := handleFailureTracking(proxy, userSuccess, userHasChecks)
+		removedUsers, orphaned, failures := handleFailureTracking(proxy, userSuccess, userHasChecks)
 		if len(removedUsers) > 0 {
 			proxy = filterRemovedUsers(proxy, removedUsers)
 		}
@@ -143,7 +150,7 @@ func work() {
 		if err != nil {
 			log.Error("failed to verify proxy ownership before requeue", "proxy_id", proxy.ID, "error", err)
 			// Requeue to avoid dropping proxies on transient errors
-			proxyqueue.PublicProxyQueue.RequeueProxy(proxy, scheduledTime)
+			proxyqueue.PublicProxyQueue.RequeueProxy(proxy, scheduledTime, failures)
 			continue
 		}
 		if !hasUsers {
@@ -152,7 +159,7 @@ func work() {
 		}
 
 		// Requeue the proxy for the next check
-		proxyqueue.PublicProxyQueue.RequeueProxy(proxy, scheduledTime)
+		proxyqueue.PublicProxyQueue.RequeueProxy(proxy, scheduledTime, failures)
 	}
 }
 
@@ -232,20 +239,20 @@ func buildRequestAssignments(proxy domain.Proxy) (map[string]*requestAssignment,
 		}
 
 		for protocol, protocolID := range user.GetProtocolMap() {
-			requestProtocol := determineRequestProtocol(protocol, protocolID, user.UseHttpsForSocks)
+			judgeProtocol := determineJudgeProtocol(protocol, protocolID, user.UseHttpsForSocks)
 
-			nextJudge, regex := judges.GetNextJudge(user.ID, requestProtocol)
+			nextJudge, regex := judges.GetNextJudge(user.ID, judgeProtocol)
 			if nextJudge == nil || config.IsWebsiteBlocked(nextJudge.FullString) {
-				log.Debug("Skipping blocked or missing judge for request assignment", "user_id", user.ID, "protocol", requestProtocol)
+				log.Debug("Skipping blocked or missing judge for request assignment", "user_id", user.ID, "protocol", judgeProtocol)
 				continue
 			}
-			judgeKey := strconv.Itoa(int(nextJudge.ID)) + "_" + requestProtocol
+			judgeKey := strconv.Itoa(int(nextJudge.ID)) + "_" + protocol
 
 			assignment, found := judgeRequests[judgeKey]
 			if !found {
 				assignment = &requestAssignment{
 					judge:    nextJudge,
-					protocol: requestProtocol,
+					protocol: protocol,
 				}
 				judgeRequests[judgeKey] = assignment
 			}
@@ -262,7 +269,9 @@ func buildRequestAssignments(proxy domain.Proxy) (map[string]*requestAssignment,
 	return judgeRequests, userSuccess, userHasChecks, maxTimeout, maxRetries
 }
 
-func determineRequestProtocol(protocol string, protocolID int, useHTTPSForSocks bool) string {
+// determineJudgeProtocol picks the judge scheme to request through a proxy.
+// SOCKS proxies tunnel either, so the user's preference decides.
+func determineJudgeProtocol(protocol string, protocolID int, useHTTPSForSocks bool) string {
 	if protocolID > 2 {
 		if useHTTPSForSocks {
 			return "https"
@@ -273,10 +282,21 @@ func determineRequestProtocol(protocol string, protocolID int, useHTTPSForSocks
 	return protocol
 }
 
-func processJudgeAssignments(proxy domain.Proxy, assignments map[string]*requestAssignment, userSuccess map[uint]bool, maxTimeout uint16, maxRetries uint8) {
+// processJudgeAssignments runs the judge checks and returns the IDs of the
+// protocols the proxy passed at least one check with.
+func processJudgeAssignments(proxy domain.Proxy, assignments map[string]*requestAssignment, userSuccess map[uint]bool, maxTimeout uint16, maxRetries uint8) map[int]bool {
+	aliveProtocols := make(map[int]bool)
 	for _, item := range assignments {
 		html, err, responseTime, attempt := CheckProxyWithRetries(proxy, item.judge, item.protocol, maxTimeout, maxRetries)
 
+		var exitIP, exitCountry string
+		if err == nil {
+			exitIP = support.ParseExitIP(html)
+			if exitIP != "" {
+				exitCountry = database.GetCountryCode(exitIP)
+			}
+		}
+
 		for _, check := range item.checks {
 			statistic := domain.ProxyStatistic{
 				Alive:        false,
@@ -292,24 +312,34 @@ func processJudgeAssignments(proxy domain.Proxy, assignments map[string]*request
 				lvl := support.GetProxyLevel(html)
 				statistic.LevelID = &lvl
 				statistic.Alive = true
+				statistic.ExitIP = exitIP
+				statistic.ExitCountry = exitCountry
 				userSuccess[check.userID] = true
+				aliveProtocols[check.protocolID] = true
 			}
 
 			jobruntime.AddProxyStatistic(statistic)
 		}
 	}
+
+	return aliveProtocols
 }
 
-func handleFailureTracking(proxy domain.Proxy, userSuccess, userHasChecks map[uint]bool) (map[uint]struct{}, []domain.Proxy) {
+// handleFailureTracking records the check outcome per user and returns the
+// users the proxy was auto-removed from, orphaned proxies and the proxy's
+// failure streak.
+func handleFailureTracking(proxy domain.Proxy, userSuccess, userHasChecks map[uint]bool) (map[uint]struct{}, []domain.Proxy, uint16) {
 	if len(proxy.Users) == 0 {
-		return nil, nil
+		return nil, nil, 0
 	}
 
 	events := make([]failureEvent, 0, len(proxy.Users))
+	var anySuccess bool
 	for _, user := range proxy.Users {
 		if !userHasChecks[user.ID] {
 			continue
 		}
+		anySuccess = anySuccess || userSuccess[user.ID]
 		events = append(events, failureEvent{
 			UserID:            user.ID,
 			Success:           userSuccess[user.ID],
@@ -320,18 +350,21 @@ func handleFailureTracking(proxy domain.Proxy, userSuccess, userHasChecks map[ui
 	}
 
 	if len(events) == 0 {
-		return nil, nil
+		return nil, nil, 0
 	}
 
 	reqCtx, cancel := context.WithTimeout(context.Background(), failureProcessingTimeout)
 	defer cancel()
-	removed, orphaned, err := processFailureEvents(reqCtx, proxy.ID, events)
+	removed, orphaned, failures, err := processFailureEvents(reqCtx, proxy.ID, events)
 	if err != nil {
 		log.Error("failed to process proxy failure tracking", "proxy_id", proxy.ID, "error", err)
-		return nil, nil
+		if anySuccess {
+			return nil, nil, 0
+		}
+		return nil, nil, 1
 	}
 
-	return removed, orphaned
+	return removed, orphaned, failures
 }
 
 func filterRemovedUsers(proxy domain.Proxy, removed map[uint]struct{}) domain.Proxy {
@@ -192,8 +192,10 @@ func (rpq *RedisProxyQueue) GetNextProxyContext(ctx context.Context) (domain.Pro
 	}
 }
 
-func (rpq *RedisProxyQueue) RequeueProxy(proxy domain.Proxy, lastCheckTime time.Time) error {
-	interval := config.GetTimeBetweenChecks()
+// RequeueProxy schedules the next check of a proxy. The interval follows the
+// checker's backoff policy for the proxy's current failure streak.
+func (rpq *RedisProxyQueue) RequeueProxy(proxy domain.Proxy, lastCheckTime time.Time, failures uint16) error {
+	interval := config.CheckIntervalFor(failures)
 	base := lastCheckTime
 	// Clamp to now so overdue proxies don't keep hogging the queue.
 	if now := time.Now(); now.After(base) {
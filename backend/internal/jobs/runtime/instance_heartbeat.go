@@ -2,12 +2,12 @@ package runtime
 
 import (
 	"context"
-	"fmt"
-	"os"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/redis/go-redis/v9"
+
+	"magpie/internal/support"
 )
 
 const (
@@ -16,18 +16,11 @@ const (
 	DefaultHeartbeatTTL        = 30 * time.Second
 )
 
-var instanceID = generateInstanceID()
-
-func generateInstanceID() string {
-	hostname, _ := os.Hostname()
-	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
-}
-
 func StartInstanceHeartbeat(ctx context.Context, client *redis.Client, keyPrefix string, interval, ttl time.Duration) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	heartbeatKey := keyPrefix + instanceID
+	heartbeatKey := keyPrefix + support.InstanceID()
 
 	sendHeartbeat := func() {
 		if err := client.SetEx(ctx, heartbeatKey, "alive", ttl).Err(); err != nil {
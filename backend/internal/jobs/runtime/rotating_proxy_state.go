@@ -0,0 +1,48 @@
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"magpie/internal/database"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	rotatingProxyStateFlushInterval = 2 * time.Second
+	rotatingProxyStateFlushTimeout  = 10 * time.Second
+)
+
+// StartRotatingProxyStateRoutine periodically persists the in-memory rotation
+// position and traffic counters of every rotator served by this instance.
+func StartRotatingProxyStateRoutine(ctx context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ticker := time.NewTicker(rotatingProxyStateFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushRotatingProxyState(context.Background())
+			return
+		case <-ticker.C:
+			flushRotatingProxyState(ctx)
+		}
+	}
+}
+
+func flushRotatingProxyState(ctx context.Context) {
+	flushCtx, cancel := context.WithTimeout(ctx, rotatingProxyStateFlushTimeout)
+	defer cancel()
+
+	if err := database.FlushRotatingProxyState(flushCtx); err != nil {
+		log.Error("Failed to persist rotating proxy state", "error", err)
+	}
+	if err := database.FlushRotatingProxyTraffic(flushCtx); err != nil {
+		log.Error("Failed to persist rotating proxy traffic", "error", err)
+	}
+}
@@ -0,0 +1,60 @@
+package rotatingproxy
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/charmbracelet/log"
+
+	"magpie/internal/domain"
+)
+
+// clientAllowlist restricts which client addresses may use a rotator. A nil
+// allowlist admits every client.
+type clientAllowlist struct {
+	prefixes []netip.Prefix
+}
+
+func newClientAllowlist(rotator domain.RotatingProxy) *clientAllowlist {
+	if len(rotator.AllowedClientCIDRs) == 0 {
+		return nil
+	}
+
+	// Entries that fail to parse are dropped rather than widening access: an
+	// allowlist with no usable entries rejects everyone.
+	allowlist := &clientAllowlist{prefixes: make([]netip.Prefix, 0, len(rotator.AllowedClientCIDRs))}
+	for _, entry := range rotator.AllowedClientCIDRs {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			log.Warn("rotating proxy: ignoring invalid client allowlist entry", "rotator_id", rotator.ID, "entry", entry, "error", err)
+			continue
+		}
+		allowlist.prefixes = append(allowlist.prefixes, prefix)
+	}
+	return allowlist
+}
+
+// allows reports whether the client at remoteAddr ("host:port" or bare host)
+// is on the allowlist.
+func (a *clientAllowlist) allows(remoteAddr string) bool {
+	if a == nil {
+		return true
+	}
+
+	host := remoteAddr
+	if parsedHost, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = parsedHost
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package rotatingproxy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/redis/go-redis/v9"
+
+	"magpie/internal/database"
+)
+
+// Host bans keep an upstream away from a destination host after the target
+// blocked it. With redis enabled they are shared by every instance as one
+// sorted set per rotator and host, scored by expiry.
+const hostBanRedisKeyPrefix = "magpie:rotator:bans:"
+
+// hostBanCacheTTL is how long an instance reuses the shared bans it read for
+// a rotator and host before reading them again. Bans recorded locally apply
+// at once.
+const hostBanCacheTTL = 5 * time.Second
+
+var (
+	hostBans       = newHostBanStore()
+	sharedHostBans = newHostBanCache()
+
+	loadSharedHostBansFunc = loadSharedHostBans
+
+	recordHostBanScript = redis.NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[3])
+local latest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+if latest[2] then
+	redis.call("PEXPIREAT", KEYS[1], latest[2])
+end
+return 1`)
+)
+
+func hostBanKey(rotatorID uint64, host string) string {
+	return hostBanRedisKeyPrefix + strconv.FormatUint(rotatorID, 10) + ":" + host
+}
+
+// BanUpstreamForHost stops the rotator from using proxyID for requests to
+// host until the cooldown ends.
+func BanUpstreamForHost(rotatorID uint64, proxyID uint64, host string, cooldown time.Duration) {
+	if host == "" || cooldown <= 0 {
+		return
+	}
+	until := time.Now().Add(cooldown)
+	key := hostBanKey(rotatorID, host)
+
+	hostBans.mark(key, proxyID, until)
+
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return
+	}
+
+	err := recordHostBanScript.Run(ctx, client, []string{key},
+		strconv.FormatUint(proxyID, 10),
+		until.UnixMilli(),
+		time.Now().UnixMilli(),
+	).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Warn("rotating proxy: failed to record host ban", "rotator_id", rotatorID, "proxy_id", proxyID, "host", host, "error", err)
+	}
+}
+
+// bannedUpstreams returns the upstreams currently banned for host. Bans
+// shared through redis are read at most once per hostBanCacheTTL, so
+// selecting an upstream does not wait on redis.
+func bannedUpstreams(rotatorID uint64, host string) []uint64 {
+	if host == "" {
+		return nil
+	}
+	key := hostBanKey(rotatorID, host)
+	now := time.Now()
+
+	shared, ok := sharedHostBans.get(key, now)
+	if !ok {
+		if shared, ok = loadSharedHostBansFunc(key); ok {
+			sharedHostBans.put(key, shared, now)
+		}
+	}
+
+	ids := hostBans.active(key)
+	for proxyID, until := range shared {
+		if now.Before(until) && !containsProxyID(ids, proxyID) {
+			ids = append(ids, proxyID)
+		}
+	}
+	return ids
+}
+
+// loadSharedHostBans reads the bans recorded by every instance for a rotator
+// and host. It reports false when redis is disabled or unreachable.
+func loadSharedHostBans(key string) (map[uint64]time.Time, bool) {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return nil, false
+	}
+
+	members, err := client.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		log.Warn("rotating proxy: failed to load host bans", "key", key, "error", err)
+		return nil, false
+	}
+
+	bans := make(map[uint64]time.Time, len(members))
+	for _, member := range members {
+		raw, _ := member.Member.(string)
+		if id, err := strconv.ParseUint(raw, 10, 64); err == nil {
+			bans[id] = time.UnixMilli(int64(member.Score))
+		}
+	}
+	return bans, true
+}
+
+// isTargetBan reports whether a target's response means it blocked the
+// upstream: access denied, rate limited, or a challenge page such as a
+// captcha.
+func isTargetBan(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return strings.EqualFold(resp.Header.Get("Cf-Mitigated"), "challenge")
+}
+
+func (h *proxyHandler) hostBanCooldown() time.Duration {
+	seconds := h.rotator.HostBanCooldownSeconds
+	if seconds == 0 {
+		seconds = database.DefaultRotatingProxyHostBanCooldownSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// noteTargetResponse bans the upstream for the destination when the target
+// blocked it.
+func (h *proxyHandler) noteTargetResponse(params clientParams, proxyID uint64, resp *http.Response) {
+	if !isTargetBan(resp) {
+		return
+	}
+	log.Debug("rotating proxy: target blocked upstream", "rotator_id", h.rotator.ID, "proxy_id", proxyID, "host", params.Destination, "status", resp.StatusCode)
+	BanUpstreamForHost(h.rotator.ID, proxyID, params.Destination, h.hostBanCooldown())
+}
+
+// hostBanStore keeps the host bans recorded by this instance in memory.
+type hostBanStore struct {
+	mu        sync.Mutex
+	until     map[string]map[uint64]time.Time
+	lastSweep time.Time
+}
+
+func newHostBanStore() *hostBanStore {
+	return &hostBanStore{until: make(map[string]map[uint64]time.Time)}
+}
+
+func (s *hostBanStore) mark(key string, proxyID uint64, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked(time.Now())
+
+	entries, ok := s.until[key]
+	if !ok {
+		entries = make(map[uint64]time.Time)
+		s.until[key] = entries
+	}
+	if until.After(entries[proxyID]) {
+		entries[proxyID] = until
+	}
+}
+
+func (s *hostBanStore) active(key string) []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var ids []uint64
+	for proxyID, until := range s.until[key] {
+		if now.Before(until) {
+			ids = append(ids, proxyID)
+		}
+	}
+	return ids
+}
+
+func (s *hostBanStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < cooldownSweepPeriod {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entries := range s.until {
+		for proxyID, until := range entries {
+			if now.After(until) {
+				delete(entries, proxyID)
+			}
+		}
+		if len(entries) == 0 {
+			delete(s.until, key)
+		}
+	}
+}
+
+// hostBanCache keeps the shared bans last read from redis per rotator and
+// host.
+type hostBanCache struct {
+	mu        sync.Mutex
+	entries   map[string]cachedHostBans
+	lastSweep time.Time
+}
+
+type cachedHostBans struct {
+	until     map[uint64]time.Time
+	fetchedAt time.Time
+}
+
+func newHostBanCache() *hostBanCache {
+	return &hostBanCache{entries: make(map[string]cachedHostBans)}
+}
+
+func (c *hostBanCache) get(key string, now time.Time) (map[uint64]time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.Sub(entry.fetchedAt) >= hostBanCacheTTL {
+		return nil, false
+	}
+	return entry.until, true
+}
+
+func (c *hostBanCache) put(key string, until map[uint64]time.Time, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) >= cooldownSweepPeriod {
+		c.lastSweep = now
+		for cached, entry := range c.entries {
+			if now.Sub(entry.fetchedAt) >= hostBanCacheTTL {
+				delete(c.entries, cached)
+			}
+		}
+	}
+	c.entries[key] = cachedHostBans{until: until, fetchedAt: now}
+}
@@ -0,0 +1,128 @@
+package rotatingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+func TestServeHTTP_BansUpstreamForHostAfterTargetBlock(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	hostBans = newHostBanStore()
+	sharedHostBans = newHostBanCache()
+	selections := stubRotation(t, 2)
+
+	var served []uint64
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		served = append(served, next.ProxyID)
+		status := http.StatusOK
+		if next.ProxyID == 1 && req.URL.Hostname() == "shop.example.com" {
+			status = http.StatusTooManyRequests
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("body"))}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	handler := newProxyHandler(domain.RotatingProxy{ID: 26, UserID: 4})
+	send := func(target string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder.Code
+	}
+
+	if code := send("http://shop.example.com/"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the target's 429 to be relayed, got %d", code)
+	}
+	if code := send("http://SHOP.example.com:80/cart"); code != http.StatusOK {
+		t.Fatalf("expected the second request to succeed, got %d", code)
+	}
+	if code := send("http://other.example.com/"); code != http.StatusOK {
+		t.Fatalf("expected a request to another host to succeed, got %d", code)
+	}
+
+	if want := []uint64{1, 2, 1}; len(served) != len(want) || served[0] != want[0] || served[1] != want[1] || served[2] != want[2] {
+		t.Fatalf("served upstreams %v, want %v", served, want)
+	}
+	if excluded := (*selections)[1].ExcludeProxyIDs; len(excluded) != 1 || excluded[0] != 1 {
+		t.Fatalf("expected upstream 1 to be excluded for the banned host, got %v", excluded)
+	}
+}
+
+func TestBanUpstreamForHost_ExpiresAfterCooldown(t *testing.T) {
+	hostBans = newHostBanStore()
+	sharedHostBans = newHostBanCache()
+
+	BanUpstreamForHost(27, 5, "example.com", 20*time.Millisecond)
+	if banned := bannedUpstreams(27, "example.com"); len(banned) != 1 || banned[0] != 5 {
+		t.Fatalf("expected upstream 5 to be banned, got %v", banned)
+	}
+	if banned := bannedUpstreams(28, "example.com"); len(banned) != 0 {
+		t.Fatalf("expected bans to be scoped to their rotator, got %v", banned)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if banned := bannedUpstreams(27, "example.com"); len(banned) != 0 {
+		t.Fatalf("expected the ban to expire, got %v", banned)
+	}
+}
+
+func TestBannedUpstreams_CachesSharedBans(t *testing.T) {
+	hostBans = newHostBanStore()
+	sharedHostBans = newHostBanCache()
+
+	loads := 0
+	originalLoad := loadSharedHostBansFunc
+	loadSharedHostBansFunc = func(key string) (map[uint64]time.Time, bool) {
+		loads++
+		return map[uint64]time.Time{7: time.Now().Add(time.Minute), 8: time.Now().Add(-time.Second)}, true
+	}
+	t.Cleanup(func() { loadSharedHostBansFunc = originalLoad })
+
+	for i := 0; i < 3; i++ {
+		if banned := bannedUpstreams(29, "example.com"); len(banned) != 1 || banned[0] != 7 {
+			t.Fatalf("expected the shared ban on upstream 7, got %v", banned)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected the shared bans to be read once, read %d times", loads)
+	}
+
+	BanUpstreamForHost(29, 9, "example.com", time.Minute)
+	if banned := bannedUpstreams(29, "example.com"); len(banned) != 2 || !containsProxyID(banned, 7) || !containsProxyID(banned, 9) {
+		t.Fatalf("expected a local ban to apply before the cache expires, got %v", banned)
+	}
+
+	key := hostBanKey(29, "example.com")
+	sharedHostBans.entries[key] = cachedHostBans{until: sharedHostBans.entries[key].until, fetchedAt: time.Now().Add(-hostBanCacheTTL)}
+	bannedUpstreams(29, "example.com")
+	if loads != 2 {
+		t.Fatalf("expected the shared bans to be read again after the cache expired, read %d times", loads)
+	}
+}
+
+func TestIsTargetBan(t *testing.T) {
+	challenge := http.Header{}
+	challenge.Set("Cf-Mitigated", "challenge")
+
+	cases := []struct {
+		resp *http.Response
+		want bool
+	}{
+		{resp: &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}, want: true},
+		{resp: &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}, want: true},
+		{resp: &http.Response{StatusCode: http.StatusOK, Header: challenge}, want: true},
+		{resp: &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}}, want: false},
+	}
+	for _, tc := range cases {
+		if got := isTargetBan(tc.resp); got != tc.want {
+			t.Fatalf("isTargetBan(%d) = %v, want %v", tc.resp.StatusCode, got, tc.want)
+		}
+	}
+}
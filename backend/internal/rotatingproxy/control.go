@@ -0,0 +1,83 @@
+package rotatingproxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"magpie/internal/database"
+)
+
+// Control headers let a client narrow the upstreams of a single request, e.g.
+// "X-Magpie-Country: Germany, Austria". They take precedence over the same
+// parameters in the proxy username and are never forwarded.
+const (
+	controlHeaderPrefix     = "X-Magpie-"
+	controlHeaderCountry    = "X-Magpie-Country"
+	controlHeaderType       = "X-Magpie-Type"
+	controlHeaderMaxLatency = "X-Magpie-Max-Latency"
+
+	maxControlValues      = 16
+	maxControlValueLength = 64
+)
+
+// applyControlHeaders overrides params with the control headers sent on r.
+func applyControlHeaders(params clientParams, header http.Header) clientParams {
+	if values := header.Values(controlHeaderCountry); len(values) > 0 {
+		params.Countries = nil
+		for _, value := range values {
+			params.Countries = appendControlValues(params.Countries, strings.Split(value, ",")...)
+		}
+	}
+	if values := header.Values(controlHeaderType); len(values) > 0 {
+		params.Types = nil
+		for _, value := range values {
+			params.Types = appendControlValues(params.Types, strings.Split(value, ",")...)
+		}
+	}
+	if value := header.Get(controlHeaderMaxLatency); value != "" {
+		params.MaxLatencyMs = parseMaxLatency(value)
+	}
+	return params
+}
+
+// stripControlHeaders removes every Magpie control header so the target never
+// sees them.
+func stripControlHeaders(header http.Header) {
+	for name := range header {
+		if strings.HasPrefix(name, controlHeaderPrefix) {
+			delete(header, name)
+		}
+	}
+}
+
+func appendControlValues(values []string, raw ...string) []string {
+	for _, entry := range raw {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || len(entry) > maxControlValueLength || len(values) >= maxControlValues {
+			continue
+		}
+		values = append(values, entry)
+	}
+	return values
+}
+
+// parseMaxLatency reads a latency limit in milliseconds. Invalid values lift
+// the limit.
+func parseMaxLatency(raw string) uint16 {
+	value, err := strconv.ParseUint(strings.TrimSpace(raw), 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(value)
+}
+
+// selection returns the pool constraints the client asked for.
+func (p clientParams) selection() database.RotatingProxySelection {
+	return database.RotatingProxySelection{
+		Countries:         p.Countries,
+		EstimatedTypes:    p.Types,
+		MaxResponseTimeMs: p.MaxLatencyMs,
+		MinAnonymityLevel: p.MinAnonymityLevel,
+	}
+}
@@ -0,0 +1,93 @@
+package rotatingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/database"
+	"magpie/internal/domain"
+)
+
+func TestParseClientUsername_ReadsPoolFilters(t *testing.T) {
+	base, params := parseClientUsername("user-country-united_states-country-germany-type-residential-latency-250-session-abc")
+	if base != "user" || params.Session != "abc" {
+		t.Fatalf("unexpected base %q or session %q", base, params.Session)
+	}
+	if want := []string{"united states", "germany"}; !reflect.DeepEqual(params.Countries, want) {
+		t.Fatalf("countries = %v, want %v", params.Countries, want)
+	}
+	if want := []string{"residential"}; !reflect.DeepEqual(params.Types, want) {
+		t.Fatalf("types = %v, want %v", params.Types, want)
+	}
+	if params.MaxLatencyMs != 250 {
+		t.Fatalf("max latency = %d, want 250", params.MaxLatencyMs)
+	}
+}
+
+func TestApplyControlHeaders_OverridesUsernameParams(t *testing.T) {
+	header := http.Header{}
+	header.Set(controlHeaderCountry, "Germany, Austria")
+	header.Set(controlHeaderMaxLatency, "not-a-number")
+
+	params := applyControlHeaders(clientParams{Countries: []string{"france"}, Types: []string{"isp"}, MaxLatencyMs: 500}, header)
+
+	if want := []string{"Germany", "Austria"}; !reflect.DeepEqual(params.Countries, want) {
+		t.Fatalf("countries = %v, want %v", params.Countries, want)
+	}
+	if want := []string{"isp"}; !reflect.DeepEqual(params.Types, want) {
+		t.Fatalf("types = %v, want %v", params.Types, want)
+	}
+	if params.MaxLatencyMs != 0 {
+		t.Fatalf("expected an invalid latency to lift the limit, got %d", params.MaxLatencyMs)
+	}
+}
+
+func TestServeHTTP_NarrowsSelectionAndStripsControlHeaders(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	selections := stubRotation(t, 1)
+
+	var forwarded http.Header
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		forwarded = req.Header.Clone()
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	handler := newProxyHandler(domain.RotatingProxy{ID: 25, UserID: 4})
+	request := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	request.Header.Set(controlHeaderCountry, "Germany")
+	request.Header.Set(controlHeaderType, "residential,isp")
+	request.Header.Set(controlHeaderMaxLatency, "300")
+	request.Header.Set("X-Magpie-Debug", "1")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d", recorder.Code)
+	}
+	if len(*selections) != 1 {
+		t.Fatalf("expected one rotation, got %d", len(*selections))
+	}
+	want := database.RotatingProxySelection{
+		Countries:         []string{"Germany"},
+		EstimatedTypes:    []string{"residential", "isp"},
+		MaxResponseTimeMs: 300,
+	}
+	got := (*selections)[0]
+	got.AvoidProxyIDs, got.ExcludeProxyIDs = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("selection = %+v, want %+v", got, want)
+	}
+	for name := range forwarded {
+		if strings.HasPrefix(name, controlHeaderPrefix) {
+			t.Fatalf("control header %s was forwarded", name)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package rotatingproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/database"
+)
+
+const cooldownSweepPeriod = time.Minute
+
+var (
+	errAcquireUpstream     = errors.New("failed to acquire upstream proxy")
+	errUnsupportedUpstream = errors.New("upstream protocol not supported by rotator")
+	// errAttemptNotRetryable ends failover after an attempt that cannot be
+	// repeated on another upstream, e.g. because the request body was sent.
+	errAttemptNotRetryable = errors.New("upstream attempt cannot be retried")
+
+	upstreamCooldowns = newCooldownStore()
+)
+
+type failoverPolicy struct {
+	attempts int
+	deadline time.Duration
+	cooldown time.Duration
+}
+
+func (h *proxyHandler) failoverPolicy() failoverPolicy {
+	attempts := h.rotator.FailoverAttempts
+	if attempts == 0 {
+		attempts = database.DefaultRotatingProxyFailoverAttempts
+	}
+	timeout := h.rotator.FailoverTimeoutSeconds
+	if timeout == 0 {
+		timeout = database.DefaultRotatingProxyFailoverTimeoutSeconds
+	}
+	cooldown := h.rotator.FailoverCooldownSeconds
+	if cooldown == 0 {
+		cooldown = database.DefaultRotatingProxyFailoverCooldownSeconds
+	}
+
+	return failoverPolicy{
+		attempts: int(attempts),
+		deadline: time.Duration(timeout) * time.Second,
+		cooldown: time.Duration(cooldown) * time.Second,
+	}
+}
+
+// withFailover runs attempt against upstreams picked for the client's
+// parameters until one succeeds, the retry budget or deadline is exhausted, or
+// ctx is cancelled. Upstreams banned by the destination host are never picked.
+// Failed upstreams are skipped for the rest of the request and put on cooldown
+// so later requests avoid them as well; upstreams at their connection limit
+// are only skipped. An attempt failing with errAttemptNotRetryable ends the
+// loop. Each attempt gets the failover deadline and must give up once it
+// passes. Upstream errors and the latency of the successful attempt are
+// recorded as traffic. It returns the upstream that succeeded, whose
+// connection slot the caller must release with h.limits.releaseUpstream.
+func (h *proxyHandler) withFailover(ctx context.Context, params clientParams, attempt func(next *dto.RotatingProxyNext, deadline time.Time) error) (*dto.RotatingProxyNext, error) {
+	policy := h.failoverPolicy()
+	deadline := time.Now().Add(policy.deadline)
+
+	var (
+		tried   []uint64
+		lastErr error
+	)
+	banned := bannedUpstreams(h.rotator.ID, params.Destination)
+
+	for i := 0; i < policy.attempts; i++ {
+		if i > 0 && (ctx.Err() != nil || time.Now().After(deadline)) {
+			break
+		}
+
+		selection := params.selection()
+		selection.ExcludeProxyIDs = append(append([]uint64(nil), banned...), tried...)
+		selection.AvoidProxyIDs = upstreamCooldowns.active(h.rotator.ID)
+
+		next, err := h.acquireUpstream(params.sessionID(), selection)
+		if err != nil {
+			if lastErr != nil {
+				break
+			}
+			return nil, fmt.Errorf("%w: %v", errAcquireUpstream, err)
+		}
+
+		if !supportedUpstream(next.Protocol) {
+			return nil, errUnsupportedUpstream
+		}
+
+		if !h.limits.acquireUpstream(next.ProxyID) {
+			lastErr = errUpstreamBusy
+			tried = append(tried, next.ProxyID)
+			continue
+		}
+
+		started := time.Now()
+		if err := attempt(next, deadline); err != nil {
+			h.limits.releaseUpstream(next.ProxyID)
+			h.recordTraffic(database.RotatingProxyTrafficSample{ProxyID: next.ProxyID, Errors: 1})
+			lastErr = err
+			tried = append(tried, next.ProxyID)
+			upstreamCooldowns.mark(h.rotator.ID, next.ProxyID, time.Now().Add(policy.cooldown))
+			log.Debug("rotating proxy: upstream attempt failed", "rotator_id", h.rotator.ID, "proxy_id", next.ProxyID, "attempt", i+1, "error", err)
+			if errors.Is(err, errAttemptNotRetryable) {
+				break
+			}
+			continue
+		}
+
+		h.recordTraffic(database.RotatingProxyTrafficSample{ProxyID: next.ProxyID, Latency: time.Since(started)})
+		return next, nil
+	}
+
+	return nil, lastErr
+}
+
+// boundAttempt returns the context of an HTTP attempt, cancelled once the
+// failover deadline passes unless stop is called first. stop reports false
+// when the deadline already passed. release cancels the context and must be
+// called once the attempt's response is no longer used.
+func boundAttempt(parent context.Context, deadline time.Time) (ctx context.Context, stop func() bool, release context.CancelFunc) {
+	ctx, release = context.WithCancel(parent)
+	timer := time.AfterFunc(time.Until(deadline), release)
+	return ctx, timer.Stop, release
+}
+
+// cooldownStore tracks upstreams that recently failed per rotator.
+type cooldownStore struct {
+	mu        sync.Mutex
+	until     map[uint64]map[uint64]time.Time
+	lastSweep time.Time
+}
+
+func newCooldownStore() *cooldownStore {
+	return &cooldownStore{until: make(map[uint64]map[uint64]time.Time)}
+}
+
+func (s *cooldownStore) mark(rotatorID uint64, proxyID uint64, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked(time.Now())
+
+	entries, ok := s.until[rotatorID]
+	if !ok {
+		entries = make(map[uint64]time.Time)
+		s.until[rotatorID] = entries
+	}
+	entries[proxyID] = until
+}
+
+func (s *cooldownStore) active(rotatorID uint64) []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var ids []uint64
+	for proxyID, until := range s.until[rotatorID] {
+		if now.Before(until) {
+			ids = append(ids, proxyID)
+		}
+	}
+	return ids
+}
+
+func (s *cooldownStore) forget(rotatorID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.until, rotatorID)
+}
+
+func (s *cooldownStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < cooldownSweepPeriod {
+		return
+	}
+	s.lastSweep = now
+
+	for rotatorID, entries := range s.until {
+		for proxyID, until := range entries {
+			if now.After(until) {
+				delete(entries, proxyID)
+			}
+		}
+		if len(entries) == 0 {
+			delete(s.until, rotatorID)
+		}
+	}
+}
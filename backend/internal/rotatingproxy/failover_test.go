@@ -0,0 +1,372 @@
+package rotatingproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/database"
+	"magpie/internal/domain"
+)
+
+// stubRotation serves upstreams 1..count in order, honouring the selection the
+// handler passes along, and records every selection it received.
+func stubRotation(t *testing.T, count uint64) *[]database.RotatingProxySelection {
+	t.Helper()
+
+	var selections []database.RotatingProxySelection
+	original := getNextRotatingProxyFunc
+	getNextRotatingProxyFunc = func(userID uint, rotatorID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+		selections = append(selections, selection)
+		for id := uint64(1); id <= count; id++ {
+			if containsProxyID(selection.ExcludeProxyIDs, id) || containsProxyID(selection.AvoidProxyIDs, id) {
+				continue
+			}
+			return &dto.RotatingProxyNext{ProxyID: id, Protocol: "http"}, nil
+		}
+		return nil, database.ErrRotatingProxyNoAliveProxies
+	}
+	t.Cleanup(func() { getNextRotatingProxyFunc = original })
+
+	return &selections
+}
+
+func TestConnectUpstream_FailsOverAndCoolsDownFailedUpstream(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 11, UserID: 4, FailoverAttempts: 3}}
+	selections := stubRotation(t, 3)
+
+	originalDial := dialUpstreamFunc
+	dialUpstreamFunc = func(next *dto.RotatingProxyNext) (net.Conn, error) {
+		if next.ProxyID == 1 {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		t.Cleanup(func() { _ = server.Close() })
+		return client, nil
+	}
+	t.Cleanup(func() { dialUpstreamFunc = originalDial })
+
+	originalConnect := performUpstreamConnectFunc
+	performUpstreamConnectFunc = func(conn net.Conn, targetHost string, next *dto.RotatingProxyNext) error {
+		return nil
+	}
+	t.Cleanup(func() { performUpstreamConnectFunc = originalConnect })
+
+	conn, _, err := handler.connectUpstream(context.Background(), clientParams{}, "example.com:443")
+	if err != nil {
+		t.Fatalf("connectUpstream: %v", err)
+	}
+	_ = conn.Close()
+
+	if len(*selections) != 2 {
+		t.Fatalf("expected 2 upstream selections, got %d", len(*selections))
+	}
+	if !containsProxyID((*selections)[1].ExcludeProxyIDs, 1) {
+		t.Fatal("expected failed upstream to be excluded from the retry")
+	}
+
+	cooling := upstreamCooldowns.active(handler.rotator.ID)
+	if len(cooling) != 1 || cooling[0] != 1 {
+		t.Fatalf("expected upstream 1 on cooldown, got %v", cooling)
+	}
+
+	if _, _, err := handler.connectUpstream(context.Background(), clientParams{}, "example.com:443"); err != nil {
+		t.Fatalf("second connectUpstream: %v", err)
+	}
+	if last := (*selections)[len(*selections)-1]; !containsProxyID(last.AvoidProxyIDs, 1) {
+		t.Fatal("expected later requests to avoid the cooling upstream")
+	}
+}
+
+func TestConnectUpstream_StopsAfterRetryBudget(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 12, UserID: 4, FailoverAttempts: 2}}
+	selections := stubRotation(t, 5)
+
+	originalDial := dialUpstreamFunc
+	dialUpstreamFunc = func(next *dto.RotatingProxyNext) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	t.Cleanup(func() { dialUpstreamFunc = originalDial })
+
+	_, _, err := handler.connectUpstream(context.Background(), clientParams{}, "example.com:443")
+	if err == nil {
+		t.Fatal("expected connectUpstream to fail")
+	}
+	if errors.Is(err, errAcquireUpstream) {
+		t.Fatalf("expected upstream failure, got acquisition error %v", err)
+	}
+	if len(*selections) != 2 {
+		t.Fatalf("expected retry budget of 2 attempts, got %d", len(*selections))
+	}
+}
+
+func TestHandleHTTP_RetriesOnNextUpstream(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 13, UserID: 4}}
+	stubRotation(t, 2)
+
+	var bodies []string
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		if next.ProxyID == 1 {
+			// Fails while dialing, before any of the body was sent.
+			return nil, errors.New("upstream timeout")
+		}
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Upstream": []string{"2"}},
+			Body:       io.NopCloser(strings.NewReader("ok")),
+		}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	request := httptest.NewRequest(http.MethodPost, "http://example.com/submit", strings.NewReader("payload"))
+	recorder := httptest.NewRecorder()
+
+	handler.handleHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+	if recorder.Header().Get("X-Upstream") != "2" || recorder.Body.String() != "ok" {
+		t.Fatalf("unexpected response: %v %q", recorder.Header(), recorder.Body.String())
+	}
+	if len(bodies) != 1 || bodies[0] != "payload" {
+		t.Fatalf("expected the request body to reach upstream 2, got %q", bodies)
+	}
+}
+
+func TestHandleHTTP_DoesNotRetryAfterBodyWasSent(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 15, UserID: 4, FailoverAttempts: 3}}
+	stubRotation(t, 3)
+
+	var attempts []uint64
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		attempts = append(attempts, next.ProxyID)
+		_, _ = io.ReadAll(req.Body)
+		return nil, errors.New("connection reset")
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	request := httptest.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader("payload"))
+	recorder := httptest.NewRecorder()
+
+	handler.handleHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", recorder.Code)
+	}
+	if len(attempts) != 1 {
+		t.Fatalf("expected a single attempt once the body was consumed, got %v", attempts)
+	}
+}
+
+func TestHandleHTTP_StreamsRequestBodyWithoutBuffering(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 16, UserID: 4}}
+	stubRotation(t, 1)
+
+	bodyReader, bodyWriter := io.Pipe()
+	received := make(chan string, 1)
+
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		if req.ContentLength != -1 {
+			t.Errorf("ContentLength = %d, want -1 for a body of unknown length", req.ContentLength)
+		}
+		// The first chunk must arrive while the client is still sending.
+		chunk := make([]byte, len("first"))
+		if _, err := io.ReadFull(req.Body, chunk); err != nil {
+			return nil, err
+		}
+		received <- string(chunk)
+		rest, _ := io.ReadAll(req.Body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(string(chunk) + string(rest))),
+		}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	go func() {
+		_, _ = bodyWriter.Write([]byte("first"))
+		<-received
+		_, _ = bodyWriter.Write([]byte("-second"))
+		_ = bodyWriter.Close()
+	}()
+
+	request := httptest.NewRequest(http.MethodPut, "http://example.com/upload", bodyReader)
+	request.ContentLength = -1
+	recorder := httptest.NewRecorder()
+
+	handler.handleHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "first-second" {
+		t.Fatalf("unexpected response: %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestHandleHTTP_RecordsTrafficPerUpstream(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 14, UserID: 4}}
+	stubRotation(t, 2)
+
+	var samples []database.RotatingProxyTrafficSample
+	originalRecord := recordTrafficFunc
+	recordTrafficFunc = func(sample database.RotatingProxyTrafficSample) {
+		samples = append(samples, sample)
+	}
+	t.Cleanup(func() { recordTrafficFunc = originalRecord })
+
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		if next.ProxyID == 1 {
+			return nil, errors.New("upstream timeout")
+		}
+		_, _ = io.ReadAll(req.Body)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("response"))}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	request := httptest.NewRequest(http.MethodPost, "http://example.com/submit", strings.NewReader("payload"))
+	handler.handleHTTP(httptest.NewRecorder(), request)
+
+	var failed, served database.RotatingProxyTrafficSample
+	var latencySamples int
+	for _, sample := range samples {
+		if sample.RotatingProxyID != 14 {
+			t.Fatalf("sample recorded for wrong rotator: %+v", sample)
+		}
+		if sample.Latency > 0 {
+			latencySamples++
+		}
+		switch sample.ProxyID {
+		case 1:
+			failed.Errors += sample.Errors
+		case 2:
+			served.Requests += sample.Requests
+			served.BytesOut += sample.BytesOut
+			served.BytesIn += sample.BytesIn
+		}
+	}
+
+	if failed.Errors != 1 {
+		t.Fatalf("expected one error on upstream 1, got %d", failed.Errors)
+	}
+	if served.Requests != 1 || served.BytesOut != int64(len("payload")) || served.BytesIn != int64(len("response")) {
+		t.Fatalf("unexpected traffic for upstream 2: %+v", served)
+	}
+	if latencySamples != 1 {
+		t.Fatalf("expected one latency sample, got %d", latencySamples)
+	}
+}
+
+func TestPipeConnections_ReportsBytesInBothDirections(t *testing.T) {
+	clientSide, clientConn := net.Pipe()
+	upstreamConn, upstreamSide := net.Pipe()
+
+	go func() {
+		_, _ = clientSide.Write([]byte("hello"))
+		buf := make([]byte, 6)
+		_, _ = io.ReadFull(clientSide, buf)
+		_ = clientSide.Close()
+	}()
+	go func() {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(upstreamSide, buf)
+		_, _ = upstreamSide.Write([]byte("world!"))
+		_, _ = io.Copy(io.Discard, upstreamSide)
+	}()
+
+	sent, received := pipeConnections(clientConn, upstreamConn)
+	if sent != 5 || received != 6 {
+		t.Fatalf("sent=%d received=%d, want 5 and 6", sent, received)
+	}
+}
+
+// silentUpstream serves an upstream that accepts connections but never
+// replies, and points every rotation at it.
+func silentUpstream(t *testing.T) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	addr := listener.Addr().(*net.TCPAddr)
+	original := getNextRotatingProxyFunc
+	getNextRotatingProxyFunc = func(userID uint, rotatorID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+		return &dto.RotatingProxyNext{ProxyID: 1, IP: "127.0.0.1", Port: uint16(addr.Port), Protocol: "http"}, nil
+	}
+	t.Cleanup(func() { getNextRotatingProxyFunc = original })
+}
+
+func TestConnectUpstream_GivesUpOnSilentUpstreamAtDeadline(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 13, UserID: 4, FailoverAttempts: 2, FailoverTimeoutSeconds: 1}}
+	silentUpstream(t)
+
+	started := time.Now()
+	if _, _, err := handler.connectUpstream(context.Background(), clientParams{}, "example.com:443"); err == nil {
+		t.Fatal("expected connectUpstream to fail against a silent upstream")
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Fatalf("expected the handshake to be bounded by the failover deadline, took %v", elapsed)
+	}
+}
+
+func TestServeHTTP_GivesUpOnSilentUpstreamAtDeadline(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := newProxyHandler(domain.RotatingProxy{ID: 14, UserID: 4, FailoverAttempts: 2, FailoverTimeoutSeconds: 1})
+	silentUpstream(t)
+
+	started := time.Now()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("expected status %d, got %d", http.StatusBadGateway, recorder.Code)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Fatalf("expected the request to be bounded by the failover deadline, took %v", elapsed)
+	}
+}
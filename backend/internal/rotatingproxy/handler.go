@@ -2,7 +2,6 @@ package rotatingproxy
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/base64"
@@ -12,10 +11,14 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/log"
+	"golang.org/x/net/http/httpguts"
 
 	"magpie/internal/api/dto"
 	"magpie/internal/database"
@@ -23,17 +26,54 @@ import (
 )
 
 const (
-	connectEstablishedResponse = "HTTP/1.1 200 Connection Established\r\nProxy-Agent: Magpie Rotator\r\n\r\n"
+	connectEstablishedStatus   = "HTTP/1.1 200 Connection Established\r\nProxy-Agent: Magpie Rotator\r\n"
+	connectEstablishedResponse = connectEstablishedStatus + "\r\n"
 )
 
 var (
-	getNextRotatingProxyFunc   = database.GetNextRotatingProxy
+	getNextRotatingProxyFunc   = database.GetNextRotatingProxyWithSelection
 	dialUpstreamFunc           = dialUpstream
 	performUpstreamConnectFunc = performUpstreamConnect
+	roundTripUpstreamFunc      = roundTripUpstream
+	recordTrafficFunc          = database.RecordRotatingProxyTraffic
 )
 
 type proxyHandler struct {
-	rotator domain.RotatingProxy
+	rotator        domain.RotatingProxy
+	limits         *admissionControl
+	allowedClients *clientAllowlist
+	// certificate is served to clients of https rotators. It is nil for other
+	// listen protocols or when the stored pair is unusable.
+	certificate *tls.Certificate
+	headers     *headerPolicy
+	routes      *routingTable
+	// tunnels tracks the hijacked connections of the rotator's server. It is
+	// shared by reconfigured handlers so stopping the server reaches them all.
+	tunnels *openTunnels
+}
+
+func newProxyHandler(rotator domain.RotatingProxy) *proxyHandler {
+	return &proxyHandler{
+		rotator:        rotator,
+		limits:         newAdmissionControl(rotator),
+		allowedClients: newClientAllowlist(rotator),
+		certificate:    loadListenerCertificate(rotator),
+		headers:        newHeaderPolicy(rotator),
+		routes:         newRoutingTable(rotator),
+		tunnels:        newOpenTunnels(),
+	}
+}
+
+// reconfigured returns a handler for the updated rotator. Admission state is
+// carried over when the limits did not change so in-flight connections stay
+// counted.
+func (h *proxyHandler) reconfigured(rotator domain.RotatingProxy) *proxyHandler {
+	next := newProxyHandler(rotator)
+	next.tunnels = h.tunnels
+	if sameLimits(h.rotator, rotator) {
+		next.limits = h.limits
+	}
+	return next
 }
 
 func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -41,49 +81,115 @@ func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch strings.ToUpper(r.Method) {
-	case http.MethodConnect:
+	if !h.limits.allowRequest() {
+		http.Error(w, "rotator rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if !h.limits.acquireConnection() {
+		http.Error(w, "rotator connection limit reached", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.limits.releaseConnection()
+
+	switch {
+	case strings.EqualFold(r.Method, http.MethodConnect):
 		h.handleConnect(w, r)
+	case isUpgradeRequest(r):
+		h.handleUpgrade(w, r)
 	default:
 		h.handleHTTP(w, r)
 	}
 }
 
+// authenticateClient checks the client against the rotator's address allowlist
+// and, when authentication is enabled, its credentials. Both must pass when a
+// rotator combines them.
 func (h *proxyHandler) authenticateClient(w http.ResponseWriter, r *http.Request) bool {
+	if !h.allowedClients.allows(r.RemoteAddr) {
+		http.Error(w, "client address not allowed", http.StatusForbidden)
+		return false
+	}
+
 	if !h.rotator.AuthRequired {
 		return true
 	}
 
-	header := strings.TrimSpace(r.Header.Get("Proxy-Authorization"))
-	if header == "" {
+	username, password, ok := parseProxyAuthorization(r)
+	if !ok {
 		writeProxyAuthRequired(w)
 		return false
 	}
 
-	parts := strings.SplitN(header, " ", 2)
-	if len(parts) != 2 || !strings.EqualFold(parts[0], "Basic") {
+	if !h.credentialsValid(username, password) {
 		writeProxyAuthRequired(w)
 		return false
 	}
 
+	return true
+}
+
+// parseProxyAuthorization extracts the Basic credentials sent in the
+// Proxy-Authorization header.
+func parseProxyAuthorization(r *http.Request) (string, string, bool) {
+	header := strings.TrimSpace(r.Header.Get("Proxy-Authorization"))
+	if header == "" {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Basic") {
+		return "", "", false
+	}
+
 	decoded, err := base64.StdEncoding.DecodeString(parts[1])
 	if err != nil {
-		writeProxyAuthRequired(w)
-		return false
+		return "", "", false
 	}
 
 	creds := strings.SplitN(string(decoded), ":", 2)
 	if len(creds) != 2 {
-		writeProxyAuthRequired(w)
-		return false
+		return "", "", false
 	}
 
-	if creds[0] != h.rotator.AuthUsername || creds[1] != h.rotator.AuthPassword {
-		writeProxyAuthRequired(w)
-		return false
+	return creds[0], creds[1], true
+}
+
+// paramsFor resolves the routing parameters for a request to target, a
+// "host:port" destination.
+func (h *proxyHandler) paramsFor(params clientParams, target string) clientParams {
+	params.Destination = database.NormalizeRotatorDestinationHost(target)
+	return h.routes.route(params)
+}
+
+// requestParams returns the routing parameters encoded in the request's proxy
+// username and control headers, if any.
+func (h *proxyHandler) requestParams(r *http.Request) clientParams {
+	var params clientParams
+	if username, _, ok := parseProxyAuthorization(r); ok {
+		_, params = h.splitClientUsername(username)
 	}
+	return applyControlHeaders(params, r.Header)
+}
 
-	return true
+func (h *proxyHandler) credentialsValid(username, password string) bool {
+	base, _ := h.splitClientUsername(username)
+	return base == h.rotator.AuthUsername && password == h.rotator.AuthPassword
+}
+
+// splitClientUsername splits a client username into the rotator username and
+// its parameters. The rotator's own username is matched first, so one that
+// contains a parameter key such as "scraper-type-a" is never cut short.
+func (h *proxyHandler) splitClientUsername(raw string) (string, clientParams) {
+	auth := h.rotator.AuthUsername
+	if auth != "" && raw == auth {
+		return raw, clientParams{}
+	}
+	if auth != "" && strings.HasPrefix(raw, auth+"-") {
+		if base, params := parseClientUsername(raw[len(auth):]); base == "" {
+			return auth, params
+		}
+	}
+	return parseClientUsername(raw)
 }
 
 func writeProxyAuthRequired(w http.ResponseWriter) {
@@ -93,60 +199,327 @@ func writeProxyAuthRequired(w http.ResponseWriter) {
 }
 
 func (h *proxyHandler) handleHTTP(w http.ResponseWriter, r *http.Request) {
-	next, err := getNextRotatingProxyFunc(h.rotator.UserID, h.rotator.ID)
+	defer r.Body.Close()
+
+	targetURL := absoluteTargetURL(r)
+	params := h.paramsFor(h.requestParams(r), targetURL.Host)
+	body := newStreamedBody(r, h.limits)
+
+	var (
+		resp           *http.Response
+		releaseAttempt context.CancelFunc = func() {}
+	)
+	defer func() { releaseAttempt() }()
+
+	next, err := h.withFailover(r.Context(), params, func(next *dto.RotatingProxyNext, deadline time.Time) error {
+		ctx, stop, release := boundAttempt(r.Context(), deadline)
+		newReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL.String(), body.attemptBody())
+		if err != nil {
+			release()
+			return err
+		}
+
+		newReq.ContentLength = body.contentLength
+		newReq.Header = r.Header.Clone()
+		newReq.Header.Del("Proxy-Authorization")
+		stripControlHeaders(newReq.Header)
+		h.headers.applyRequest(newReq.Header)
+
+		upstreamResp, err := roundTripUpstreamFunc(next, newReq)
+		if err != nil {
+			release()
+			return body.retryable(err)
+		}
+		if !stop() {
+			_ = upstreamResp.Body.Close()
+			release()
+			return body.retryable(context.DeadlineExceeded)
+		}
+		if upstreamResp.StatusCode == http.StatusProxyAuthRequired {
+			_ = upstreamResp.Body.Close()
+			release()
+			return body.retryable(errors.New("upstream proxy rejected credentials"))
+		}
+
+		resp, releaseAttempt = upstreamResp, release
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "failed to acquire upstream proxy", http.StatusBadGateway)
+		writeUpstreamFailure(w, err)
+		h.recordTraffic(database.RotatingProxyTrafficSample{Requests: 1, BytesOut: body.sent()})
 		return
 	}
+	defer h.limits.releaseUpstream(next.ProxyID)
+
+	h.noteTargetResponse(params, next.ProxyID, resp)
+	h.relayResponse(w, resp, next, body.sent())
+}
+
+// relayResponse streams an upstream response back to the client and records
+// the request's traffic.
+func (h *proxyHandler) relayResponse(w http.ResponseWriter, resp *http.Response, next *dto.RotatingProxyNext, sent int64) {
+	defer resp.Body.Close()
+
+	copyHeaders(w.Header(), resp.Header)
+	h.headers.applyResponse(w.Header(), next)
+	w.WriteHeader(resp.StatusCode)
+	received, err := io.Copy(newFlushWriter(w), h.limits.throttleReader(resp.Body))
+	if err != nil {
+		log.Warn("rotating proxy: failed to copy response body", "rotator_id", h.rotator.ID, "error", err)
+	}
 
-	if !supportedUpstream(next.Protocol) {
+	h.recordTraffic(database.RotatingProxyTrafficSample{
+		ProxyID:  next.ProxyID,
+		Requests: 1,
+		BytesOut: sent,
+		BytesIn:  received,
+	})
+}
+
+func absoluteTargetURL(r *http.Request) *url.URL {
+	if r.URL.IsAbs() {
+		return r.URL
+	}
+
+	scheme := "http"
+	if strings.HasPrefix(strings.ToLower(r.Proto), "https") {
+		scheme = "https"
+	}
+	return &url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawQuery: r.URL.RawQuery,
+	}
+}
+
+func writeUpstreamFailure(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errAcquireUpstream):
+		http.Error(w, "failed to acquire upstream proxy", http.StatusBadGateway)
+	case errors.Is(err, errUnsupportedUpstream):
 		http.Error(w, "upstream protocol not supported by rotator", http.StatusBadGateway)
-		return
+	case errors.Is(err, errUpstreamBusy):
+		http.Error(w, "all upstream proxies are at their connection limit", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "upstream proxy request failed", http.StatusBadGateway)
 	}
+}
+
+// isUpgradeRequest reports whether the client asks to switch protocols, e.g.
+// to a WebSocket.
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade")
+}
+
+// handleUpgrade forwards a protocol upgrade through an upstream. When the
+// target switches protocols the client connection is hijacked and spliced to
+// the upstream connection like a CONNECT tunnel; any other answer is relayed
+// as a regular response.
+func (h *proxyHandler) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	targetURL := absoluteTargetURL(r)
+	params := h.paramsFor(h.requestParams(r), targetURL.Host)
+
+	var (
+		resp           *http.Response
+		releaseAttempt context.CancelFunc = func() {}
+	)
+	defer func() { releaseAttempt() }()
+
+	next, err := h.withFailover(r.Context(), params, func(next *dto.RotatingProxyNext, deadline time.Time) error {
+		ctx, stop, release := boundAttempt(r.Context(), deadline)
+		newReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL.String(), http.NoBody)
+		if err != nil {
+			release()
+			return err
+		}
+
+		newReq.Header = r.Header.Clone()
+		newReq.Header.Del("Proxy-Authorization")
+		stripControlHeaders(newReq.Header)
+		h.headers.applyRequest(newReq.Header)
+
+		upstreamResp, err := roundTripUpstreamFunc(next, newReq)
+		if err != nil {
+			release()
+			return err
+		}
+		if !stop() {
+			_ = upstreamResp.Body.Close()
+			release()
+			return context.DeadlineExceeded
+		}
+		if upstreamResp.StatusCode == http.StatusProxyAuthRequired {
+			_ = upstreamResp.Body.Close()
+			release()
+			return errors.New("upstream proxy rejected credentials")
+		}
 
-	bodyBytes, err := io.ReadAll(r.Body)
+		resp, releaseAttempt = upstreamResp, release
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		writeUpstreamFailure(w, err)
+		h.recordTraffic(database.RotatingProxyTrafficSample{Requests: 1, Errors: 1})
 		return
 	}
-	_ = r.Body.Close()
+	defer h.limits.releaseUpstream(next.ProxyID)
 
-	targetURL := r.URL
-	if !targetURL.IsAbs() {
-		scheme := "http"
-		if strings.HasPrefix(strings.ToLower(r.Proto), "https") {
-			scheme = "https"
-		}
-		targetURL = &url.URL{
-			Scheme:   scheme,
-			Host:     r.Host,
-			Path:     r.URL.Path,
-			RawQuery: r.URL.RawQuery,
-		}
+	// The upgrade only counts as a tunnel once the switch reached the client;
+	// until then it is a request like any other.
+	failed := func() {
+		h.recordTraffic(database.RotatingProxyTrafficSample{ProxyID: next.ProxyID, Requests: 1, Errors: 1})
 	}
 
-	newReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL.String(), bytes.NewReader(bodyBytes))
-	if err != nil {
-		http.Error(w, "failed to build upstream request", http.StatusInternalServerError)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		h.noteTargetResponse(params, next.ProxyID, resp)
+		h.relayResponse(w, resp, next, 0)
 		return
 	}
 
-	newReq.Header = r.Header.Clone()
-	newReq.Header.Del("Proxy-Authorization")
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		_ = resp.Body.Close()
+		http.Error(w, "upstream did not switch protocols", http.StatusBadGateway)
+		failed()
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		failed()
+		return
+	}
 
-	transport := buildHTTPTransport(next)
-	resp, err := transport.RoundTrip(newReq)
+	clientConn, buf, err := hijacker.Hijack()
 	if err != nil {
-		http.Error(w, "upstream proxy request failed", http.StatusBadGateway)
+		_ = upstream.Close()
+		http.Error(w, "failed to hijack connection", http.StatusInternalServerError)
+		failed()
 		return
 	}
-	defer resp.Body.Close()
 
-	copyHeaders(w.Header(), resp.Header)
-	w.WriteHeader(resp.StatusCode)
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		log.Warn("rotating proxy: failed to copy response body", "rotator_id", h.rotator.ID, "error", err)
+	h.headers.applyResponse(resp.Header, next)
+	if err := writeSwitchingProtocols(buf.Writer, resp); err != nil {
+		_ = clientConn.Close()
+		_ = upstream.Close()
+		failed()
+		return
+	}
+
+	h.tunnel(bufferedConn{Conn: clientConn, reader: buf.Reader}, upstream, next)
+}
+
+func writeSwitchingProtocols(w *bufio.Writer, resp *http.Response) error {
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// bufferedConn serves reads from the server's buffer first so bytes the
+// client sent right after its request are not lost when the connection is
+// hijacked.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// streamedBody hands the client's request body to the upstream as it
+// arrives instead of buffering it. Attempts that fail before any of the body
+// was read can be retried on another upstream; once reading started the
+// request cannot be replayed. Uploads count against the rotator's bandwidth
+// limit like tunnel traffic does.
+type streamedBody struct {
+	source        io.Reader
+	contentLength int64
+	read          atomic.Int64
+	started       atomic.Bool
+}
+
+func newStreamedBody(r *http.Request, limits *admissionControl) *streamedBody {
+	body := &streamedBody{contentLength: r.ContentLength}
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		body.source = limits.throttleReader(r.Body)
+	}
+	return body
+}
+
+func (b *streamedBody) attemptBody() io.ReadCloser {
+	if b.source == nil {
+		return http.NoBody
+	}
+	return streamedBodyReader{body: b}
+}
+
+func (b *streamedBody) sent() int64 {
+	return b.read.Load()
+}
+
+// retryable marks err as final once the body was consumed by the failed
+// attempt.
+func (b *streamedBody) retryable(err error) error {
+	if b.started.Load() {
+		return fmt.Errorf("%w: %v", errAttemptNotRetryable, err)
+	}
+	return err
+}
+
+// streamedBodyReader reads from the shared body. Close is a no-op because the
+// transport closes request bodies while the client's body belongs to the
+// server.
+type streamedBodyReader struct {
+	body *streamedBody
+}
+
+func (r streamedBodyReader) Read(p []byte) (int, error) {
+	r.body.started.Store(true)
+	n, err := r.body.source.Read(p)
+	r.body.read.Add(int64(n))
+	return n, err
+}
+
+func (streamedBodyReader) Close() error {
+	return nil
+}
+
+// flushWriter flushes after every write so responses reach the client as the
+// upstream produces them.
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) io.Writer {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return w
 	}
+	return flushWriter{w: w, flusher: flusher}
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if n > 0 {
+		fw.flusher.Flush()
+	}
+	return n, err
+}
+
+func roundTripUpstream(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+	return upstreamTransports.get(next).RoundTrip(req)
 }
 
 func (h *proxyHandler) handleConnect(w http.ResponseWriter, r *http.Request) {
@@ -168,35 +541,152 @@ func (h *proxyHandler) handleConnect(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	next, err := getNextRotatingProxyFunc(h.rotator.UserID, h.rotator.ID)
+	upConn, next, err := h.connectUpstream(r.Context(), h.paramsFor(h.requestParams(r), r.Host), r.Host)
 	if err != nil {
-		writeHijackedResponse(buf, http.StatusBadGateway, "Failed to acquire upstream proxy")
+		switch {
+		case errors.Is(err, errAcquireUpstream):
+			writeHijackedResponse(buf, http.StatusBadGateway, "Failed to acquire upstream proxy")
+		case errors.Is(err, errUnsupportedUpstream):
+			writeHijackedResponse(buf, http.StatusBadGateway, "Upstream protocol not supported by rotator")
+		case errors.Is(err, errUpstreamBusy):
+			writeHijackedResponse(buf, http.StatusServiceUnavailable, "All upstream proxies are at their connection limit")
+		default:
+			writeHijackedResponse(buf, http.StatusBadGateway, "Upstream CONNECT failed")
+		}
+		h.recordTraffic(database.RotatingProxyTrafficSample{Tunnels: 1})
 		return
 	}
+	defer h.limits.releaseUpstream(next.ProxyID)
 
-	if !supportedUpstream(next.Protocol) {
-		writeHijackedResponse(buf, http.StatusBadGateway, "Upstream protocol not supported by rotator")
+	if _, err := clientConn.Write(h.connectEstablished(next)); err != nil {
+		_ = upConn.Close()
 		return
 	}
 
-	upConn, err := dialUpstreamFunc(next)
-	if err != nil {
-		writeHijackedResponse(buf, http.StatusBadGateway, "Failed to connect to upstream proxy")
-		return
+	h.tunnel(clientConn, upConn, next)
+}
+
+// connectEstablished returns the response that tells a CONNECT client its
+// tunnel is open.
+func (h *proxyHandler) connectEstablished(next *dto.RotatingProxyNext) []byte {
+	header := http.Header{}
+	h.headers.applyResponse(header, next)
+	if len(header) == 0 {
+		return []byte(connectEstablishedResponse)
 	}
 
-	if err := performUpstreamConnectFunc(upConn, r.Host, next); err != nil {
+	var b strings.Builder
+	b.WriteString(connectEstablishedStatus)
+	_ = header.Write(&b)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// tunnel pipes the client and upstream together until either side closes and
+// records the tunnel's traffic.
+func (h *proxyHandler) tunnel(clientConn net.Conn, upConn io.ReadWriteCloser, next *dto.RotatingProxyNext) {
+	release, ok := h.tunnels.track(clientConn, upConn)
+	if !ok {
+		_ = clientConn.Close()
 		_ = upConn.Close()
-		writeHijackedResponse(buf, http.StatusBadGateway, "Upstream CONNECT failed")
 		return
 	}
+	defer release()
 
-	if _, err := clientConn.Write([]byte(connectEstablishedResponse)); err != nil {
-		_ = upConn.Close()
+	sent, received := pipeConnections(h.limits.throttleConn(clientConn), upConn)
+	h.recordTraffic(database.RotatingProxyTrafficSample{
+		ProxyID:  next.ProxyID,
+		Tunnels:  1,
+		BytesOut: sent,
+		BytesIn:  received,
+	})
+}
+
+// openTunnels holds the connections of the tunnels a server relays. Hijacked
+// connections outlive http.Server.Shutdown, so stopping a server closes them
+// through here.
+type openTunnels struct {
+	mu      sync.Mutex
+	closers map[uint64][]io.Closer
+	nextID  uint64
+	closed  bool
+}
+
+func newOpenTunnels() *openTunnels {
+	return &openTunnels{closers: make(map[uint64][]io.Closer)}
+}
+
+// track registers a tunnel's connections. It reports false once the server
+// stopped; release must be called when the tunnel ends.
+func (t *openTunnels) track(closers ...io.Closer) (func(), bool) {
+	if t == nil {
+		return func() {}, true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, false
+	}
+
+	t.nextID++
+	id := t.nextID
+	t.closers[id] = closers
+	return func() {
+		t.mu.Lock()
+		delete(t.closers, id)
+		t.mu.Unlock()
+	}, true
+}
+
+// closeAll closes every open tunnel and refuses new ones.
+func (t *openTunnels) closeAll() {
+	if t == nil {
 		return
 	}
 
-	pipeConnections(clientConn, upConn)
+	t.mu.Lock()
+	t.closed = true
+	open := t.closers
+	t.closers = make(map[uint64][]io.Closer)
+	t.mu.Unlock()
+
+	for _, closers := range open {
+		for _, closer := range closers {
+			_ = closer.Close()
+		}
+	}
+}
+
+func (h *proxyHandler) recordTraffic(sample database.RotatingProxyTrafficSample) {
+	sample.RotatingProxyID = h.rotator.ID
+	recordTrafficFunc(sample)
+}
+
+// connectUpstream opens a tunnel to targetHost through the rotator's upstreams,
+// failing over to other candidates when dialing or the handshake fails. The
+// caller must release the returned upstream's slot once the tunnel closes.
+func (h *proxyHandler) connectUpstream(ctx context.Context, params clientParams, targetHost string) (net.Conn, *dto.RotatingProxyNext, error) {
+	var upConn net.Conn
+	next, err := h.withFailover(ctx, params, func(next *dto.RotatingProxyNext, deadline time.Time) error {
+		conn, err := dialUpstreamFunc(next)
+		if err != nil {
+			return err
+		}
+		// A silent upstream must not hold the client past the deadline.
+		_ = conn.SetDeadline(deadline)
+		if err := performUpstreamConnectFunc(conn, targetHost, next); err != nil {
+			_ = conn.Close()
+			return err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		upConn = conn
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return upConn, next, nil
 }
 
 func writeHijackedResponse(buf *bufio.ReadWriter, status int, message string) {
@@ -219,29 +709,38 @@ func copyHeaders(dst, src http.Header) {
 
 func supportedUpstream(protocol string) bool {
 	switch strings.ToLower(protocol) {
-	case "http", "https":
+	case "http", "https", "socks4", "socks5":
 		return true
 	default:
 		return false
 	}
 }
 
+// buildHTTPTransport returns a keep-alive transport that sends requests through
+// the upstream. Transports are shared through upstreamTransports.
 func buildHTTPTransport(next *dto.RotatingProxyNext) *http.Transport {
+	transport := &http.Transport{
+		MaxIdleConns:        upstreamTransportIdleConns,
+		MaxIdleConnsPerHost: upstreamTransportIdleConnsPerHost,
+		IdleConnTimeout:     upstreamTransportIdleTimeout,
+	}
+
+	if isSOCKSUpstream(next.Protocol) {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialThroughSOCKSUpstream(ctx, addr, next)
+		}
+		return transport
+	}
+
 	proxyURL := &url.URL{
 		Scheme: "http",
-		Host:   fmt.Sprintf("%s:%d", next.IP, next.Port),
+		Host:   upstreamAddress(next),
 	}
 	if next.HasAuth {
 		proxyURL.User = url.UserPassword(next.Username, next.Password)
 	}
 
-	transport := &http.Transport{
-		Proxy:             http.ProxyURL(proxyURL),
-		DisableKeepAlives: true,
-		MaxIdleConns:      0,
-		IdleConnTimeout:   0,
-	}
-
+	transport.Proxy = http.ProxyURL(proxyURL)
 	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 		return dialProxyWithFallback(ctx, network, addr, next)
 	}
@@ -249,8 +748,12 @@ func buildHTTPTransport(next *dto.RotatingProxyNext) *http.Transport {
 	return transport
 }
 
+func upstreamAddress(next *dto.RotatingProxyNext) string {
+	return net.JoinHostPort(next.IP, strconv.Itoa(int(next.Port)))
+}
+
 func dialUpstream(next *dto.RotatingProxyNext) (net.Conn, error) {
-	address := fmt.Sprintf("%s:%d", next.IP, next.Port)
+	address := upstreamAddress(next)
 	dialer := &net.Dialer{Timeout: 10 * time.Second}
 	conn, err := dialer.Dial("tcp", address)
 	if err != nil {
@@ -277,6 +780,13 @@ func dialUpstream(next *dto.RotatingProxyNext) (net.Conn, error) {
 }
 
 func performUpstreamConnect(conn net.Conn, targetHost string, next *dto.RotatingProxyNext) error {
+	switch {
+	case isSOCKS5Upstream(next.Protocol):
+		return performSOCKS5Connect(conn, targetHost, next)
+	case isSOCKS4Upstream(next.Protocol):
+		return performSOCKS4Connect(conn, targetHost, next)
+	}
+
 	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\nProxy-Connection: Keep-Alive\r\n", targetHost, targetHost)
 	if next.HasAuth {
 		auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", next.Username, next.Password)))
@@ -303,22 +813,52 @@ func performUpstreamConnect(conn net.Conn, targetHost string, next *dto.Rotating
 	return nil
 }
 
-func pipeConnections(left, right net.Conn) {
-	errCh := make(chan error, 2)
+// pipeConnections copies between client and upstream until either side
+// closes, then closes both and returns the bytes sent to and received from the
+// upstream.
+func pipeConnections(client, upstream io.ReadWriteCloser) (sent, received int64) {
+	done := make(chan struct{}, 2)
 
 	go func() {
-		_, err := io.Copy(left, right)
-		errCh <- err
+		received, _ = io.Copy(client, upstream)
+		done <- struct{}{}
 	}()
 
 	go func() {
-		_, err := io.Copy(right, left)
-		errCh <- err
+		sent, _ = io.Copy(upstream, client)
+		done <- struct{}{}
 	}()
 
-	<-errCh
-	left.Close()
-	right.Close()
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+
+	return sent, received
+}
+
+// dialThroughSOCKSUpstream opens a tunnel to addr through a SOCKS upstream so
+// plain HTTP requests can be sent over it directly.
+func dialThroughSOCKSUpstream(ctx context.Context, addr string, next *dto.RotatingProxyNext) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", upstreamAddress(next))
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	if err := performUpstreamConnect(conn, addr, next); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
 }
 
 func dialProxyWithFallback(ctx context.Context, network, addr string, next *dto.RotatingProxyNext) (net.Conn, error) {
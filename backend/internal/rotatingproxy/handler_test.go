@@ -4,14 +4,17 @@ import (
 	"bufio"
 	"context"
 	"encoding/base64"
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"magpie/internal/api/dto"
+	"magpie/internal/database"
 	"magpie/internal/domain"
 )
 
@@ -91,13 +94,63 @@ func TestAuthenticateClient_AllowsUnauthenticatedAccessWhenDisabled(t *testing.T
 	}
 }
 
+func TestAuthenticateClient_EnforcesClientAllowlist(t *testing.T) {
+	allowlistOnly := newProxyHandler(domain.RotatingProxy{
+		AllowedClientCIDRs: domain.StringList{"203.0.113.0/24", "2001:db8::/32"},
+	})
+
+	for _, remote := range []string{"203.0.113.7:50000", "[2001:db8::1]:50000", "[::ffff:203.0.113.9]:50000"} {
+		request := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		request.RemoteAddr = remote
+		if ok := allowlistOnly.authenticateClient(httptest.NewRecorder(), request); !ok {
+			t.Fatalf("expected %s to be allowed without credentials", remote)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	request.RemoteAddr = "198.51.100.1:50000"
+	if ok := allowlistOnly.authenticateClient(recorder, request); ok {
+		t.Fatal("expected client outside the allowlist to be rejected")
+	}
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, recorder.Code)
+	}
+
+	combined := newProxyHandler(domain.RotatingProxy{
+		AuthRequired:       true,
+		AuthUsername:       "proxy-user",
+		AuthPassword:       "proxy-pass",
+		AllowedClientCIDRs: domain.StringList{"203.0.113.0/24"},
+	})
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	request.RemoteAddr = "203.0.113.7:50000"
+	if ok := combined.authenticateClient(recorder, request); ok || recorder.Code != http.StatusProxyAuthRequired {
+		t.Fatalf("expected allowlisted client without credentials to need auth, got ok=%v status=%d", ok, recorder.Code)
+	}
+
+	request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("proxy-user:proxy-pass")))
+	if ok := combined.authenticateClient(httptest.NewRecorder(), request); !ok {
+		t.Fatal("expected allowlisted client with credentials to be accepted")
+	}
+
+	request.RemoteAddr = "198.51.100.1:50000"
+	if ok := combined.authenticateClient(httptest.NewRecorder(), request); ok {
+		t.Fatal("expected valid credentials from outside the allowlist to be rejected")
+	}
+}
+
 func TestSupportedUpstream(t *testing.T) {
 	cases := map[string]bool{
-		"http":  true,
-		"HTTP":  true,
-		"https": true,
-		"socks": false,
-		"":      false,
+		"http":   true,
+		"HTTP":   true,
+		"https":  true,
+		"socks4": true,
+		"SOCKS5": true,
+		"socks":  false,
+		"":       false,
 	}
 
 	for protocol, want := range cases {
@@ -177,7 +230,7 @@ func TestHandleConnect_ProxiesDataThroughUpstream(t *testing.T) {
 	}
 
 	originalGetNext := getNextRotatingProxyFunc
-	getNextRotatingProxyFunc = func(userID uint, rotatorID uint64) (*dto.RotatingProxyNext, error) {
+	getNextRotatingProxyFunc = func(userID uint, rotatorID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
 		if userID != 7 || rotatorID != 42 {
 			t.Fatalf("unexpected identifiers: userID=%d rotatorID=%d", userID, rotatorID)
 		}
@@ -336,3 +389,137 @@ func (m *mockHijackResponseWriter) WriteHeader(_ int) {}
 func (m *mockHijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return m.conn, m.buf, nil
 }
+
+func TestServeHTTP_SplicesWebSocketUpgradeThroughUpstream(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stubRotation(t, 1)
+
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Upgrade") != "websocket" || req.URL.String() != "http://example.com/ws" {
+			t.Errorf("unexpected upstream request: %s %v", req.URL, req.Header)
+		}
+
+		proxySide, targetSide := net.Pipe()
+		go func() {
+			defer targetSide.Close()
+			message := make([]byte, 4)
+			if _, err := io.ReadFull(targetSide, message); err != nil {
+				return
+			}
+			_, _ = targetSide.Write([]byte(strings.ToUpper(string(message))))
+		}()
+
+		return &http.Response{
+			Status:     "101 Switching Protocols",
+			StatusCode: http.StatusSwitchingProtocols,
+			Header:     http.Header{"Upgrade": []string{"websocket"}, "Connection": []string{"Upgrade"}},
+			Body:       proxySide,
+		}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	server := httptest.NewServer(newProxyHandler(domain.RotatingProxy{ID: 17, UserID: 4}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial rotator: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The first frame follows the handshake immediately to make sure data
+	// buffered by the server is forwarded after the hijack.
+	request := "GET http://example.com/ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\nping"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("write upgrade request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		t.Fatalf("read upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "websocket" {
+		t.Fatalf("unexpected upgrade response: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(reader, reply); err != nil {
+		t.Fatalf("read spliced reply: %v", err)
+	}
+	if string(reply) != "PING" {
+		t.Fatalf("reply = %q, want PING", reply)
+	}
+}
+
+func TestServeHTTP_RelaysRefusedUpgrade(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stubRotation(t, 1)
+
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("no upgrade"))}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	handler := newProxyHandler(domain.RotatingProxy{ID: 18, UserID: 4})
+	request := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+	request.Header.Set("Connection", "keep-alive, Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest || recorder.Body.String() != "no upgrade" {
+		t.Fatalf("unexpected response: %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestServeHTTP_CountsUnswitchedUpgradesAsRequests(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stubRotation(t, 1)
+
+	var samples []database.RotatingProxyTrafficSample
+	originalRecord := recordTrafficFunc
+	recordTrafficFunc = func(sample database.RotatingProxyTrafficSample) {
+		samples = append(samples, sample)
+	}
+	t.Cleanup(func() { recordTrafficFunc = originalRecord })
+
+	responses := []func() (*http.Response, error){
+		func() (*http.Response, error) { return nil, errors.New("upstream timeout") },
+		func() (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("no upgrade"))}, nil
+		},
+		func() (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusSwitchingProtocols, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	originalRoundTrip := roundTripUpstreamFunc
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	handler := newProxyHandler(domain.RotatingProxy{ID: 19, UserID: 4, FailoverAttempts: 1})
+	for idx, respond := range responses {
+		roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+			return respond()
+		}
+		upstreamCooldowns = newCooldownStore()
+
+		request := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+		request.Header.Set("Connection", "Upgrade")
+		request.Header.Set("Upgrade", "websocket")
+		samples = nil
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		var total database.RotatingProxyTrafficSample
+		for _, sample := range samples {
+			total.Requests += sample.Requests
+			total.Tunnels += sample.Tunnels
+		}
+		if total.Requests != 1 || total.Tunnels != 0 {
+			t.Fatalf("case %d: expected one request and no tunnel, got %+v", idx, samples)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package rotatingproxy
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+// upstreamHeader names the response header that reports which upstream served
+// a request when a rotator exposes it.
+const upstreamHeader = "X-Magpie-Upstream"
+
+// headerPolicy rewrites the headers a rotator forwards so clients do not leak
+// identifying headers to the target. A nil policy leaves requests unchanged.
+type headerPolicy struct {
+	strip          []string
+	set            map[string]string
+	userAgents     []string
+	nextUserAgent  atomic.Uint64
+	exposeUpstream bool
+}
+
+func newHeaderPolicy(rotator domain.RotatingProxy) *headerPolicy {
+	if len(rotator.StripRequestHeaders) == 0 && len(rotator.SetRequestHeaders) == 0 &&
+		len(rotator.UserAgentPool) == 0 && !rotator.ExposeUpstreamHeader {
+		return nil
+	}
+
+	policy := &headerPolicy{
+		strip:          make([]string, 0, len(rotator.StripRequestHeaders)),
+		set:            make(map[string]string, len(rotator.SetRequestHeaders)),
+		userAgents:     rotator.UserAgentPool.Clone(),
+		exposeUpstream: rotator.ExposeUpstreamHeader,
+	}
+	for _, name := range rotator.StripRequestHeaders {
+		policy.strip = append(policy.strip, http.CanonicalHeaderKey(name))
+	}
+	for name, value := range rotator.SetRequestHeaders {
+		policy.set[http.CanonicalHeaderKey(name)] = value
+	}
+	return policy
+}
+
+// applyRequest strips, then sets headers on a request about to be forwarded.
+// A User-Agent pool takes precedence over a fixed User-Agent and is cycled
+// through one request at a time.
+func (p *headerPolicy) applyRequest(header http.Header) {
+	if p == nil {
+		return
+	}
+
+	for _, name := range p.strip {
+		header.Del(name)
+	}
+	for name, value := range p.set {
+		header.Set(name, value)
+	}
+	if len(p.userAgents) > 0 {
+		idx := (p.nextUserAgent.Add(1) - 1) % uint64(len(p.userAgents))
+		header.Set("User-Agent", p.userAgents[idx])
+	}
+}
+
+// applyResponse adds the serving upstream to a response sent to the client.
+func (p *headerPolicy) applyResponse(header http.Header, next *dto.RotatingProxyNext) {
+	if p == nil || !p.exposeUpstream || next == nil {
+		return
+	}
+	header.Set(upstreamHeader, strconv.FormatUint(next.ProxyID, 10))
+}
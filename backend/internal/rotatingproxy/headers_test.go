@@ -0,0 +1,94 @@
+package rotatingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+func TestServeHTTP_AppliesHeaderPolicy(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stubRotation(t, 1)
+
+	var forwarded []http.Header
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		forwarded = append(forwarded, req.Header.Clone())
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	handler := newProxyHandler(domain.RotatingProxy{
+		ID:                  22,
+		UserID:              4,
+		StripRequestHeaders: domain.StringList{"X-Forwarded-For", "Via"},
+		SetRequestHeaders:   domain.StringMap{"Accept-Language": "en-US"},
+		UserAgentPool:       domain.StringList{"agent-a", "agent-b"},
+	})
+
+	for i := 0; i < 3; i++ {
+		request := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		request.Header.Set("X-Forwarded-For", "192.0.2.10")
+		request.Header.Set("Via", "1.1 client-cache")
+		request.Header.Set("Accept-Language", "de-DE")
+		request.Header.Set("User-Agent", "client-agent")
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, recorder.Code)
+		}
+		if recorder.Header().Get(upstreamHeader) != "" {
+			t.Fatalf("request %d: upstream header exposed without being enabled", i)
+		}
+	}
+
+	wantAgents := []string{"agent-a", "agent-b", "agent-a"}
+	for i, header := range forwarded {
+		if header.Get("X-Forwarded-For") != "" || header.Get("Via") != "" {
+			t.Fatalf("request %d: stripped headers were forwarded: %v", i, header)
+		}
+		if got := header.Get("Accept-Language"); got != "en-US" {
+			t.Fatalf("request %d: Accept-Language = %q, want en-US", i, got)
+		}
+		if got := header.Get("User-Agent"); got != wantAgents[i] {
+			t.Fatalf("request %d: User-Agent = %q, want %q", i, got, wantAgents[i])
+		}
+	}
+}
+
+func TestServeHTTP_ExposesServingUpstream(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stubRotation(t, 1)
+	stubRoundTripOK(t)
+
+	handler := newProxyHandler(domain.RotatingProxy{ID: 23, UserID: 4, ExposeUpstreamHeader: true})
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	if got := recorder.Header().Get(upstreamHeader); got != "1" {
+		t.Fatalf("%s = %q, want 1", upstreamHeader, got)
+	}
+}
+
+func TestConnectEstablished_IncludesUpstreamWhenExposed(t *testing.T) {
+	next := &dto.RotatingProxyNext{ProxyID: 42}
+
+	plain := newProxyHandler(domain.RotatingProxy{ID: 24})
+	if got := string(plain.connectEstablished(next)); got != connectEstablishedResponse {
+		t.Fatalf("unexpected response without exposure: %q", got)
+	}
+
+	exposed := newProxyHandler(domain.RotatingProxy{ID: 24, ExposeUpstreamHeader: true})
+	want := connectEstablishedStatus + upstreamHeader + ": 42\r\n\r\n"
+	if got := string(exposed.connectEstablished(next)); got != want {
+		t.Fatalf("connectEstablished = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package rotatingproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"magpie/internal/domain"
+)
+
+var errUpstreamBusy = errors.New("upstream proxy is at its connection limit")
+
+// admissionControl enforces a rotator's rate, concurrency and bandwidth
+// limits. A nil *admissionControl admits everything.
+type admissionControl struct {
+	requests  *tokenBucket
+	bandwidth *tokenBucket
+
+	mu                sync.Mutex
+	maxActive         uint32
+	active            uint32
+	maxPerUpstream    uint32
+	activePerUpstream map[uint64]uint32
+}
+
+func newAdmissionControl(rotator domain.RotatingProxy) *admissionControl {
+	limits := &admissionControl{
+		maxActive:         rotator.MaxConcurrentConnections,
+		maxPerUpstream:    rotator.MaxConnectionsPerUpstream,
+		activePerUpstream: make(map[uint64]uint32),
+	}
+	if rotator.RateLimitPerSecond > 0 {
+		rate := float64(rotator.RateLimitPerSecond)
+		limits.requests = newTokenBucket(rate, rate)
+	}
+	if rotator.MaxBandwidthKBPerSecond > 0 {
+		rate := float64(rotator.MaxBandwidthKBPerSecond) * 1024
+		limits.bandwidth = newTokenBucket(rate, rate)
+	}
+	return limits
+}
+
+// sameLimits reports whether two rotator configurations enforce the same
+// limits.
+func sameLimits(a, b domain.RotatingProxy) bool {
+	return a.RateLimitPerSecond == b.RateLimitPerSecond &&
+		a.MaxConcurrentConnections == b.MaxConcurrentConnections &&
+		a.MaxConnectionsPerUpstream == b.MaxConnectionsPerUpstream &&
+		a.MaxBandwidthKBPerSecond == b.MaxBandwidthKBPerSecond
+}
+
+// allowRequest reports whether another request fits in the rate limit.
+func (a *admissionControl) allowRequest() bool {
+	if a == nil || a.requests == nil {
+		return true
+	}
+	return a.requests.tryTake(1)
+}
+
+func (a *admissionControl) acquireConnection() bool {
+	if a == nil || a.maxActive == 0 {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active >= a.maxActive {
+		return false
+	}
+	a.active++
+	return true
+}
+
+func (a *admissionControl) releaseConnection() {
+	if a == nil || a.maxActive == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active > 0 {
+		a.active--
+	}
+}
+
+func (a *admissionControl) acquireUpstream(proxyID uint64) bool {
+	if a == nil || a.maxPerUpstream == 0 {
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.activePerUpstream[proxyID] >= a.maxPerUpstream {
+		return false
+	}
+	a.activePerUpstream[proxyID]++
+	return true
+}
+
+func (a *admissionControl) releaseUpstream(proxyID uint64) {
+	if a == nil || a.maxPerUpstream == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch count := a.activePerUpstream[proxyID]; count {
+	case 0:
+	case 1:
+		delete(a.activePerUpstream, proxyID)
+	default:
+		a.activePerUpstream[proxyID] = count - 1
+	}
+}
+
+// throttleConn applies the rotator's bandwidth limit to reads and writes on conn.
+func (a *admissionControl) throttleConn(conn net.Conn) net.Conn {
+	if a == nil || a.bandwidth == nil {
+		return conn
+	}
+	return &throttledConn{Conn: conn, bucket: a.bandwidth}
+}
+
+// throttleReader applies the rotator's bandwidth limit to reads from r.
+func (a *admissionControl) throttleReader(r io.Reader) io.Reader {
+	if a == nil || a.bandwidth == nil {
+		return r
+	}
+	return &throttledReader{reader: r, bucket: a.bandwidth}
+}
+
+type throttledConn struct {
+	net.Conn
+	bucket *tokenBucket
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		time.Sleep(c.bucket.take(n))
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	time.Sleep(c.bucket.take(len(p)))
+	return c.Conn.Write(p)
+}
+
+type throttledReader struct {
+	reader io.Reader
+	bucket *tokenBucket
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		time.Sleep(r.bucket.take(n))
+	}
+	return n, err
+}
+
+// tokenBucket is a minimal token bucket that lets callers go into debt and
+// tells them how long to wait until the debt is paid off.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// tryTake consumes n tokens only if they are available right now.
+func (b *tokenBucket) tryTake(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked(time.Now())
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// take consumes n tokens and returns how long the caller must wait for them
+// to be available. A zero duration means the tokens were available.
+func (b *tokenBucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refillLocked(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
@@ -0,0 +1,139 @@
+package rotatingproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/domain"
+)
+
+func stubRoundTripOK(t *testing.T) {
+	t.Helper()
+
+	original := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = original })
+}
+
+func TestServeHTTP_RateLimitReturns429(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := newProxyHandler(domain.RotatingProxy{ID: 21, UserID: 4, RateLimitPerSecond: 2})
+	stubRotation(t, 1)
+	stubRoundTripOK(t)
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		codes = append(codes, recorder.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status codes %v", codes)
+	}
+}
+
+func TestServeHTTP_ConnectionCapReturns503(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := newProxyHandler(domain.RotatingProxy{ID: 22, UserID: 4, MaxConcurrentConnections: 1})
+	stubRotation(t, 1)
+	stubRoundTripOK(t)
+
+	if !handler.limits.acquireConnection() {
+		t.Fatal("expected first connection to be admitted")
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", recorder.Code)
+	}
+
+	handler.limits.releaseConnection()
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status after release = %d, want 200", recorder.Code)
+	}
+}
+
+func TestWithFailover_SkipsUpstreamsAtCapacity(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := newProxyHandler(domain.RotatingProxy{ID: 23, UserID: 4, MaxConnectionsPerUpstream: 1})
+	stubRotation(t, 2)
+
+	if !handler.limits.acquireUpstream(1) {
+		t.Fatal("expected to reserve upstream 1")
+	}
+
+	next, err := handler.withFailover(context.Background(), clientParams{}, func(next *dto.RotatingProxyNext, deadline time.Time) error { return nil })
+	if err != nil {
+		t.Fatalf("withFailover: %v", err)
+	}
+	if next.ProxyID != 2 {
+		t.Fatalf("expected busy upstream to be skipped, got %d", next.ProxyID)
+	}
+	if cooling := upstreamCooldowns.active(handler.rotator.ID); len(cooling) != 0 {
+		t.Fatalf("busy upstreams must not be put on cooldown, got %v", cooling)
+	}
+
+	if _, err := handler.withFailover(context.Background(), clientParams{}, func(next *dto.RotatingProxyNext, deadline time.Time) error { return nil }); !errors.Is(err, errUpstreamBusy) {
+		t.Fatalf("expected errUpstreamBusy once every upstream is full, got %v", err)
+	}
+}
+
+func TestServeHTTP_ThrottlesRequestBodies(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	handler := newProxyHandler(domain.RotatingProxy{ID: 24, UserID: 4, MaxBandwidthKBPerSecond: 1})
+	stubRotation(t, 1)
+
+	var uploaded int
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		payload, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		uploaded = len(payload)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+	}
+	t.Cleanup(func() { roundTripUpstreamFunc = originalRoundTrip })
+
+	// The body exceeds the 1 KiB burst by half, so sending it waits ~500ms.
+	body := strings.Repeat("x", 1536)
+	started := time.Now()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusOK || uploaded != len(body) {
+		t.Fatalf("unexpected upload result: status %d, %d bytes", recorder.Code, uploaded)
+	}
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond {
+		t.Fatalf("expected the upload to be throttled, took %v", elapsed)
+	}
+}
+
+func TestTokenBucket_TakeReportsWaitWhenExhausted(t *testing.T) {
+	bucket := newTokenBucket(1000, 1000)
+
+	if wait := bucket.take(1000); wait != 0 {
+		t.Fatalf("expected burst to be available immediately, got wait %v", wait)
+	}
+	wait := bucket.take(500)
+	if wait < 400*time.Millisecond || wait > 600*time.Millisecond {
+		t.Fatalf("expected roughly 500ms wait, got %v", wait)
+	}
+	if bucket.tryTake(1) {
+		t.Fatal("tryTake must fail while the bucket is in debt")
+	}
+}
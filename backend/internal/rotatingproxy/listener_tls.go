@@ -0,0 +1,49 @@
+package rotatingproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"github.com/charmbracelet/log"
+
+	"magpie/internal/database"
+	"magpie/internal/domain"
+)
+
+var errListenerCertificateMissing = errors.New("rotator has no usable tls certificate")
+
+func loadListenerCertificate(rotator domain.RotatingProxy) *tls.Certificate {
+	if database.NormalizeRotatorListenProtocol(rotator.ListenProtocol) != database.RotatingProxyListenHTTPS {
+		return nil
+	}
+
+	certificate, err := tls.X509KeyPair([]byte(rotator.TLSCertificate), []byte(rotator.TLSPrivateKey))
+	if err != nil {
+		log.Error("rotating proxy: invalid tls certificate", "rotator_id", rotator.ID, "error", err)
+		return nil
+	}
+	return &certificate
+}
+
+// newTLSListener wraps the listener of an https rotator. The certificate is
+// looked up per handshake so a reconfigured rotator serves its new certificate
+// without restarting the listener. Only HTTP/1.1 is offered because CONNECT
+// and upgrades hijack the client connection.
+func (ps *proxyServer) newTLSListener(listener net.Listener) (net.Listener, error) {
+	if ps.currentHandler().certificate == nil {
+		return nil, errListenerCertificateMissing
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if certificate := ps.currentHandler().certificate; certificate != nil {
+				return certificate, nil
+			}
+			return nil, errListenerCertificateMissing
+		},
+	}
+	return tls.NewListener(listener, config), nil
+}
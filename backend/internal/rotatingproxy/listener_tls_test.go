@@ -0,0 +1,76 @@
+package rotatingproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"magpie/internal/database"
+	"magpie/internal/domain"
+	"magpie/internal/security"
+)
+
+func TestProxyServer_ServesHTTPSListener(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stubRotation(t, 1)
+	stubRoundTripOK(t)
+
+	certPEM, keyPEM, err := security.GenerateSelfSignedCertificate("rotator", []string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+
+	server := newProxyServer(domain.RotatingProxy{
+		ID:             41,
+		UserID:         4,
+		ListenProtocol: database.RotatingProxyListenHTTPS,
+		TLSCertificate: certPEM,
+		TLSPrivateKey:  keyPEM,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("start https rotator: %v", err)
+	}
+	defer server.Stop()
+
+	_, port, err := net.SplitHostPort(server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("listener address: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(certPEM)) {
+		t.Fatal("failed to add certificate to pool")
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "https", Host: net.JoinHostPort("127.0.0.1", port)}),
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("request through https rotator: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestProxyServer_HTTPSListenerRequiresCertificate(t *testing.T) {
+	server := newProxyServer(domain.RotatingProxy{ID: 42, ListenProtocol: database.RotatingProxyListenHTTPS})
+	if err := server.Start(); err != errListenerCertificateMissing {
+		server.Stop()
+		t.Fatalf("expected errListenerCertificateMissing, got %v", err)
+	}
+}
@@ -2,14 +2,17 @@ package rotatingproxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/log"
 
+	"magpie/internal/api/dto"
 	"magpie/internal/database"
 	"magpie/internal/domain"
 	"magpie/internal/support"
@@ -18,18 +21,39 @@ import (
 type Manager struct {
 	mu      sync.RWMutex
 	servers map[uint64]*proxyServer
+
+	placementOnce sync.Once
+	placementKick chan struct{}
+	// orphans holds the rotators no instance claimed in the previous
+	// placement round. Only the placement loop touches it.
+	orphans map[uint64]struct{}
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		servers: make(map[uint64]*proxyServer),
+		servers:       make(map[uint64]*proxyServer),
+		placementKick: make(chan struct{}, 1),
 	}
 }
 
 var GlobalManager = NewManager()
 
+// StartAll starts the rotator listeners this instance is responsible for.
+// Without redis that is every rotator; with redis the placement loop spreads
+// them across instances and keeps following changes.
 func (m *Manager) StartAll() {
-	start, end := support.GetRotatingProxyPortRange()
+	if placementEnabled() {
+		m.placementOnce.Do(func() {
+			redisSyncState.mu.RLock()
+			client, ctx := redisSyncState.client, redisSyncState.ctx
+			redisSyncState.mu.RUnlock()
+
+			go m.subscribeToRotatorEvents(ctx, client)
+			go m.runPlacement(ctx)
+		})
+		return
+	}
+
 	rotators, err := database.GetAllRotatingProxies()
 	if err != nil {
 		log.Error("rotating proxy manager: failed to load rotators", "error", err)
@@ -37,7 +61,7 @@ func (m *Manager) StartAll() {
 	}
 
 	for _, rotator := range rotators {
-		if rotator.ListenPort == 0 || int(rotator.ListenPort) < start || int(rotator.ListenPort) > end {
+		if !listenPortInRange(rotator.ListenPort) {
 			log.Warn("rotating proxy manager: skipping rotator without valid port", "rotator_id", rotator.ID, "listen_port", rotator.ListenPort)
 			continue
 		}
@@ -47,9 +71,13 @@ func (m *Manager) StartAll() {
 	}
 }
 
-func (m *Manager) startServer(rotator domain.RotatingProxy) error {
+func listenPortInRange(port uint16) bool {
 	start, end := support.GetRotatingProxyPortRange()
-	if rotator.ListenPort == 0 || int(rotator.ListenPort) < start || int(rotator.ListenPort) > end {
+	return port != 0 && int(port) >= start && int(port) <= end
+}
+
+func (m *Manager) startServer(rotator domain.RotatingProxy) error {
+	if !listenPortInRange(rotator.ListenPort) {
 		return fmt.Errorf("invalid listen port %d for rotator %d", rotator.ListenPort, rotator.ID)
 	}
 	m.mu.Lock()
@@ -66,49 +94,176 @@ func (m *Manager) startServer(rotator domain.RotatingProxy) error {
 	}
 
 	m.servers[rotator.ID] = server
-	log.Info("rotating proxy server started", "rotator_id", rotator.ID, "port", rotator.ListenPort)
+	log.Info("rotating proxy server started", "rotator_id", rotator.ID, "port", rotator.ListenPort, "listen_protocol", database.NormalizeRotatorListenProtocol(rotator.ListenProtocol))
 	return nil
 }
 
+// stopServer stops the rotator's listener if it runs on this instance and
+// reports whether it did.
+func (m *Manager) stopServer(rotatorID uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	server, ok := m.servers[rotatorID]
+	if !ok {
+		return false
+	}
+	server.Stop()
+	delete(m.servers, rotatorID)
+	upstreamCooldowns.forget(rotatorID)
+	log.Info("rotating proxy server stopped", "rotator_id", rotatorID)
+	return true
+}
+
+func (m *Manager) hosts(rotatorID uint64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.servers[rotatorID]
+	return ok
+}
+
+func (m *Manager) hostedIDs() []uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]uint64, 0, len(m.servers))
+	for id := range m.servers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Add starts a newly created rotator. With redis the creating instance claims
+// it and tells the other instances once it listens; a rotator it could not
+// claim is left to the next placement round.
 func (m *Manager) Add(rotatorID uint64) error {
 	rotator, err := database.GetRotatingProxyByID(rotatorID)
 	if err != nil {
 		return err
 	}
-	return m.startServer(*rotator)
+
+	if !placementEnabled() {
+		return m.startServer(*rotator)
+	}
+
+	claimed, err := claimRotator(rotatorID)
+	if err != nil {
+		log.Warn("rotating proxy placement: failed to claim new rotator", "rotator_id", rotatorID, "error", err)
+		m.requestPlacement()
+		return nil
+	}
+	if !claimed {
+		m.requestPlacement()
+		return nil
+	}
+
+	if err := m.startServer(*rotator); err != nil {
+		releaseRotator(rotatorID)
+		return err
+	}
+	publishRotatorEvent(rotatorEventAdded, rotatorID)
+	return nil
 }
 
-func (m *Manager) Remove(rotatorID uint64) {
+// Reload applies a rotator's stored configuration to its running server. New
+// requests and connections use the updated settings while established tunnels
+// finish on the configuration they started with. The server is only restarted
+// when its listener itself has to change. With redis the instance hosting the
+// rotator is told to reload it.
+func (m *Manager) Reload(rotatorID uint64) error {
+	rotator, err := database.GetRotatingProxyByID(rotatorID)
+	if err != nil {
+		return err
+	}
+
+	if placementEnabled() {
+		defer publishRotatorEvent(rotatorEventUpdated, rotatorID)
+		if !m.hosts(rotatorID) {
+			return nil
+		}
+	}
+
+	return m.reloadLocal(*rotator)
+}
+
+func (m *Manager) reloadLocal(rotator domain.RotatingProxy) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	server, ok := m.servers[rotator.ID]
+	if ok && server.servesListener(rotator) {
+		server.reconfigure(rotator)
+		m.mu.Unlock()
+		log.Info("rotating proxy server reconfigured", "rotator_id", rotator.ID)
+		return nil
+	}
+	m.mu.Unlock()
 
-	server, ok := m.servers[rotatorID]
-	if !ok {
-		return
+	return m.startServer(rotator)
+}
+
+// RotateNow moves a rotator onto another upstream right away. With redis the
+// instance hosting the rotator is told to switch to the same upstream.
+func (m *Manager) RotateNow(userID uint, rotatorID uint64) (*dto.RotatingProxyNext, error) {
+	next, err := database.RotateRotatingProxyNow(userID, rotatorID)
+	if err != nil {
+		return nil, err
 	}
-	server.Stop()
-	delete(m.servers, rotatorID)
-	log.Info("rotating proxy server stopped", "rotator_id", rotatorID)
+
+	if placementEnabled() && !m.hosts(rotatorID) {
+		publishEvent(rotatorEvent{Action: rotatorEventRotated, RotatorID: rotatorID, ProxyID: next.ProxyID})
+	}
+	return next, nil
+}
+
+// Remove stops a deleted rotator wherever it runs.
+func (m *Manager) Remove(rotatorID uint64) {
+	if m.stopServer(rotatorID) {
+		releaseRotator(rotatorID)
+	}
+	publishRotatorEvent(rotatorEventRemoved, rotatorID)
 }
 
 func (m *Manager) StopAll() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	for id, server := range m.servers {
-		server.Stop()
-		delete(m.servers, id)
+	for _, rotatorID := range m.hostedIDs() {
+		if m.stopServer(rotatorID) {
+			releaseRotator(rotatorID)
+		}
 	}
 }
 
+// proxyServer owns a rotator's listener. rotator holds the settings the
+// listener was started with; the live configuration is the current handler.
 type proxyServer struct {
 	rotator    domain.RotatingProxy
+	handler    atomic.Pointer[proxyHandler]
 	listener   net.Listener
 	httpServer *http.Server
 	closeOnce  sync.Once
 }
 
 func newProxyServer(rotator domain.RotatingProxy) *proxyServer {
-	return &proxyServer{rotator: rotator}
+	ps := &proxyServer{rotator: rotator}
+	ps.handler.Store(newProxyHandler(rotator))
+	return ps
+}
+
+func (ps *proxyServer) currentHandler() *proxyHandler {
+	return ps.handler.Load()
+}
+
+// ServeHTTP dispatches each request to the handler that is current when it
+// arrives.
+func (ps *proxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.currentHandler().ServeHTTP(w, r)
+}
+
+// servesListener reports whether the server's listener matches the rotator's
+// listen settings, i.e. whether it can be reconfigured in place.
+func (ps *proxyServer) servesListener(rotator domain.RotatingProxy) bool {
+	return ps.rotator.ListenPort == rotator.ListenPort &&
+		database.NormalizeRotatorListenProtocol(ps.rotator.ListenProtocol) == database.NormalizeRotatorListenProtocol(rotator.ListenProtocol)
+}
+
+func (ps *proxyServer) reconfigure(rotator domain.RotatingProxy) {
+	ps.handler.Store(ps.currentHandler().reconfigured(rotator))
 }
 
 func (ps *proxyServer) Start() error {
@@ -118,15 +273,36 @@ func (ps *proxyServer) Start() error {
 		return err
 	}
 
-	handler := &proxyHandler{rotator: ps.rotator}
+	listenProtocol := database.NormalizeRotatorListenProtocol(ps.rotator.ListenProtocol)
+	if listenProtocol == database.RotatingProxyListenHTTPS {
+		tlsListener, err := ps.newTLSListener(listener)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = tlsListener
+	}
+
+	ps.listener = listener
+
+	if listenProtocol == database.RotatingProxyListenSOCKS5 {
+		go func() {
+			if err := serveSOCKS5(listener, ps.currentHandler); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Error("rotating proxy server: serve error", "rotator_id", ps.rotator.ID, "error", err)
+			}
+		}()
+		return nil
+	}
+
+	// Bodies are streamed end to end, so only the header read and idle
+	// keep-alive connections are bounded; whole-request timeouts would cut
+	// off large uploads and downloads.
 	server := &http.Server{
-		Handler:           handler,
-		ReadTimeout:       30 * time.Second,
-		WriteTimeout:      30 * time.Second,
+		Handler:           ps,
 		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       upstreamTransportIdleTimeout,
 	}
 
-	ps.listener = listener
 	ps.httpServer = server
 
 	go func() {
@@ -138,6 +314,7 @@ func (ps *proxyServer) Start() error {
 	return nil
 }
 
+// Stop closes the listener and every tunnel still relayed through it.
 func (ps *proxyServer) Stop() {
 	ps.closeOnce.Do(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -150,5 +327,6 @@ func (ps *proxyServer) Stop() {
 		if ps.listener != nil {
 			_ = ps.listener.Close()
 		}
+		ps.currentHandler().tunnels.closeAll()
 	})
 }
@@ -0,0 +1,107 @@
+package rotatingproxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/database"
+	"magpie/internal/domain"
+)
+
+func TestProxyServerReconfigure_AppliesToNewRequestsOnly(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stubRotation(t, 1)
+	stubRoundTripOK(t)
+
+	rotator := domain.RotatingProxy{ID: 31, UserID: 4, ListenPort: 20031, MaxConcurrentConnections: 5}
+	server := newProxyServer(rotator)
+	previous := server.currentHandler()
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status before reconfigure = %d, want 200", recorder.Code)
+	}
+
+	updated := rotator
+	updated.AuthRequired = true
+	updated.AuthUsername = "client"
+	updated.AuthPassword = "secret"
+	if !server.servesListener(updated) {
+		t.Fatal("expected auth changes to be applied in place")
+	}
+	server.reconfigure(updated)
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if recorder.Code != http.StatusProxyAuthRequired {
+		t.Fatalf("status after reconfigure = %d, want 407", recorder.Code)
+	}
+
+	if previous.rotator.AuthRequired {
+		t.Fatal("handlers serving established connections must keep their configuration")
+	}
+	if server.currentHandler().limits != previous.limits {
+		t.Fatal("expected admission state to be carried over when limits are unchanged")
+	}
+
+	updated.ListenProtocol = "socks5"
+	if server.servesListener(updated) {
+		t.Fatal("expected a listen protocol change to require a restart")
+	}
+}
+
+func TestProxyServerStop_ClosesOpenTunnels(t *testing.T) {
+	var recorded []database.RotatingProxyTrafficSample
+	originalRecord := recordTrafficFunc
+	recordTrafficFunc = func(sample database.RotatingProxyTrafficSample) {
+		recorded = append(recorded, sample)
+	}
+	t.Cleanup(func() { recordTrafficFunc = originalRecord })
+
+	server := newProxyServer(domain.RotatingProxy{ID: 32, UserID: 4, ListenPort: 20032})
+	server.reconfigure(domain.RotatingProxy{ID: 32, UserID: 4, ListenPort: 20032, AuthRequired: true})
+	handler := server.currentHandler()
+
+	clientConn, clientPeer := net.Pipe()
+	upConn, upPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer upPeer.Close()
+
+	done := make(chan struct{})
+	go func() {
+		handler.tunnel(clientConn, upConn, &dto.RotatingProxyNext{ProxyID: 7})
+		close(done)
+	}()
+
+	// Wait for the tunnel to be relaying before stopping the server.
+	go func() { _, _ = clientPeer.Write([]byte("ping")) }()
+	buf := make([]byte, 4)
+	if _, err := upPeer.Read(buf); err != nil {
+		t.Fatalf("read through tunnel: %v", err)
+	}
+
+	server.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected stopping the server to close its open tunnels")
+	}
+	if len(recorded) != 1 || recorded[0].RotatingProxyID != 32 || recorded[0].BytesOut != 4 {
+		t.Fatalf("unexpected recorded traffic %+v", recorded)
+	}
+
+	late, lateServer := net.Pipe()
+	lateUp, lateUpstream := net.Pipe()
+	defer lateServer.Close()
+	defer lateUpstream.Close()
+	handler.tunnel(late, lateUp, &dto.RotatingProxyNext{ProxyID: 7})
+	if _, err := lateServer.Write([]byte("x")); err == nil {
+		t.Fatal("expected tunnels opened after stop to be closed right away")
+	}
+}
@@ -0,0 +1,429 @@
+package rotatingproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/redis/go-redis/v9"
+
+	"magpie/internal/config"
+	"magpie/internal/database"
+	"magpie/internal/domain"
+	"magpie/internal/support"
+)
+
+// With redis enabled every rotator listener is hosted by exactly one backend
+// instance. Ownership is a lock per rotator that the hosting instance renews;
+// instances claim unowned rotators up to their fair share, and pick up any
+// rotator left orphaned for a full placement round regardless of share.
+// Instances taking part are kept in a sorted set scored by their last
+// heartbeat.
+const (
+	rotatorOwnerKeyPrefix    = "magpie:rotator:owner:"
+	rotatorInstanceKeyPrefix = "magpie:rotator:instance:"
+	rotatorInstancesKey      = "magpie:rotator:instances"
+	rotatorEventsChannel     = "magpie:rotator:events"
+	rotatorOwnershipTTL      = 45 * time.Second
+	rotatorPlacementInterval = 15 * time.Second
+)
+
+const (
+	rotatorEventAdded   = "added"
+	rotatorEventUpdated = "updated"
+	rotatorEventRemoved = "removed"
+	rotatorEventRotated = "rotated"
+)
+
+// rotatorEvent tells other instances about a rotator change. ProxyID is the
+// upstream a manual rotation moved to.
+type rotatorEvent struct {
+	Action    string `json:"action"`
+	RotatorID uint64 `json:"rotator_id"`
+	ProxyID   uint64 `json:"proxy_id,omitempty"`
+	Origin    string `json:"origin"`
+}
+
+// RotatorPlacement describes which backend instance hosts a rotator's
+// listener. Host is the public address that instance advertises, if known.
+type RotatorPlacement struct {
+	Instance string
+	Host     string
+}
+
+func placementEnabled() bool {
+	redisSyncState.mu.RLock()
+	defer redisSyncState.mu.RUnlock()
+	return redisSyncState.client != nil
+}
+
+func rotatorOwnerKey(rotatorID uint64) string {
+	return rotatorOwnerKeyPrefix + strconv.FormatUint(rotatorID, 10)
+}
+
+// claimRotator takes ownership of an unowned rotator for this instance.
+func claimRotator(rotatorID uint64) (bool, error) {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return true, nil
+	}
+
+	return client.SetNX(ctx, rotatorOwnerKey(rotatorID), support.InstanceID(), rotatorOwnershipTTL).Result()
+}
+
+// renewRotator extends this instance's ownership of a rotator. It reports
+// false when another instance owns the rotator by now.
+func renewRotator(rotatorID uint64) (bool, error) {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return true, nil
+	}
+
+	return support.RenewLock(ctx, client, rotatorOwnerKey(rotatorID), support.InstanceID(), rotatorOwnershipTTL)
+}
+
+func releaseRotator(rotatorID uint64) {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return
+	}
+
+	if err := support.ReleaseLock(ctx, client, rotatorOwnerKey(rotatorID), support.InstanceID()); err != nil {
+		log.Warn("rotating proxy placement: failed to release rotator", "rotator_id", rotatorID, "error", err)
+	}
+}
+
+// advertiseInstance marks this instance as taking part in placement and
+// records the address clients reach it on, so other instances can report where
+// a rotator listens.
+func advertiseInstance() {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return
+	}
+
+	host := strings.TrimSpace(config.GetCurrentIp())
+	pipe := client.Pipeline()
+	pipe.Set(ctx, rotatorInstanceKeyPrefix+support.InstanceID(), host, rotatorOwnershipTTL)
+	pipe.ZAdd(ctx, rotatorInstancesKey, redis.Z{
+		Score:  float64(time.Now().UnixMilli()),
+		Member: support.InstanceID(),
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Warn("rotating proxy placement: failed to advertise instance", "error", err)
+	}
+}
+
+// activeInstanceCount returns the number of instances that advertised
+// themselves within the ownership TTL, dropping the ones that stopped.
+func activeInstanceCount() int {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return 1
+	}
+
+	stale := time.Now().Add(-rotatorOwnershipTTL).UnixMilli()
+	pipe := client.Pipeline()
+	pipe.ZRemRangeByScore(ctx, rotatorInstancesKey, "-inf", strconv.FormatInt(stale, 10))
+	count := pipe.ZCard(ctx, rotatorInstancesKey)
+	if _, err := pipe.Exec(ctx); err != nil || count.Val() == 0 {
+		return 1
+	}
+	return int(count.Val())
+}
+
+// placementQuota is the number of rotators one instance hosts when they are
+// spread evenly.
+func placementQuota(rotators, instances int) int {
+	if instances < 1 {
+		instances = 1
+	}
+	return (rotators + instances - 1) / instances
+}
+
+func publishRotatorEvent(action string, rotatorID uint64) {
+	publishEvent(rotatorEvent{Action: action, RotatorID: rotatorID})
+}
+
+func publishEvent(event rotatorEvent) {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return
+	}
+
+	event.Origin = support.InstanceID()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Error("rotating proxy placement: failed to encode event", "error", err)
+		return
+	}
+
+	if err := client.Publish(ctx, rotatorEventsChannel, payload).Err(); err != nil {
+		log.Warn("rotating proxy placement: failed to publish event", "action", event.Action, "rotator_id", event.RotatorID, "error", err)
+	}
+}
+
+func (m *Manager) subscribeToRotatorEvents(ctx context.Context, client *redis.Client) {
+	pubsub := client.Subscribe(ctx, rotatorEventsChannel)
+	defer pubsub.Close()
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, redis.ErrClosed) || ctx.Err() != nil {
+				return
+			}
+			log.Error("rotating proxy placement: subscription error", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var event rotatorEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			log.Error("rotating proxy placement: invalid event payload", "error", err)
+			continue
+		}
+
+		m.handleEvent(event)
+	}
+}
+
+// handleEvent applies a change made on another instance.
+func (m *Manager) handleEvent(event rotatorEvent) {
+	if event.RotatorID == 0 || event.Origin == support.InstanceID() {
+		return
+	}
+
+	switch event.Action {
+	case rotatorEventAdded:
+		m.requestPlacement()
+	case rotatorEventUpdated:
+		if !m.hosts(event.RotatorID) {
+			return
+		}
+		database.ReloadRotatingProxyPool(event.RotatorID)
+		rotator, err := database.GetRotatingProxyByID(event.RotatorID)
+		if err != nil {
+			log.Error("rotating proxy placement: failed to load updated rotator", "rotator_id", event.RotatorID, "error", err)
+			return
+		}
+		if err := m.reloadLocal(*rotator); err != nil {
+			log.Error("rotating proxy placement: failed to reload rotator", "rotator_id", event.RotatorID, "error", err)
+		}
+	case rotatorEventRotated:
+		if m.hosts(event.RotatorID) && event.ProxyID != 0 {
+			database.ApplyRotatingProxyRotation(event.RotatorID, event.ProxyID)
+		}
+	case rotatorEventRemoved:
+		database.ForgetRotatingProxyPool(event.RotatorID)
+		database.ForgetRotatingProxyTraffic(event.RotatorID)
+		if m.stopServer(event.RotatorID) {
+			releaseRotator(event.RotatorID)
+		}
+	}
+}
+
+func (m *Manager) requestPlacement() {
+	select {
+	case m.placementKick <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) runPlacement(ctx context.Context) {
+	m.reconcile()
+
+	ticker := time.NewTicker(rotatorPlacementInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.placementKick:
+		}
+		m.reconcile()
+	}
+}
+
+// reconcile renews the rotators this instance hosts, stops the ones it lost or
+// that were deleted, and claims unowned ones. It only runs on the placement
+// loop.
+func (m *Manager) reconcile() {
+	rotators, err := database.GetAllRotatingProxies()
+	if err != nil {
+		log.Error("rotating proxy placement: failed to load rotators", "error", err)
+		return
+	}
+
+	advertiseInstance()
+
+	known := make(map[uint64]struct{}, len(rotators))
+	for _, rotator := range rotators {
+		known[rotator.ID] = struct{}{}
+	}
+
+	for _, rotatorID := range m.hostedIDs() {
+		if _, ok := known[rotatorID]; !ok {
+			m.stopServer(rotatorID)
+			releaseRotator(rotatorID)
+			continue
+		}
+
+		held, err := renewRotator(rotatorID)
+		if err != nil {
+			// Keep serving through a redis hiccup; the lock outlives a few
+			// missed renewals.
+			log.Warn("rotating proxy placement: failed to renew rotator", "rotator_id", rotatorID, "error", err)
+			continue
+		}
+		if !held {
+			log.Warn("rotating proxy placement: rotator is owned by another instance", "rotator_id", rotatorID)
+			m.stopServer(rotatorID)
+		}
+	}
+
+	quota := placementQuota(len(rotators), activeInstanceCount())
+	hosted := len(m.hostedIDs())
+	orphans := make(map[uint64]struct{})
+
+	for _, rotator := range rotators {
+		if m.hosts(rotator.ID) || !listenPortInRange(rotator.ListenPort) {
+			continue
+		}
+
+		_, wasOrphaned := m.orphans[rotator.ID]
+		if hosted >= quota && !wasOrphaned {
+			if m.ownerOf(rotator.ID) == "" {
+				orphans[rotator.ID] = struct{}{}
+			}
+			continue
+		}
+
+		if m.claimAndStart(rotator) {
+			hosted++
+		}
+	}
+
+	m.orphans = orphans
+}
+
+// claimAndStart starts a rotator's listener here if this instance can claim
+// it.
+func (m *Manager) claimAndStart(rotator domain.RotatingProxy) bool {
+	claimed, err := claimRotator(rotator.ID)
+	if err != nil {
+		log.Warn("rotating proxy placement: failed to claim rotator", "rotator_id", rotator.ID, "error", err)
+		return false
+	}
+	if !claimed {
+		return false
+	}
+
+	if err := m.startServer(rotator); err != nil {
+		log.Error("rotating proxy manager: failed to start server", "rotator_id", rotator.ID, "port", rotator.ListenPort, "error", err)
+		releaseRotator(rotator.ID)
+		return false
+	}
+	return true
+}
+
+func (m *Manager) ownerOf(rotatorID uint64) string {
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return ""
+	}
+
+	owner, err := client.Get(ctx, rotatorOwnerKey(rotatorID)).Result()
+	if err != nil {
+		return ""
+	}
+	return owner
+}
+
+// Placements reports which instance hosts each of the given rotators.
+// Rotators that are not running anywhere are left out.
+func (m *Manager) Placements(rotatorIDs []uint64) map[uint64]RotatorPlacement {
+	result := make(map[uint64]RotatorPlacement, len(rotatorIDs))
+	if len(rotatorIDs) == 0 {
+		return result
+	}
+
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+
+	if client == nil {
+		for _, rotatorID := range rotatorIDs {
+			if m.hosts(rotatorID) {
+				result[rotatorID] = RotatorPlacement{Instance: support.InstanceID()}
+			}
+		}
+		return result
+	}
+
+	keys := make([]string, len(rotatorIDs))
+	for idx, rotatorID := range rotatorIDs {
+		keys[idx] = rotatorOwnerKey(rotatorID)
+	}
+
+	owners, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Warn("rotating proxy placement: failed to load owners", "error", err)
+		return result
+	}
+
+	instances := make([]string, 0)
+	seen := make(map[string]struct{})
+	for idx, raw := range owners {
+		owner, ok := raw.(string)
+		if !ok || owner == "" {
+			continue
+		}
+		result[rotatorIDs[idx]] = RotatorPlacement{Instance: owner}
+		if _, dup := seen[owner]; !dup {
+			seen[owner] = struct{}{}
+			instances = append(instances, owner)
+		}
+	}
+
+	if len(instances) == 0 {
+		return result
+	}
+
+	hostKeys := make([]string, len(instances))
+	for idx, instance := range instances {
+		hostKeys[idx] = rotatorInstanceKeyPrefix + instance
+	}
+
+	hosts, err := client.MGet(ctx, hostKeys...).Result()
+	if err != nil {
+		log.Warn("rotating proxy placement: failed to load instance hosts", "error", err)
+		return result
+	}
+
+	hostByInstance := make(map[string]string, len(instances))
+	for idx, raw := range hosts {
+		if host, ok := raw.(string); ok && host != "" {
+			hostByInstance[instances[idx]] = host
+		}
+	}
+
+	for rotatorID, placement := range result {
+		placement.Host = hostByInstance[placement.Instance]
+		result[rotatorID] = placement
+	}
+
+	return result
+}
@@ -0,0 +1,77 @@
+package rotatingproxy
+
+import (
+	"testing"
+
+	"magpie/internal/domain"
+	"magpie/internal/support"
+)
+
+func TestPlacementQuota_SpreadsRotatorsEvenly(t *testing.T) {
+	cases := []struct {
+		rotators, instances, want int
+	}{
+		{rotators: 0, instances: 3, want: 0},
+		{rotators: 4, instances: 1, want: 4},
+		{rotators: 4, instances: 2, want: 2},
+		{rotators: 5, instances: 2, want: 3},
+		{rotators: 2, instances: 5, want: 1},
+		{rotators: 3, instances: 0, want: 3},
+	}
+
+	for _, tc := range cases {
+		if got := placementQuota(tc.rotators, tc.instances); got != tc.want {
+			t.Errorf("placementQuota(%d, %d) = %d, want %d", tc.rotators, tc.instances, got, tc.want)
+		}
+	}
+}
+
+func TestHandleEvent_RemovedStopsLocalListener(t *testing.T) {
+	manager := NewManager()
+	manager.servers[7] = newProxyServer(domain.RotatingProxy{ID: 7})
+	manager.servers[8] = newProxyServer(domain.RotatingProxy{ID: 8})
+
+	manager.handleEvent(rotatorEvent{Action: rotatorEventRemoved, RotatorID: 7, Origin: support.InstanceID()})
+	if !manager.hosts(7) {
+		t.Fatal("expected events from this instance to be ignored")
+	}
+
+	manager.handleEvent(rotatorEvent{Action: rotatorEventRemoved, RotatorID: 7, Origin: "other-instance"})
+	if manager.hosts(7) {
+		t.Fatal("expected rotator 7 to be stopped")
+	}
+	if !manager.hosts(8) {
+		t.Fatal("expected rotator 8 to keep running")
+	}
+}
+
+func TestHandleEvent_AddedRequestsPlacementOnce(t *testing.T) {
+	manager := NewManager()
+
+	manager.handleEvent(rotatorEvent{Action: rotatorEventAdded, RotatorID: 3, Origin: "other-instance"})
+	manager.handleEvent(rotatorEvent{Action: rotatorEventAdded, RotatorID: 4, Origin: "other-instance"})
+
+	select {
+	case <-manager.placementKick:
+	default:
+		t.Fatal("expected a placement round to be requested")
+	}
+	select {
+	case <-manager.placementKick:
+		t.Fatal("expected pending placement requests to be coalesced")
+	default:
+	}
+}
+
+func TestPlacements_WithoutRedisReportsLocalListeners(t *testing.T) {
+	manager := NewManager()
+	manager.servers[5] = newProxyServer(domain.RotatingProxy{ID: 5})
+
+	placements := manager.Placements([]uint64{5, 6})
+	if placement, ok := placements[5]; !ok || placement.Instance != support.InstanceID() {
+		t.Fatalf("placement for rotator 5 = %+v, want this instance", placement)
+	}
+	if _, ok := placements[6]; ok {
+		t.Fatal("expected rotator 6 without a listener to be left out")
+	}
+}
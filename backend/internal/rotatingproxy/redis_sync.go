@@ -0,0 +1,65 @@
+package rotatingproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const rotatorRedisOpTimeout = 2 * time.Second
+
+type rotatorSyncState struct {
+	mu     sync.RWMutex
+	client *redis.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var redisSyncState rotatorSyncState
+
+// EnableRedisSynchronization shares rotator runtime state (such as sticky
+// sessions) through redis so every backend instance makes the same choices.
+func EnableRedisSynchronization(ctx context.Context, client *redis.Client) {
+	if client == nil {
+		log.Warn("Rotating proxy sync disabled: redis client is nil")
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	redisSyncState.mu.Lock()
+	defer redisSyncState.mu.Unlock()
+
+	if redisSyncState.client != nil {
+		return
+	}
+
+	syncCtx, cancel := context.WithCancel(ctx)
+	redisSyncState.client = client
+	redisSyncState.ctx = syncCtx
+	redisSyncState.cancel = cancel
+}
+
+// redisOpContext returns the shared redis client with a bounded context for a
+// single operation, or a nil client when synchronization is disabled.
+func redisOpContext() (*redis.Client, context.Context, context.CancelFunc) {
+	redisSyncState.mu.RLock()
+	client := redisSyncState.client
+	baseCtx := redisSyncState.ctx
+	redisSyncState.mu.RUnlock()
+
+	if client == nil {
+		return nil, nil, func() {}
+	}
+
+	if baseCtx == nil || baseCtx.Err() != nil {
+		baseCtx = context.Background()
+	}
+
+	ctx, cancel := context.WithTimeout(baseCtx, rotatorRedisOpTimeout)
+	return client, ctx, cancel
+}
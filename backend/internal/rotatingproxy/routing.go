@@ -0,0 +1,100 @@
+package rotatingproxy
+
+import (
+	"strings"
+
+	"magpie/internal/database"
+	"magpie/internal/domain"
+)
+
+// hostRoute is a compiled routing rule.
+type hostRoute struct {
+	exact     map[string]struct{}
+	suffixes  []string
+	matchAll  bool
+	countries []string
+	types     []string
+	latencyMs uint16
+	anonymity string
+}
+
+// routingTable picks the pool filters for a destination host from a rotator's
+// ordered routing rules. A nil table routes every host to the rotator's own
+// filters.
+type routingTable struct {
+	routes []hostRoute
+}
+
+func newRoutingTable(rotator domain.RotatingProxy) *routingTable {
+	if len(rotator.RoutingRules) == 0 {
+		return nil
+	}
+
+	table := &routingTable{routes: make([]hostRoute, 0, len(rotator.RoutingRules))}
+	for _, rule := range rotator.RoutingRules {
+		route := hostRoute{
+			exact:     make(map[string]struct{}, len(rule.Hosts)),
+			countries: rule.Countries,
+			types:     rule.EstimatedTypes,
+			latencyMs: rule.MaxResponseTimeMs,
+			anonymity: rule.MinAnonymityLevel,
+		}
+		for _, pattern := range rule.Hosts {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			switch {
+			case pattern == database.RotatingProxyDefaultRouteHost:
+				route.matchAll = true
+			case strings.HasPrefix(pattern, "*."):
+				route.suffixes = append(route.suffixes, pattern[1:])
+			case pattern != "":
+				route.exact[pattern] = struct{}{}
+			}
+		}
+		table.routes = append(table.routes, route)
+	}
+	return table
+}
+
+func (r hostRoute) matches(host string) bool {
+	if r.matchAll {
+		return true
+	}
+	if _, ok := r.exact[host]; ok {
+		return true
+	}
+	for _, suffix := range r.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// route fills the parameters the client left unset from the first rule
+// matching the destination host.
+func (t *routingTable) route(params clientParams) clientParams {
+	if t == nil || params.Destination == "" {
+		return params
+	}
+
+	for idx, route := range t.routes {
+		if !route.matches(params.Destination) {
+			continue
+		}
+		params.Route = idx + 1
+		if len(params.Countries) == 0 {
+			params.Countries = route.countries
+		}
+		if len(params.Types) == 0 {
+			params.Types = route.types
+		}
+		if params.MaxLatencyMs == 0 {
+			params.MaxLatencyMs = route.latencyMs
+		}
+		if params.MinAnonymityLevel == "" {
+			params.MinAnonymityLevel = route.anonymity
+		}
+		break
+	}
+	return params
+}
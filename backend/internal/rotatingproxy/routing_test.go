@@ -0,0 +1,78 @@
+package rotatingproxy
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"magpie/internal/domain"
+)
+
+func TestRoutingTable_RoutesByDestinationHost(t *testing.T) {
+	handler := newProxyHandler(domain.RotatingProxy{RoutingRules: domain.RotatorRoutingRules{
+		{Hosts: []string{"*.google.com"}, EstimatedTypes: []string{"residential"}, MinAnonymityLevel: "elite"},
+		{Hosts: []string{"example.com", "203.0.113.9"}, Countries: []string{"germany"}, MaxResponseTimeMs: 200},
+		{Hosts: []string{"*"}, EstimatedTypes: []string{"datacenter"}},
+	}})
+
+	cases := []struct {
+		target string
+		want   clientParams
+	}{
+		{target: "www.google.com:443", want: clientParams{Destination: "www.google.com", Route: 1, Types: []string{"residential"}, MinAnonymityLevel: "elite"}},
+		{target: "MAPS.Google.com.", want: clientParams{Destination: "maps.google.com", Route: 1, Types: []string{"residential"}, MinAnonymityLevel: "elite"}},
+		{target: "example.com:80", want: clientParams{Destination: "example.com", Route: 2, Countries: []string{"germany"}, MaxLatencyMs: 200}},
+		{target: "203.0.113.9:8080", want: clientParams{Destination: "203.0.113.9", Route: 2, Countries: []string{"germany"}, MaxLatencyMs: 200}},
+		{target: "google.com:443", want: clientParams{Destination: "google.com", Route: 3, Types: []string{"datacenter"}}},
+		{target: "[2001:db8::1]:443", want: clientParams{Destination: "2001:db8::1", Route: 3, Types: []string{"datacenter"}}},
+	}
+	for _, tc := range cases {
+		if got := handler.paramsFor(clientParams{}, tc.target); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("route(%q) = %+v, want %+v", tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestRoutingTable_ClientParamsTakePrecedence(t *testing.T) {
+	handler := newProxyHandler(domain.RotatingProxy{RoutingRules: domain.RotatorRoutingRules{
+		{Hosts: []string{"*"}, Countries: []string{"france"}, EstimatedTypes: []string{"datacenter"}},
+	}})
+
+	got := handler.paramsFor(clientParams{Session: "abc", Countries: []string{"Germany"}}, "example.com:443")
+	want := clientParams{Destination: "example.com", Route: 1, Session: "abc", Countries: []string{"Germany"}, Types: []string{"datacenter"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("paramsFor = %+v, want %+v", got, want)
+	}
+
+	var empty *routingTable
+	if got := empty.route(clientParams{Destination: "example.com", Session: "abc"}); !reflect.DeepEqual(got, clientParams{Destination: "example.com", Session: "abc"}) {
+		t.Fatalf("expected a rotator without rules to keep the client params, got %+v", got)
+	}
+}
+
+func TestServeHTTP_SessionKeepsAnUpstreamPerRoutingRule(t *testing.T) {
+	stickySessions = newLocalSessionStore()
+	upstreamCooldowns = newCooldownStore()
+	used := stubCountryPool(t, map[uint64]string{1: "United States", 2: "Germany"})
+
+	handler := newProxyHandler(domain.RotatingProxy{ID: 27, UserID: 4, SessionTTLSeconds: 60, RoutingRules: domain.RotatorRoutingRules{
+		{Hosts: []string{"*.google.com"}, Countries: []string{"Germany"}},
+		{Hosts: []string{"*"}},
+	}})
+
+	for _, target := range []string{"http://example.com/", "http://www.google.com/", "http://example.com/", "http://www.google.com/"} {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("user-session-abc:secret")))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", target, recorder.Code)
+		}
+	}
+
+	if want := []uint64{1, 2, 1, 2}; !reflect.DeepEqual(*used, want) {
+		t.Fatalf("upstreams used = %v, want %v", *used, want)
+	}
+}
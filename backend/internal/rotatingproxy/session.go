@@ -0,0 +1,305 @@
+package rotatingproxy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/redis/go-redis/v9"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/database"
+)
+
+const (
+	sessionRedisKeyPrefix   = "magpie:rotator:session:"
+	maxSessionIDLength      = 64
+	localSessionSweepPeriod = time.Minute
+)
+
+var (
+	getRotatingProxyUpstreamFunc = database.GetRotatingProxyUpstream
+
+	stickySessions = newLocalSessionStore()
+
+	forgetSessionScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+else
+	return 0
+end`)
+)
+
+// clientParams holds the routing parameters a client appends to its proxy
+// username, e.g. "user-session-abc123-country-germany", or sends as control
+// headers. Parameters the client leaves unset are filled in from the
+// rotator's routing rules for the request's Destination host; Route is the
+// 1-based index of the rule that matched, or 0.
+type clientParams struct {
+	Destination       string
+	Route             int
+	Session           string
+	Countries         []string
+	Types             []string
+	MaxLatencyMs      uint16
+	MinAnonymityLevel string
+}
+
+var clientParamKeys = []string{"session", "country", "type", "latency"}
+
+// parseClientUsername splits a proxy username into the rotator username and
+// the parameters appended to it. Parameters are "-key-value" pairs following
+// the first recognised key.
+func parseClientUsername(raw string) (string, clientParams) {
+	cut := -1
+	lower := strings.ToLower(raw)
+	for _, key := range clientParamKeys {
+		idx := strings.Index(lower, "-"+key+"-")
+		if idx >= 0 && (cut == -1 || idx < cut) {
+			cut = idx
+		}
+	}
+	if cut == -1 {
+		return raw, clientParams{}
+	}
+
+	var params clientParams
+	tokens := strings.Split(raw[cut+1:], "-")
+	for i := 0; i+1 < len(tokens); i += 2 {
+		switch strings.ToLower(tokens[i]) {
+		case "session":
+			params.Session = sanitizeSessionID(tokens[i+1])
+		case "country":
+			params.Countries = appendControlValues(params.Countries, strings.ReplaceAll(tokens[i+1], "_", " "))
+		case "type":
+			params.Types = appendControlValues(params.Types, tokens[i+1])
+		case "latency":
+			params.MaxLatencyMs = parseMaxLatency(tokens[i+1])
+		}
+	}
+
+	return raw[:cut], params
+}
+
+// sessionID is the sticky session the request belongs to. Each routing rule
+// keeps its own upstream per session, so a session used for hosts routed to
+// different pools never carries one pool's upstream over to the other.
+func (p clientParams) sessionID() string {
+	if p.Session == "" || p.Route == 0 {
+		return p.Session
+	}
+	return p.Session + ":route-" + strconv.Itoa(p.Route)
+}
+
+func sanitizeSessionID(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxSessionIDLength {
+		return ""
+	}
+	for _, r := range raw {
+		isAlnum := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		if !isAlnum && r != '_' {
+			return ""
+		}
+	}
+	return raw
+}
+
+func (h *proxyHandler) sessionTTL() time.Duration {
+	seconds := h.rotator.SessionTTLSeconds
+	if seconds == 0 {
+		seconds = database.DefaultRotatingProxySessionTTLSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// acquireUpstream picks the upstream for a client request. Requests carrying a
+// session keep the upstream recorded for that session until it expires, stops
+// being alive, is excluded by selection or no longer matches the request's
+// narrowing; everything else rotates normally.
+func (h *proxyHandler) acquireUpstream(session string, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+	if session == "" {
+		return getNextRotatingProxyFunc(h.rotator.UserID, h.rotator.ID, selection)
+	}
+
+	if proxyID, ok := lookupSession(h.rotator.ID, session); ok {
+		if containsProxyID(selection.ExcludeProxyIDs, proxyID) {
+			forgetSession(h.rotator.ID, session, proxyID)
+		} else {
+			next, err := getRotatingProxyUpstreamFunc(h.rotator.UserID, h.rotator.ID, proxyID, selection)
+			if err == nil {
+				return next, nil
+			}
+			if !errors.Is(err, database.ErrRotatingProxyUpstreamGone) {
+				return nil, err
+			}
+			forgetSession(h.rotator.ID, session, proxyID)
+		}
+	}
+
+	next, err := getNextRotatingProxyFunc(h.rotator.UserID, h.rotator.ID, selection)
+	if err != nil {
+		return nil, err
+	}
+
+	winner := claimSession(h.rotator.ID, session, next.ProxyID, h.sessionTTL())
+	if winner != next.ProxyID && !containsProxyID(selection.ExcludeProxyIDs, winner) {
+		// Another request (possibly on another instance) claimed the session first.
+		if claimed, err := getRotatingProxyUpstreamFunc(h.rotator.UserID, h.rotator.ID, winner, selection); err == nil {
+			return claimed, nil
+		}
+	}
+
+	return next, nil
+}
+
+func containsProxyID(ids []uint64, id uint64) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sessionKey(rotatorID uint64, session string) string {
+	return sessionRedisKeyPrefix + strconv.FormatUint(rotatorID, 10) + ":" + session
+}
+
+func lookupSession(rotatorID uint64, session string) (uint64, bool) {
+	key := sessionKey(rotatorID, session)
+
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return stickySessions.get(key)
+	}
+
+	raw, err := client.Get(ctx, key).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Warn("rotating proxy: session lookup failed", "rotator_id", rotatorID, "error", err)
+		}
+		return 0, false
+	}
+
+	proxyID, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return proxyID, true
+}
+
+// claimSession stores proxyID for the session unless one is already recorded
+// and returns the proxy the session is bound to afterwards.
+func claimSession(rotatorID uint64, session string, proxyID uint64, ttl time.Duration) uint64 {
+	key := sessionKey(rotatorID, session)
+
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		return stickySessions.claim(key, proxyID, ttl)
+	}
+
+	value := strconv.FormatUint(proxyID, 10)
+	ok, err := client.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		log.Warn("rotating proxy: session claim failed", "rotator_id", rotatorID, "error", err)
+		return proxyID
+	}
+	if ok {
+		return proxyID
+	}
+
+	existing, found := lookupSession(rotatorID, session)
+	if !found {
+		return proxyID
+	}
+	return existing
+}
+
+func forgetSession(rotatorID uint64, session string, proxyID uint64) {
+	key := sessionKey(rotatorID, session)
+
+	client, ctx, cancel := redisOpContext()
+	defer cancel()
+	if client == nil {
+		stickySessions.forget(key, proxyID)
+		return
+	}
+
+	if err := forgetSessionScript.Run(ctx, client, []string{key}, strconv.FormatUint(proxyID, 10)).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		log.Warn("rotating proxy: session release failed", "rotator_id", rotatorID, "error", err)
+	}
+}
+
+type localSessionEntry struct {
+	proxyID uint64
+	expires time.Time
+}
+
+// localSessionStore keeps sticky sessions in memory when redis is unavailable.
+type localSessionStore struct {
+	mu        sync.Mutex
+	entries   map[string]localSessionEntry
+	lastSweep time.Time
+}
+
+func newLocalSessionStore() *localSessionStore {
+	return &localSessionStore{entries: make(map[string]localSessionEntry)}
+}
+
+func (s *localSessionStore) get(key string) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(s.entries, key)
+		return 0, false
+	}
+	return entry.proxyID, true
+}
+
+func (s *localSessionStore) claim(key string, proxyID uint64, ttl time.Duration) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepLocked(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expires) {
+		return entry.proxyID
+	}
+
+	s.entries[key] = localSessionEntry{proxyID: proxyID, expires: now.Add(ttl)}
+	return proxyID
+}
+
+func (s *localSessionStore) forget(key string, proxyID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.proxyID == proxyID {
+		delete(s.entries, key)
+	}
+}
+
+func (s *localSessionStore) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < localSessionSweepPeriod {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package rotatingproxy
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/database"
+	"magpie/internal/domain"
+)
+
+func TestParseClientUsername(t *testing.T) {
+	cases := []struct {
+		raw         string
+		wantBase    string
+		wantSession string
+	}{
+		{raw: "user", wantBase: "user"},
+		{raw: "user-session-abc123", wantBase: "user", wantSession: "abc123"},
+		{raw: "my-user-session-s_1", wantBase: "my-user", wantSession: "s_1"},
+		{raw: "user-SESSION-abc", wantBase: "user", wantSession: "abc"},
+		{raw: "user-session-bad!id", wantBase: "user"},
+		{raw: "user-session-", wantBase: "user"},
+	}
+
+	for _, tc := range cases {
+		base, params := parseClientUsername(tc.raw)
+		if base != tc.wantBase || params.Session != tc.wantSession {
+			t.Fatalf("parseClientUsername(%q) = %q, %q; want %q, %q", tc.raw, base, params.Session, tc.wantBase, tc.wantSession)
+		}
+	}
+}
+
+func TestCredentialsValid_IgnoresSessionSuffix(t *testing.T) {
+	handler := &proxyHandler{
+		rotator: domain.RotatingProxy{AuthRequired: true, AuthUsername: "proxy-user", AuthPassword: "secret"},
+	}
+
+	if !handler.credentialsValid("proxy-user-session-abc", "secret") {
+		t.Fatal("expected credentials with session suffix to be accepted")
+	}
+	if handler.credentialsValid("other-session-abc", "secret") {
+		t.Fatal("expected credentials for another user to be rejected")
+	}
+}
+
+func TestServeHTTP_AcceptsUsernameContainingParameterKey(t *testing.T) {
+	upstreamCooldowns = newCooldownStore()
+	stickySessions = newLocalSessionStore()
+	handler := newProxyHandler(domain.RotatingProxy{ID: 31, UserID: 4, AuthRequired: true, AuthUsername: "scraper-type-a", AuthPassword: "secret", SessionTTLSeconds: 60})
+	selections := stubRotation(t, 1)
+	stubRoundTripOK(t)
+
+	for _, username := range []string{"scraper-type-a", "scraper-type-a-session-s1"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":secret")))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", username, http.StatusOK, recorder.Code)
+		}
+	}
+
+	for _, selection := range *selections {
+		if len(selection.EstimatedTypes) != 0 {
+			t.Fatalf("expected the username not to narrow the upstream type, got %v", selection.EstimatedTypes)
+		}
+	}
+	if _, params := handler.splitClientUsername("scraper-type-a-session-s1"); params.Session != "s1" {
+		t.Fatalf("expected session s1 after the rotator username, got %+v", params)
+	}
+	if handler.credentialsValid("scraper", "secret") {
+		t.Fatal("expected a truncated username to be rejected")
+	}
+}
+
+func TestAcquireUpstream_KeepsSessionOnSameUpstream(t *testing.T) {
+	stickySessions = newLocalSessionStore()
+	handler := &proxyHandler{rotator: domain.RotatingProxy{ID: 9, UserID: 2, SessionTTLSeconds: 60}}
+
+	var rotations uint64
+	originalGetNext := getNextRotatingProxyFunc
+	getNextRotatingProxyFunc = func(userID uint, rotatorID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+		rotations++
+		return &dto.RotatingProxyNext{ProxyID: rotations, Protocol: "http"}, nil
+	}
+	t.Cleanup(func() { getNextRotatingProxyFunc = originalGetNext })
+
+	dead := map[uint64]bool{}
+	originalUpstream := getRotatingProxyUpstreamFunc
+	getRotatingProxyUpstreamFunc = func(userID uint, rotatorID uint64, proxyID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+		if dead[proxyID] {
+			return nil, database.ErrRotatingProxyUpstreamGone
+		}
+		return &dto.RotatingProxyNext{ProxyID: proxyID, Protocol: "http"}, nil
+	}
+	t.Cleanup(func() { getRotatingProxyUpstreamFunc = originalUpstream })
+
+	first, err := handler.acquireUpstream("abc", database.RotatingProxySelection{})
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	second, err := handler.acquireUpstream("abc", database.RotatingProxySelection{})
+	if err != nil {
+		t.Fatalf("second acquire: %v", err)
+	}
+	if first.ProxyID != second.ProxyID {
+		t.Fatalf("session switched upstream: %d -> %d", first.ProxyID, second.ProxyID)
+	}
+
+	other, err := handler.acquireUpstream("", database.RotatingProxySelection{})
+	if err != nil {
+		t.Fatalf("acquire without session: %v", err)
+	}
+	if other.ProxyID == first.ProxyID {
+		t.Fatal("requests without a session should keep rotating")
+	}
+
+	dead[first.ProxyID] = true
+	third, err := handler.acquireUpstream("abc", database.RotatingProxySelection{})
+	if err != nil {
+		t.Fatalf("acquire after upstream died: %v", err)
+	}
+	if third.ProxyID == first.ProxyID {
+		t.Fatal("expected session to move off a dead upstream")
+	}
+
+	fourth, err := handler.acquireUpstream("abc", database.RotatingProxySelection{})
+	if err != nil {
+		t.Fatalf("acquire after reassignment: %v", err)
+	}
+	if fourth.ProxyID != third.ProxyID {
+		t.Fatalf("session did not stick to reassigned upstream: %d -> %d", third.ProxyID, fourth.ProxyID)
+	}
+}
+
+// stubCountryPool serves upstreams from a fixed set of proxy countries and
+// records the upstream each request was sent through.
+func stubCountryPool(t *testing.T, countries map[uint64]string) *[]uint64 {
+	t.Helper()
+
+	matches := func(proxyID uint64, selection database.RotatingProxySelection) bool {
+		if containsProxyID(selection.ExcludeProxyIDs, proxyID) {
+			return false
+		}
+		if len(selection.Countries) == 0 {
+			return true
+		}
+		for _, country := range selection.Countries {
+			if strings.EqualFold(country, countries[proxyID]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	originalNext := getNextRotatingProxyFunc
+	getNextRotatingProxyFunc = func(userID uint, rotatorID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+		for id := uint64(1); id <= uint64(len(countries)); id++ {
+			if matches(id, selection) {
+				return &dto.RotatingProxyNext{ProxyID: id, Protocol: "http"}, nil
+			}
+		}
+		return nil, database.ErrRotatingProxyNoAliveProxies
+	}
+	originalUpstream := getRotatingProxyUpstreamFunc
+	getRotatingProxyUpstreamFunc = func(userID uint, rotatorID uint64, proxyID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+		if !matches(proxyID, selection) {
+			return nil, database.ErrRotatingProxyUpstreamGone
+		}
+		return &dto.RotatingProxyNext{ProxyID: proxyID, Protocol: "http"}, nil
+	}
+
+	var used []uint64
+	originalRoundTrip := roundTripUpstreamFunc
+	roundTripUpstreamFunc = func(next *dto.RotatingProxyNext, req *http.Request) (*http.Response, error) {
+		used = append(used, next.ProxyID)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+
+	t.Cleanup(func() {
+		getNextRotatingProxyFunc = originalNext
+		getRotatingProxyUpstreamFunc = originalUpstream
+		roundTripUpstreamFunc = originalRoundTrip
+	})
+	return &used
+}
+
+func TestServeHTTP_SessionHonoursControlHeaders(t *testing.T) {
+	stickySessions = newLocalSessionStore()
+	upstreamCooldowns = newCooldownStore()
+	used := stubCountryPool(t, map[uint64]string{1: "United States", 2: "Germany"})
+
+	handler := newProxyHandler(domain.RotatingProxy{ID: 26, UserID: 4, SessionTTLSeconds: 60})
+	send := func(country string) {
+		request := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("user-session-abc:secret")))
+		if country != "" {
+			request.Header.Set(controlHeaderCountry, country)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d", recorder.Code)
+		}
+	}
+
+	send("")
+	send("Germany")
+	send("Germany")
+
+	if want := []uint64{1, 2, 2}; len(*used) != 3 || (*used)[0] != want[0] || (*used)[1] != want[1] || (*used)[2] != want[2] {
+		t.Fatalf("upstreams used = %v, want %v", *used, want)
+	}
+}
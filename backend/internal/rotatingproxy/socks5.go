@@ -0,0 +1,266 @@
+package rotatingproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+
+	"magpie/internal/database"
+)
+
+const (
+	socks5Version            = 0x05
+	socks5AuthVersion        = 0x01
+	socks5MethodNoAuth       = 0x00
+	socks5MethodUserPass     = 0x02
+	socks5MethodNoAcceptable = 0xFF
+	socks5CommandConnect     = 0x01
+	socks5AddrIPv4           = 0x01
+	socks5AddrDomain         = 0x03
+	socks5AddrIPv6           = 0x04
+
+	socks5ReplySucceeded           = 0x00
+	socks5ReplyGeneralFailure      = 0x01
+	socks5ReplyNotAllowed          = 0x02
+	socks5ReplyHostUnreachable     = 0x04
+	socks5ReplyCommandNotSupported = 0x07
+	socks5ReplyAddressNotSupported = 0x08
+
+	socks5HandshakeTimeout = 15 * time.Second
+)
+
+var errSOCKS5AuthFailed = errors.New("socks5: authentication failed")
+
+// serveSOCKS5 accepts client connections on the listener and serves each one
+// as a SOCKS5 session, using the handler current at accept time, until the
+// listener is closed.
+func serveSOCKS5(listener net.Listener, current func() *proxyHandler) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go current().handleSOCKS5(conn)
+	}
+}
+
+func (h *proxyHandler) handleSOCKS5(clientConn net.Conn) {
+	defer func() {
+		if err := clientConn.Close(); err != nil {
+			log.Debug("rotating proxy: socks5 client connection close", "error", err)
+		}
+	}()
+
+	if !h.allowedClients.allows(clientConn.RemoteAddr().String()) {
+		log.Debug("rotating proxy: socks5 client address not allowed", "rotator_id", h.rotator.ID, "remote_addr", clientConn.RemoteAddr().String())
+		return
+	}
+
+	_ = clientConn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+
+	params, err := h.negotiateSOCKS5Auth(clientConn)
+	if err != nil {
+		log.Debug("rotating proxy: socks5 negotiation failed", "rotator_id", h.rotator.ID, "error", err)
+		return
+	}
+
+	targetHost, reply, err := readSOCKS5Request(clientConn)
+	if err != nil {
+		if reply != 0 {
+			_ = writeSOCKS5Reply(clientConn, reply)
+		}
+		log.Debug("rotating proxy: socks5 request rejected", "rotator_id", h.rotator.ID, "error", err)
+		return
+	}
+
+	if !h.limits.allowRequest() {
+		_ = writeSOCKS5Reply(clientConn, socks5ReplyNotAllowed)
+		return
+	}
+	if !h.limits.acquireConnection() {
+		_ = writeSOCKS5Reply(clientConn, socks5ReplyGeneralFailure)
+		return
+	}
+	defer h.limits.releaseConnection()
+
+	upConn, next, err := h.connectUpstream(context.Background(), h.paramsFor(params, targetHost), targetHost)
+	if err != nil {
+		reply := byte(socks5ReplyHostUnreachable)
+		if errors.Is(err, errAcquireUpstream) || errors.Is(err, errUnsupportedUpstream) || errors.Is(err, errUpstreamBusy) {
+			reply = socks5ReplyGeneralFailure
+		}
+		_ = writeSOCKS5Reply(clientConn, reply)
+		h.recordTraffic(database.RotatingProxyTrafficSample{Tunnels: 1})
+		return
+	}
+	defer h.limits.releaseUpstream(next.ProxyID)
+
+	if err := writeSOCKS5Reply(clientConn, socks5ReplySucceeded); err != nil {
+		_ = upConn.Close()
+		return
+	}
+
+	_ = clientConn.SetDeadline(time.Time{})
+	h.tunnel(clientConn, upConn, next)
+}
+
+// negotiateSOCKS5Auth performs method selection and, when credentials are
+// sent, username/password authentication. Clients of rotators without
+// authentication may still send credentials to pass routing parameters.
+func (h *proxyHandler) negotiateSOCKS5Auth(conn net.Conn) (clientParams, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return clientParams{}, err
+	}
+	if header[0] != socks5Version {
+		return clientParams{}, fmt.Errorf("socks5: unsupported version %d", header[0])
+	}
+
+	methods := make([]byte, int(header[1]))
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return clientParams{}, err
+	}
+
+	offersNoAuth, offersUserPass := false, false
+	for _, method := range methods {
+		switch method {
+		case socks5MethodNoAuth:
+			offersNoAuth = true
+		case socks5MethodUserPass:
+			offersUserPass = true
+		}
+	}
+
+	var selected byte
+	switch {
+	case offersUserPass:
+		selected = socks5MethodUserPass
+	case offersNoAuth && !h.rotator.AuthRequired:
+		selected = socks5MethodNoAuth
+	default:
+		_, _ = conn.Write([]byte{socks5Version, socks5MethodNoAcceptable})
+		return clientParams{}, errors.New("socks5: no acceptable authentication method")
+	}
+
+	if _, err := conn.Write([]byte{socks5Version, selected}); err != nil {
+		return clientParams{}, err
+	}
+
+	if selected == socks5MethodNoAuth {
+		return clientParams{}, nil
+	}
+
+	username, password, err := readSOCKS5Credentials(conn)
+	if err != nil {
+		return clientParams{}, err
+	}
+
+	if h.rotator.AuthRequired && !h.credentialsValid(username, password) {
+		_, _ = conn.Write([]byte{socks5AuthVersion, 0x01})
+		return clientParams{}, errSOCKS5AuthFailed
+	}
+
+	if _, err := conn.Write([]byte{socks5AuthVersion, 0x00}); err != nil {
+		return clientParams{}, err
+	}
+
+	_, params := h.splitClientUsername(username)
+	return params, nil
+}
+
+func readSOCKS5Credentials(conn net.Conn) (string, string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", "", err
+	}
+	if header[0] != socks5AuthVersion {
+		return "", "", fmt.Errorf("socks5: unsupported auth version %d", header[0])
+	}
+
+	username := make([]byte, int(header[1]))
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return "", "", err
+	}
+
+	passwordLength := make([]byte, 1)
+	if _, err := io.ReadFull(conn, passwordLength); err != nil {
+		return "", "", err
+	}
+
+	password := make([]byte, int(passwordLength[0]))
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return "", "", err
+	}
+
+	return string(username), string(password), nil
+}
+
+// readSOCKS5Request parses the client's request and returns the target as a
+// host:port string. When the request is rejected the reply code to send back
+// is returned alongside the error.
+func readSOCKS5Request(conn net.Conn) (string, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", 0, err
+	}
+	if header[0] != socks5Version {
+		return "", socks5ReplyGeneralFailure, fmt.Errorf("socks5: unsupported version %d", header[0])
+	}
+	if header[1] != socks5CommandConnect {
+		return "", socks5ReplyCommandNotSupported, fmt.Errorf("socks5: unsupported command %d", header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4:
+		addr := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(addr).String()
+	case socks5AddrIPv6:
+		addr := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(addr).String()
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", 0, err
+		}
+		domain := make([]byte, int(length[0]))
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", 0, err
+		}
+		host = string(domain)
+	default:
+		return "", socks5ReplyAddressNotSupported, fmt.Errorf("socks5: unsupported address type %d", header[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return "", 0, err
+	}
+	port := binary.BigEndian.Uint16(portBytes)
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), 0, nil
+}
+
+func writeSOCKS5Reply(conn net.Conn, reply byte) error {
+	// The bound address is not meaningful for a rotator, so report 0.0.0.0:0.
+	_, err := conn.Write([]byte{socks5Version, reply, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,161 @@
+package rotatingproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/database"
+	"magpie/internal/domain"
+)
+
+func TestHandleSOCKS5_AuthenticatesAndProxiesData(t *testing.T) {
+	handler := &proxyHandler{
+		rotator: domain.RotatingProxy{
+			ID:           5,
+			UserID:       3,
+			AuthRequired: true,
+			AuthUsername: "socks-user",
+			AuthPassword: "socks-pass",
+		},
+	}
+
+	originalGetNext := getNextRotatingProxyFunc
+	getNextRotatingProxyFunc = func(userID uint, rotatorID uint64, selection database.RotatingProxySelection) (*dto.RotatingProxyNext, error) {
+		if userID != 3 || rotatorID != 5 {
+			t.Fatalf("unexpected identifiers: userID=%d rotatorID=%d", userID, rotatorID)
+		}
+		return &dto.RotatingProxyNext{ProxyID: 1, IP: "192.0.2.10", Port: 8080, Protocol: "http"}, nil
+	}
+	t.Cleanup(func() { getNextRotatingProxyFunc = originalGetNext })
+
+	upstreamClient, upstreamServer := net.Pipe()
+	originalDial := dialUpstreamFunc
+	dialUpstreamFunc = func(next *dto.RotatingProxyNext) (net.Conn, error) {
+		return upstreamServer, nil
+	}
+	t.Cleanup(func() { dialUpstreamFunc = originalDial })
+
+	originalConnect := performUpstreamConnectFunc
+	performUpstreamConnectFunc = func(conn net.Conn, targetHost string, next *dto.RotatingProxyNext) error {
+		if targetHost != "example.com:443" {
+			t.Fatalf("expected target host example.com:443, got %s", targetHost)
+		}
+		return nil
+	}
+	t.Cleanup(func() { performUpstreamConnectFunc = originalConnect })
+
+	clientEnd, serverEnd := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handler.handleSOCKS5(serverEnd)
+		close(done)
+	}()
+
+	mustWrite(t, clientEnd, []byte{socks5Version, 1, socks5MethodUserPass})
+	expectBytes(t, clientEnd, []byte{socks5Version, socks5MethodUserPass})
+
+	auth := []byte{socks5AuthVersion, byte(len("socks-user"))}
+	auth = append(auth, "socks-user"...)
+	auth = append(auth, byte(len("socks-pass")))
+	auth = append(auth, "socks-pass"...)
+	mustWrite(t, clientEnd, auth)
+	expectBytes(t, clientEnd, []byte{socks5AuthVersion, 0x00})
+
+	request := []byte{socks5Version, socks5CommandConnect, 0x00, socks5AddrDomain, byte(len("example.com"))}
+	request = append(request, "example.com"...)
+	request = append(request, 0x01, 0xBB)
+	mustWrite(t, clientEnd, request)
+
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(clientEnd, reply); err != nil {
+		t.Fatalf("read socks5 reply: %v", err)
+	}
+	if reply[1] != socks5ReplySucceeded {
+		t.Fatalf("socks5 reply code = %d, want %d", reply[1], socks5ReplySucceeded)
+	}
+
+	mustWrite(t, clientEnd, []byte("ping"))
+	expectBytes(t, upstreamClient, []byte("ping"))
+	mustWrite(t, upstreamClient, []byte("pong"))
+	expectBytes(t, clientEnd, []byte("pong"))
+
+	_ = clientEnd.Close()
+	_ = upstreamClient.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handleSOCKS5 did not return after closing connections")
+	}
+}
+
+func TestHandleSOCKS5_RejectsInvalidCredentials(t *testing.T) {
+	handler := &proxyHandler{
+		rotator: domain.RotatingProxy{
+			AuthRequired: true,
+			AuthUsername: "socks-user",
+			AuthPassword: "socks-pass",
+		},
+	}
+
+	clientEnd, serverEnd := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handler.handleSOCKS5(serverEnd)
+		close(done)
+	}()
+
+	mustWrite(t, clientEnd, []byte{socks5Version, 1, socks5MethodUserPass})
+	expectBytes(t, clientEnd, []byte{socks5Version, socks5MethodUserPass})
+
+	auth := []byte{socks5AuthVersion, byte(len("socks-user"))}
+	auth = append(auth, "socks-user"...)
+	auth = append(auth, byte(len("wrong")))
+	auth = append(auth, "wrong"...)
+	mustWrite(t, clientEnd, auth)
+	expectBytes(t, clientEnd, []byte{socks5AuthVersion, 0x01})
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handleSOCKS5 did not return after rejecting credentials")
+	}
+}
+
+func TestHandleSOCKS5_RequiresUserPassMethodWhenAuthEnabled(t *testing.T) {
+	handler := &proxyHandler{
+		rotator: domain.RotatingProxy{
+			AuthRequired: true,
+			AuthUsername: "socks-user",
+			AuthPassword: "socks-pass",
+		},
+	}
+
+	clientEnd, serverEnd := net.Pipe()
+	go handler.handleSOCKS5(serverEnd)
+
+	mustWrite(t, clientEnd, []byte{socks5Version, 1, socks5MethodNoAuth})
+	expectBytes(t, clientEnd, []byte{socks5Version, socks5MethodNoAcceptable})
+	_ = clientEnd.Close()
+}
+
+func mustWrite(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("write %v: %v", payload, err)
+	}
+}
+
+func expectBytes(t *testing.T, conn net.Conn, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("read %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package rotatingproxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"magpie/internal/api/dto"
+)
+
+const (
+	upstreamTransportIdleConnsPerHost = 8
+	upstreamTransportIdleConns        = 32
+	upstreamTransportIdleTimeout      = 90 * time.Second
+	upstreamTransportCacheSize        = 512
+)
+
+var upstreamTransports = newTransportCache(upstreamTransportCacheSize)
+
+type transportKey struct {
+	protocol string
+	address  string
+	username string
+	password string
+}
+
+func transportKeyFor(next *dto.RotatingProxyNext) transportKey {
+	key := transportKey{
+		protocol: strings.ToLower(next.Protocol),
+		address:  upstreamAddress(next),
+	}
+	if next.HasAuth {
+		key.username, key.password = next.Username, next.Password
+	}
+	return key
+}
+
+type cachedTransport struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+// transportCache keeps one keep-alive transport per upstream so requests
+// routed to the same upstream reuse its connections. Transports unused for
+// longer than their idle connections live are dropped, and the least recently
+// used one makes room once the cache is full.
+type transportCache struct {
+	mu      sync.Mutex
+	entries map[transportKey]*cachedTransport
+	max     int
+}
+
+func newTransportCache(max int) *transportCache {
+	return &transportCache{entries: make(map[transportKey]*cachedTransport), max: max}
+}
+
+func (c *transportCache) get(next *dto.RotatingProxyNext) *http.Transport {
+	key := transportKeyFor(next)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		entry.lastUsed = now
+		return entry.transport
+	}
+
+	c.evictLocked(now)
+
+	upstream := *next
+	transport := buildHTTPTransport(&upstream)
+	c.entries[key] = &cachedTransport{transport: transport, lastUsed: now}
+	return transport
+}
+
+func (c *transportCache) evictLocked(now time.Time) {
+	var (
+		oldestKey transportKey
+		oldest    *cachedTransport
+	)
+
+	for key, entry := range c.entries {
+		if now.Sub(entry.lastUsed) > upstreamTransportIdleTimeout {
+			entry.transport.CloseIdleConnections()
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == nil || entry.lastUsed.Before(oldest.lastUsed) {
+			oldestKey, oldest = key, entry
+		}
+	}
+
+	if oldest != nil && len(c.entries) >= c.max {
+		oldest.transport.CloseIdleConnections()
+		delete(c.entries, oldestKey)
+	}
+}
+
+func (c *transportCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
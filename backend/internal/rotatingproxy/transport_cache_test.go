@@ -0,0 +1,45 @@
+package rotatingproxy
+
+import (
+	"testing"
+
+	"magpie/internal/api/dto"
+)
+
+func TestTransportCache_ReusesTransportPerUpstream(t *testing.T) {
+	cache := newTransportCache(8)
+
+	upstream := &dto.RotatingProxyNext{ProxyID: 1, Protocol: "http", IP: "127.0.0.1", Port: 8080}
+	first := cache.get(upstream)
+	if second := cache.get(&dto.RotatingProxyNext{ProxyID: 1, Protocol: "http", IP: "127.0.0.1", Port: 8080}); second != first {
+		t.Fatal("expected the same upstream to reuse its transport")
+	}
+
+	withAuth := &dto.RotatingProxyNext{ProxyID: 1, Protocol: "http", IP: "127.0.0.1", Port: 8080, HasAuth: true, Username: "user", Password: "pass"}
+	if cache.get(withAuth) == first {
+		t.Fatal("expected different credentials to use a separate transport")
+	}
+
+	if first.DisableKeepAlives {
+		t.Fatal("expected cached transports to keep connections alive")
+	}
+	if first.MaxIdleConnsPerHost != upstreamTransportIdleConnsPerHost || first.IdleConnTimeout != upstreamTransportIdleTimeout {
+		t.Fatalf("unexpected idle pool settings: %d %s", first.MaxIdleConnsPerHost, first.IdleConnTimeout)
+	}
+}
+
+func TestTransportCache_EvictsLeastRecentlyUsedWhenFull(t *testing.T) {
+	cache := newTransportCache(2)
+
+	first := cache.get(&dto.RotatingProxyNext{Protocol: "http", IP: "127.0.0.1", Port: 8001})
+	cache.get(&dto.RotatingProxyNext{Protocol: "http", IP: "127.0.0.1", Port: 8002})
+	cache.get(&dto.RotatingProxyNext{Protocol: "http", IP: "127.0.0.1", Port: 8001})
+	cache.get(&dto.RotatingProxyNext{Protocol: "http", IP: "127.0.0.1", Port: 8003})
+
+	if cache.len() != 2 {
+		t.Fatalf("cache holds %d transports, want 2", cache.len())
+	}
+	if cache.get(&dto.RotatingProxyNext{Protocol: "http", IP: "127.0.0.1", Port: 8001}) != first {
+		t.Fatal("expected the recently used transport to be kept")
+	}
+}
@@ -0,0 +1,157 @@
+package rotatingproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"magpie/internal/api/dto"
+	"magpie/internal/support"
+)
+
+func isSOCKSUpstream(protocol string) bool {
+	return isSOCKS4Upstream(protocol) || isSOCKS5Upstream(protocol)
+}
+
+func isSOCKS4Upstream(protocol string) bool {
+	return strings.EqualFold(protocol, "socks4")
+}
+
+func isSOCKS5Upstream(protocol string) bool {
+	return strings.EqualFold(protocol, "socks5")
+}
+
+// performSOCKS5Connect runs the client side of a SOCKS5 handshake on conn and
+// asks the upstream to open a tunnel to targetHost.
+func performSOCKS5Connect(conn net.Conn, targetHost string, next *dto.RotatingProxyNext) error {
+	host, port, err := splitTargetHostPort(targetHost)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{socks5MethodNoAuth}
+	if next.HasAuth {
+		methods = []byte{socks5MethodUserPass, socks5MethodNoAuth}
+	}
+
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	choice := make([]byte, 2)
+	if _, err := io.ReadFull(conn, choice); err != nil {
+		return err
+	}
+	if choice[0] != socks5Version {
+		return fmt.Errorf("socks5 upstream: unexpected version %d", choice[0])
+	}
+
+	switch choice[1] {
+	case socks5MethodNoAuth:
+	case socks5MethodUserPass:
+		if !next.HasAuth {
+			return errors.New("socks5 upstream: credentials required")
+		}
+		if len(next.Username) > 255 || len(next.Password) > 255 {
+			return errors.New("socks5 upstream: credentials too long")
+		}
+		auth := []byte{socks5AuthVersion, byte(len(next.Username))}
+		auth = append(auth, next.Username...)
+		auth = append(auth, byte(len(next.Password)))
+		auth = append(auth, next.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		status := make([]byte, 2)
+		if _, err := io.ReadFull(conn, status); err != nil {
+			return err
+		}
+		if status[1] != 0x00 {
+			return errors.New("socks5 upstream: authentication rejected")
+		}
+	default:
+		return errors.New("socks5 upstream: no acceptable authentication method")
+	}
+
+	request := []byte{socks5Version, socks5CommandConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ipv4 := ip.To4(); ipv4 != nil {
+			request = append(request, socks5AddrIPv4)
+			request = append(request, ipv4...)
+		} else {
+			request = append(request, socks5AddrIPv6)
+			request = append(request, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks5 upstream: target host too long")
+		}
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, port)
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != socks5ReplySucceeded {
+		return fmt.Errorf("socks5 upstream: connect failed with reply %d", header[1])
+	}
+
+	// Drain the bound address so the tunnel starts on a clean boundary.
+	var skip int
+	switch header[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("socks5 upstream: unsupported bound address type %d", header[3])
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// performSOCKS4Connect asks a SOCKS4 upstream to open a tunnel to targetHost.
+// The proxy username is sent as the SOCKS4 user id.
+func performSOCKS4Connect(conn net.Conn, targetHost string, next *dto.RotatingProxyNext) error {
+	var userID string
+	if next.HasAuth {
+		userID = next.Username
+	}
+	if err := support.SOCKS4Connect(conn, targetHost, userID); err != nil {
+		return fmt.Errorf("socks4 upstream: %w", err)
+	}
+	return nil
+}
+
+func splitTargetHostPort(targetHost string) (string, uint16, error) {
+	host, rawPort, err := net.SplitHostPort(targetHost)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid target port %q", rawPort)
+	}
+
+	return host, uint16(port), nil
+}
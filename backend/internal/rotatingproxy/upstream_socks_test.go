@@ -0,0 +1,149 @@
+package rotatingproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	"magpie/internal/api/dto"
+)
+
+func TestPerformUpstreamConnect_SOCKS5WithAuth(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer serverEnd.Close()
+		errCh <- fakeSOCKS5Upstream(t, serverEnd, "user", "pass", "example.com:443")
+	}()
+
+	next := &dto.RotatingProxyNext{
+		Protocol: "socks5",
+		HasAuth:  true,
+		Username: "user",
+		Password: "pass",
+	}
+
+	if err := performUpstreamConnect(clientEnd, "example.com:443", next); err != nil {
+		t.Fatalf("performUpstreamConnect returned error: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("fake upstream: %v", err)
+	}
+}
+
+func TestPerformUpstreamConnect_SOCKS4aForHostnames(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		defer serverEnd.Close()
+		reader := bufio.NewReader(serverEnd)
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			received <- nil
+			return
+		}
+		userID, _ := reader.ReadBytes(0x00)
+		host, _ := reader.ReadBytes(0x00)
+		payload := append(header, userID...)
+		payload = append(payload, host...)
+		received <- payload
+		_, _ = serverEnd.Write([]byte{0x00, 0x5A, 0, 0, 0, 0, 0, 0})
+	}()
+
+	next := &dto.RotatingProxyNext{Protocol: "socks4", HasAuth: true, Username: "id", Password: "ignored"}
+	if err := performUpstreamConnect(clientEnd, "example.com:80", next); err != nil {
+		t.Fatalf("performUpstreamConnect returned error: %v", err)
+	}
+
+	want := []byte{0x04, 0x01, 0x00, 0x50, 0, 0, 0, 1, 'i', 'd', 0x00}
+	want = append(want, "example.com"...)
+	want = append(want, 0x00)
+
+	got := <-received
+	if string(got) != string(want) {
+		t.Fatalf("socks4a request = %v, want %v", got, want)
+	}
+}
+
+func TestPerformUpstreamConnect_SOCKS4Rejected(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	go func() {
+		defer serverEnd.Close()
+		_, _ = io.ReadFull(serverEnd, make([]byte, 9))
+		_, _ = serverEnd.Write([]byte{0x00, 0x5B, 0, 0, 0, 0, 0, 0})
+	}()
+
+	next := &dto.RotatingProxyNext{Protocol: "socks4"}
+	if err := performUpstreamConnect(clientEnd, "192.0.2.1:80", next); err == nil {
+		t.Fatal("expected error for rejected socks4 request")
+	}
+}
+
+func TestPerformUpstreamConnect_SOCKS4ValidatesReplyVersion(t *testing.T) {
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close()
+
+	go func() {
+		defer serverEnd.Close()
+		_, _ = io.ReadFull(serverEnd, make([]byte, 9))
+		_, _ = serverEnd.Write([]byte{0x04, 0x5A, 0, 0, 0, 0, 0, 0})
+	}()
+
+	next := &dto.RotatingProxyNext{Protocol: "socks4"}
+	if err := performUpstreamConnect(clientEnd, "192.0.2.1:80", next); err == nil {
+		t.Fatal("expected error for a socks4 reply with the wrong version")
+	}
+}
+
+func TestBuildHTTPTransport_SOCKSUpstreamDialsDirectly(t *testing.T) {
+	transport := buildHTTPTransport(&dto.RotatingProxyNext{Protocol: "socks5", IP: "127.0.0.1", Port: 1080})
+	if transport.Proxy != nil {
+		t.Fatal("expected no HTTP proxy function for socks upstream")
+	}
+	if transport.DialContext == nil {
+		t.Fatal("expected custom dialer for socks upstream")
+	}
+}
+
+func fakeSOCKS5Upstream(t *testing.T, conn net.Conn, username, password, wantTarget string) error {
+	t.Helper()
+
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, int(greeting[1]))); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5MethodUserPass}); err != nil {
+		return err
+	}
+
+	gotUser, gotPass, err := readSOCKS5Credentials(conn)
+	if err != nil {
+		return err
+	}
+	if gotUser != username || gotPass != password {
+		t.Errorf("credentials = %s:%s, want %s:%s", gotUser, gotPass, username, password)
+	}
+	if _, err := conn.Write([]byte{socks5AuthVersion, 0x00}); err != nil {
+		return err
+	}
+
+	target, _, err := readSOCKS5Request(conn)
+	if err != nil {
+		return err
+	}
+	if target != wantTarget {
+		t.Errorf("target = %s, want %s", target, wantTarget)
+	}
+
+	return writeSOCKS5Reply(conn, socks5ReplySucceeded)
+}
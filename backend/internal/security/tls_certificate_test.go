@@ -0,0 +1,48 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSignedCertificate_ProducesUsablePair(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSignedCertificate("rotator", []string{"203.0.113.7", "proxy.example.com", ""}, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCertificate returned error: %v", err)
+	}
+
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatalf("generated pair does not load: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	if err := cert.VerifyHostname("203.0.113.7"); err != nil {
+		t.Fatalf("certificate is not valid for the IP: %v", err)
+	}
+	if err := cert.VerifyHostname("proxy.example.com"); err != nil {
+		t.Fatalf("certificate is not valid for the DNS name: %v", err)
+	}
+
+	fingerprint, notAfter, err := CertificateFingerprint(certPEM)
+	if err != nil {
+		t.Fatalf("CertificateFingerprint returned error: %v", err)
+	}
+	if len(fingerprint) != 64 {
+		t.Fatalf("fingerprint %q is not a hex SHA-256", fingerprint)
+	}
+	if !notAfter.Equal(cert.NotAfter) {
+		t.Fatalf("expiry = %s, want %s", notAfter, cert.NotAfter)
+	}
+}
+
+func TestCertificateFingerprint_RejectsNonCertificates(t *testing.T) {
+	if _, _, err := CertificateFingerprint("not a certificate"); err == nil {
+		t.Fatal("expected an error for input without a certificate")
+	}
+}
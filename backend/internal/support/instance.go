@@ -0,0 +1,20 @@
+package support
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+var instanceID = generateInstanceID()
+
+// InstanceID identifies this backend process among the instances sharing
+// redis.
+func InstanceID() string {
+	return instanceID
+}
+
+func generateInstanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
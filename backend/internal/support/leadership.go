@@ -187,17 +187,11 @@ func (ls *leaderSession) renewLock() error {
 	ctx, cancel := context.WithTimeout(context.Background(), renewalTimeout)
 	defer cancel()
 
-	ttlMs := ls.ttl.Milliseconds()
-	if ttlMs <= 0 {
-		ttlMs = DefaultLeadershipTTL.Milliseconds()
-	}
-
-	res, err := renewScript.Run(ctx, ls.client, []string{ls.key}, ls.value, ttlMs).Result()
+	held, err := RenewLock(ctx, ls.client, ls.key, ls.value, ls.ttl)
 	if err != nil {
 		return err
 	}
-
-	if updated, ok := res.(int64); ok && updated == 0 {
+	if !held {
 		return errors.New("lock lost")
 	}
 
@@ -208,7 +202,32 @@ func (ls *leaderSession) releaseLock() error {
 	ctx, cancel := context.WithTimeout(context.Background(), renewalTimeout)
 	defer cancel()
 
-	_, err := releaseScript.Run(ctx, ls.client, []string{ls.key}, ls.value).Result()
+	return ReleaseLock(ctx, ls.client, ls.key, ls.value)
+}
+
+// RenewLock extends a lock that was taken with SETNX and value. It reports
+// false when the lock has expired or is held by someone else.
+func RenewLock(ctx context.Context, client *redis.Client, key, value string, ttl time.Duration) (bool, error) {
+	ttlMs := ttl.Milliseconds()
+	if ttlMs <= 0 {
+		ttlMs = DefaultLeadershipTTL.Milliseconds()
+	}
+
+	res, err := renewScript.Run(ctx, client, []string{key}, value, ttlMs).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if updated, ok := res.(int64); ok && updated == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// ReleaseLock deletes a lock if it is still held with value.
+func ReleaseLock(ctx context.Context, client *redis.Client, key, value string) error {
+	_, err := releaseScript.Run(ctx, client, []string{key}, value).Result()
 	if err != nil && !errors.Is(err, redis.Nil) {
 		return err
 	}
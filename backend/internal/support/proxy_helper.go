@@ -5,6 +5,7 @@ import (
 	"magpie/internal/config"
 	"magpie/internal/domain"
 	"net"
+	"net/netip"
 	"regexp"
 	"strconv"
 	"strings"
@@ -54,13 +55,12 @@ func parseTextToProxies(text string, allowColonAuth bool) []domain.Proxy {
 			}
 		}
 
-		hostSplit := strings.Split(hostPart, ":")
+		ip, hostSplit := splitProxyHost(hostPart)
 		if len(hostSplit) < 2 {
 			continue
 		}
 
-		ip := strings.TrimSpace(hostSplit[0])
-		if len(ip) > 0 && ip[0] == '0' {
+		if len(ip) > 0 && ip[0] == '0' && !strings.Contains(ip, ":") {
 			ip = ip[1:] // Fix proxy if it leads with 0
 		}
 		if net.ParseIP(ip) == nil {
@@ -101,6 +101,24 @@ func parseTextToProxies(text string, allowColonAuth bool) []domain.Proxy {
 	return proxies
 }
 
+// splitProxyHost splits a host:port[:user:pass] entry on colons. IPv6 hosts
+// must be bracketed, as in [2001:db8::1]:8080; the host is returned without
+// brackets and is also the first element of the split.
+func splitProxyHost(hostPart string) (string, []string) {
+	hostPart = strings.TrimSpace(hostPart)
+	if strings.HasPrefix(hostPart, "[") {
+		end := strings.Index(hostPart, "]")
+		if end == -1 || !strings.HasPrefix(hostPart[end+1:], ":") {
+			return "", nil
+		}
+		host := strings.TrimSpace(hostPart[1:end])
+		return host, append([]string{host}, strings.Split(hostPart[end+2:], ":")...)
+	}
+
+	split := strings.Split(hostPart, ":")
+	return strings.TrimSpace(split[0]), split
+}
+
 func clearProxyString(proxies string) string {
 	return cleanProxyString(proxies, true)
 }
@@ -150,6 +168,35 @@ func GetProxyLevel(html string) int {
 	return 1
 }
 
+// exitIPRegex matches the address the judge saw the request coming from, as
+// printed by azenv style judges (REMOTE_ADDR = 1.2.3.4) and JSON echo services
+// ("origin": "1.2.3.4" or "ip": "1.2.3.4").
+var exitIPRegex = regexp.MustCompile(`(?i)(?:remote_addr\s*[=:]|"(?:origin|ip|remote_addr)"\s*:)\s*"?([0-9A-Fa-f:., \t]+)`)
+
+// ParseExitIP returns the public IP a proxy exits from, read from a judge
+// response. Judges echoing a forwarded chain list the connecting address last.
+// It returns an empty string when the response holds no usable address.
+func ParseExitIP(html string) string {
+	candidates := []string{strings.TrimSpace(html)}
+	if match := exitIPRegex.FindStringSubmatch(html); match != nil {
+		candidates = strings.Split(match[1], ",")
+	}
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(candidates[i]))
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() {
+			continue
+		}
+		return addr.String()
+	}
+
+	return ""
+}
+
 // FormatProxies formats the list of proxies according to the specified output format
 func FormatProxies(proxies []domain.Proxy, outputFormat string) string {
 	var result strings.Builder
@@ -73,6 +73,30 @@ func TestParseTextToProxiesStrictAuth(t *testing.T) {
 	}
 }
 
+func TestParseTextToProxiesIPv6(t *testing.T) {
+	input := "[2001:db8::1]:8080\nuser:pass@[2001:DB8::2]:9000\n[2001:db8::3]:3128:name:secret\n2001:db8::4:80\n[2001:db8::5]\n"
+
+	parsed := ParseTextToProxiesStrictAuth(input)
+	if len(parsed) != 3 {
+		t.Fatalf("ParseTextToProxiesStrictAuth returned %d proxies, want 3", len(parsed))
+	}
+
+	if got := parsed[0].GetFullProxy(); got != "[2001:db8::1]:8080" {
+		t.Fatalf("first proxy was %s, want [2001:db8::1]:8080", got)
+	}
+	if got := parsed[1].GetFullProxy(); got != "[2001:db8::2]:9000" || parsed[1].Username != "user" {
+		t.Fatalf("second proxy was %s with user %q, want [2001:db8::2]:9000 with user", got, parsed[1].Username)
+	}
+	if got := parsed[2].GetFullProxy(); got != "[2001:db8::3]:3128" || parsed[2].HasAuth() {
+		t.Fatalf("third proxy was %s, want [2001:db8::3]:3128 without auth", got)
+	}
+
+	withColonAuth := ParseTextToProxies("[2001:db8::3]:3128:name:secret")
+	if len(withColonAuth) != 1 || withColonAuth[0].Username != "name" || withColonAuth[0].Password != "secret" {
+		t.Fatalf("ParseTextToProxies returned %+v, want credentials name:secret", withColonAuth)
+	}
+}
+
 func TestFindIP(t *testing.T) {
 	input := "Client address: 203.0.113.5 connected via [2001:db8::1]"
 
@@ -81,6 +105,24 @@ func TestFindIP(t *testing.T) {
 	}
 }
 
+func TestParseExitIP(t *testing.T) {
+	cases := map[string]string{
+		"<pre>\nHTTP_HOST = azenv.net\nREMOTE_ADDR = 198.51.100.7\nREMOTE_PORT = 51234\n</pre>": "198.51.100.7",
+		`{"origin": "203.0.113.9, 198.51.100.20"}`:                                              "198.51.100.20",
+		`{"ip":"2001:4860:4860::8888"}`:                                                         "2001:4860:4860::8888",
+		" 198.51.100.33\n":                                                                      "198.51.100.33",
+		"REMOTE_ADDR = 10.0.0.5":                                                                "",
+		"HTTP_X_FORWARDED_FOR = 198.51.100.7":                                                   "",
+		"":                                                                                      "",
+	}
+
+	for body, want := range cases {
+		if got := ParseExitIP(body); got != want {
+			t.Errorf("ParseExitIP(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
 func TestFormatProxies(t *testing.T) {
 	proxy := domain.Proxy{Port: 3128, Username: "user", Password: "pass", Country: "United States", EstimatedType: "Residential"}
 	if err := proxy.SetIP("10.0.0.5"); err != nil {
@@ -3,6 +3,7 @@ package support
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"golang.org/x/net/proxy"
 	"magpie/internal/config"
 	"magpie/internal/domain"
@@ -27,6 +28,8 @@ func CreateTransport(proxyToCheck domain.Proxy, judge *domain.Judge, protocol st
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
+	timeout := time.Duration(config.GetConfig().Checker.Timeout) * time.Millisecond
+
 	switch protocol {
 	case "http", "https":
 		// Configure HTTP/HTTPS proxy
@@ -40,29 +43,48 @@ func CreateTransport(proxyToCheck domain.Proxy, judge *domain.Judge, protocol st
 		transport.Proxy = http.ProxyURL(proxyURL)
 
 		// Override dialer to resolve judge's host to pre-defined IP
-		dialer := &net.Dialer{Timeout: time.Duration(config.GetConfig().Checker.Timeout) * time.Millisecond}
+		dialer := &net.Dialer{Timeout: timeout}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, judgeAddress(judge, addr))
+		}
+
+	case "socks4":
+		socksDialer := &SOCKS4Dialer{
+			ProxyAddress: proxyToCheck.GetFullProxy(),
+			UserID:       proxyToCheck.Username,
+			Forward:      &net.Dialer{Timeout: timeout},
+		}
 		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if host, port, err := net.SplitHostPort(addr); err == nil && host == judge.GetHostname() {
-				addr = net.JoinHostPort(judge.GetIp(), port)
+			target := judgeAddress(judge, addr)
+			if host, _, err := net.SplitHostPort(target); err == nil && net.ParseIP(host).To4() == nil {
+				// SOCKS4 only carries IPv4 addresses; let the proxy resolve
+				// the judge's name instead.
+				target = addr
 			}
-			return dialer.DialContext(ctx, network, addr)
+			return socksDialer.DialContext(ctx, network, target)
 		}
 
-	default:
-		// Handle SOCKS5 proxy
+	case "socks5":
 		var auth *proxy.Auth
 		if proxyToCheck.HasAuth() {
 			auth = &proxy.Auth{User: proxyToCheck.Username, Password: proxyToCheck.Password}
 		}
 		socksDialer, err := proxy.SOCKS5("tcp", proxyToCheck.GetFullProxy(), auth, &net.Dialer{
-			Timeout: time.Duration(config.GetConfig().Checker.Timeout) * time.Millisecond,
+			Timeout: timeout,
 		})
 		if err != nil {
 			return nil, err
 		}
+		contextDialer, ok := socksDialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("socks5 dialer does not support contexts")
+		}
 		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return socksDialer.Dial(network, addr)
+			return contextDialer.DialContext(ctx, network, judgeAddress(judge, addr))
 		}
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy protocol %q", protocol)
 	}
 
 	// Configure TLS to use judge's hostname
@@ -73,3 +95,12 @@ func CreateTransport(proxyToCheck domain.Proxy, judge *domain.Judge, protocol st
 
 	return transport, nil
 }
+
+// judgeAddress points connections to the judge at its pre-resolved IP.
+func judgeAddress(judge *domain.Judge, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != judge.GetHostname() || judge.GetIp() == "" {
+		return addr
+	}
+	return net.JoinHostPort(judge.GetIp(), port)
+}
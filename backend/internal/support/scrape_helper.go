@@ -3,6 +3,7 @@ package support
 import (
 	"fmt"
 	"html"
+	"net"
 	"net/url"
 	"regexp"
 	"sort"
@@ -28,6 +29,14 @@ func GetProxiesOfHTML(rawHTML string) []string {
 		set[candidate] = struct{}{}
 	}
 
+	// IPv6 endpoints are only unambiguous with a bracketed host.
+	ipv6PortRe := regexp.MustCompile(`\[([0-9A-Fa-f:.]+)\]\s*(?::|&colon;)\s*(\d{1,5})\b`)
+	for _, m := range ipv6PortRe.FindAllStringSubmatch(decoded, -1) {
+		if ip := parseIPv6Token(m[1]); ip != "" {
+			set[net.JoinHostPort(ip, m[2])] = struct{}{}
+		}
+	}
+
 	// 2. Table‑aware extraction where IP and port are split across cells.
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(decoded))
 	if err == nil {
@@ -41,13 +50,14 @@ func GetProxiesOfHTML(rawHTML string) []string {
 				if ip == "" {
 					if ipOnlyRe.MatchString(txt) {
 						ip = ipOnlyRe.FindString(txt)
+					} else {
+						ip = parseIPv6Token(txt)
 					}
 					return
 				}
 				if portOnlyRe.MatchString(txt) {
 					port := portOnlyRe.FindString(txt)
-					proxy := fmt.Sprintf("%s:%s", ip, port)
-					set[proxy] = struct{}{}
+					set[net.JoinHostPort(ip, port)] = struct{}{}
 				}
 			})
 		})
@@ -57,9 +67,14 @@ func GetProxiesOfHTML(rawHTML string) []string {
 		words := strings.Fields(plain)
 		for i := 0; i+1 < len(words); i++ {
 			w1, w2 := words[i], words[i+1]
-			if ipOnlyRe.MatchString(w1) && portOnlyRe.MatchString(w2) {
+			if !portOnlyRe.MatchString(w2) {
+				continue
+			}
+			if ipOnlyRe.MatchString(w1) {
 				proxy := fmt.Sprintf("%s:%s", w1, w2)
 				set[proxy] = struct{}{}
+			} else if ip := parseIPv6Token(w1); ip != "" {
+				set[net.JoinHostPort(ip, w2)] = struct{}{}
 			}
 		}
 	}
@@ -73,6 +88,17 @@ func GetProxiesOfHTML(rawHTML string) []string {
 	return proxies
 }
 
+// parseIPv6Token returns the canonical form of an optionally bracketed IPv6
+// address, or "" when token is anything else.
+func parseIPv6Token(token string) string {
+	token = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(token), "["), "]")
+	parsed := net.ParseIP(token)
+	if parsed == nil || parsed.To4() != nil {
+		return ""
+	}
+	return parsed.String()
+}
+
 func ParseTextToSources(text string) []string {
 	lines := strings.Split(text, "\n")
 	var sources []string
@@ -50,3 +50,18 @@ func TestGetProxiesOfHTML(t *testing.T) {
 		t.Fatalf("GetProxiesOfHTML returned %v, want %v", got, expected)
 	}
 }
+
+func TestGetProxiesOfHTMLIPv6(t *testing.T) {
+	html := `
+		<table>
+			<tr><td>2001:db8::10</td><td>8080</td></tr>
+		</table>
+		<p>Inline [2001:DB8::1]:3128 entry</p>
+		<p>Clock 12:30:45 is not a proxy</p>
+	`
+
+	expected := []string{"[2001:db8::10]:8080", "[2001:db8::1]:3128"}
+	if got := GetProxiesOfHTML(html); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("GetProxiesOfHTML returned %v, want %v", got, expected)
+	}
+}
@@ -0,0 +1,130 @@
+package support
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socks4Version        = 0x04
+	socks4CommandConnect = 0x01
+	socks4ReplyGranted   = 0x5A
+)
+
+var errSOCKS4Rejected = errors.New("socks4: request rejected")
+
+// SOCKS4Dialer connects to targets through a SOCKS4 proxy. Targets given by
+// host name use the SOCKS4a extension and are resolved by the proxy.
+type SOCKS4Dialer struct {
+	ProxyAddress string
+	// UserID is sent as the SOCKS4 user identifier; SOCKS4 has no passwords.
+	UserID  string
+	Forward *net.Dialer
+}
+
+// DialContext opens a connection to addr through the proxy.
+func (d *SOCKS4Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4":
+	default:
+		return nil, fmt.Errorf("socks4: network %q is not supported", network)
+	}
+
+	request, err := buildSOCKS4Request(addr, d.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	forward := d.Forward
+	if forward == nil {
+		forward = &net.Dialer{}
+	}
+	conn, err := forward.DialContext(ctx, "tcp", d.ProxyAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else if forward.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(forward.Timeout))
+	}
+
+	if err := socks4Handshake(conn, request); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// SOCKS4Connect asks the SOCKS4 proxy at the other end of conn to open a
+// tunnel to addr. Host names are sent using SOCKS4a so the proxy resolves
+// them. It is used by the checker's dialer and by rotators tunnelling through
+// SOCKS4 upstreams.
+func SOCKS4Connect(conn net.Conn, addr, userID string) error {
+	request, err := buildSOCKS4Request(addr, userID)
+	if err != nil {
+		return err
+	}
+	return socks4Handshake(conn, request)
+}
+
+func buildSOCKS4Request(addr, userID string) ([]byte, error) {
+	host, rawPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks4: invalid port %q", rawPort)
+	}
+
+	request := []byte{socks4Version, socks4CommandConnect, 0, 0}
+	binary.BigEndian.PutUint16(request[2:], uint16(port))
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && ip.To4() != nil:
+		request = append(request, ip.To4()...)
+		request = append(request, userID...)
+		request = append(request, 0)
+	case ip != nil:
+		return nil, fmt.Errorf("socks4: IPv6 target %s is not supported", host)
+	default:
+		// SOCKS4a: an address of 0.0.0.x tells the proxy to resolve the host
+		// name that follows the user ID.
+		request = append(request, 0, 0, 0, 1)
+		request = append(request, userID...)
+		request = append(request, 0)
+		request = append(request, host...)
+		request = append(request, 0)
+	}
+
+	return request, nil
+}
+
+func socks4Handshake(conn net.Conn, request []byte) error {
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x00 {
+		return fmt.Errorf("socks4: unexpected reply version %d", reply[0])
+	}
+	if reply[1] != socks4ReplyGranted {
+		return fmt.Errorf("%w: code %#x", errSOCKS4Rejected, reply[1])
+	}
+	return nil
+}
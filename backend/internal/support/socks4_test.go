@@ -0,0 +1,227 @@
+package support
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"magpie/internal/domain"
+)
+
+type socks4Request struct {
+	port   uint16
+	ip     net.IP
+	userID string
+	host   string
+}
+
+func readSOCKS4Request(t *testing.T, conn net.Conn) socks4Request {
+	t.Helper()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Errorf("read socks4 header: %v", err)
+		return socks4Request{}
+	}
+	if header[0] != socks4Version || header[1] != socks4CommandConnect {
+		t.Errorf("unexpected socks4 header %v", header[:2])
+	}
+
+	readString := func() string {
+		var buf bytes.Buffer
+		b := make([]byte, 1)
+		for {
+			if _, err := io.ReadFull(conn, b); err != nil {
+				t.Errorf("read socks4 string: %v", err)
+				return buf.String()
+			}
+			if b[0] == 0 {
+				return buf.String()
+			}
+			buf.WriteByte(b[0])
+		}
+	}
+
+	request := socks4Request{
+		port: binary.BigEndian.Uint16(header[2:4]),
+		ip:   net.IP(header[4:8]),
+	}
+	request.userID = readString()
+	if request.ip[0] == 0 && request.ip[1] == 0 && request.ip[2] == 0 && request.ip[3] != 0 {
+		request.host = readString()
+	}
+	return request
+}
+
+// startSOCKS4Server runs a SOCKS4 proxy that answers every request with reply
+// and, when granted, tunnels the connection to the requested target.
+func startSOCKS4Server(t *testing.T, reply byte) (string, <-chan socks4Request) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	requests := make(chan socks4Request, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				request := readSOCKS4Request(t, conn)
+				requests <- request
+
+				if _, err := conn.Write([]byte{0, reply, 0, 0, 0, 0, 0, 0}); err != nil || reply != socks4ReplyGranted {
+					return
+				}
+
+				host := request.host
+				if host == "" {
+					host = request.ip.String()
+				}
+				upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(request.port))))
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), requests
+}
+
+func TestSOCKS4Dialer_SendsIPv4Request(t *testing.T) {
+	proxyAddr, requests := startSOCKS4Server(t, socks4ReplyGranted)
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer target.Close()
+
+	dialer := &SOCKS4Dialer{ProxyAddress: proxyAddr, UserID: "alice"}
+	conn, err := dialer.DialContext(context.Background(), "tcp", target.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext returned error: %v", err)
+	}
+	conn.Close()
+
+	request := <-requests
+	_, rawPort, _ := net.SplitHostPort(target.Addr().String())
+	if strconv.Itoa(int(request.port)) != rawPort || !request.ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("request target = %s:%d, want %s", request.ip, request.port, target.Addr())
+	}
+	if request.userID != "alice" || request.host != "" {
+		t.Fatalf("request = %+v, want user alice without SOCKS4a host", request)
+	}
+}
+
+func TestSOCKS4Dialer_UsesSOCKS4aForHostNames(t *testing.T) {
+	proxyAddr, requests := startSOCKS4Server(t, socks4ReplyGranted)
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer target.Close()
+	_, rawPort, _ := net.SplitHostPort(target.Addr().String())
+
+	dialer := &SOCKS4Dialer{ProxyAddress: proxyAddr}
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("localhost", rawPort))
+	if err != nil {
+		t.Fatalf("DialContext returned error: %v", err)
+	}
+	conn.Close()
+
+	if request := <-requests; request.host != "localhost" {
+		t.Fatalf("request host = %q, want localhost", request.host)
+	}
+}
+
+func TestSOCKS4Dialer_ReportsRejection(t *testing.T) {
+	proxyAddr, _ := startSOCKS4Server(t, 0x5B)
+
+	dialer := &SOCKS4Dialer{ProxyAddress: proxyAddr}
+	_, err := dialer.DialContext(context.Background(), "tcp", "127.0.0.1:80")
+	if !errors.Is(err, errSOCKS4Rejected) {
+		t.Fatalf("DialContext error = %v, want rejection", err)
+	}
+}
+
+func TestSOCKS4Dialer_RejectsIPv6Targets(t *testing.T) {
+	dialer := &SOCKS4Dialer{ProxyAddress: "127.0.0.1:1"}
+	if _, err := dialer.DialContext(context.Background(), "tcp", "[::1]:80"); err == nil {
+		t.Fatal("expected IPv6 target to be rejected")
+	}
+}
+
+func TestCreateTransport_ChecksThroughSOCKS4(t *testing.T) {
+	proxyAddr, requests := startSOCKS4Server(t, socks4ReplyGranted)
+
+	judgeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("judge ok"))
+	}))
+	defer judgeServer.Close()
+
+	judge := &domain.Judge{FullString: judgeServer.URL}
+	if err := judge.SetUp(); err != nil {
+		t.Fatalf("judge setup: %v", err)
+	}
+
+	host, rawPort, _ := net.SplitHostPort(proxyAddr)
+	port, _ := strconv.Atoi(rawPort)
+	proxyToCheck := domain.Proxy{Port: uint16(port)}
+	if err := proxyToCheck.SetIP(host); err != nil {
+		t.Fatalf("set proxy ip: %v", err)
+	}
+
+	transport, err := CreateTransport(proxyToCheck, judge, "socks4")
+	if err != nil {
+		t.Fatalf("CreateTransport returned error: %v", err)
+	}
+	defer transport.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Get(judgeServer.URL)
+	if err != nil {
+		t.Fatalf("request through socks4 proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "judge ok" {
+		t.Fatalf("body = %q, want judge ok", body)
+	}
+	select {
+	case <-requests:
+	default:
+		t.Fatal("expected the request to go through the socks4 proxy")
+	}
+}
+
+func TestCreateTransport_RejectsUnknownProtocol(t *testing.T) {
+	judge := &domain.Judge{FullString: "http://example.com"}
+	if err := judge.SetUp(); err != nil {
+		t.Fatalf("judge setup: %v", err)
+	}
+
+	if _, err := CreateTransport(domain.Proxy{IP: "127.0.0.1", Port: 1}, judge, "gopher"); err == nil {
+		t.Fatal("expected unsupported protocol to be rejected")
+	}
+}
@@ -108,6 +108,18 @@ export class AdminCheckerComponent implements OnInit, OnDestroy {
         minutes: [0],
         seconds: [0]
       }),
+      backoff: this.fb.group({
+        enabled: [true],
+        alive_factor: [0.5],
+        grace_failures: [2],
+        multiplier: [2],
+        max_timer: this.fb.group({
+          days: [7],
+          hours: [0],
+          minutes: [0],
+          seconds: [0]
+        })
+      }),
       judges_threads: [3],
       judges_timeout: [5000],
       judge_timer: this.fb.group({
@@ -151,6 +163,10 @@ export class AdminCheckerComponent implements OnInit, OnDestroy {
       use_https_for_socks: checkerSettings.use_https_for_socks
     });
 
+    if (checkerSettings.backoff) {
+      this.settingsForm.patchValue({backoff: checkerSettings.backoff});
+    }
+
     // Update judge timer if exists
     if (checkerSettings.judge_timer) {
       this.settingsForm.patchValue({
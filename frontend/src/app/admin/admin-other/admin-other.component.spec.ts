@@ -15,6 +15,13 @@ const defaultSettings: GlobalSettings = {
     retries: 2,
     timeout: 7500,
     checker_timer: timer(),
+    backoff: {
+      enabled: true,
+      alive_factor: 0.5,
+      grace_failures: 2,
+      multiplier: 2,
+      max_timer: timer()
+    },
     judges_threads: 1,
     judges_timeout: 1000,
     judges: [],
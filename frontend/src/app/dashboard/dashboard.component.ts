@@ -22,6 +22,7 @@ import {
 } from '../services/graphql.service';
 import {LoadingComponent} from '../ui-elements/loading/loading.component';
 import {ProxyReputationCardComponent} from './cards/proxy-reputation-card/proxy-reputation-card.component';
+import {formatHostPort} from '../shared/format-address';
 
 interface SparklineMetric {
   value: number;
@@ -368,7 +369,7 @@ export class DashboardComponent implements OnInit, OnDestroy {
 
           const entry: ProxyCheck = {
             id: `#${proxy.id}`,
-            ip: `${proxy.ip}:${proxy.port}`,
+            ip: formatHostPort(proxy.ip, proxy.port),
             status,
             date: latest,
             time: this.toTimeLabel(latest)
@@ -9,5 +9,6 @@ export interface ExportSettings {
   maxTimeout: number
   proxyStatus: 'all' | 'alive' | 'dead'
   reputationLabels: string[]
+  targetCheckIds?: number[]
   outputFormat: string
 }
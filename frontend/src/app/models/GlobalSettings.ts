@@ -17,6 +17,18 @@ export interface GlobalSettings {
       minutes: number;
       seconds: number;
     };
+    backoff: {
+      enabled: boolean;
+      alive_factor: number;
+      grace_failures: number;
+      multiplier: number;
+      max_timer: {
+        days: number;
+        hours: number;
+        minutes: number;
+        seconds: number;
+      };
+    };
     judges_threads: number;
     judges_timeout: number;
     judges: {
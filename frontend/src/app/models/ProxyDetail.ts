@@ -1,5 +1,6 @@
 import {ProxyStatistic} from './ProxyStatistic';
 import {ProxyReputationBreakdown} from './ProxyReputation';
+import {TargetCheckResult} from './TargetCheck';
 
 export interface ProxyDetail {
   id: number;
@@ -10,8 +11,12 @@ export interface ProxyDetail {
   has_auth: boolean;
   estimated_type: string;
   country: string;
+  exit_ip?: string;
+  exit_country?: string;
+  exit_changed?: boolean;
   created_at: string;
   latest_check?: string | null;
   latest_statistic?: ProxyStatistic | null;
   reputation?: ProxyReputationBreakdown | null;
+  target_checks?: TargetCheckResult[] | null;
 }
@@ -6,6 +6,8 @@ export interface ProxyStatistic {
   protocol: string;
   anonymity_level: string;
   judge: string;
+  exit_ip?: string;
+  exit_country?: string;
   created_at: string;
 }
 
@@ -4,24 +4,97 @@ export interface RotatingProxy {
   protocol: string;
   alive_proxy_count: number;
   listen_port: number;
+  listen_protocol: string;
   auth_required: boolean;
   auth_username?: string | null;
   auth_password?: string | null;
+  allowed_client_cidrs?: string[] | null;
+  tls_certificate?: string | null;
+  tls_fingerprint?: string | null;
+  tls_expires_at?: string | null;
+  strip_request_headers?: string[] | null;
+  set_request_headers?: Record<string, string> | null;
+  user_agent_pool?: string[] | null;
+  expose_upstream_header: boolean;
+  routing_rules?: RotatingProxyRoutingRule[] | null;
+  session_ttl_seconds: number;
+  rotation_strategy: string;
+  rotation_interval_seconds: number;
+  failover_attempts: number;
+  failover_timeout_seconds: number;
+  failover_cooldown_seconds: number;
+  host_ban_cooldown_seconds: number;
+  rate_limit_per_second: number;
+  max_concurrent_connections: number;
+  max_connections_per_upstream: number;
+  max_bandwidth_kb_per_second: number;
   listen_host?: string | null;
   listen_address?: string | null;
+  host_instance?: string | null;
   last_rotation_at?: string | null;
   last_served_proxy?: string | null;
   reputation_labels?: string[] | null;
+  allowed_countries?: string[] | null;
+  blocked_countries?: string[] | null;
+  estimated_types?: string[] | null;
+  max_response_time_ms?: number | null;
+  min_anonymity_level?: string | null;
+  target_check_ids?: number[] | null;
   created_at: string;
 }
 
 export interface CreateRotatingProxy {
   name: string;
   protocol: string;
+  listen_protocol?: string | null;
   auth_required: boolean;
   auth_username?: string | null;
   auth_password?: string | null;
+  allowed_client_cidrs?: string[] | null;
+  tls_certificate?: string | null;
+  tls_private_key?: string | null;
+  strip_request_headers?: string[] | null;
+  set_request_headers?: Record<string, string> | null;
+  user_agent_pool?: string[] | null;
+  expose_upstream_header?: boolean | null;
+  routing_rules?: RotatingProxyRoutingRule[] | null;
+  session_ttl_seconds?: number | null;
+  rotation_strategy?: string | null;
+  rotation_interval_seconds?: number | null;
+  failover_attempts?: number | null;
+  failover_timeout_seconds?: number | null;
+  failover_cooldown_seconds?: number | null;
+  host_ban_cooldown_seconds?: number | null;
+  rate_limit_per_second?: number | null;
+  max_concurrent_connections?: number | null;
+  max_connections_per_upstream?: number | null;
+  max_bandwidth_kb_per_second?: number | null;
   reputation_labels?: string[] | null;
+  allowed_countries?: string[] | null;
+  blocked_countries?: string[] | null;
+  estimated_types?: string[] | null;
+  max_response_time_ms?: number | null;
+  min_anonymity_level?: string | null;
+  target_check_ids?: number[] | null;
+}
+
+export type UpdateRotatingProxy = Partial<Omit<CreateRotatingProxy, 'listen_protocol'>> & {
+  regenerate_tls_certificate?: boolean | null;
+};
+
+export interface RotatingProxyRoutingRule {
+  hosts: string[];
+  countries?: string[] | null;
+  estimated_types?: string[] | null;
+  max_response_time_ms?: number | null;
+  min_anonymity_level?: string | null;
+}
+
+export interface RotatingProxyHostBan {
+  proxy_id: number;
+  host: string;
+  cooldown_seconds?: number | null;
+  expires_at?: string | null;
 }
 
 export interface RotatingProxyNext {
@@ -33,3 +106,30 @@ export interface RotatingProxyNext {
   has_auth: boolean;
   protocol: string;
 }
+
+export interface RotatingProxyTrafficTotals {
+  requests: number;
+  tunnels: number;
+  bytes_in: number;
+  bytes_out: number;
+  errors: number;
+  avg_latency_ms: number;
+}
+
+export interface RotatingProxyUpstreamTraffic extends RotatingProxyTrafficTotals {
+  proxy_id: number;
+  ip?: string | null;
+  port?: number | null;
+}
+
+export interface RotatingProxyTrafficBucket extends RotatingProxyTrafficTotals {
+  bucket_start: string;
+}
+
+export interface RotatingProxyTraffic {
+  rotating_proxy_id: number;
+  since: string;
+  totals: RotatingProxyTrafficTotals;
+  upstreams: RotatingProxyUpstreamTraffic[];
+  buckets: RotatingProxyTrafficBucket[];
+}
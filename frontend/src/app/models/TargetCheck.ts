@@ -0,0 +1,30 @@
+export interface TargetCheck {
+  id: number;
+  name: string;
+  url: string;
+  method: string;
+  expected_statuses: number[];
+  body_regex?: string | null;
+  max_latency_ms?: number | null;
+  created_at: string;
+}
+
+export interface TargetCheckRequest {
+  name: string;
+  url: string;
+  method?: string | null;
+  expected_statuses?: number[] | null;
+  body_regex?: string | null;
+  max_latency_ms?: number | null;
+}
+
+export interface TargetCheckResult {
+  target_check_id: number;
+  name: string;
+  protocol: string;
+  passed: boolean;
+  status_code?: number | null;
+  response_time: number;
+  error?: string | null;
+  checked_at: string;
+}
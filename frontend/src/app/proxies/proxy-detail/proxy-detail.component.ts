@@ -14,6 +14,7 @@ import {HttpService} from '../../services/http.service';
 import {Subscription} from 'rxjs';
 import {NotificationService} from '../../services/notification-service.service';
 import {LoadingComponent} from '../../ui-elements/loading/loading.component';
+import {formatHostPort} from '../../shared/format-address';
 
 interface ThemePalette {
   primary: string;
@@ -135,7 +136,7 @@ export class ProxyDetailComponent implements OnInit, OnDestroy {
     if (port === undefined || port === null || `${port}`.trim() === '') {
       return ip;
     }
-    return `${ip}:${port}`;
+    return formatHostPort(ip, port);
   }
 
   get externalLookupLinks(): { label: string; url: string; icon: string }[] {
@@ -307,7 +308,7 @@ export class ProxyDetailComponent implements OnInit, OnDestroy {
       return '';
     }
 
-    return `${ip}:${port}:${credentials.username}:${credentials.password}`;
+    return `${formatHostPort(ip, port)}:${credentials.username}:${credentials.password}`;
   }
 
   get latestStatistic(): ProxyStatistic | null {
@@ -17,6 +17,7 @@ import {NotificationService} from '../services/notification-service.service';
 import {CreateRotatingProxy, RotatingProxy, RotatingProxyNext} from '../models/RotatingProxy';
 import {UserSettings} from '../models/UserSettings';
 import {LoadingComponent} from '../ui-elements/loading/loading.component';
+import {formatHostPort} from '../shared/format-address';
 
 type RotatingProxyPreview = RotatingProxyNext & { name: string };
 
@@ -277,7 +278,7 @@ export class RotatingProxiesComponent implements OnInit, OnDestroy {
             next.delete(proxy.id);
             return next;
           });
-          const address = `${res.ip}:${res.port}`;
+          const address = formatHostPort(res.ip, res.port);
           let updatedRotator: RotatingProxy | null = null;
           this.rotatingProxies.update(list =>
             list.map(item => {
@@ -324,8 +325,8 @@ export class RotatingProxiesComponent implements OnInit, OnDestroy {
     }
 
     const address = preview.has_auth && preview.username && preview.password
-      ? `${preview.username}:${preview.password}@${preview.ip}:${preview.port}`
-      : `${preview.ip}:${preview.port}`;
+      ? `${preview.username}:${preview.password}@${formatHostPort(preview.ip, preview.port)}`
+      : formatHostPort(preview.ip, preview.port);
 
     this.copyValueToClipboard(address, 'Copied to clipboard.', 'No proxy available to copy yet.');
   }
@@ -16,6 +16,7 @@ import {ProxyStatisticResponseDetail} from '../models/ProxyStatisticResponseDeta
 import {RotatingProxy, CreateRotatingProxy, RotatingProxyNext} from '../models/RotatingProxy';
 import {map} from 'rxjs/operators';
 import {DeleteSettings} from '../models/DeleteSettings';
+import {TargetCheck, TargetCheckRequest} from '../models/TargetCheck';
 
 @Injectable({
   providedIn: 'root'
@@ -116,6 +117,24 @@ export class HttpService {
     return this.http.post<RotatingProxyNext>(`${this.apiUrl}/rotatingProxies/${id}/next`, {});
   }
 
+  getTargetChecks() {
+    return this.http
+      .get<{target_checks: TargetCheck[]}>(`${this.apiUrl}/targetChecks`)
+      .pipe(map(res => res?.target_checks ?? []));
+  }
+
+  createTargetCheck(payload: TargetCheckRequest) {
+    return this.http.post<TargetCheck>(`${this.apiUrl}/targetChecks`, payload);
+  }
+
+  updateTargetCheck(id: number, payload: TargetCheckRequest) {
+    return this.http.put<TargetCheck>(`${this.apiUrl}/targetChecks/${id}`, payload);
+  }
+
+  deleteTargetCheck(id: number) {
+    return this.http.delete<void>(`${this.apiUrl}/targetChecks/${id}`);
+  }
+
 
   saveGlobalSettings(payload: GlobalSettings) {
     return this.http.post(environment.apiUrl + "/saveSettings", payload)
@@ -173,6 +173,19 @@ export class SettingsService {
         seconds: formData?.checker_timer?.seconds ?? current?.checker?.checker_timer?.seconds ?? 0
       },
 
+      backoff: {
+        enabled:        formData?.backoff?.enabled        ?? current?.checker?.backoff?.enabled        ?? true,
+        alive_factor:   formData?.backoff?.alive_factor   ?? current?.checker?.backoff?.alive_factor   ?? 0.5,
+        grace_failures: formData?.backoff?.grace_failures ?? current?.checker?.backoff?.grace_failures ?? 2,
+        multiplier:     formData?.backoff?.multiplier     ?? current?.checker?.backoff?.multiplier     ?? 2,
+        max_timer: {
+          days:    formData?.backoff?.max_timer?.days    ?? current?.checker?.backoff?.max_timer?.days    ?? 7,
+          hours:   formData?.backoff?.max_timer?.hours   ?? current?.checker?.backoff?.max_timer?.hours   ?? 0,
+          minutes: formData?.backoff?.max_timer?.minutes ?? current?.checker?.backoff?.max_timer?.minutes ?? 0,
+          seconds: formData?.backoff?.max_timer?.seconds ?? current?.checker?.backoff?.max_timer?.seconds ?? 0
+        }
+      },
+
       judges_threads: formData.judges_threads      ?? current?.checker?.judges_threads      ?? 3,
       judges_timeout: formData.judges_timeout      ?? current?.checker?.judges_timeout      ?? 5000,
 
@@ -0,0 +1,5 @@
+/** Joins an IP and port the way URLs do, bracketing IPv6 addresses. */
+export function formatHostPort(ip: string, port: number | string): string {
+  const host = ip.includes(':') && !ip.startsWith('[') ? `[${ip}]` : ip;
+  return `${host}:${port}`;
+}